@@ -48,6 +48,8 @@ func (a *APIHandler) postHandler(c *gin.Context) {
 		a.ApiService.RestartApp(c)
 	case "restartSb":
 		a.ApiService.RestartSb(c)
+	case "closeConnections":
+		a.ApiService.CloseConnections(c)
 	case "linkConvert":
 		a.ApiService.LinkConvert(c)
 	case "importdb":
@@ -76,12 +78,42 @@ func (a *APIHandler) postHandler(c *gin.Context) {
 		a.ApiService.SendTelegramBroadcast(c)
 	case "telegramBroadcastEdit":
 		a.ApiService.EditTelegramBroadcast(c)
+	case "telegramBroadcastSchedule":
+		a.ApiService.ScheduleTelegramBroadcast(c)
+	case "telegramBroadcastCancel":
+		a.ApiService.CancelTelegramBroadcast(c)
+	case "telegramBroadcastMedia":
+		a.ApiService.UploadTelegramBroadcastMedia(c)
+	case "telegramAudiencePreview":
+		a.ApiService.PreviewTelegramAudience(c)
 	case "telegramPromo":
 		a.ApiService.SaveTelegramPromoCode(c)
 	case "telegramPromoDelete":
 		a.ApiService.DeleteTelegramPromoCode(c)
+	case "telegramPromoRedeem":
+		a.ApiService.RedeemTelegramPromoCode(c)
 	case "telegramConversationReply":
 		a.ApiService.ReplyTelegramConversation(c)
+	case "telegramConversationUpdate":
+		a.ApiService.UpdateTelegramConversation(c)
+	case "telegramCannedReply":
+		a.ApiService.SaveTelegramCannedReply(c)
+	case "telegramCannedReplyDelete":
+		a.ApiService.DeleteTelegramCannedReply(c)
+	case "telegramPaymentRefund":
+		a.ApiService.RefundTelegramPayment(c)
+	case "telegramClientLink":
+		a.ApiService.LinkTelegramClient(c)
+	case "telegramClientUnlink":
+		a.ApiService.UnlinkTelegramClient(c)
+	case "telegramTemplate":
+		a.ApiService.SaveTelegramTemplate(c)
+	case "telegramTemplateDelete":
+		a.ApiService.DeleteTelegramTemplate(c)
+	case "telegramTemplatePreview":
+		a.ApiService.PreviewTelegramTemplate(c)
+	case "telegramAdminTest":
+		a.ApiService.SendTelegramAdminTest(c)
 	default:
 		jsonMsg(c, "failed", common.NewError("unknown action: ", action))
 	}
@@ -107,6 +139,14 @@ func (a *APIHandler) getHandler(c *gin.Context) {
 		a.ApiService.GetSettings(c)
 	case "stats":
 		a.ApiService.GetStats(c)
+	case "trafficHistory":
+		a.ApiService.GetTrafficHistory(c)
+	case "clientIps":
+		a.ApiService.GetClientIPs(c)
+	case "connections":
+		a.ApiService.GetConnections(c)
+	case "connectionsFeed":
+		a.ApiService.StreamConnections(c)
 	case "status":
 		a.ApiService.GetStatus(c)
 	case "onlines":
@@ -123,8 +163,32 @@ func (a *APIHandler) getHandler(c *gin.Context) {
 		a.ApiService.GetTelegramState(c)
 	case "telegramBroadcastDeliveries":
 		a.ApiService.GetTelegramBroadcastDeliveries(c)
+	case "telegramBroadcastMedia":
+		a.ApiService.GetTelegramBroadcastMedia(c)
 	case "telegramConversation":
 		a.ApiService.GetTelegramConversation(c)
+	case "telegramConversations":
+		a.ApiService.GetTelegramConversations(c)
+	case "telegramAttachment":
+		a.ApiService.GetTelegramAttachment(c)
+	case "telegramCannedReplies":
+		a.ApiService.GetTelegramCannedReplies(c)
+	case "telegramReferralTree":
+		a.ApiService.GetTelegramReferralTree(c)
+	case "telegramReferralRewards":
+		a.ApiService.GetTelegramReferralRewards(c)
+	case "telegramTrials":
+		a.ApiService.GetTelegramTrials(c)
+	case "telegramPayments":
+		a.ApiService.GetTelegramPayments(c)
+	case "telegramPayment":
+		a.ApiService.GetTelegramPayment(c)
+	case "telegramPaymentReport":
+		a.ApiService.GetTelegramPaymentReport(c)
+	case "telegramPaymentsCsv":
+		a.ApiService.ExportTelegramPayments(c)
+	case "telegramTemplates":
+		a.ApiService.GetTelegramTemplates(c)
 	case "tokens":
 		a.ApiService.GetTokens(c)
 	default:
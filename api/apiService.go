@@ -2,6 +2,7 @@ package api
 
 import (
 	"encoding/json"
+	"io"
 	"strconv"
 	"time"
 
@@ -26,6 +27,7 @@ type ApiService struct {
 	service.PanelService
 	service.StatsService
 	service.ServerService
+	service.ConnectionService
 	TelegramService *service.TelegramService
 }
 
@@ -206,6 +208,60 @@ func (a *ApiService) GetStats(c *gin.Context) {
 	jsonObj(c, data, err)
 }
 
+func (a *ApiService) GetTrafficHistory(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Query("id"), 10, 32)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil {
+		limit = 100
+	}
+	history, err := a.ClientService.GetTrafficHistory(uint(id), limit)
+	jsonObj(c, history, err)
+}
+
+func (a *ApiService) GetClientIPs(c *gin.Context) {
+	ips, err := a.ClientService.GetClientIPs(c.Query("name"))
+	jsonObj(c, ips, err)
+}
+
+func (a *ApiService) GetConnections(c *gin.Context) {
+	jsonObj(c, a.ConnectionService.GetConnections(), nil)
+}
+
+// StreamConnections sends the live connections as server-sent events every interval
+// seconds until the client goes away.
+func (a *ApiService) StreamConnections(c *gin.Context) {
+	interval, err := strconv.Atoi(c.Query("interval"))
+	if err != nil || interval < 1 || interval > 60 {
+		interval = 2
+	}
+	ticker := time.NewTicker(time.Duration(interval) * time.Second)
+	defer ticker.Stop()
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+	first := true
+	c.Stream(func(w io.Writer) bool {
+		if !first {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-ticker.C:
+			}
+		}
+		first = false
+		c.SSEvent("connections", a.ConnectionService.GetConnections())
+		return true
+	})
+}
+
+func (a *ApiService) CloseConnections(c *gin.Context) {
+	closed, err := a.ConnectionService.CloseConnections(c.Request.FormValue("id"), c.Request.FormValue("user"), c.Request.FormValue("destination"))
+	jsonObj(c, closed, err)
+}
+
 func (a *ApiService) GetStatus(c *gin.Context) {
 	request := c.Query("r")
 	result := a.ServerService.GetStatus(request)
@@ -49,6 +49,8 @@ func (a *APIv2Handler) postHandler(c *gin.Context) {
 		a.ApiService.RestartApp(c)
 	case "restartSb":
 		a.ApiService.RestartSb(c)
+	case "closeConnections":
+		a.ApiService.CloseConnections(c)
 	case "linkConvert":
 		a.ApiService.LinkConvert(c)
 	case "importdb":
@@ -76,6 +78,14 @@ func (a *APIv2Handler) getHandler(c *gin.Context) {
 		a.ApiService.GetSettings(c)
 	case "stats":
 		a.ApiService.GetStats(c)
+	case "trafficHistory":
+		a.ApiService.GetTrafficHistory(c)
+	case "clientIps":
+		a.ApiService.GetClientIPs(c)
+	case "connections":
+		a.ApiService.GetConnections(c)
+	case "connectionsFeed":
+		a.ApiService.StreamConnections(c)
 	case "status":
 		a.ApiService.GetStatus(c)
 	case "onlines":
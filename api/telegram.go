@@ -1,7 +1,9 @@
 package api
 
 import (
+	"bytes"
 	"net/http"
+	"time"
 
 	"github.com/alireza0/s-ui/service"
 
@@ -188,6 +190,94 @@ func (a *ApiService) EditTelegramBroadcast(c *gin.Context) {
 	jsonObj(c, broadcast, nil)
 }
 
+func (a *ApiService) ScheduleTelegramBroadcast(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload service.TelegramBroadcastSchedulePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	broadcast, err := a.TelegramService.ScheduleBroadcast(&payload)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, broadcast, nil)
+}
+
+func (a *ApiService) CancelTelegramBroadcast(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	broadcast, err := a.TelegramService.CancelBroadcastSchedule(payload.ID)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, broadcast, nil)
+}
+
+func (a *ApiService) UploadTelegramBroadcastMedia(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	file, header, err := c.Request.FormFile("file")
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	defer file.Close()
+	media, err := a.TelegramService.SaveBroadcastMedia(c.Request.FormValue("type"), header.Filename, file)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, media, nil)
+}
+
+func (a *ApiService) GetTelegramBroadcastMedia(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	path, err := a.TelegramService.BroadcastMediaPath(c.Query("file"))
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.File(path)
+}
+
+func (a *ApiService) PreviewTelegramAudience(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var audience service.TelegramBroadcastAudience
+	if err := c.ShouldBindJSON(&audience); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	count, err := a.TelegramService.PreviewAudience(audience)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, gin.H{"count": count}, nil)
+}
+
 func (a *ApiService) GetTelegramBroadcastDeliveries(c *gin.Context) {
 	if a.TelegramService == nil {
 		c.Status(http.StatusServiceUnavailable)
@@ -247,6 +337,27 @@ func (a *ApiService) DeleteTelegramPromoCode(c *gin.Context) {
 	jsonMsg(c, "", nil)
 }
 
+func (a *ApiService) RedeemTelegramPromoCode(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		UserID uint   `json:"userId"`
+		Code   string `json:"code"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	redemption, err := a.TelegramService.RedeemPromoCode(payload.UserID, payload.Code)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, redemption, nil)
+}
+
 func (a *ApiService) GetTelegramConversation(c *gin.Context) {
 	if a.TelegramService == nil {
 		c.Status(http.StatusServiceUnavailable)
@@ -269,23 +380,404 @@ func (a *ApiService) GetTelegramConversation(c *gin.Context) {
 	jsonObj(c, convo, nil)
 }
 
-func (a *ApiService) ReplyTelegramConversation(c *gin.Context) {
+func (a *ApiService) GetTelegramReferralTree(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		UserID uint `form:"userId" json:"userId"`
+	}
+	if err := c.ShouldBindQuery(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	tree, err := a.TelegramService.GetReferralTree(payload.UserID)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, tree, nil)
+}
+
+func (a *ApiService) GetTelegramReferralRewards(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		UserID uint `form:"userId" json:"userId"`
+	}
+	if err := c.ShouldBindQuery(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	rewards, err := a.TelegramService.ListReferralRewards(payload.UserID)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, rewards, nil)
+}
+
+func (a *ApiService) GetTelegramTrials(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	trials, err := a.TelegramService.ListTrials()
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, trials, nil)
+}
+
+func (a *ApiService) GetTelegramPayments(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var filter service.TelegramPaymentFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	payments, err := a.TelegramService.ListPayments(filter)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, payments, nil)
+}
+
+func (a *ApiService) GetTelegramPayment(c *gin.Context) {
 	if a.TelegramService == nil {
 		c.Status(http.StatusServiceUnavailable)
 		return
 	}
 	var payload struct {
-		ID   uint   `json:"id"`
-		Text string `json:"text"`
+		ID uint `form:"id" json:"id"`
+	}
+	if err := c.ShouldBindQuery(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	payment, err := a.TelegramService.GetPayment(payload.ID)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, payment, nil)
+}
+
+func (a *ApiService) GetTelegramPaymentReport(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var filter service.TelegramPaymentFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	report, err := a.TelegramService.PaymentReport(filter)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, report, nil)
+}
+
+func (a *ApiService) ExportTelegramPayments(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var filter service.TelegramPaymentFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := a.TelegramService.ExportPaymentsCSV(&buf, filter); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename=s-ui_payments_"+time.Now().Format("20060102-150405")+".csv")
+	c.Writer.Write(buf.Bytes())
+}
+
+func (a *ApiService) RefundTelegramPayment(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		ID          uint   `json:"id"`
+		AmountMinor int64  `json:"amountMinor"`
+		Reason      string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	payment, err := a.TelegramService.RefundPayment(payload.ID, payload.AmountMinor, payload.Reason, GetLoginUser(c))
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, payment, nil)
+}
+
+func (a *ApiService) ReplyTelegramConversation(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload service.TelegramConversationReply
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
 	}
+	convo, err := a.TelegramService.ReplyToConversation(&payload, GetLoginUser(c))
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, convo, nil)
+}
+
+func (a *ApiService) UpdateTelegramConversation(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload service.TelegramConversationUpdate
 	if err := c.ShouldBindJSON(&payload); err != nil {
 		jsonMsg(c, "", err)
 		return
 	}
-	convo, err := a.TelegramService.ReplyToConversation(payload.ID, payload.Text)
+	convo, err := a.TelegramService.UpdateConversation(&payload)
 	if err != nil {
 		jsonMsg(c, "", err)
 		return
 	}
 	jsonObj(c, convo, nil)
 }
+
+func (a *ApiService) GetTelegramConversations(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var filter service.TelegramConversationFilter
+	if err := c.ShouldBindQuery(&filter); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	conversations, err := a.TelegramService.ListConversations(filter)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, conversations, nil)
+}
+
+// GetTelegramAttachment shows photos in the browser and downloads documents under their original name.
+func (a *ApiService) GetTelegramAttachment(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		ID uint `form:"id"`
+	}
+	if err := c.ShouldBindQuery(&payload); err != nil {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	path, msg, err := a.TelegramService.AttachmentPath(payload.ID)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	if msg.AttachmentType == "photo" {
+		c.File(path)
+		return
+	}
+	c.FileAttachment(path, msg.AttachmentName)
+}
+
+func (a *ApiService) GetTelegramCannedReplies(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	replies, err := a.TelegramService.ListCannedReplies()
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, replies, nil)
+}
+
+func (a *ApiService) SaveTelegramCannedReply(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload service.TelegramCannedReplyPayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	reply, err := a.TelegramService.SaveCannedReply(&payload)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, reply, nil)
+}
+
+func (a *ApiService) DeleteTelegramCannedReply(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	if err := a.TelegramService.DeleteCannedReply(payload.ID); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonMsg(c, "", nil)
+}
+
+func (a *ApiService) LinkTelegramClient(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		UserID   uint `json:"userId"`
+		ClientID uint `json:"clientId"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	sub, err := a.TelegramService.LinkClient(payload.UserID, payload.ClientID)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, sub, nil)
+}
+
+func (a *ApiService) UnlinkTelegramClient(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		UserID   uint `json:"userId"`
+		ClientID uint `json:"clientId"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	sub, err := a.TelegramService.UnlinkClient(payload.UserID, payload.ClientID)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, sub, nil)
+}
+
+func (a *ApiService) GetTelegramTemplates(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	templates, err := a.TelegramService.ListMessageTemplates()
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, templates, nil)
+}
+
+func (a *ApiService) SaveTelegramTemplate(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload service.TelegramMessageTemplatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	tmpl, err := a.TelegramService.SaveMessageTemplate(&payload)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, tmpl, nil)
+}
+
+func (a *ApiService) DeleteTelegramTemplate(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload struct {
+		ID uint `json:"id"`
+	}
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	if err := a.TelegramService.DeleteMessageTemplate(payload.ID); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonMsg(c, "", nil)
+}
+
+func (a *ApiService) PreviewTelegramTemplate(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	var payload service.TelegramMessageTemplatePayload
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	text, err := a.TelegramService.PreviewMessageTemplate(&payload)
+	if err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonObj(c, gin.H{"text": text}, nil)
+}
+
+func (a *ApiService) SendTelegramAdminTest(c *gin.Context) {
+	if a.TelegramService == nil {
+		c.Status(http.StatusServiceUnavailable)
+		return
+	}
+	if err := a.TelegramService.SendAdminTest(); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	jsonMsg(c, "", nil)
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/telegram/botapi"
+	"github.com/alireza0/s-ui/telegram/yookassa"
+
+	"github.com/gin-gonic/gin"
+)
+
+const telegramSecretHeader = "X-Telegram-Bot-Api-Secret-Token"
+
+type TelegramWebhookHandler struct {
+	TelegramService *service.TelegramService
+}
+
+func NewTelegramWebhookHandler(g *gin.RouterGroup) {
+	a := &TelegramWebhookHandler{
+		TelegramService: service.SharedTelegramService(),
+	}
+	a.initRouter(g)
+}
+
+func (a *TelegramWebhookHandler) initRouter(g *gin.RouterGroup) {
+	g.POST("/webhook", a.webhook)
+	g.POST("/yookassa", a.yookassa)
+
+	miniApp := g.Group("/miniapp")
+	miniApp.Use(a.miniAppAuth)
+	miniApp.GET("/data", a.miniAppData)
+	miniApp.POST("/buy", a.miniAppBuy)
+	miniApp.POST("/trial", a.miniAppTrial)
+}
+
+func (a *TelegramWebhookHandler) webhook(c *gin.Context) {
+	if !a.TelegramService.VerifyWebhookSecret(c.GetHeader(telegramSecretHeader)) {
+		logger.Warningf("telegram webhook: rejected request from %s", getRemoteIp(c))
+		c.Status(http.StatusUnauthorized)
+		return
+	}
+	var update botapi.Update
+	if err := c.ShouldBindJSON(&update); err != nil {
+		logger.Warning("telegram webhook: invalid update:", err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	// Telegram retries on non-2xx answers, so processing errors are only logged
+	if err := a.TelegramService.HandleUpdate(&update); err != nil {
+		logger.Warningf("telegram webhook: update %d failed: %v", update.UpdateID, err)
+	}
+	c.Status(http.StatusOK)
+}
+
+func (a *TelegramWebhookHandler) yookassa(c *gin.Context) {
+	var notification yookassa.Notification
+	if err := c.ShouldBindJSON(&notification); err != nil {
+		logger.Warning("yookassa notification: invalid body:", err)
+		c.Status(http.StatusBadRequest)
+		return
+	}
+	// YooKassa keeps retrying until it gets 200, which is wanted while provisioning fails
+	if err := a.TelegramService.HandleYooKassaNotification(&notification); err != nil {
+		if errors.Is(err, service.ErrPaymentIgnored) {
+			logger.Warningf("yookassa notification %s from %s ignored: %v", notification.Event, getRemoteIp(c), err)
+			c.Status(http.StatusOK)
+			return
+		}
+		logger.Warningf("yookassa notification %s from %s failed: %v", notification.Event, getRemoteIp(c), err)
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// miniAppAuth accepts the mini app initData as "Authorization: tma <initData>".
+func (a *TelegramWebhookHandler) miniAppAuth(c *gin.Context) {
+	initData, ok := strings.CutPrefix(c.GetHeader("Authorization"), "tma ")
+	if !ok {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	user, err := a.TelegramService.ValidateMiniAppInitData(initData)
+	if err != nil {
+		logger.Warningf("telegram mini app: rejected request from %s: %v", getRemoteIp(c), err)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, Msg{Msg: err.Error()})
+		return
+	}
+	c.Set("miniAppUser", user)
+	c.Next()
+}
+
+func miniAppUser(c *gin.Context) *botapi.User {
+	return c.MustGet("miniAppUser").(*botapi.User)
+}
+
+func (a *TelegramWebhookHandler) miniAppData(c *gin.Context) {
+	data, err := a.TelegramService.MiniAppData(miniAppUser(c))
+	jsonObj(c, data, err)
+}
+
+func (a *TelegramWebhookHandler) miniAppBuy(c *gin.Context) {
+	var req struct {
+		TariffID uint `json:"tariffId" form:"tariffId"`
+	}
+	if err := c.ShouldBind(&req); err != nil {
+		jsonMsg(c, "", err)
+		return
+	}
+	purchase, err := a.TelegramService.MiniAppBuy(miniAppUser(c), req.TariffID)
+	jsonObj(c, purchase, err)
+}
+
+func (a *TelegramWebhookHandler) miniAppTrial(c *gin.Context) {
+	trial, err := a.TelegramService.ClaimTrial(miniAppUser(c).ID)
+	jsonObj(c, trial, err)
+}
@@ -3,10 +3,17 @@ package core
 import (
 	"context"
 	"net"
+	"sort"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alireza0/s-ui/logger"
 
 	"github.com/gofrs/uuid/v5"
 	"github.com/sagernet/sing-box/adapter"
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
 	"github.com/sagernet/sing/common/network"
 )
 
@@ -16,19 +23,150 @@ type ConnectionInfo struct {
 	PacketConn network.PacketConn
 	Inbound    string
 	Type       string // "tcp" or "udp"
+	User       string
+	Source     string // source IP, empty when unknown
+	SourceAddr string
+	Outbound   string
+	// Destination is the requested address and destinationHost its domain or IP without the port
+	Destination     string
+	destinationHost string
+	Start           time.Time
+	up              atomic.Int64
+	down            atomic.Int64
+}
+
+// Connection is a snapshot of a live connection. Up is the traffic read from the client
+// and Down the traffic written to it.
+type Connection struct {
+	ID          string `json:"id"`
+	Inbound     string `json:"inbound"`
+	Outbound    string `json:"outbound"`
+	User        string `json:"user"`
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Network     string `json:"network"`
+	Start       int64  `json:"start"`
+	Up          int64  `json:"up"`
+	Down        int64  `json:"down"`
+}
+
+// ClientIP is a source address of a client, active while it has connections
+// and for the IP limit window after the last one closed.
+type ClientIP struct {
+	IP          string    `json:"ip"`
+	Connections int       `json:"connections"`
+	LastSeen    time.Time `json:"lastSeen"`
+}
+
+type ipActivity struct {
+	connections int
+	lastSeen    time.Time
 }
 
 type ConnTracker struct {
 	access      sync.Mutex
 	connections map[string]*ConnectionInfo
+	userIPs     map[string]map[string]*ipActivity
+	ipLimits    map[string]int
+	ipWindow    time.Duration
+	// rejected throttles the log of rejected connections to one per user and IP in a window
+	rejected    map[string]time.Time
+	speedLimits map[string]SpeedLimit
+	limiters    map[string]*userLimiter
 }
 
 func NewConnTracker() *ConnTracker {
 	return &ConnTracker{
 		connections: make(map[string]*ConnectionInfo),
+		userIPs:     make(map[string]map[string]*ipActivity),
+		ipLimits:    make(map[string]int),
+		ipWindow:    time.Minute,
+		rejected:    make(map[string]time.Time),
+		speedLimits: make(map[string]SpeedLimit),
+		limiters:    make(map[string]*userLimiter),
 	}
 }
 
+// SetIPLimits replaces the number of distinct source IPs each client may use. An IP
+// stays counted for window after its last connection closed.
+func (c *ConnTracker) SetIPLimits(limits map[string]int, window time.Duration) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.ipLimits = limits
+	c.ipWindow = window
+}
+
+// ClientIPs returns the active source addresses of every client, or of one client when user is set.
+func (c *ConnTracker) ClientIPs(user string) map[string][]ClientIP {
+	c.access.Lock()
+	defer c.access.Unlock()
+	now := time.Now()
+	result := make(map[string][]ClientIP)
+	for name := range c.userIPs {
+		if user != "" && name != user {
+			continue
+		}
+		c.pruneIPs(name, now)
+		for ip, activity := range c.userIPs[name] {
+			result[name] = append(result[name], ClientIP{IP: ip, Connections: activity.connections, LastSeen: activity.lastSeen})
+		}
+		sort.Slice(result[name], func(i, j int) bool { return result[name][i].IP < result[name][j].IP })
+	}
+	return result
+}
+
+// pruneIPs forgets the addresses without connections whose window passed, the caller holds the lock.
+func (c *ConnTracker) pruneIPs(user string, now time.Time) {
+	ips := c.userIPs[user]
+	for ip, activity := range ips {
+		if activity.connections == 0 && now.Sub(activity.lastSeen) > c.ipWindow {
+			delete(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		delete(c.userIPs, user)
+	}
+}
+
+// allowSource counts a new connection of the user from the source, unless the source would
+// exceed the IP limit of the user.
+func (c *ConnTracker) allowSource(connInfo *ConnectionInfo) bool {
+	if connInfo.User == "" || connInfo.Source == "" {
+		return true
+	}
+	c.access.Lock()
+	defer c.access.Unlock()
+	now := time.Now()
+	c.pruneIPs(connInfo.User, now)
+	ips, ok := c.userIPs[connInfo.User]
+	if !ok {
+		ips = make(map[string]*ipActivity)
+		c.userIPs[connInfo.User] = ips
+	}
+	activity, ok := ips[connInfo.Source]
+	if !ok {
+		limit := c.ipLimits[connInfo.User]
+		if limit > 0 && len(ips) >= limit {
+			key := connInfo.User + " " + connInfo.Source
+			if now.Sub(c.rejected[key]) > c.ipWindow {
+				for k, at := range c.rejected {
+					if now.Sub(at) > c.ipWindow {
+						delete(c.rejected, k)
+					}
+				}
+				c.rejected[key] = now
+				logger.Warningf("client %s exceeded its limit of %d IPs, rejected connection from %s", connInfo.User, limit, connInfo.Source)
+			}
+			return false
+		}
+		activity = &ipActivity{}
+		ips[connInfo.Source] = activity
+	}
+	activity.connections++
+	activity.lastSeen = now
+	return true
+}
+
 func (c *ConnTracker) generateConnectionID() string {
 	return uuid.Must(uuid.NewV4()).String()
 }
@@ -40,11 +178,23 @@ func (c *ConnTracker) RoutedConnection(ctx context.Context, conn net.Conn, metad
 		Conn:    conn,
 		Inbound: metadata.Inbound,
 		Type:    "tcp",
+		User:    metadata.User,
+		Source:  sourceIP(metadata),
 	}
+	c.describeConnection(connInfo, metadata, matchOutbound)
 
+	if !c.allowSource(connInfo) {
+		// The outbound fails on the closed connection
+		conn.Close()
+		return conn
+	}
+	if limiter := c.userLimiter(metadata.User); limiter != nil {
+		conn = newLimitedConn(conn, limiter)
+		connInfo.Conn = conn
+	}
 	c.trackConnection(connID, connInfo)
 
-	return c.createWrappedConn(conn, connID)
+	return c.createWrappedConn(conn, connInfo)
 }
 
 func (c *ConnTracker) RoutedPacketConnection(ctx context.Context, conn network.PacketConn, metadata adapter.InboundContext, matchedRule adapter.Rule, matchOutbound adapter.Outbound) network.PacketConn {
@@ -54,27 +204,105 @@ func (c *ConnTracker) RoutedPacketConnection(ctx context.Context, conn network.P
 		PacketConn: conn,
 		Inbound:    metadata.Inbound,
 		Type:       "udp",
+		User:       metadata.User,
+		Source:     sourceIP(metadata),
 	}
+	c.describeConnection(connInfo, metadata, matchOutbound)
 
+	if !c.allowSource(connInfo) {
+		conn.Close()
+		return conn
+	}
+	if limiter := c.userLimiter(metadata.User); limiter != nil {
+		conn = newLimitedPacketConn(conn, limiter)
+		connInfo.PacketConn = conn
+	}
 	c.trackConnection(connID, connInfo)
 
-	return c.createWrappedPacketConn(conn, connID)
+	return c.createWrappedPacketConn(conn, connInfo)
+}
+
+func (c *ConnTracker) describeConnection(connInfo *ConnectionInfo, metadata adapter.InboundContext, matchOutbound adapter.Outbound) {
+	if metadata.Source.IsValid() {
+		connInfo.SourceAddr = metadata.Source.String()
+	}
+	if metadata.Destination.IsValid() {
+		connInfo.Destination = metadata.Destination.String()
+		connInfo.destinationHost = metadata.Destination.AddrString()
+	}
+	if matchOutbound != nil {
+		connInfo.Outbound = matchOutbound.Tag()
+	}
+	connInfo.Start = time.Now()
+}
+
+// Connections returns the live connections, the oldest first.
+func (c *ConnTracker) Connections() []Connection {
+	c.access.Lock()
+	defer c.access.Unlock()
+	result := make([]Connection, 0, len(c.connections))
+	for _, connInfo := range c.connections {
+		result = append(result, Connection{
+			ID:          connInfo.ID,
+			Inbound:     connInfo.Inbound,
+			Outbound:    connInfo.Outbound,
+			User:        connInfo.User,
+			Source:      connInfo.SourceAddr,
+			Destination: connInfo.Destination,
+			Network:     connInfo.Type,
+			Start:       connInfo.Start.Unix(),
+			Up:          connInfo.up.Load(),
+			Down:        connInfo.down.Load(),
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start < result[j].Start
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result
 }
 
 func (c *ConnTracker) CloseConnByInbound(inbound string) int {
+	return c.closeConnections(func(connInfo *ConnectionInfo) bool {
+		return connInfo.Inbound == inbound
+	})
+}
+
+func (c *ConnTracker) CloseConnByID(connID string) int {
+	return c.closeConnections(func(connInfo *ConnectionInfo) bool {
+		return connInfo.ID == connID
+	})
+}
+
+func (c *ConnTracker) CloseConnByUser(user string) int {
+	return c.closeConnections(func(connInfo *ConnectionInfo) bool {
+		return connInfo.User == user
+	})
+}
+
+// CloseConnByDestination closes the connections to an address, with or without its port.
+func (c *ConnTracker) CloseConnByDestination(destination string) int {
+	return c.closeConnections(func(connInfo *ConnectionInfo) bool {
+		return connInfo.Destination == destination || connInfo.destinationHost == destination
+	})
+}
+
+func (c *ConnTracker) closeConnections(match func(*ConnectionInfo) bool) int {
 	c.access.Lock()
 	defer c.access.Unlock()
 
 	closedCount := 0
 	for connID, connInfo := range c.connections {
-		if connInfo.Inbound == inbound {
+		if match(connInfo) {
 			if connInfo.Conn != nil {
 				connInfo.Conn.Close()
 			}
 			if connInfo.PacketConn != nil {
 				connInfo.PacketConn.Close()
 			}
-			delete(c.connections, connID)
+			c.removeConnection(connID)
 			closedCount++
 		}
 	}
@@ -90,30 +318,66 @@ func (c *ConnTracker) trackConnection(connID string, connInfo *ConnectionInfo) {
 func (c *ConnTracker) untrackConnection(connID string) {
 	c.access.Lock()
 	defer c.access.Unlock()
+	c.removeConnection(connID)
+}
+
+// removeConnection forgets a connection and releases its source IP and buckets, the caller holds the lock.
+func (c *ConnTracker) removeConnection(connID string) {
+	connInfo, ok := c.connections[connID]
+	if !ok {
+		return
+	}
 	delete(c.connections, connID)
+	if activity, ok := c.userIPs[connInfo.User][connInfo.Source]; ok {
+		activity.connections--
+		activity.lastSeen = time.Now()
+	}
+	if limiter, ok := c.limiters[connInfo.User]; ok {
+		limiter.conns--
+		c.pruneLimiter(connInfo.User)
+	}
 }
 
-func (c *ConnTracker) createWrappedConn(conn net.Conn, connID string) *wrappedConn {
+func sourceIP(metadata adapter.InboundContext) string {
+	if !metadata.Source.IsIP() {
+		return ""
+	}
+	return metadata.Source.Addr.Unmap().String()
+}
+
+func (c *ConnTracker) createWrappedConn(conn net.Conn, connInfo *ConnectionInfo) *wrappedConn {
 	return &wrappedConn{
-		Conn:   conn,
-		connID: connID,
+		Conn: conn,
+		info: connInfo,
 	}
 }
 
-func (c *ConnTracker) createWrappedPacketConn(conn network.PacketConn, connID string) *wrappedPacketConn {
+func (c *ConnTracker) createWrappedPacketConn(conn network.PacketConn, connInfo *ConnectionInfo) *wrappedPacketConn {
 	return &wrappedPacketConn{
 		PacketConn: conn,
-		connID:     connID,
+		info:       connInfo,
 	}
 }
 
 type wrappedConn struct {
 	net.Conn
-	connID string
+	info *ConnectionInfo
+}
+
+func (w *wrappedConn) Read(p []byte) (int, error) {
+	n, err := w.Conn.Read(p)
+	w.info.up.Add(int64(n))
+	return n, err
+}
+
+func (w *wrappedConn) Write(p []byte) (int, error) {
+	n, err := w.Conn.Write(p)
+	w.info.down.Add(int64(n))
+	return n, err
 }
 
 func (w *wrappedConn) Close() error {
-	connTracker.untrackConnection(w.connID)
+	connTracker.untrackConnection(w.info.ID)
 	return w.Conn.Close()
 }
 
@@ -123,11 +387,28 @@ func (w *wrappedConn) Upstream() any {
 
 type wrappedPacketConn struct {
 	network.PacketConn
-	connID string
+	info *ConnectionInfo
+}
+
+func (w *wrappedPacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
+	destination, err := w.PacketConn.ReadPacket(buffer)
+	if err == nil {
+		w.info.up.Add(int64(buffer.Len()))
+	}
+	return destination, err
+}
+
+func (w *wrappedPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	n := buffer.Len()
+	err := w.PacketConn.WritePacket(buffer, destination)
+	if err == nil {
+		w.info.down.Add(int64(n))
+	}
+	return err
 }
 
 func (w *wrappedPacketConn) Close() error {
-	connTracker.untrackConnection(w.connID)
+	connTracker.untrackConnection(w.info.ID)
 	return w.PacketConn.Close()
 }
 
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"context"
+	"net"
+
+	"github.com/sagernet/sing/common/buf"
+	M "github.com/sagernet/sing/common/metadata"
+	"github.com/sagernet/sing/common/network"
+	"golang.org/x/time/rate"
+)
+
+// SpeedLimit is the throughput of a client in bytes per second, 0 for no limit.
+// Up is the traffic read from the client and Down the traffic written to it.
+type SpeedLimit struct {
+	Up   int64 `json:"up"`
+	Down int64 `json:"down"`
+}
+
+// userLimiter holds the token buckets shared by all connections of a user
+type userLimiter struct {
+	up   *rate.Limiter
+	down *rate.Limiter
+	// conns counts the open connections using the buckets, guarded by the tracker lock
+	conns int
+}
+
+func newUserLimiter() *userLimiter {
+	return &userLimiter{
+		up:   rate.NewLimiter(rate.Inf, 0),
+		down: rate.NewLimiter(rate.Inf, 0),
+	}
+}
+
+func (l *userLimiter) set(limit SpeedLimit) {
+	setRate(l.up, limit.Up)
+	setRate(l.down, limit.Down)
+}
+
+// setRate changes the rate of a bucket in place, so connections that are open follow it.
+// A bucket holds one second of traffic.
+func setRate(limiter *rate.Limiter, bytesPerSecond int64) {
+	if bytesPerSecond <= 0 {
+		limiter.SetLimit(rate.Inf)
+		return
+	}
+	burst := bytesPerSecond
+	if burst > 1<<30 {
+		burst = 1 << 30
+	}
+	limiter.SetBurst(int(burst))
+	limiter.SetLimit(rate.Limit(bytesPerSecond))
+}
+
+// waitBytes takes n tokens from the bucket, in parts when n is more than the bucket holds.
+// The limit may change while waiting, so the part is sized again on each step.
+func waitBytes(ctx context.Context, limiter *rate.Limiter, n int) error {
+	for n > 0 {
+		if limiter.Limit() == rate.Inf {
+			return nil
+		}
+		part := n
+		if burst := limiter.Burst(); part > burst && burst > 0 {
+			part = burst
+		}
+		if err := limiter.WaitN(ctx, part); err != nil {
+			if ctx.Err() == nil && part > limiter.Burst() {
+				// The bucket was made smaller after the part was sized
+				continue
+			}
+			return err
+		}
+		n -= part
+	}
+	return nil
+}
+
+// SetSpeedLimits replaces the speed limits of the clients. Users without an entry are not limited,
+// their buckets are dropped once no connection uses them.
+func (c *ConnTracker) SetSpeedLimits(limits map[string]SpeedLimit) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.speedLimits = limits
+	for user, limiter := range c.limiters {
+		limiter.set(limits[user])
+		c.pruneLimiter(user)
+	}
+}
+
+// pruneLimiter drops the buckets of a user without a limit and without connections,
+// the caller holds the lock.
+func (c *ConnTracker) pruneLimiter(user string) {
+	limiter, ok := c.limiters[user]
+	if !ok || limiter.conns > 0 {
+		return
+	}
+	if _, limited := c.speedLimits[user]; !limited {
+		delete(c.limiters, user)
+	}
+}
+
+// userLimiter returns the buckets of the user for a new connection, created with the current
+// limits on first use, or nil when the user is not limited. The connection is counted until
+// it is removed from the tracker.
+func (c *ConnTracker) userLimiter(user string) *userLimiter {
+	if user == "" {
+		return nil
+	}
+	c.access.Lock()
+	defer c.access.Unlock()
+	limiter, ok := c.limiters[user]
+	if !ok {
+		if limit := c.speedLimits[user]; limit.Up <= 0 && limit.Down <= 0 {
+			return nil
+		}
+		limiter = newUserLimiter()
+		limiter.set(c.speedLimits[user])
+		c.limiters[user] = limiter
+	}
+	limiter.conns++
+	return limiter
+}
+
+// limitedConn throttles a connection to the buckets of its user
+type limitedConn struct {
+	net.Conn
+	limiter *userLimiter
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newLimitedConn(conn net.Conn, limiter *userLimiter) *limitedConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &limitedConn{Conn: conn, limiter: limiter, ctx: ctx, cancel: cancel}
+}
+
+func (c *limitedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		if waitErr := waitBytes(c.ctx, c.limiter.up, n); waitErr != nil && err == nil {
+			err = net.ErrClosed
+		}
+	}
+	return n, err
+}
+
+func (c *limitedConn) Write(p []byte) (int, error) {
+	if err := waitBytes(c.ctx, c.limiter.down, len(p)); err != nil {
+		return 0, net.ErrClosed
+	}
+	return c.Conn.Write(p)
+}
+
+func (c *limitedConn) Close() error {
+	c.cancel()
+	return c.Conn.Close()
+}
+
+func (c *limitedConn) Upstream() any {
+	return c.Conn
+}
+
+// limitedPacketConn throttles a packet connection to the buckets of its user
+type limitedPacketConn struct {
+	network.PacketConn
+	limiter *userLimiter
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func newLimitedPacketConn(conn network.PacketConn, limiter *userLimiter) *limitedPacketConn {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &limitedPacketConn{PacketConn: conn, limiter: limiter, ctx: ctx, cancel: cancel}
+}
+
+func (c *limitedPacketConn) ReadPacket(buffer *buf.Buffer) (M.Socksaddr, error) {
+	destination, err := c.PacketConn.ReadPacket(buffer)
+	if err != nil {
+		return destination, err
+	}
+	if err = waitBytes(c.ctx, c.limiter.up, buffer.Len()); err != nil {
+		return destination, net.ErrClosed
+	}
+	return destination, nil
+}
+
+func (c *limitedPacketConn) WritePacket(buffer *buf.Buffer, destination M.Socksaddr) error {
+	if err := waitBytes(c.ctx, c.limiter.down, buffer.Len()); err != nil {
+		buffer.Release()
+		return net.ErrClosed
+	}
+	return c.PacketConn.WritePacket(buffer, destination)
+}
+
+func (c *limitedPacketConn) Close() error {
+	c.cancel()
+	return c.PacketConn.Close()
+}
+
+func (c *limitedPacketConn) Upstream() any {
+	return c.PacketConn
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sagernet/sing-box/adapter"
+)
+
+func newTestTracker(limits map[string]SpeedLimit) *ConnTracker {
+	connTracker = NewConnTracker()
+	connTracker.SetSpeedLimits(limits)
+	return connTracker
+}
+
+// routedPipe returns the tracked server side of a pipe for the user, and the client side
+func routedPipe(tracker *ConnTracker, user string) (net.Conn, net.Conn) {
+	server, client := net.Pipe()
+	metadata := adapter.InboundContext{Inbound: "test", User: user}
+	return tracker.RoutedConnection(context.Background(), server, metadata, nil, nil), client
+}
+
+func TestSpeedLimitDown(t *testing.T) {
+	tracker := newTestTracker(map[string]SpeedLimit{"user": {Down: 32 << 10}})
+	conn, client := routedPipe(tracker, "user")
+	defer conn.Close()
+	go io.Copy(io.Discard, client)
+
+	// A full bucket passes at once, the next 16KB take half a second
+	start := time.Now()
+	if _, err := conn.Write(make([]byte, 48<<10)); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("writing 48KB at 32KB/s took %v", elapsed)
+	}
+}
+
+func TestSpeedLimitUpSharedByConnections(t *testing.T) {
+	tracker := newTestTracker(map[string]SpeedLimit{"user": {Up: 32 << 10}})
+	start := time.Now()
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		conn, client := routedPipe(tracker, "user")
+		defer conn.Close()
+		go client.Write(make([]byte, 24<<10))
+		go func() {
+			_, err := io.ReadFull(conn, make([]byte, 24<<10))
+			done <- err
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each connection alone fits in the bucket, together they wait for 16KB
+	elapsed := time.Since(start)
+	if elapsed < 400*time.Millisecond || elapsed > 2*time.Second {
+		t.Fatalf("reading 2x24KB at a shared 32KB/s took %v", elapsed)
+	}
+}
+
+func TestSpeedLimitChangedAtRuntime(t *testing.T) {
+	tracker := newTestTracker(map[string]SpeedLimit{"user": {Down: 1 << 10}})
+	conn, client := routedPipe(tracker, "user")
+	defer conn.Close()
+	go io.Copy(io.Discard, client)
+
+	done := make(chan error, 1)
+	go func() {
+		// About a minute at 1KB/s
+		_, err := conn.Write(make([]byte, 64<<10))
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	tracker.SetSpeedLimits(nil)
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("open connection did not follow the removed limit")
+	}
+}
+
+func TestSpeedLimitCloseStopsWaiting(t *testing.T) {
+	tracker := newTestTracker(map[string]SpeedLimit{"user": {Down: 1 << 10}})
+	conn, client := routedPipe(tracker, "user")
+	go io.Copy(io.Discard, client)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(make([]byte, 64<<10))
+		done <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("write on a closed connection succeeded")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("write kept waiting after close")
+	}
+	if len(tracker.connections) != 0 {
+		t.Fatalf("%d connections still tracked", len(tracker.connections))
+	}
+}
+
+func TestSpeedLimitOtherUsers(t *testing.T) {
+	tracker := newTestTracker(map[string]SpeedLimit{"user": {Up: 1 << 10, Down: 1 << 10}})
+	for _, user := range []string{"other", ""} {
+		conn, client := routedPipe(tracker, user)
+		go io.Copy(io.Discard, client)
+		start := time.Now()
+		if _, err := conn.Write(make([]byte, 1<<20)); err != nil {
+			t.Fatal(err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("unlimited user %q took %v for 1MB", user, elapsed)
+		}
+		for _, connInfo := range tracker.connections {
+			if _, limited := connInfo.Conn.(*limitedConn); limited {
+				t.Fatalf("connection of unlimited user %q is throttled", user)
+			}
+		}
+		conn.Close()
+	}
+}
+
+func TestSpeedLimitBucketsPruned(t *testing.T) {
+	tracker := newTestTracker(map[string]SpeedLimit{"user": {Down: 1 << 10}, "gone": {Down: 1 << 10}})
+	conn, _ := routedPipe(tracker, "user")
+	other, _ := routedPipe(tracker, "gone")
+	other.Close()
+	free, _ := routedPipe(tracker, "free")
+	free.Close()
+	if _, ok := tracker.limiters["free"]; ok {
+		t.Fatal("buckets of an unlimited user kept after its last connection")
+	}
+
+	// The limits of both users are removed, only the open connection keeps its buckets
+	tracker.SetSpeedLimits(nil)
+	if len(tracker.limiters) != 1 || tracker.limiters["user"] == nil {
+		t.Fatalf("buckets kept for %v", tracker.limiters)
+	}
+	conn.Close()
+	if len(tracker.limiters) != 0 {
+		t.Fatalf("buckets kept for %v after the last connection", tracker.limiters)
+	}
+}
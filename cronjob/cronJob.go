@@ -23,12 +23,20 @@ func (c *CronJob) Start(loc *time.Location, trafficAge int) error {
 		c.cron.AddJob("@every 10s", NewStatsJob(trafficAge > 0))
 		// Start expiry job
 		c.cron.AddJob("@every 1m", NewDepleteJob())
+		// Start traffic reset job
+		c.cron.AddJob("@every 1m", NewTrafficResetJob())
 		// Start deleting old stats
 		if trafficAge > 0 {
 			c.cron.AddJob("@daily", NewDelStatsJob(trafficAge))
 		}
 		// Start core if it is not running
 		c.cron.AddJob("@every 5s", NewCheckCoreJob())
+		// Send scheduled telegram broadcasts
+		c.cron.AddJob("@every 30s", NewTelegramBroadcastJob())
+		// Retry the support attachments that failed to download
+		c.cron.AddJob("@every 1m", NewTelegramAttachmentJob())
+		// Send the daily summary to telegram admin chats
+		c.cron.AddJob("@daily", NewTelegramAdminSummaryJob())
 	}()
 
 	return nil
@@ -1,6 +1,8 @@
 package cronjob
 
 import (
+	"time"
+
 	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/logger"
 	"github.com/alireza0/s-ui/service"
@@ -9,6 +11,7 @@ import (
 type DepleteJob struct {
 	service.ClientService
 	service.InboundService
+	service.TelegramService
 }
 
 func NewDepleteJob() *DepleteJob {
@@ -16,15 +19,19 @@ func NewDepleteJob() *DepleteJob {
 }
 
 func (s *DepleteJob) Run() {
-	inboundIds, err := s.ClientService.DepleteClients()
+	inboundIds, disabled, err := s.ClientService.DepleteClients()
 	if err != nil {
 		logger.Warning("Disable depleted users failed: ", err)
 		return
 	}
+	s.TelegramService.NotifyClientsDisabled(disabled, time.Now().Unix())
 	if len(inboundIds) > 0 {
 		err := s.InboundService.RestartInbounds(database.GetDB(), inboundIds)
 		if err != nil {
 			logger.Error("unable to restart inbounds: ", err)
 		}
 	}
+	if err := s.TelegramService.SendReminders(); err != nil {
+		logger.Warning("Send telegram reminders failed: ", err)
+	}
 }
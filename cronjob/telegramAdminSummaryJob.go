@@ -0,0 +1,21 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type TelegramAdminSummaryJob struct {
+	service.TelegramService
+}
+
+func NewTelegramAdminSummaryJob() *TelegramAdminSummaryJob {
+	return new(TelegramAdminSummaryJob)
+}
+
+func (s *TelegramAdminSummaryJob) Run() {
+	err := s.TelegramService.SendAdminDailySummary()
+	if err != nil {
+		logger.Warning("Sending telegram admin summary failed: ", err)
+	}
+}
@@ -0,0 +1,21 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type TelegramAttachmentJob struct {
+	service.TelegramService
+}
+
+func NewTelegramAttachmentJob() *TelegramAttachmentJob {
+	return new(TelegramAttachmentJob)
+}
+
+func (s *TelegramAttachmentJob) Run() {
+	err := s.TelegramService.DownloadPendingAttachments()
+	if err != nil {
+		logger.Warning("Downloading telegram support attachments failed: ", err)
+	}
+}
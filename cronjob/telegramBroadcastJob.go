@@ -0,0 +1,21 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type TelegramBroadcastJob struct {
+	service.TelegramService
+}
+
+func NewTelegramBroadcastJob() *TelegramBroadcastJob {
+	return new(TelegramBroadcastJob)
+}
+
+func (s *TelegramBroadcastJob) Run() {
+	err := s.TelegramService.DispatchScheduledBroadcasts()
+	if err != nil {
+		logger.Warning("Dispatching scheduled telegram broadcasts failed: ", err)
+	}
+}
@@ -0,0 +1,30 @@
+package cronjob
+
+import (
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+)
+
+type TrafficResetJob struct {
+	service.ClientService
+	service.InboundService
+}
+
+func NewTrafficResetJob() *TrafficResetJob {
+	return new(TrafficResetJob)
+}
+
+func (s *TrafficResetJob) Run() {
+	inboundIds, err := s.ClientService.ResetTraffic()
+	if err != nil {
+		logger.Warning("Reset client traffic failed: ", err)
+		return
+	}
+	if len(inboundIds) > 0 {
+		err := s.InboundService.RestartInbounds(database.GetDB(), inboundIds)
+		if err != nil {
+			logger.Error("unable to restart inbounds: ", err)
+		}
+	}
+}
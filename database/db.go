@@ -31,6 +31,21 @@ func initUser() error {
 	return nil
 }
 
+// initTelegramConversations creates the ticket of every user who wrote to support: open when
+// there are unread messages, closed otherwise. Bot commands are not support messages.
+func initTelegramConversations() error {
+	return db.Exec(`INSERT INTO telegram_conversations
+		(user_id, status, assignee, tags, unread, last_message_id, last_message_at, created_at, updated_at)
+		SELECT user_id,
+			CASE WHEN SUM(direction = 'inbound' AND seen = 0) > 0 THEN 'open' ELSE 'closed' END,
+			'', '[]',
+			SUM(direction = 'inbound' AND seen = 0),
+			MAX(id), MAX(created_at), MIN(created_at), MAX(created_at)
+		FROM telegram_user_messages
+		WHERE NOT (direction = 'inbound' AND body LIKE '/%')
+		GROUP BY user_id`).Error
+}
+
 func OpenDB(dbPath string) error {
 	dir := path.Dir(dbPath)
 	err := os.MkdirAll(dir, 01740)
@@ -72,6 +87,9 @@ func InitDB(dbPath string) error {
 		db.Create(&defaultOutbound)
 	}
 
+	// Messages received before support tickets get their conversations once
+	newConversations := !db.Migrator().HasTable(&model.TelegramConversation{})
+
 	err = db.AutoMigrate(
 		&model.Setting{},
 		&model.Tls{},
@@ -83,19 +101,36 @@ func InitDB(dbPath string) error {
 		&model.Tokens{},
 		&model.Stats{},
 		&model.Client{},
+		&model.ClientTrafficHistory{},
 		&model.Changes{},
 		&model.TelegramBotConfig{},
 		&model.TelegramTariff{},
 		&model.TelegramTariffButton{},
 		&model.TelegramUserProfile{},
+		&model.TelegramUserClient{},
 		&model.TelegramUserMessage{},
 		&model.TelegramBroadcast{},
 		&model.TelegramBroadcastDelivery{},
 		&model.TelegramPromoCode{},
+		&model.TelegramPromoRedemption{},
+		&model.TelegramPayment{},
+		&model.TelegramReminder{},
+		&model.TelegramReferralReward{},
+		&model.TelegramTrial{},
+		&model.TelegramPaymentEvent{},
+		&model.TelegramMessageTemplate{},
+		&model.TelegramConversation{},
+		&model.TelegramCannedReply{},
 	)
 	if err != nil {
 		return err
 	}
+	if newConversations {
+		err = initTelegramConversations()
+		if err != nil {
+			return err
+		}
+	}
 	err = initUser()
 	if err != nil {
 		return err
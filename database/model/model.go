@@ -35,6 +35,32 @@ type Client struct {
 	Up       int64           `json:"up" form:"up"`
 	Desc     string          `json:"desc" form:"desc"`
 	Group    string          `json:"group" form:"group"`
+	// ResetPolicy zeroes Up and Down every period: daily, weekly, monthly or days, empty for never.
+	// ResetDay is the weekday (0 is Sunday) for weekly, the day of month for monthly and
+	// the length of the period for days, counted from CreatedAt. LastReset is the start of the
+	// current period.
+	ResetPolicy string `json:"resetPolicy" form:"resetPolicy"`
+	ResetDay    int    `json:"resetDay" form:"resetDay"`
+	LastReset   int64  `json:"lastReset" form:"lastReset"`
+	// CreatedAt is when the client was added, 0 for clients added before it was kept
+	CreatedAt int64 `json:"createdAt" form:"createdAt"`
+	// IPLimit is the number of distinct source IPs the client may connect from, 0 for no limit
+	IPLimit int `json:"ipLimit" form:"ipLimit"`
+	// UpLimit and DownLimit cap the speed of the client in bytes per second, 0 for no limit
+	UpLimit   int64 `json:"upLimit" form:"upLimit"`
+	DownLimit int64 `json:"downLimit" form:"downLimit"`
+}
+
+// ClientTrafficHistory keeps the usage of a client in a period ended by a traffic reset
+type ClientTrafficHistory struct {
+	Id          uint64 `json:"id" gorm:"primaryKey;autoIncrement"`
+	ClientId    uint   `json:"clientId" gorm:"index"`
+	ClientName  string `json:"clientName"`
+	PeriodStart int64  `json:"periodStart"`
+	PeriodEnd   int64  `json:"periodEnd"`
+	Up          int64  `json:"up"`
+	Down        int64  `json:"down"`
+	Volume      int64  `json:"volume"`
 }
 
 type Stats struct {
@@ -9,14 +9,33 @@ type TelegramBotConfig struct {
 	ID                 uint      `json:"id" gorm:"primaryKey;autoIncrement"`
 	Enabled            bool      `json:"enabled"`
 	BotToken           string    `json:"botToken"`
+	APIBaseURL         string    `json:"apiBaseUrl"`
 	WebhookDomain      string    `json:"webhookDomain"`
 	WebhookSecret      string    `json:"webhookSecret"`
 	YooKassaShopID     string    `json:"yooKassaShopId"`
 	YooKassaSecretKey  string    `json:"yooKassaSecretKey"`
+	YooKassaAPIURL     string    `json:"yooKassaApiUrl"`
 	SuccessRedirectURL string    `json:"successRedirectUrl"`
 	FailureRedirectURL string    `json:"failureRedirectUrl"`
 	MiniAppURL         string    `json:"miniAppUrl"`
 	DownloadLinks      string    `json:"downloadLinks" gorm:"type:text"`
+	RemindersEnabled   bool      `json:"remindersEnabled"`
+	ReminderDays       string    `json:"reminderDays" gorm:"type:text"`
+	ReminderVolume     string    `json:"reminderVolume" gorm:"type:text"`
+	ReferralEnabled    bool      `json:"referralEnabled"`
+	ReferralReward     string    `json:"referralReward"`
+	ReferralDays       int       `json:"referralDays"`
+	ReferralPercent    int       `json:"referralPercent"`
+	TrialEnabled       bool      `json:"trialEnabled"`
+	TrialInbounds      string    `json:"trialInbounds" gorm:"type:text"`
+	TrialDays          int       `json:"trialDays"`
+	TrialVolume        int64     `json:"trialVolume"`
+	TrialMinAccountAge int       `json:"trialMinAccountAge"`
+	DefaultLanguage    string    `json:"defaultLanguage"`
+	AdminChatIDs       string    `json:"adminChatIds" gorm:"type:text"`
+	AdminEvents        string    `json:"adminEvents" gorm:"type:text"`
+	AdminUserIDs       string    `json:"adminUserIds" gorm:"type:text"`
+	UpdateOffset       int64     `json:"-"`
 	UpdatedAt          time.Time `json:"updatedAt"`
 	CreatedAt          time.Time `json:"createdAt"`
 }
@@ -28,9 +47,11 @@ type TelegramTariff struct {
 	PriceMinor   int64                  `json:"priceMinor"`
 	Currency     string                 `json:"currency"`
 	DurationDays int                    `json:"durationDays"`
+	Volume       int64                  `json:"volume"`
+	Inbounds     json.RawMessage        `json:"inbounds"`
 	SortOrder    int                    `json:"sortOrder"`
 	Active       bool                   `json:"active"`
-	Buttons      []TelegramTariffButton `json:"buttons" gorm:"constraint:OnDelete:CASCADE;"`
+	Buttons      []TelegramTariffButton `json:"buttons" gorm:"foreignKey:TariffID;constraint:OnDelete:CASCADE;"`
 	CreatedAt    time.Time              `json:"createdAt"`
 	UpdatedAt    time.Time              `json:"updatedAt"`
 }
@@ -47,46 +68,102 @@ type TelegramTariffButton struct {
 }
 
 type TelegramUserProfile struct {
-	ID                    uint                        `json:"id" gorm:"primaryKey;autoIncrement"`
-	TelegramID            int64                       `json:"telegramId" gorm:"uniqueIndex"`
-	Username              string                      `json:"username"`
-	FirstName             string                      `json:"firstName"`
-	LastName              string                      `json:"lastName"`
-	Language              string                      `json:"language"`
-	Notes                 string                      `json:"notes" gorm:"type:text"`
-	EverPaid              bool                        `json:"everPaid"`
-	ActiveSubscription    bool                        `json:"activeSubscription"`
-	SubscriptionExpiresAt *time.Time                  `json:"subscriptionExpiresAt"`
-	LastTariffID          *uint                       `json:"lastTariffId"`
-	LastInteractionAt     time.Time                   `json:"lastInteractionAt"`
-	CreatedAt             time.Time                   `json:"createdAt"`
-	UpdatedAt             time.Time                   `json:"updatedAt"`
-	Messages              []TelegramUserMessage       `json:"messages" gorm:"constraint:OnDelete:CASCADE;"`
-	BroadcastDeliveries   []TelegramBroadcastDelivery `json:"deliveries" gorm:"constraint:OnDelete:CASCADE;"`
+	ID                  uint                        `json:"id" gorm:"primaryKey;autoIncrement"`
+	TelegramID          int64                       `json:"telegramId" gorm:"uniqueIndex"`
+	Username            string                      `json:"username"`
+	FirstName           string                      `json:"firstName"`
+	LastName            string                      `json:"lastName"`
+	Language            string                      `json:"language"`
+	Notes               string                      `json:"notes" gorm:"type:text"`
+	EverPaid            bool                        `json:"everPaid"`
+	LastTariffID        *uint                       `json:"lastTariffId"`
+	ReferralCode        string                      `json:"referralCode" gorm:"index"`
+	ReferredByID        *uint                       `json:"referredById" gorm:"index"`
+	ReferredAt          *time.Time                  `json:"referredAt"`
+	LastInteractionAt   time.Time                   `json:"lastInteractionAt"`
+	CreatedAt           time.Time                   `json:"createdAt"`
+	UpdatedAt           time.Time                   `json:"updatedAt"`
+	Clients             []TelegramUserClient        `json:"clients" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	Messages            []TelegramUserMessage       `json:"messages" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+	BroadcastDeliveries []TelegramBroadcastDelivery `json:"deliveries" gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE;"`
+}
+
+// TelegramUserClient links a Telegram profile to a panel client, a client belongs to one profile at most.
+type TelegramUserClient struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID    uint      `json:"userId" gorm:"index"`
+	ClientID  uint      `json:"clientId" gorm:"uniqueIndex"`
+	CreatedAt time.Time `json:"createdAt"`
 }
 
 type TelegramUserMessage struct {
-	ID                uint      `json:"id" gorm:"primaryKey;autoIncrement"`
-	UserID            uint      `json:"userId" gorm:"index"`
-	Direction         string    `json:"direction" gorm:"index"`
-	Body              string    `json:"body" gorm:"type:text"`
-	TelegramMessageID string    `json:"telegramMessageId"`
-	Seen              bool      `json:"seen"`
-	CreatedAt         time.Time `json:"createdAt"`
-	UpdatedAt         time.Time `json:"updatedAt"`
+	ID                uint   `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID            uint   `json:"userId" gorm:"index"`
+	Direction         string `json:"direction" gorm:"index"`
+	Body              string `json:"body" gorm:"type:text"`
+	TelegramMessageID string `json:"telegramMessageId"`
+	Seen              bool   `json:"seen"`
+	// Author is the panel user who wrote an outbound reply
+	Author string `json:"author"`
+	// AttachmentType is "photo" or "document" when the user sent a file, AttachmentFile is
+	// its name in the attachment folder once downloaded from Telegram, AttachmentError the
+	// reason of the last failed download
+	AttachmentType   string    `json:"attachmentType"`
+	AttachmentName   string    `json:"attachmentName"`
+	AttachmentFileID string    `json:"-"`
+	AttachmentFile   string    `json:"-"`
+	AttachmentError  string    `json:"attachmentError"`
+	CreatedAt        time.Time `json:"createdAt"`
+	UpdatedAt        time.Time `json:"updatedAt"`
+}
+
+// TelegramConversation is the support ticket of a profile. It keeps the state of the
+// message history, so conversations can be listed without reading the messages.
+type TelegramConversation struct {
+	ID            uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID        uint       `json:"userId" gorm:"uniqueIndex"`
+	Status        string     `json:"status" gorm:"index"`
+	Assignee      string     `json:"assignee" gorm:"index"`
+	Tags          string     `json:"tags" gorm:"type:text"`
+	Unread        int        `json:"unread"`
+	LastMessageID uint       `json:"lastMessageId"`
+	LastMessageAt time.Time  `json:"lastMessageAt" gorm:"index"`
+	ClosedAt      *time.Time `json:"closedAt"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	UpdatedAt     time.Time  `json:"updatedAt"`
+}
+
+// TelegramCannedReply is a prepared answer admins pick when replying to a conversation.
+type TelegramCannedReply struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Title     string    `json:"title"`
+	Body      string    `json:"body" gorm:"type:text"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
 }
 
 type TelegramBroadcast struct {
-	ID         uint                        `json:"id" gorm:"primaryKey;autoIncrement"`
-	Title      string                      `json:"title"`
-	Body       string                      `json:"body" gorm:"type:text"`
-	Editable   bool                        `json:"editable"`
-	Status     string                      `json:"status"`
-	Audience   string                      `json:"audience" gorm:"type:text"`
-	SentAt     *time.Time                  `json:"sentAt"`
-	CreatedAt  time.Time                   `json:"createdAt"`
-	UpdatedAt  time.Time                   `json:"updatedAt"`
-	Deliveries []TelegramBroadcastDelivery `json:"deliveries" gorm:"constraint:OnDelete:CASCADE;"`
+	ID          uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	Title       string     `json:"title"`
+	Body        string     `json:"body" gorm:"type:text"`
+	ParseMode   string     `json:"parseMode"`
+	MediaType   string     `json:"mediaType"`
+	MediaFile   string     `json:"mediaFile"`
+	MediaName   string     `json:"mediaName"`
+	MediaFileID string     `json:"mediaFileId"`
+	Buttons     string     `json:"buttons" gorm:"type:text"`
+	Editable    bool       `json:"editable"`
+	Status      string     `json:"status"`
+	Audience    string     `json:"audience" gorm:"type:text"`
+	SentAt      *time.Time `json:"sentAt"`
+	ScheduledAt *time.Time `json:"scheduledAt" gorm:"index"`
+	Recurrence  string     `json:"recurrence"`
+	ParentID    *uint      `json:"parentId" gorm:"index"`
+	// EditRevision counts the edits of the sent messages
+	EditRevision uint                        `json:"editRevision"`
+	CreatedAt    time.Time                   `json:"createdAt"`
+	UpdatedAt    time.Time                   `json:"updatedAt"`
+	Deliveries   []TelegramBroadcastDelivery `json:"deliveries" gorm:"foreignKey:BroadcastID;constraint:OnDelete:CASCADE;"`
 }
 
 type TelegramBroadcastDelivery struct {
@@ -97,8 +174,13 @@ type TelegramBroadcastDelivery struct {
 	Status            string     `json:"status"`
 	ErrorMessage      string     `json:"errorMessage" gorm:"type:text"`
 	SentAt            *time.Time `json:"sentAt"`
-	CreatedAt         time.Time  `json:"createdAt"`
-	UpdatedAt         time.Time  `json:"updatedAt"`
+	// EditStatus is the state of the last edit of the sent message, EditRevision the revision
+	// of the broadcast it was queued for and EditMedia whether the media is replaced as well
+	EditStatus   string    `json:"editStatus" gorm:"index"`
+	EditRevision uint      `json:"editRevision"`
+	EditMedia    bool      `json:"editMedia"`
+	CreatedAt    time.Time `json:"createdAt"`
+	UpdatedAt    time.Time `json:"updatedAt"`
 }
 
 type TelegramPromoCode struct {
@@ -115,6 +197,63 @@ type TelegramPromoCode struct {
 	UpdatedAt       time.Time  `json:"updatedAt"`
 }
 
+// TelegramPromoRedemption records that a user redeemed a code, each user may redeem a code once.
+// A discount stays available until a payment uses it.
+type TelegramPromoRedemption struct {
+	ID              uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	PromoCodeID     uint      `json:"promoCodeId" gorm:"uniqueIndex:idx_promo_redemption_user"`
+	UserID          uint      `json:"userId" gorm:"uniqueIndex:idx_promo_redemption_user"`
+	DiscountPercent int       `json:"discountPercent"`
+	FreeDays        int       `json:"freeDays"`
+	PaymentID       *uint     `json:"paymentId" gorm:"index"`
+	CreatedAt       time.Time `json:"createdAt"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+type TelegramPayment struct {
+	ID                uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID            uint       `json:"userId" gorm:"index"`
+	TariffID          uint       `json:"tariffId" gorm:"index"`
+	ProviderPaymentID string     `json:"providerPaymentId" gorm:"index"`
+	IdempotenceKey    string     `json:"-" gorm:"uniqueIndex"`
+	AmountMinor       int64      `json:"amountMinor"`
+	PromoCodeID       *uint      `json:"promoCodeId"`
+	Currency          string     `json:"currency"`
+	Status            string     `json:"status" gorm:"index"`
+	ConfirmationURL   string     `json:"confirmationUrl"`
+	ClientID          *uint      `json:"clientId"`
+	ErrorMessage      string     `json:"errorMessage" gorm:"type:text"`
+	PaidAt            *time.Time `json:"paidAt"`
+	ProvisionedAt     *time.Time `json:"provisionedAt"`
+	RefundedMinor     int64      `json:"refundedMinor"`
+	RefundedAt        *time.Time `json:"refundedAt"`
+	CreatedAt         time.Time  `json:"createdAt"`
+	UpdatedAt         time.Time  `json:"updatedAt"`
+}
+
+// TelegramPaymentEvent is the history of a payment: status transitions and refunds.
+type TelegramPaymentEvent struct {
+	ID          uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	PaymentID   uint      `json:"paymentId" gorm:"index"`
+	FromStatus  string    `json:"fromStatus"`
+	Status      string    `json:"status"`
+	AmountMinor int64     `json:"amountMinor"`
+	Actor       string    `json:"actor"`
+	Note        string    `json:"note" gorm:"type:text"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// TelegramReminder marks a reminder as sent. It is removed when its condition stops holding,
+// e.g. after a renewal, so the same threshold is reminded again in the next period.
+type TelegramReminder struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	UserID    uint      `json:"userId" gorm:"index"`
+	ClientID  uint      `json:"clientId" gorm:"uniqueIndex:idx_reminder_threshold"`
+	Kind      string    `json:"kind" gorm:"uniqueIndex:idx_reminder_threshold"`
+	Threshold int       `json:"threshold" gorm:"uniqueIndex:idx_reminder_threshold"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
 func (c *TelegramBotConfig) GetDownloadLinks() map[string]string {
 	links := map[string]string{}
 	if c.DownloadLinks == "" {
@@ -135,3 +274,196 @@ func (c *TelegramBotConfig) SetDownloadLinks(links map[string]string) error {
 	c.DownloadLinks = string(raw)
 	return nil
 }
+
+func (t *TelegramTariff) GetInbounds() []uint {
+	ids := []uint{}
+	if len(t.Inbounds) == 0 {
+		return ids
+	}
+	_ = json.Unmarshal(t.Inbounds, &ids)
+	return ids
+}
+
+func (t *TelegramTariff) SetInbounds(ids []uint) error {
+	if ids == nil {
+		ids = []uint{}
+	}
+	raw, err := json.Marshal(ids)
+	if err != nil {
+		return err
+	}
+	t.Inbounds = raw
+	return nil
+}
+
+// GetReminderDays returns the days before expiry to remind at
+func (c *TelegramBotConfig) GetReminderDays() []int {
+	return decodeIntList(c.ReminderDays)
+}
+
+func (c *TelegramBotConfig) SetReminderDays(days []int) error {
+	raw, err := encodeIntList(days)
+	c.ReminderDays = raw
+	return err
+}
+
+// GetReminderVolume returns the used volume percentages to remind at
+func (c *TelegramBotConfig) GetReminderVolume() []int {
+	return decodeIntList(c.ReminderVolume)
+}
+
+func (c *TelegramBotConfig) SetReminderVolume(percents []int) error {
+	raw, err := encodeIntList(percents)
+	c.ReminderVolume = raw
+	return err
+}
+
+// GetTrialInbounds returns the inbounds of trial clients
+func (c *TelegramBotConfig) GetTrialInbounds() []uint {
+	ids := []uint{}
+	if c.TrialInbounds == "" {
+		return ids
+	}
+	_ = json.Unmarshal([]byte(c.TrialInbounds), &ids)
+	return ids
+}
+
+func (c *TelegramBotConfig) SetTrialInbounds(ids []uint) error {
+	if ids == nil {
+		ids = []uint{}
+	}
+	raw, err := json.Marshal(ids)
+	c.TrialInbounds = string(raw)
+	return err
+}
+
+// GetAdminChatIDs returns the chats that receive panel events
+func (c *TelegramBotConfig) GetAdminChatIDs() []int64 {
+	ids := []int64{}
+	if c.AdminChatIDs == "" {
+		return ids
+	}
+	_ = json.Unmarshal([]byte(c.AdminChatIDs), &ids)
+	return ids
+}
+
+func (c *TelegramBotConfig) SetAdminChatIDs(ids []int64) error {
+	if ids == nil {
+		ids = []int64{}
+	}
+	raw, err := json.Marshal(ids)
+	c.AdminChatIDs = string(raw)
+	return err
+}
+
+// GetAdminUserIDs returns the Telegram users allowed to manage clients from the bot
+func (c *TelegramBotConfig) GetAdminUserIDs() []int64 {
+	ids := []int64{}
+	if c.AdminUserIDs == "" {
+		return ids
+	}
+	_ = json.Unmarshal([]byte(c.AdminUserIDs), &ids)
+	return ids
+}
+
+func (c *TelegramBotConfig) SetAdminUserIDs(ids []int64) error {
+	if ids == nil {
+		ids = []int64{}
+	}
+	raw, err := json.Marshal(ids)
+	c.AdminUserIDs = string(raw)
+	return err
+}
+
+// GetAdminEvents returns the panel events sent to the admin chats
+func (c *TelegramBotConfig) GetAdminEvents() []string {
+	events := []string{}
+	if c.AdminEvents == "" {
+		return events
+	}
+	_ = json.Unmarshal([]byte(c.AdminEvents), &events)
+	return events
+}
+
+func (c *TelegramBotConfig) SetAdminEvents(events []string) error {
+	if events == nil {
+		events = []string{}
+	}
+	raw, err := json.Marshal(events)
+	c.AdminEvents = string(raw)
+	return err
+}
+
+// GetTags returns the tags of the conversation, sorted and in lower case
+func (c *TelegramConversation) GetTags() []string {
+	tags := []string{}
+	if c.Tags == "" {
+		return tags
+	}
+	_ = json.Unmarshal([]byte(c.Tags), &tags)
+	return tags
+}
+
+func (c *TelegramConversation) SetTags(tags []string) error {
+	if tags == nil {
+		tags = []string{}
+	}
+	raw, err := json.Marshal(tags)
+	c.Tags = string(raw)
+	return err
+}
+
+func decodeIntList(raw string) []int {
+	list := []int{}
+	if raw == "" {
+		return list
+	}
+	_ = json.Unmarshal([]byte(raw), &list)
+	return list
+}
+
+func encodeIntList(list []int) (string, error) {
+	if list == nil {
+		list = []int{}
+	}
+	raw, err := json.Marshal(list)
+	return string(raw), err
+}
+
+// TelegramReferralReward is the ledger of rewards for invited users, a referee earns its referrer one reward.
+type TelegramReferralReward struct {
+	ID              uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	ReferrerID      uint       `json:"referrerId" gorm:"index"`
+	RefereeID       uint       `json:"refereeId" gorm:"uniqueIndex"`
+	PaymentID       uint       `json:"paymentId"`
+	Kind            string     `json:"kind"`
+	FreeDays        int        `json:"freeDays"`
+	DiscountPercent int        `json:"discountPercent"`
+	PromoCodeID     *uint      `json:"promoCodeId"`
+	Status          string     `json:"status"`
+	ErrorMessage    string     `json:"errorMessage" gorm:"type:text"`
+	GrantedAt       *time.Time `json:"grantedAt"`
+	CreatedAt       time.Time  `json:"createdAt"`
+	UpdatedAt       time.Time  `json:"updatedAt"`
+}
+
+// TelegramTrial records a claimed free trial. It is keyed by the Telegram ID, so a trial
+// cannot be claimed again after the profile is deleted.
+type TelegramTrial struct {
+	ID         uint       `json:"id" gorm:"primaryKey;autoIncrement"`
+	TelegramID int64      `json:"telegramId" gorm:"uniqueIndex"`
+	UserID     uint       `json:"userId" gorm:"index"`
+	ClientID   uint       `json:"clientId"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// TelegramMessageTemplate overrides the built-in text of a bot message in one language.
+type TelegramMessageTemplate struct {
+	ID        uint      `json:"id" gorm:"primaryKey;autoIncrement"`
+	Key       string    `json:"key" gorm:"uniqueIndex:idx_telegram_template_key_language"`
+	Language  string    `json:"language" gorm:"uniqueIndex:idx_telegram_template_key_language"`
+	Text      string    `json:"text" gorm:"type:text"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
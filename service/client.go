@@ -38,7 +38,7 @@ func (s *ClientService) getById(id string) (*[]model.Client, error) {
 func (s *ClientService) GetAll() (*[]model.Client, error) {
 	db := database.GetDB()
 	var clients []model.Client
-	err := db.Model(model.Client{}).Select("`id`, `enable`, `name`, `desc`, `group`, `inbounds`, `up`, `down`, `volume`, `expiry`").Scan(&clients).Error
+	err := db.Model(model.Client{}).Select("`id`, `enable`, `name`, `desc`, `group`, `inbounds`, `up`, `down`, `volume`, `expiry`, `reset_policy`, `reset_day`, `last_reset`, `ip_limit`, `up_limit`, `down_limit`").Scan(&clients).Error
 	if err != nil {
 		return nil, err
 	}
@@ -56,6 +56,22 @@ func (s *ClientService) Save(tx *gorm.DB, act string, data json.RawMessage, host
 		if err != nil {
 			return nil, err
 		}
+		var oldClient *model.Client
+		if act == "edit" {
+			oldClient = &model.Client{}
+			err = tx.Model(model.Client{}).Where("id = ?", client.Id).First(oldClient).Error
+			if err != nil {
+				return nil, err
+			}
+			client.CreatedAt = oldClient.CreatedAt
+		} else {
+			client.CreatedAt = time.Now().Unix()
+		}
+		err = validateResetPolicy(&client, oldClient, data)
+		if err != nil {
+			return nil, err
+		}
+		keepClientLimits(&client, oldClient, data)
 		err = s.updateLinksWithFixedInbounds(tx, []*model.Client{&client}, hostname)
 		if err != nil {
 			return nil, err
@@ -86,6 +102,13 @@ func (s *ClientService) Save(tx *gorm.DB, act string, data json.RawMessage, host
 		if err != nil {
 			return nil, err
 		}
+		for _, client := range clients {
+			client.CreatedAt = time.Now().Unix()
+			err = validateResetPolicy(client, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
 		err = s.updateLinksWithFixedInbounds(tx, clients, hostname)
 		if err != nil {
 			return nil, err
@@ -113,6 +136,14 @@ func (s *ClientService) Save(tx *gorm.DB, act string, data json.RawMessage, host
 		if err != nil {
 			return nil, err
 		}
+		err = tx.Where("client_id = ?", id).Delete(model.TelegramUserClient{}).Error
+		if err != nil {
+			return nil, err
+		}
+		err = tx.Where("client_id = ?", id).Delete(model.ClientTrafficHistory{}).Error
+		if err != nil {
+			return nil, err
+		}
 	default:
 		return nil, common.NewErrorf("unknown action: %s", act)
 	}
@@ -302,12 +333,11 @@ func (s *ClientService) UpdateLinksByInboundChange(tx *gorm.DB, inbounds *[]mode
 	return nil
 }
 
-func (s *ClientService) DepleteClients() ([]uint, error) {
-	var err error
-	var clients []model.Client
+// DepleteClients disables the clients that used up their traffic or expired, and returns
+// the inbounds to restart and the disabled clients once the change is committed.
+func (s *ClientService) DepleteClients() (inboundIds []uint, clients []model.Client, err error) {
 	var changes []model.Changes
 	var users []string
-	var inboundIds []uint
 
 	now := time.Now().Unix()
 	db := database.GetDB()
@@ -315,15 +345,18 @@ func (s *ClientService) DepleteClients() ([]uint, error) {
 	tx := db.Begin()
 	defer func() {
 		if err == nil {
-			tx.Commit()
+			err = tx.Commit().Error
 		} else {
 			tx.Rollback()
 		}
+		if err != nil {
+			inboundIds, clients = nil, nil
+		}
 	}()
 
 	err = tx.Model(model.Client{}).Where("enable = true AND ((volume >0 AND up+down > volume) OR (expiry > 0 AND expiry < ?))", now).Scan(&clients).Error
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	dt := time.Now().Unix()
@@ -347,16 +380,16 @@ func (s *ClientService) DepleteClients() ([]uint, error) {
 	if len(changes) > 0 {
 		err = tx.Model(model.Client{}).Where("enable = true AND ((volume >0 AND up+down > volume) OR (expiry > 0 AND expiry < ?))", now).Update("enable", false).Error
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		err = tx.Model(model.Changes{}).Create(&changes).Error
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		LastUpdate = dt
 	}
 
-	return inboundIds, nil
+	return inboundIds, clients, nil
 }
 
 func (s *ClientService) findInboundsChanges(tx *gorm.DB, client model.Client) ([]uint, error) {
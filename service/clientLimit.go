@@ -0,0 +1,76 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/alireza0/s-ui/core"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+)
+
+// keepClientLimits keeps the stored limits of a client when a save does not send them,
+// like the panel that does not know about them.
+func keepClientLimits(client *model.Client, old *model.Client, data json.RawMessage) {
+	if old == nil {
+		return
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return
+	}
+	if _, ok := fields["ipLimit"]; !ok {
+		client.IPLimit = old.IPLimit
+	}
+	if _, ok := fields["upLimit"]; !ok {
+		client.UpLimit = old.UpLimit
+	}
+	if _, ok := fields["downLimit"]; !ok {
+		client.DownLimit = old.DownLimit
+	}
+}
+
+// ApplyClientLimits passes the IP and speed limits of the clients to the connection tracker
+// of the running core. Open connections follow the new speed limits, and the buckets of clients
+// that are no longer limited are dropped once their connections close.
+func (s *ClientService) ApplyClientLimits() {
+	if !corePtr.IsRunning() {
+		return
+	}
+	var clients []model.Client
+	err := database.GetDB().Model(model.Client{}).
+		Select("`name`, `ip_limit`, `up_limit`, `down_limit`").
+		Where("ip_limit > 0 or up_limit > 0 or down_limit > 0").
+		Scan(&clients).Error
+	if err != nil {
+		logger.Warning("unable to load client limits: ", err)
+		return
+	}
+	ipLimits := make(map[string]int)
+	speedLimits := make(map[string]core.SpeedLimit)
+	for _, client := range clients {
+		if client.IPLimit > 0 {
+			ipLimits[client.Name] = client.IPLimit
+		}
+		if client.UpLimit > 0 || client.DownLimit > 0 {
+			speedLimits[client.Name] = core.SpeedLimit{Up: client.UpLimit, Down: client.DownLimit}
+		}
+	}
+	settingService := SettingService{}
+	window, err := settingService.GetIPLimitWindow()
+	if err != nil || window < 0 {
+		window = 60
+	}
+	tracker := corePtr.GetInstance().ConnTracker()
+	tracker.SetIPLimits(ipLimits, time.Duration(window)*time.Second)
+	tracker.SetSpeedLimits(speedLimits)
+}
+
+// GetClientIPs returns the current source IPs of the clients, or of one client when name is set.
+func (s *ClientService) GetClientIPs(name string) (map[string][]core.ClientIP, error) {
+	if !corePtr.IsRunning() {
+		return map[string][]core.ClientIP{}, nil
+	}
+	return corePtr.GetInstance().ConnTracker().ClientIPs(name), nil
+}
@@ -0,0 +1,242 @@
+package service
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+// Traffic reset policies of a client
+const (
+	ResetPolicyNone    = ""
+	ResetPolicyDaily   = "daily"
+	ResetPolicyWeekly  = "weekly"
+	ResetPolicyMonthly = "monthly"
+	ResetPolicyDays    = "days"
+)
+
+// validateResetPolicy checks the policy of a client and starts its first period when the
+// policy is new. Saves that do not send a policy keep the stored one.
+func validateResetPolicy(client *model.Client, old *model.Client, data json.RawMessage) error {
+	if old != nil {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err == nil {
+			if _, ok := fields["resetPolicy"]; !ok {
+				client.ResetPolicy, client.ResetDay, client.LastReset = old.ResetPolicy, old.ResetDay, old.LastReset
+			}
+		}
+	}
+	switch client.ResetPolicy {
+	case ResetPolicyNone:
+		client.ResetDay = 0
+		client.LastReset = 0
+		return nil
+	case ResetPolicyDaily:
+		client.ResetDay = 0
+	case ResetPolicyWeekly:
+		if client.ResetDay < 0 || client.ResetDay > 6 {
+			return common.NewError("weekly reset day must be between 0 (Sunday) and 6")
+		}
+	case ResetPolicyMonthly:
+		if client.ResetDay < 1 || client.ResetDay > 31 {
+			return common.NewError("monthly reset day must be between 1 and 31")
+		}
+	case ResetPolicyDays:
+		if client.ResetDay < 1 || client.ResetDay > 3650 {
+			return common.NewError("reset period must be between 1 and 3650 days")
+		}
+	default:
+		return common.NewErrorf("unknown reset policy: %s", client.ResetPolicy)
+	}
+	if old == nil || old.ResetPolicy == ResetPolicyNone || client.LastReset == 0 {
+		client.LastReset = time.Now().Unix()
+	}
+	return nil
+}
+
+// resetBoundary returns the start of the period that contains now, midnight in the panel location
+// for calendar policies. A period of days counts from the creation of the client, or from the time
+// the policy was set for clients without a creation time.
+func resetBoundary(client *model.Client, now time.Time, loc *time.Location) time.Time {
+	now = now.In(loc)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	switch client.ResetPolicy {
+	case ResetPolicyDaily:
+		return today
+	case ResetPolicyWeekly:
+		back := (int(now.Weekday()) - client.ResetDay + 7) % 7
+		return today.AddDate(0, 0, -back)
+	case ResetPolicyMonthly:
+		boundary := monthDay(now.Year(), now.Month(), client.ResetDay, loc)
+		if boundary.After(now) {
+			boundary = monthDay(now.Year(), now.Month()-1, client.ResetDay, loc)
+		}
+		return boundary
+	case ResetPolicyDays:
+		anchor := client.CreatedAt
+		if anchor == 0 {
+			anchor = client.LastReset
+		}
+		start := time.Unix(anchor, 0).In(loc)
+		periods := int(now.Sub(start) / (time.Duration(client.ResetDay) * 24 * time.Hour))
+		boundary := start.AddDate(0, 0, periods*client.ResetDay)
+		// Daylight saving time can move the boundary past now
+		if boundary.After(now) && periods > 0 {
+			boundary = start.AddDate(0, 0, (periods-1)*client.ResetDay)
+		}
+		return boundary
+	}
+	return time.Unix(client.LastReset, 0)
+}
+
+// monthDay is the day of the month at midnight, the last day for months that are too short.
+func monthDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day()
+	if day > last {
+		day = last
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// ResetTraffic starts a new period for the clients whose period ended. The usage of the past
+// period is archived, and clients disabled only because they used their volume are enabled again.
+func (s *ClientService) ResetTraffic() ([]uint, error) {
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+	var clients []model.Client
+	db := database.GetDB()
+	err = db.Model(model.Client{}).Where("reset_policy <> ''").Scan(&clients).Error
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	dt := now.Unix()
+	var inboundIds []uint
+	var changes []model.Changes
+	err = db.Transaction(func(tx *gorm.DB) error {
+		for _, client := range clients {
+			if client.LastReset == 0 {
+				// A policy written directly to the database starts its first period now
+				if err := tx.Model(model.Client{}).Where("id = ?", client.Id).Update("last_reset", dt).Error; err != nil {
+					return err
+				}
+				continue
+			}
+			boundary := resetBoundary(&client, now, loc).Unix()
+			if boundary <= client.LastReset {
+				continue
+			}
+			logger.Debug("Client ", client.Name, " starts a new traffic period")
+			err := tx.Create(&model.ClientTrafficHistory{
+				ClientId:    client.Id,
+				ClientName:  client.Name,
+				PeriodStart: client.LastReset,
+				PeriodEnd:   boundary,
+				Up:          client.Up,
+				Down:        client.Down,
+				Volume:      client.Volume,
+			}).Error
+			if err != nil {
+				return err
+			}
+			// Traffic saved by StatsJob after the clients were read belongs to the new period
+			updates := map[string]interface{}{
+				"up":         gorm.Expr("up - ?", client.Up),
+				"down":       gorm.Expr("down - ?", client.Down),
+				"last_reset": boundary,
+			}
+			changes = append(changes, model.Changes{
+				DateTime: dt,
+				Actor:    "TrafficResetJob",
+				Key:      "clients",
+				Action:   "reset",
+				Obj:      json.RawMessage("\"" + client.Name + "\""),
+			})
+			volumeDepleted := client.Volume > 0 && client.Up+client.Down >= client.Volume
+			expired := client.Expiry > 0 && client.Expiry <= dt
+			reenable := false
+			if !client.Enable && volumeDepleted && !expired {
+				reenable, err = disabledByDeplete(tx, client.Name)
+				if err != nil {
+					return err
+				}
+			}
+			if reenable {
+				updates["enable"] = true
+				var userInbounds []uint
+				json.Unmarshal(client.Inbounds, &userInbounds)
+				inboundIds = common.UnionUintArray(inboundIds, userInbounds)
+				changes = append(changes, model.Changes{
+					DateTime: dt,
+					Actor:    "TrafficResetJob",
+					Key:      "clients",
+					Action:   "enable",
+					Obj:      json.RawMessage("\"" + client.Name + "\""),
+				})
+			}
+			if err := tx.Model(model.Client{}).Where("id = ?", client.Id).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+		if len(changes) == 0 {
+			return nil
+		}
+		return tx.Model(model.Changes{}).Create(&changes).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		LastUpdate = dt
+	}
+	return inboundIds, nil
+}
+
+// disabledByDeplete reports whether the client was last disabled by DepleteJob. A client an admin
+// disabled, or saved as disabled after DepleteJob, stays disabled.
+func disabledByDeplete(tx *gorm.DB, name string) (bool, error) {
+	var changes []model.Changes
+	err := tx.Model(model.Changes{}).
+		Where("`key` = ? AND `action` IN ? AND obj LIKE ?", "clients", []string{"disable", "new", "edit"}, "%"+name+"%").
+		Order("`id` desc").Scan(&changes).Error
+	if err != nil {
+		return false, err
+	}
+	for _, change := range changes {
+		if change.Action == "disable" {
+			if string(change.Obj) == "\""+name+"\"" {
+				return change.Actor == "DepleteJob", nil
+			}
+			continue
+		}
+		var saved struct {
+			Name   string `json:"name"`
+			Enable *bool  `json:"enable"`
+		}
+		if json.Unmarshal(change.Obj, &saved) != nil || saved.Name != name || saved.Enable == nil || *saved.Enable {
+			continue
+		}
+		return false, nil
+	}
+	return false, nil
+}
+
+// GetTrafficHistory returns the archived periods of a client, the most recent first.
+func (s *ClientService) GetTrafficHistory(clientId uint, limit int) ([]model.ClientTrafficHistory, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	history := []model.ClientTrafficHistory{}
+	err := database.GetDB().Where("client_id = ?", clientId).Order("period_end desc").Limit(limit).Find(&history).Error
+	return history, err
+}
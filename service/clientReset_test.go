@@ -0,0 +1,59 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func TestResetTrafficKeepsManualDisable(t *testing.T) {
+	initTestDB(t)
+	db := database.GetDB()
+	for _, name := range []string{"depleted", "manual"} {
+		saveTestClient(t, "new", `{"enable":true,"name":"`+name+`","config":{},"inbounds":[],"links":[],`+
+			`"volume":100,"up":200,"resetPolicy":"daily"}`)
+	}
+	s := ClientService{}
+	if _, _, err := s.DepleteClients(); err != nil {
+		t.Fatal(err)
+	}
+	// The admin saves the depleted client as disabled afterwards
+	manual := loadTestClient(t, "manual")
+	obj, _ := json.Marshal(manual)
+	db.Create(&model.Changes{DateTime: time.Now().Unix(), Actor: "admin", Key: "clients", Action: "edit", Obj: obj})
+
+	db.Exec("UPDATE clients SET last_reset = last_reset - 2*86400")
+	if _, err := s.ResetTraffic(); err != nil {
+		t.Fatal(err)
+	}
+	if client := loadTestClient(t, "depleted"); !client.Enable || client.Up != 0 {
+		t.Fatalf("client disabled by DepleteJob after reset: enable %v, up %d", client.Enable, client.Up)
+	}
+	if client := loadTestClient(t, "manual"); client.Enable || client.Up != 0 {
+		t.Fatalf("client disabled by the admin after reset: enable %v, up %d", client.Enable, client.Up)
+	}
+}
+
+func TestResetBoundaryDaysFromCreation(t *testing.T) {
+	created := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	client := model.Client{
+		ResetPolicy: ResetPolicyDays,
+		ResetDay:    30,
+		CreatedAt:   created.Unix(),
+		// The policy was set 45 days after the client was added
+		LastReset: created.AddDate(0, 0, 45).Unix(),
+	}
+	now := created.AddDate(0, 0, 70)
+	if got, want := resetBoundary(&client, now, time.UTC), created.AddDate(0, 0, 60); !got.Equal(want) {
+		t.Fatalf("boundary %v, want %v", got, want)
+	}
+
+	// Clients without a creation time count from the policy
+	client.CreatedAt = 0
+	if got, want := resetBoundary(&client, now, time.UTC), created.AddDate(0, 0, 45); !got.Equal(want) {
+		t.Fatalf("boundary without creation time %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func saveTestClient(t *testing.T, act string, data string) {
+	t.Helper()
+	s := ClientService{}
+	tx := database.GetDB().Begin()
+	if _, err := s.Save(tx, act, json.RawMessage(data), ""); err != nil {
+		tx.Rollback()
+		t.Fatal(err)
+	}
+	if err := tx.Commit().Error; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loadTestClient(t *testing.T, name string) model.Client {
+	t.Helper()
+	var client model.Client
+	if err := database.GetDB().Where("name = ?", name).First(&client).Error; err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestClientEditKeepsLimits(t *testing.T) {
+	initTestDB(t)
+	saveTestClient(t, "new", `{"enable":true,"name":"alice","config":{},"inbounds":[],"links":[],"ipLimit":3,"upLimit":1000,"downLimit":2000}`)
+	client := loadTestClient(t, "alice")
+	if client.IPLimit != 3 || client.UpLimit != 1000 || client.DownLimit != 2000 {
+		t.Fatalf("new client has limits %d %d %d", client.IPLimit, client.UpLimit, client.DownLimit)
+	}
+
+	// An edit from the panel does not send the limits
+	edit, _ := json.Marshal(map[string]interface{}{
+		"id": client.Id, "enable": true, "name": "alice", "desc": "edited",
+		"config": json.RawMessage(`{}`), "inbounds": json.RawMessage(`[]`), "links": json.RawMessage(`[]`),
+	})
+	saveTestClient(t, "edit", string(edit))
+	client = loadTestClient(t, "alice")
+	if client.Desc != "edited" {
+		t.Fatalf("edit was not saved, desc %q", client.Desc)
+	}
+	if client.IPLimit != 3 || client.UpLimit != 1000 || client.DownLimit != 2000 {
+		t.Fatalf("edit without limits changed them to %d %d %d", client.IPLimit, client.UpLimit, client.DownLimit)
+	}
+
+	// Sending the keys still changes them
+	saveTestClient(t, "edit", string(edit[:len(edit)-1])+`,"ipLimit":0,"upLimit":0,"downLimit":500}`)
+	client = loadTestClient(t, "alice")
+	if client.IPLimit != 0 || client.UpLimit != 0 || client.DownLimit != 500 {
+		t.Fatalf("edit with limits left %d %d %d", client.IPLimit, client.UpLimit, client.DownLimit)
+	}
+}
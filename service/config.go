@@ -90,11 +90,13 @@ func (s *ConfigService) StartCore(defaultConfig string) error {
 		return err
 	}
 	err = corePtr.Start(rawConfig)
+	SharedTelegramService().NotifyCoreStatus(err)
 	if err != nil {
 		logger.Error("start sing-box err:", err.Error())
 		return err
 	}
 	logger.Info("sing-box started")
+	s.ClientService.ApplyClientLimits()
 	return nil
 }
 
@@ -135,6 +137,8 @@ func (s *ConfigService) Save(obj string, act string, data json.RawMessage, initU
 			// Try to start core if it is not running
 			if !corePtr.IsRunning() {
 				s.StartCore("")
+			} else if obj == "clients" || obj == "settings" {
+				s.ClientService.ApplyClientLimits()
 			}
 		} else {
 			tx.Rollback()
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"github.com/alireza0/s-ui/core"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/util/common"
+)
+
+type ConnectionService struct{}
+
+// GetConnections returns the live connections of the running core.
+func (s *ConnectionService) GetConnections() []core.Connection {
+	if !corePtr.IsRunning() {
+		return []core.Connection{}
+	}
+	return corePtr.GetInstance().ConnTracker().Connections()
+}
+
+// CloseConnections closes the connection with the id, the connections of the user or the
+// connections to the destination, whichever is set, and returns how many were closed.
+func (s *ConnectionService) CloseConnections(id string, user string, destination string) (int, error) {
+	set := 0
+	for _, value := range []string{id, user, destination} {
+		if value != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return 0, common.NewError("one of id, user or destination is required")
+	}
+	if !corePtr.IsRunning() {
+		return 0, common.NewError("core is not running")
+	}
+	tracker := corePtr.GetInstance().ConnTracker()
+	var closed int
+	switch {
+	case id != "":
+		closed = tracker.CloseConnByID(id)
+	case user != "":
+		closed = tracker.CloseConnByUser(user)
+	default:
+		closed = tracker.CloseConnByDestination(destination)
+	}
+	logger.Infof("closed %d connections (id: %q, user: %q, destination: %q)", closed, id, user, destination)
+	return closed, nil
+}
@@ -52,6 +52,7 @@ var defaultValueMap = map[string]string{
 	"webURI":        "",
 	"sessionMaxAge": "0",
 	"trafficAge":    "30",
+	"ipLimitWindow": "60",
 	"timeLocation":  "Asia/Tehran",
 	"subListen":     "",
 	"subPort":       "2096",
@@ -242,6 +243,10 @@ func (s *SettingService) GetTrafficAge() (int, error) {
 	return s.getInt("trafficAge")
 }
 
+func (s *SettingService) GetIPLimitWindow() (int, error) {
+	return s.getInt("ipLimitWindow")
+}
+
 func (s *SettingService) GetTimeLocation() (*time.Location, error) {
 	l, err := s.getString("timeLocation")
 	if err != nil {
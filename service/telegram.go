@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -11,6 +12,9 @@ import (
 
 	"github.com/alireza0/s-ui/database"
 	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+	"github.com/alireza0/s-ui/util/common"
 
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -19,9 +23,10 @@ import (
 type TelegramService struct{}
 
 var (
-	telegramListenersMu sync.RWMutex
-	telegramListeners   []func()
-	sharedService       = &TelegramService{}
+	telegramListenersMu  sync.RWMutex
+	telegramListeners    []func()
+	sharedService        = &TelegramService{}
+	webhookSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,256}$`)
 )
 
 func SharedTelegramService() *TelegramService {
@@ -31,14 +36,32 @@ func SharedTelegramService() *TelegramService {
 type TelegramConfigPayload struct {
 	Enabled            bool              `json:"enabled"`
 	BotToken           string            `json:"botToken"`
+	APIBaseURL         string            `json:"apiBaseUrl"`
 	WebhookDomain      string            `json:"webhookDomain"`
 	WebhookSecret      string            `json:"webhookSecret"`
 	YooKassaShopID     string            `json:"yooKassaShopId"`
 	YooKassaSecretKey  string            `json:"yooKassaSecretKey"`
+	YooKassaAPIURL     string            `json:"yooKassaApiUrl"`
 	SuccessRedirectURL string            `json:"successRedirectUrl"`
 	FailureRedirectURL string            `json:"failureRedirectUrl"`
 	MiniAppURL         string            `json:"miniAppUrl"`
 	DownloadLinks      map[string]string `json:"downloadLinks"`
+	RemindersEnabled   bool              `json:"remindersEnabled"`
+	ReminderDays       []int             `json:"reminderDays"`
+	ReminderVolume     []int             `json:"reminderVolume"`
+	ReferralEnabled    bool              `json:"referralEnabled"`
+	ReferralReward     string            `json:"referralReward"`
+	ReferralDays       int               `json:"referralDays"`
+	ReferralPercent    int               `json:"referralPercent"`
+	TrialEnabled       bool              `json:"trialEnabled"`
+	TrialInbounds      []uint            `json:"trialInbounds"`
+	TrialDays          int               `json:"trialDays"`
+	TrialVolume        int64             `json:"trialVolume"`
+	TrialMinAccountAge int               `json:"trialMinAccountAge"`
+	DefaultLanguage    string            `json:"defaultLanguage"`
+	AdminChatIDs       []int64           `json:"adminChatIds"`
+	AdminEvents        []string          `json:"adminEvents"`
+	AdminUserIDs       []int64           `json:"adminUserIds"`
 }
 
 type TelegramTariffPayload struct {
@@ -48,6 +71,8 @@ type TelegramTariffPayload struct {
 	PriceMinor   int64  `json:"priceMinor"`
 	Currency     string `json:"currency"`
 	DurationDays int    `json:"durationDays"`
+	Volume       int64  `json:"volume"`
+	Inbounds     []uint `json:"inbounds"`
 	SortOrder    int    `json:"sortOrder"`
 	Active       bool   `json:"active"`
 }
@@ -64,13 +89,31 @@ type TelegramButtonPayload struct {
 type TelegramConfigDTO struct {
 	Enabled            bool              `json:"enabled"`
 	BotTokenMasked     string            `json:"botTokenMasked"`
+	APIBaseURL         string            `json:"apiBaseUrl"`
 	WebhookDomain      string            `json:"webhookDomain"`
 	WebhookSecret      string            `json:"webhookSecret"`
 	YooKassaShopID     string            `json:"yooKassaShopId"`
+	YooKassaAPIURL     string            `json:"yooKassaApiUrl"`
 	SuccessRedirectURL string            `json:"successRedirectUrl"`
 	FailureRedirectURL string            `json:"failureRedirectUrl"`
 	MiniAppURL         string            `json:"miniAppUrl"`
 	DownloadLinks      map[string]string `json:"downloadLinks"`
+	RemindersEnabled   bool              `json:"remindersEnabled"`
+	ReminderDays       []int             `json:"reminderDays"`
+	ReminderVolume     []int             `json:"reminderVolume"`
+	ReferralEnabled    bool              `json:"referralEnabled"`
+	ReferralReward     string            `json:"referralReward"`
+	ReferralDays       int               `json:"referralDays"`
+	ReferralPercent    int               `json:"referralPercent"`
+	TrialEnabled       bool              `json:"trialEnabled"`
+	TrialInbounds      []uint            `json:"trialInbounds"`
+	TrialDays          int               `json:"trialDays"`
+	TrialVolume        int64             `json:"trialVolume"`
+	TrialMinAccountAge int               `json:"trialMinAccountAge"`
+	DefaultLanguage    string            `json:"defaultLanguage"`
+	AdminChatIDs       []int64           `json:"adminChatIds"`
+	AdminEvents        []string          `json:"adminEvents"`
+	AdminUserIDs       []int64           `json:"adminUserIds"`
 }
 
 type TelegramButtonDTO struct {
@@ -89,16 +132,23 @@ type TelegramTariffDTO struct {
 	PriceMinor   int64               `json:"priceMinor"`
 	Currency     string              `json:"currency"`
 	DurationDays int                 `json:"durationDays"`
+	Volume       int64               `json:"volume"`
+	Inbounds     []uint              `json:"inbounds"`
 	SortOrder    int                 `json:"sortOrder"`
 	Active       bool                `json:"active"`
 	Buttons      []TelegramButtonDTO `json:"buttons"`
 }
 
 type TelegramAdminState struct {
-	Config        *TelegramConfigDTO            `json:"config"`
-	Tariffs       []TelegramTariffDTO           `json:"tariffs"`
-	Broadcasts    []TelegramBroadcastDTO        `json:"broadcasts"`
-	PromoCodes    []TelegramPromoCodeDTO        `json:"promoCodes"`
+	Config     *TelegramConfigDTO     `json:"config"`
+	Tariffs    []TelegramTariffDTO    `json:"tariffs"`
+	Broadcasts []TelegramBroadcastDTO `json:"broadcasts"`
+	PromoCodes []TelegramPromoCodeDTO `json:"promoCodes"`
+	// Templates is nil in states exported before message templates existed
+	Templates []model.TelegramMessageTemplate `json:"templates"`
+	// CannedReplies is nil in states exported before support tickets existed
+	CannedReplies []model.TelegramCannedReply `json:"cannedReplies"`
+	// Conversations is the first page of telegramConversations, the action pages and filters them
 	Conversations []TelegramConversationSummary `json:"conversations"`
 }
 
@@ -107,32 +157,50 @@ type TelegramBroadcastAudience struct {
 	TariffIDs              []uint `json:"tariffIds"`
 	IncludeNeverSubscribed bool   `json:"includeNeverSubscribed"`
 	IncludeExpired         bool   `json:"includeExpired"`
+	IncludeTrial           bool   `json:"includeTrial"`
+	// Expression replaces the filters above when set, see telegramAudience.go
+	Expression string `json:"expression,omitempty"`
 }
 
 type TelegramBroadcastPayload struct {
-	ID       uint                      `json:"id"`
-	Title    string                    `json:"title"`
-	Body     string                    `json:"body"`
-	Editable bool                      `json:"editable"`
-	Audience TelegramBroadcastAudience `json:"audience"`
+	ID        uint                        `json:"id"`
+	Title     string                      `json:"title"`
+	Body      string                      `json:"body"`
+	ParseMode string                      `json:"parseMode"`
+	Media     *TelegramBroadcastMedia     `json:"media"`
+	Buttons   [][]TelegramBroadcastButton `json:"buttons"`
+	Editable  bool                        `json:"editable"`
+	Audience  TelegramBroadcastAudience   `json:"audience"`
 }
 
 type TelegramBroadcastDTO struct {
-	ID         uint                      `json:"id"`
-	Title      string                    `json:"title"`
-	Body       string                    `json:"body"`
-	Editable   bool                      `json:"editable"`
-	Status     string                    `json:"status"`
-	Audience   TelegramBroadcastAudience `json:"audience"`
-	SentAt     *time.Time                `json:"sentAt"`
-	Deliveries int                       `json:"deliveries"`
-	Success    int                       `json:"success"`
-	Failed     int                       `json:"failed"`
+	ID          uint                        `json:"id"`
+	Title       string                      `json:"title"`
+	Body        string                      `json:"body"`
+	ParseMode   string                      `json:"parseMode"`
+	Media       *TelegramBroadcastMedia     `json:"media"`
+	Buttons     [][]TelegramBroadcastButton `json:"buttons"`
+	Editable    bool                        `json:"editable"`
+	Status      string                      `json:"status"`
+	Audience    TelegramBroadcastAudience   `json:"audience"`
+	SentAt      *time.Time                  `json:"sentAt"`
+	ScheduledAt *time.Time                  `json:"scheduledAt"`
+	Recurrence  string                      `json:"recurrence"`
+	ParentID    *uint                       `json:"parentId"`
+	Deliveries  int                         `json:"deliveries"`
+	Queued      int                         `json:"queued"`
+	Success     int                         `json:"success"`
+	Failed      int                         `json:"failed"`
+	Editing     int                         `json:"editing"`
+	EditFailed  int                         `json:"editFailed"`
 }
 
 type TelegramBroadcastEditPayload struct {
-	BroadcastID uint   `json:"broadcastId"`
-	Body        string `json:"body"`
+	BroadcastID uint                        `json:"broadcastId"`
+	Body        string                      `json:"body"`
+	ParseMode   string                      `json:"parseMode"`
+	Media       *TelegramBroadcastMedia     `json:"media"`
+	Buttons     [][]TelegramBroadcastButton `json:"buttons"`
 }
 
 type TelegramPromoCodePayload struct {
@@ -160,16 +228,18 @@ type TelegramPromoCodeDTO struct {
 }
 
 type TelegramUserDTO struct {
-	ID                 uint       `json:"id"`
-	TelegramID         int64      `json:"telegramId"`
-	Username           string     `json:"username"`
-	FirstName          string     `json:"firstName"`
-	LastName           string     `json:"lastName"`
-	Language           string     `json:"language"`
-	EverPaid           bool       `json:"everPaid"`
+	ID           uint                  `json:"id"`
+	TelegramID   int64                 `json:"telegramId"`
+	Username     string                `json:"username"`
+	FirstName    string                `json:"firstName"`
+	LastName     string                `json:"lastName"`
+	Language     string                `json:"language"`
+	EverPaid     bool                  `json:"everPaid"`
+	LastTariffID *uint                 `json:"lastTariffId"`
+	Subscription *TelegramSubscription `json:"subscription"`
+	// ActiveSubscription and SubscriptionEnds repeat Subscription for older API consumers
 	ActiveSubscription bool       `json:"activeSubscription"`
 	SubscriptionEnds   *time.Time `json:"subscriptionEnds"`
-	LastTariffID       *uint      `json:"lastTariffId"`
 }
 
 type TelegramConversationMessage struct {
@@ -178,35 +248,53 @@ type TelegramConversationMessage struct {
 	Body              string    `json:"body"`
 	TelegramMessageID string    `json:"telegramMessageId"`
 	Seen              bool      `json:"seen"`
+	Author            string    `json:"author"`
+	AttachmentType    string    `json:"attachmentType"`
+	AttachmentName    string    `json:"attachmentName"`
+	AttachmentStored  bool      `json:"attachmentStored"`
+	AttachmentError   string    `json:"attachmentError"`
 	CreatedAt         time.Time `json:"createdAt"`
 }
 
 type TelegramConversationDTO struct {
-	User     TelegramUserDTO               `json:"user"`
+	User TelegramUserDTO `json:"user"`
+	TelegramTicket
 	Messages []TelegramConversationMessage `json:"messages"`
 }
 
 type TelegramConversationSummary struct {
-	User        TelegramUserDTO              `json:"user"`
-	LastMessage *TelegramConversationMessage `json:"lastMessage"`
-	UnreadCount int                          `json:"unreadCount"`
+	User TelegramUserDTO `json:"user"`
+	TelegramTicket
+	LastMessage   *TelegramConversationMessage `json:"lastMessage"`
+	LastMessageAt time.Time                    `json:"lastMessageAt"`
+	UnreadCount   int                          `json:"unreadCount"`
 }
 
 type TelegramInboundMessage struct {
-	TelegramID            int64      `json:"telegramId"`
-	Username              string     `json:"username"`
-	FirstName             string     `json:"firstName"`
-	LastName              string     `json:"lastName"`
-	Language              string     `json:"language"`
-	Message               string     `json:"message"`
-	MessageID             string     `json:"messageId"`
-	TariffID              *uint      `json:"tariffId"`
-	EverPaid              bool       `json:"everPaid"`
+	TelegramID int64  `json:"telegramId"`
+	Username   string `json:"username"`
+	FirstName  string `json:"firstName"`
+	LastName   string `json:"lastName"`
+	Language   string `json:"language"`
+	Message    string `json:"message"`
+	MessageID  string `json:"messageId"`
+	// Attachment of a photo or document message, downloaded when the message is stored
+	AttachmentType   string `json:"attachmentType"`
+	AttachmentFileID string `json:"attachmentFileId"`
+	AttachmentName   string `json:"attachmentName"`
+
+	// Deprecated: EverPaid and TariffID are still recorded on the profile, but payments set them.
+	TariffID *uint `json:"tariffId"`
+	EverPaid bool  `json:"everPaid"`
+	// Deprecated: ignored, the subscription state comes from the linked clients.
 	ActiveSubscription    bool       `json:"activeSubscription"`
 	SubscriptionExpiresAt *time.Time `json:"subscriptionExpiresAt"`
 }
 
 func (p *TelegramConfigPayload) Validate() error {
+	if secret := strings.TrimSpace(p.WebhookSecret); secret != "" && !webhookSecretPattern.MatchString(secret) {
+		return errors.New("webhook secret may contain only A-Z, a-z, 0-9, _ and - (up to 256 characters)")
+	}
 	if p.Enabled {
 		if strings.TrimSpace(p.BotToken) == "" {
 			return errors.New("bot token is required when bot is enabled")
@@ -218,6 +306,63 @@ func (p *TelegramConfigPayload) Validate() error {
 			return errors.New("yookassa secret key is required when bot is enabled")
 		}
 	}
+	for _, days := range p.ReminderDays {
+		if days < 1 || days > 365 {
+			return errors.New("reminder days must be between 1 and 365")
+		}
+	}
+	for _, percent := range p.ReminderVolume {
+		if percent < 1 || percent > 99 {
+			return errors.New("reminder volume must be between 1 and 99 percent")
+		}
+	}
+	if p.ReferralEnabled {
+		switch p.ReferralReward {
+		case ReferralRewardDays:
+			if p.ReferralDays < 1 || p.ReferralDays > 3650 {
+				return errors.New("referral reward days must be between 1 and 3650")
+			}
+		case ReferralRewardDiscount:
+			if p.ReferralPercent < 1 || p.ReferralPercent > 100 {
+				return errors.New("referral discount must be between 1 and 100 percent")
+			}
+		default:
+			return errors.New("referral reward must be days or discount")
+		}
+	}
+	if p.TrialEnabled {
+		if len(p.TrialInbounds) == 0 {
+			return errors.New("select at least one inbound for the trial")
+		}
+		if p.TrialDays < 1 || p.TrialDays > 365 {
+			return errors.New("trial days must be between 1 and 365")
+		}
+	}
+	if p.TrialVolume < 0 {
+		return errors.New("trial volume cannot be negative")
+	}
+	if p.TrialMinAccountAge < 0 {
+		return errors.New("trial account age cannot be negative")
+	}
+	for _, event := range p.AdminEvents {
+		if !isAdminEvent(event) {
+			return fmt.Errorf("unknown admin event %q", event)
+		}
+	}
+	for _, id := range p.AdminChatIDs {
+		if id == 0 {
+			return errors.New("invalid admin chat id")
+		}
+	}
+	for _, id := range p.AdminUserIDs {
+		if id <= 0 {
+			return errors.New("invalid admin telegram id")
+		}
+	}
+	p.DefaultLanguage = normalizeMessageLanguage(p.DefaultLanguage)
+	if p.DefaultLanguage != "" && !messageLanguagePattern.MatchString(p.DefaultLanguage) {
+		return errors.New("default language must be a code like en, ru or pt-br")
+	}
 	return nil
 }
 
@@ -234,6 +379,9 @@ func (p *TelegramTariffPayload) Validate() error {
 	if p.DurationDays < 0 {
 		return errors.New("duration must be zero or positive")
 	}
+	if p.Volume < 0 {
+		return errors.New("volume must be zero or positive")
+	}
 	return nil
 }
 
@@ -248,10 +396,14 @@ func (p *TelegramButtonPayload) Validate() error {
 }
 
 func (a TelegramBroadcastAudience) Validate() error {
+	if strings.TrimSpace(a.Expression) != "" {
+		_, err := parseAudienceExpression(a.Expression)
+		return err
+	}
 	if a.AllUsers {
 		return nil
 	}
-	if len(a.TariffIDs) == 0 && !a.IncludeNeverSubscribed && !a.IncludeExpired {
+	if len(a.TariffIDs) == 0 && !a.IncludeNeverSubscribed && !a.IncludeExpired && !a.IncludeTrial {
 		return errors.New("select at least one audience filter")
 	}
 	for _, id := range a.TariffIDs {
@@ -266,8 +418,9 @@ func (p *TelegramBroadcastPayload) Validate() error {
 	if strings.TrimSpace(p.Title) == "" {
 		return errors.New("broadcast title is required")
 	}
-	if strings.TrimSpace(p.Body) == "" {
-		return errors.New("broadcast body is required")
+	p.Buttons = normalizeBroadcastButtons(p.Buttons)
+	if err := validateBroadcastContent(p.Body, p.ParseMode, p.Media, p.Buttons); err != nil {
+		return err
 	}
 	if err := p.Audience.Validate(); err != nil {
 		return err
@@ -279,10 +432,8 @@ func (p *TelegramBroadcastEditPayload) Validate() error {
 	if p.BroadcastID == 0 {
 		return errors.New("broadcast id is required")
 	}
-	if strings.TrimSpace(p.Body) == "" {
-		return errors.New("updated text is required")
-	}
-	return nil
+	p.Buttons = normalizeBroadcastButtons(p.Buttons)
+	return validateBroadcastContent(p.Body, p.ParseMode, p.Media, p.Buttons)
 }
 
 func (p *TelegramPromoCodePayload) Validate() error {
@@ -312,8 +463,16 @@ func (s *TelegramService) ensureConfig(tx *gorm.DB) (*model.TelegramBotConfig, e
 	if err != nil {
 		return nil, err
 	}
-	if cfg.DownloadLinks == "" {
-		_ = cfg.SetDownloadLinks(map[string]string{})
+	if cfg.DownloadLinks == "" || cfg.ReminderDays == "" || cfg.ReminderVolume == "" {
+		if cfg.DownloadLinks == "" {
+			_ = cfg.SetDownloadLinks(map[string]string{})
+		}
+		if cfg.ReminderDays == "" {
+			_ = cfg.SetReminderDays([]int{3, 1})
+		}
+		if cfg.ReminderVolume == "" {
+			_ = cfg.SetReminderVolume([]int{80, 95})
+		}
 		if err := tx.Save(&cfg).Error; err != nil {
 			return nil, err
 		}
@@ -335,24 +494,67 @@ func (s *TelegramService) UpdateConfig(payload *TelegramConfigPayload) (*Telegra
 	}
 	db := database.GetDB()
 	var result *model.TelegramBotConfig
+	var previous model.TelegramBotConfig
 	err := db.Transaction(func(tx *gorm.DB) error {
 		cfg, err := s.ensureConfig(tx)
 		if err != nil {
 			return err
 		}
+		previous = *cfg
 		cfg.Enabled = payload.Enabled
 		cfg.BotToken = strings.TrimSpace(payload.BotToken)
+		cfg.APIBaseURL = strings.TrimRight(strings.TrimSpace(payload.APIBaseURL), "/")
 		cfg.WebhookDomain = strings.TrimSpace(payload.WebhookDomain)
 		cfg.WebhookSecret = strings.TrimSpace(payload.WebhookSecret)
 		cfg.YooKassaShopID = strings.TrimSpace(payload.YooKassaShopID)
 		cfg.YooKassaSecretKey = strings.TrimSpace(payload.YooKassaSecretKey)
+		cfg.YooKassaAPIURL = strings.TrimRight(strings.TrimSpace(payload.YooKassaAPIURL), "/")
 		cfg.SuccessRedirectURL = strings.TrimSpace(payload.SuccessRedirectURL)
 		cfg.FailureRedirectURL = strings.TrimSpace(payload.FailureRedirectURL)
 		cfg.MiniAppURL = strings.TrimSpace(payload.MiniAppURL)
+		if cfg.WebhookDomain != "" && cfg.WebhookSecret == "" {
+			cfg.WebhookSecret = common.Random(32)
+		}
 		if err := cfg.SetDownloadLinks(payload.DownloadLinks); err != nil {
 			return err
 		}
-		if err := tx.Save(cfg).Error; err != nil {
+		cfg.RemindersEnabled = payload.RemindersEnabled
+		if err := cfg.SetReminderDays(payload.ReminderDays); err != nil {
+			return err
+		}
+		if err := cfg.SetReminderVolume(payload.ReminderVolume); err != nil {
+			return err
+		}
+		cfg.ReferralEnabled = payload.ReferralEnabled
+		cfg.ReferralReward = payload.ReferralReward
+		cfg.ReferralDays = payload.ReferralDays
+		cfg.ReferralPercent = payload.ReferralPercent
+		cfg.TrialEnabled = payload.TrialEnabled
+		if err := cfg.SetTrialInbounds(payload.TrialInbounds); err != nil {
+			return err
+		}
+		cfg.TrialDays = payload.TrialDays
+		cfg.TrialVolume = payload.TrialVolume
+		cfg.TrialMinAccountAge = payload.TrialMinAccountAge
+		cfg.DefaultLanguage = payload.DefaultLanguage
+		if err := cfg.SetAdminChatIDs(payload.AdminChatIDs); err != nil {
+			return err
+		}
+		if err := cfg.SetAdminEvents(payload.AdminEvents); err != nil {
+			return err
+		}
+		if err := cfg.SetAdminUserIDs(payload.AdminUserIDs); err != nil {
+			return err
+		}
+		query := tx
+		if cfg.BotToken != previous.BotToken {
+			// Update ids are per bot, a new token starts from scratch
+			cfg.UpdateOffset = 0
+		} else {
+			// The polling worker owns the offset and may have moved it meanwhile
+			query = tx.Omit("update_offset")
+		}
+		if err := query.Save(cfg).Error; err != nil {
 			return err
 		}
 		result = cfg
@@ -361,6 +563,9 @@ func (s *TelegramService) UpdateConfig(payload *TelegramConfigPayload) (*Telegra
 	if err != nil {
 		return nil, err
 	}
+	if err := s.syncWebhook(&previous, result); err != nil {
+		logger.Warning("telegram webhook update failed:", err)
+	}
 	s.notifyChange()
 	return newTelegramConfigDTO(result), nil
 }
@@ -380,13 +585,31 @@ func newTelegramConfigDTO(cfg *model.TelegramBotConfig) *TelegramConfigDTO {
 	return &TelegramConfigDTO{
 		Enabled:            cfg.Enabled,
 		BotTokenMasked:     masked,
+		APIBaseURL:         cfg.APIBaseURL,
 		WebhookDomain:      cfg.WebhookDomain,
 		WebhookSecret:      cfg.WebhookSecret,
 		YooKassaShopID:     cfg.YooKassaShopID,
+		YooKassaAPIURL:     cfg.YooKassaAPIURL,
 		SuccessRedirectURL: cfg.SuccessRedirectURL,
 		FailureRedirectURL: cfg.FailureRedirectURL,
 		MiniAppURL:         cfg.MiniAppURL,
 		DownloadLinks:      cfg.GetDownloadLinks(),
+		RemindersEnabled:   cfg.RemindersEnabled,
+		ReminderDays:       cfg.GetReminderDays(),
+		ReminderVolume:     cfg.GetReminderVolume(),
+		ReferralEnabled:    cfg.ReferralEnabled,
+		ReferralReward:     cfg.ReferralReward,
+		ReferralDays:       cfg.ReferralDays,
+		ReferralPercent:    cfg.ReferralPercent,
+		TrialEnabled:       cfg.TrialEnabled,
+		TrialInbounds:      cfg.GetTrialInbounds(),
+		TrialDays:          cfg.TrialDays,
+		TrialVolume:        cfg.TrialVolume,
+		TrialMinAccountAge: cfg.TrialMinAccountAge,
+		DefaultLanguage:    cfg.DefaultLanguage,
+		AdminChatIDs:       cfg.GetAdminChatIDs(),
+		AdminEvents:        cfg.GetAdminEvents(),
+		AdminUserIDs:       cfg.GetAdminUserIDs(),
 	}
 }
 
@@ -418,6 +641,10 @@ func (s *TelegramService) UpsertTariff(payload *TelegramTariffPayload) (*Telegra
 	tariff.PriceMinor = payload.PriceMinor
 	tariff.Currency = strings.ToUpper(strings.TrimSpace(payload.Currency))
 	tariff.DurationDays = payload.DurationDays
+	tariff.Volume = payload.Volume
+	if err := tariff.SetInbounds(payload.Inbounds); err != nil {
+		return nil, err
+	}
 	tariff.SortOrder = payload.SortOrder
 	tariff.Active = payload.Active
 	if err := db.Save(&tariff).Error; err != nil {
@@ -523,7 +750,7 @@ func (s *TelegramService) UpsertBroadcast(payload *TelegramBroadcastPayload) (*T
 		if err := db.Preload("Deliveries").First(&broadcast, payload.ID).Error; err != nil {
 			return nil, err
 		}
-		if broadcast.Status != "" && broadcast.Status != "draft" {
+		if broadcast.Status != "" && broadcast.Status != broadcastDraft {
 			return nil, errors.New("only draft broadcasts can be edited")
 		}
 	}
@@ -531,16 +758,22 @@ func (s *TelegramService) UpsertBroadcast(payload *TelegramBroadcastPayload) (*T
 	if err != nil {
 		return nil, err
 	}
+	previousMedia := broadcast.MediaFile
 	broadcast.Title = strings.TrimSpace(payload.Title)
-	broadcast.Body = strings.TrimSpace(payload.Body)
+	if err := setBroadcastContent(&broadcast, payload.Body, payload.ParseMode, payload.Media, payload.Buttons); err != nil {
+		return nil, err
+	}
 	broadcast.Editable = payload.Editable
 	broadcast.Audience = audienceRaw
 	if strings.TrimSpace(broadcast.Status) == "" {
-		broadcast.Status = "draft"
+		broadcast.Status = broadcastDraft
 	}
 	if err := db.Save(&broadcast).Error; err != nil {
 		return nil, err
 	}
+	if previousMedia != broadcast.MediaFile {
+		removeUnusedMedia(previousMedia)
+	}
 	if err := db.Preload("Deliveries").First(&broadcast, broadcast.ID).Error; err != nil {
 		return nil, err
 	}
@@ -557,77 +790,90 @@ func (s *TelegramService) DeleteBroadcast(id uint) error {
 	if err := db.First(&broadcast, id).Error; err != nil {
 		return err
 	}
-	if broadcast.Status != "draft" {
+	if broadcast.Status != broadcastDraft {
 		return errors.New("only draft broadcasts can be deleted")
 	}
 	if err := db.Delete(&model.TelegramBroadcast{}, id).Error; err != nil {
 		return err
 	}
+	removeUnusedMedia(broadcast.MediaFile)
 	s.notifyChange()
 	return nil
 }
 
+// SendBroadcast queues a delivery per matched user for the background queue. Sending a broadcast
+// that partially failed queues its failed deliveries again.
 func (s *TelegramService) SendBroadcast(id uint) (*TelegramBroadcastDTO, error) {
 	if id == 0 {
 		return nil, errors.New("broadcast id is required")
 	}
 	db := database.GetDB()
 	var broadcast model.TelegramBroadcast
-	if err := db.Preload("Deliveries").First(&broadcast, id).Error; err != nil {
+	if err := db.First(&broadcast, id).Error; err != nil {
 		return nil, err
 	}
-	if broadcast.Status == "sent" {
+	switch broadcast.Status {
+	case broadcastDraft, "":
+		if err := s.queueBroadcast(db, &broadcast); err != nil {
+			return nil, err
+		}
+	case broadcastPartiallyFailed, broadcastFailed:
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&model.TelegramBroadcastDelivery{}).
+				Where("broadcast_id = ? AND status = ?", broadcast.ID, deliveryFailed).
+				Updates(map[string]interface{}{"status": deliveryQueued, "error_message": ""}).Error; err != nil {
+				return err
+			}
+			return tx.Model(&broadcast).Update("status", broadcastQueued).Error
+		})
+		if err != nil {
+			return nil, err
+		}
+	case broadcastQueued, broadcastSending:
+		return nil, errors.New("broadcast is already being sent")
+	case broadcastScheduled:
+		return nil, errors.New("cancel the schedule to send the broadcast now")
+	default:
 		return nil, errors.New("broadcast already sent")
 	}
+	telegramBroadcastQueue.signal()
+	if err := db.Preload("Deliveries").First(&broadcast, broadcast.ID).Error; err != nil {
+		return nil, err
+	}
+	s.notifyChange()
+	return newTelegramBroadcastDTO(&broadcast, broadcast.Deliveries), nil
+}
+
+// queueBroadcast creates a queued delivery per matched user, the caller signals the queue.
+func (s *TelegramService) queueBroadcast(db *gorm.DB, broadcast *model.TelegramBroadcast) error {
 	audience := decodeAudience(broadcast.Audience)
 	users, err := s.selectAudienceUsers(audience)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	if len(users) == 0 {
-		return nil, errors.New("no users match broadcast audience")
+		return errors.New("no users match broadcast audience")
 	}
-	now := time.Now()
-	err = db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Model(&broadcast).Updates(map[string]interface{}{
-			"status":  "sent",
-			"sent_at": &now,
-		}).Error; err != nil {
-			return err
+	deliveries := make([]model.TelegramBroadcastDelivery, 0, len(users))
+	for _, user := range users {
+		deliveries = append(deliveries, model.TelegramBroadcastDelivery{
+			BroadcastID: broadcast.ID,
+			UserID:      user.ID,
+			Status:      deliveryQueued,
+		})
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&model.TelegramBroadcast{}).Where("id = ? AND status = ?", broadcast.ID, broadcast.Status).
+			Update("status", broadcastQueued)
+		if result.Error != nil {
+			return result.Error
 		}
-		broadcast.Status = "sent"
-		broadcast.SentAt = &now
-		for _, user := range users {
-			messageID, sendErr := s.dispatchBroadcastMessage(tx, &broadcast, &user)
-			status := "sent"
-			errorMessage := ""
-			if sendErr != nil {
-				status = "failed"
-				errorMessage = sendErr.Error()
-			}
-			delivery := model.TelegramBroadcastDelivery{
-				BroadcastID:       broadcast.ID,
-				UserID:            user.ID,
-				TelegramMessageID: messageID,
-				Status:            status,
-				ErrorMessage:      errorMessage,
-				SentAt:            &now,
-			}
-			if err := tx.Where("broadcast_id = ? AND user_id = ?", broadcast.ID, user.ID).
-				Assign(delivery).FirstOrCreate(&delivery).Error; err != nil {
-				return err
-			}
+		if result.RowsAffected == 0 {
+			return errors.New("broadcast is already queued")
 		}
-		return nil
+		broadcast.Status = broadcastQueued
+		return tx.CreateInBatches(&deliveries, 500).Error
 	})
-	if err != nil {
-		return nil, err
-	}
-	if err := db.Preload("Deliveries").First(&broadcast, broadcast.ID).Error; err != nil {
-		return nil, err
-	}
-	s.notifyChange()
-	return newTelegramBroadcastDTO(&broadcast, broadcast.Deliveries), nil
 }
 
 func (s *TelegramService) EditBroadcast(payload *TelegramBroadcastEditPayload) (*TelegramBroadcastDTO, error) {
@@ -645,24 +891,51 @@ func (s *TelegramService) EditBroadcast(payload *TelegramBroadcastEditPayload) (
 	if !broadcast.Editable {
 		return nil, errors.New("broadcast is not marked as editable")
 	}
-	broadcast.Body = strings.TrimSpace(payload.Body)
+	if broadcast.Status == broadcastQueued || broadcast.Status == broadcastSending {
+		return nil, errors.New("wait until the broadcast is sent")
+	}
+	if broadcast.MediaType == "" && payload.Media != nil {
+		return nil, errors.New("media cannot be added to sent text messages")
+	}
+	if broadcast.MediaType != "" && payload.Media == nil {
+		return nil, errors.New("media cannot be removed from sent messages")
+	}
+	if _, err := s.BotAPI(); err != nil {
+		return nil, err
+	}
+	previousMedia := broadcast.MediaFile
+	if err := setBroadcastContent(&broadcast, payload.Body, payload.ParseMode, payload.Media, payload.Buttons); err != nil {
+		return nil, err
+	}
+	mediaChanged := previousMedia != broadcast.MediaFile
+	broadcast.EditRevision++
 	err := db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Save(&broadcast).Error; err != nil {
-			return err
+		save := tx.Omit(clause.Associations)
+		if !mediaChanged {
+			// The queue may store the file_id of the unchanged media meanwhile
+			save = tx.Omit(clause.Associations, "media_file_id")
 		}
-		for _, delivery := range broadcast.Deliveries {
-			if delivery.Status != "sent" {
-				continue
-			}
-			if _, err := s.editBroadcastMessage(tx, &broadcast, &delivery); err != nil {
-				return err
-			}
+		if err := save.Save(&broadcast).Error; err != nil {
+			return err
 		}
-		return nil
+		// Messages still waiting for an earlier edit get the new content, and the new media
+		// if any of the edits replaced it
+		return tx.Model(&model.TelegramBroadcastDelivery{}).
+			Where("broadcast_id = ? AND status = ?", broadcast.ID, deliverySent).
+			Updates(map[string]interface{}{
+				"edit_status":   editQueued,
+				"edit_revision": broadcast.EditRevision,
+				"edit_media":    gorm.Expr("edit_media OR ?", mediaChanged),
+				"error_message": "",
+			}).Error
 	})
 	if err != nil {
 		return nil, err
 	}
+	telegramBroadcastQueue.signal()
+	if mediaChanged {
+		removeUnusedMedia(previousMedia)
+	}
 	if err := db.Preload("Deliveries").First(&broadcast, broadcast.ID).Error; err != nil {
 		return nil, err
 	}
@@ -735,261 +1008,64 @@ func (s *TelegramService) DeletePromoCode(id uint) error {
 		return errors.New("promo code id is required")
 	}
 	db := database.GetDB()
-	if err := db.Delete(&model.TelegramPromoCode{}, id).Error; err != nil {
-		return err
-	}
-	s.notifyChange()
-	return nil
-}
-
-func (s *TelegramService) ListConversations(limit int) ([]TelegramConversationSummary, error) {
-	db := database.GetDB()
-	var users []model.TelegramUserProfile
-	query := db.Order("updated_at desc")
-	if limit > 0 {
-		query = query.Limit(limit)
-	}
-	if err := query.Find(&users).Error; err != nil {
-		return nil, err
-	}
-	summaries := make([]TelegramConversationSummary, 0, len(users))
-	for _, user := range users {
-		var last model.TelegramUserMessage
-		err := db.Where("user_id = ?", user.ID).Order("created_at desc").First(&last).Error
-		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, err
-		}
-		var unreadCount int64
-		if err := db.Model(&model.TelegramUserMessage{}).
-			Where("user_id = ? AND direction = ? AND seen = ?", user.ID, "inbound", false).
-			Count(&unreadCount).Error; err != nil {
-			return nil, err
-		}
-		summary := TelegramConversationSummary{
-			User:        newTelegramUserDTO(&user),
-			UnreadCount: int(unreadCount),
-		}
-		if err == nil {
-			summary.LastMessage = newTelegramConversationMessageDTO(&last)
-		}
-		summaries = append(summaries, summary)
-	}
-	return summaries, nil
-}
-
-func (s *TelegramService) GetConversation(userID uint) (*TelegramConversationDTO, error) {
-	if userID == 0 {
-		return nil, errors.New("user id is required")
-	}
-	db := database.GetDB()
-	var user model.TelegramUserProfile
-	if err := db.First(&user, userID).Error; err != nil {
-		return nil, err
-	}
-	var messages []model.TelegramUserMessage
-	if err := db.Where("user_id = ?", user.ID).Order("created_at asc").Find(&messages).Error; err != nil {
-		return nil, err
-	}
-	dto := &TelegramConversationDTO{
-		User:     newTelegramUserDTO(&user),
-		Messages: make([]TelegramConversationMessage, 0, len(messages)),
-	}
-	for i := range messages {
-		dto.Messages = append(dto.Messages, *newTelegramConversationMessageDTO(&messages[i]))
-	}
-	_ = db.Model(&model.TelegramUserMessage{}).
-		Where("user_id = ? AND direction = ?", user.ID, "inbound").
-		Update("seen", true).Error
-	return dto, nil
-}
-
-func (s *TelegramService) ReplyToConversation(userID uint, text string) (*TelegramConversationDTO, error) {
-	if userID == 0 {
-		return nil, errors.New("user id is required")
-	}
-	if strings.TrimSpace(text) == "" {
-		return nil, errors.New("message body is required")
-	}
-	db := database.GetDB()
-	var user model.TelegramUserProfile
-	if err := db.First(&user, userID).Error; err != nil {
-		return nil, err
-	}
-	now := time.Now()
-	msg := model.TelegramUserMessage{
-		UserID:            user.ID,
-		Direction:         "outbound",
-		Body:              strings.TrimSpace(text),
-		TelegramMessageID: fmt.Sprintf("admin-%d-%d", user.TelegramID, now.UnixNano()),
-		Seen:              true,
-		CreatedAt:         now,
-		UpdatedAt:         now,
-	}
 	err := db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Create(&msg).Error; err != nil {
+		if err := tx.Delete(&model.TelegramPromoRedemption{}, "promo_code_id = ?", id).Error; err != nil {
 			return err
 		}
-		return tx.Model(&model.TelegramUserProfile{}).
-			Where("id = ?", user.ID).
-			Updates(map[string]interface{}{
-				"updated_at":          now,
-				"last_interaction_at": now,
-			}).Error
+		return tx.Delete(&model.TelegramPromoCode{}, id).Error
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return s.GetConversation(userID)
+	s.notifyChange()
+	return nil
 }
 
-func (s *TelegramService) RecordInboundMessage(input *TelegramInboundMessage) (*TelegramConversationDTO, error) {
-	if input == nil {
-		return nil, errors.New("input is required")
-	}
-	if input.TelegramID == 0 {
-		return nil, errors.New("telegram id is required")
+// sendBroadcastMessage sends the broadcast to one user and returns the Telegram message id.
+func (s *TelegramService) sendBroadcastMessage(api botapi.API, broadcast *model.TelegramBroadcast, user *model.TelegramUserProfile) (string, error) {
+	if user == nil {
+		return "", errors.New("user is required")
 	}
-	if strings.TrimSpace(input.Message) == "" {
-		return nil, errors.New("message body is required")
+	if broadcast == nil {
+		return "", errors.New("broadcast is required")
 	}
-	db := database.GetDB()
-	now := time.Now()
-	var profile model.TelegramUserProfile
-	err := db.Transaction(func(tx *gorm.DB) error {
-		if err := tx.Where(model.TelegramUserProfile{TelegramID: input.TelegramID}).FirstOrCreate(&profile).Error; err != nil {
-			return err
-		}
-		profile.Username = input.Username
-		profile.FirstName = input.FirstName
-		profile.LastName = input.LastName
-		profile.Language = input.Language
-		profile.EverPaid = profile.EverPaid || input.EverPaid
-		profile.ActiveSubscription = input.ActiveSubscription
-		profile.SubscriptionExpiresAt = input.SubscriptionExpiresAt
-		profile.LastTariffID = input.TariffID
-		profile.LastInteractionAt = now
-		if err := tx.Save(&profile).Error; err != nil {
-			return err
-		}
-		msg := model.TelegramUserMessage{
-			UserID:            profile.ID,
-			Direction:         "inbound",
-			Body:              strings.TrimSpace(input.Message),
-			TelegramMessageID: input.MessageID,
-			Seen:              false,
-			CreatedAt:         now,
-			UpdatedAt:         now,
-		}
-		if err := tx.Create(&msg).Error; err != nil {
-			return err
-		}
-		return nil
-	})
+	ctx, cancel := telegramContext()
+	defer cancel()
+	fileID := broadcast.MediaFileID
+	sent, err := sendBroadcastContent(ctx, api, broadcast, user.TelegramID)
 	if err != nil {
-		return nil, err
-	}
-	return s.GetConversation(profile.ID)
-}
-
-func (s *TelegramService) selectAudienceUsers(a TelegramBroadcastAudience) ([]model.TelegramUserProfile, error) {
-	db := database.GetDB()
-	var users []model.TelegramUserProfile
-	if err := db.Order("updated_at desc").Find(&users).Error; err != nil {
-		return nil, err
-	}
-	if a.AllUsers {
-		return users, nil
+		return "", err
 	}
-	now := time.Now()
-	selected := make([]model.TelegramUserProfile, 0, len(users))
-	for _, user := range users {
-		include := false
-		if len(a.TariffIDs) > 0 && user.LastTariffID != nil {
-			for _, id := range a.TariffIDs {
-				if *user.LastTariffID == id {
-					include = true
-					break
-				}
-			}
-		}
-		if !include && a.IncludeNeverSubscribed && !user.EverPaid {
-			include = true
-		}
-		if !include && a.IncludeExpired {
-			if !user.ActiveSubscription && user.SubscriptionExpiresAt != nil && user.SubscriptionExpiresAt.Before(now) {
-				include = true
-			}
-		}
-		if include {
-			selected = append(selected, user)
+	if broadcast.MediaFileID != fileID {
+		// The message is out, a file_id that is not stored only costs another upload
+		if err := database.GetDB().Model(&model.TelegramBroadcast{}).Where("id = ?", broadcast.ID).
+			Update("media_file_id", broadcast.MediaFileID).Error; err != nil {
+			logger.Warningf("telegram broadcast %d: unable to store media file id: %v", broadcast.ID, err)
 		}
 	}
-	return selected, nil
+	return formatTelegramMessageID(sent.MessageID), nil
 }
 
-func (s *TelegramService) dispatchBroadcastMessage(tx *gorm.DB, broadcast *model.TelegramBroadcast, user *model.TelegramUserProfile) (string, error) {
-	if user == nil {
-		return "", errors.New("user is required")
-	}
-	if broadcast == nil {
-		return "", errors.New("broadcast is required")
-	}
-	messageID := fmt.Sprintf("broadcast-%d-%d", broadcast.ID, user.TelegramID)
+// recordBroadcastMessage adds a sent broadcast message to the conversation of the user.
+func (s *TelegramService) recordBroadcastMessage(broadcast *model.TelegramBroadcast, userID uint, messageID string) error {
+	db := database.GetDB()
 	now := time.Now()
 	message := model.TelegramUserMessage{
-		UserID:            user.ID,
+		UserID:            userID,
 		Direction:         "outbound",
-		Body:              broadcast.Body,
+		Body:              broadcastTranscript(broadcast),
 		TelegramMessageID: messageID,
 		Seen:              true,
 		CreatedAt:         now,
 		UpdatedAt:         now,
 	}
-	if err := tx.Create(&message).Error; err != nil {
-		return "", err
+	if err := db.Create(&message).Error; err != nil {
+		return err
 	}
-	if err := tx.Model(&model.TelegramUserProfile{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+	return db.Model(&model.TelegramUserProfile{}).Where("id = ?", userID).Updates(map[string]interface{}{
 		"updated_at":          now,
 		"last_interaction_at": now,
-	}).Error; err != nil {
-		return "", err
-	}
-	return messageID, nil
-}
-
-func (s *TelegramService) editBroadcastMessage(tx *gorm.DB, broadcast *model.TelegramBroadcast, delivery *model.TelegramBroadcastDelivery) (string, error) {
-	if delivery == nil {
-		return "", errors.New("delivery is required")
-	}
-	messageID := delivery.TelegramMessageID
-	if messageID == "" {
-		messageID = fmt.Sprintf("broadcast-%d-%d", broadcast.ID, delivery.UserID)
-	}
-	updates := map[string]interface{}{
-		"body":       broadcast.Body,
-		"updated_at": time.Now(),
-	}
-	result := tx.Model(&model.TelegramUserMessage{}).
-		Where("user_id = ? AND telegram_message_id = ?", delivery.UserID, delivery.TelegramMessageID).
-		Updates(updates)
-	if result.Error != nil {
-		return "", result.Error
-	}
-	if result.RowsAffected == 0 {
-		msg := model.TelegramUserMessage{
-			UserID:            delivery.UserID,
-			Direction:         "outbound",
-			Body:              broadcast.Body,
-			TelegramMessageID: messageID,
-			Seen:              true,
-			CreatedAt:         time.Now(),
-			UpdatedAt:         time.Now(),
-		}
-		if err := tx.Create(&msg).Error; err != nil {
-			return "", err
-		}
-	}
-	return messageID, nil
+	}).Error
 }
 
 func (s *TelegramService) GetAdminState() (*TelegramAdminState, error) {
@@ -1009,7 +1085,15 @@ func (s *TelegramService) GetAdminState() (*TelegramAdminState, error) {
 	if err != nil {
 		return nil, err
 	}
-	conversations, err := s.ListConversations(0)
+	var templates []model.TelegramMessageTemplate
+	if err := database.GetDB().Order("id asc").Find(&templates).Error; err != nil {
+		return nil, err
+	}
+	cannedReplies, err := s.ListCannedReplies()
+	if err != nil {
+		return nil, err
+	}
+	conversations, err := s.ListConversations(TelegramConversationFilter{})
 	if err != nil {
 		return nil, err
 	}
@@ -1018,7 +1102,9 @@ func (s *TelegramService) GetAdminState() (*TelegramAdminState, error) {
 		Tariffs:       make([]TelegramTariffDTO, 0, len(tariffs)),
 		Broadcasts:    make([]TelegramBroadcastDTO, 0, len(broadcasts)),
 		PromoCodes:    make([]TelegramPromoCodeDTO, 0, len(promos)),
-		Conversations: conversations,
+		Templates:     templates,
+		CannedReplies: cannedReplies,
+		Conversations: conversations.Conversations,
 	}
 	for _, tariff := range tariffs {
 		state.Tariffs = append(state.Tariffs, *newTelegramTariffDTO(&tariff))
@@ -1043,6 +1129,8 @@ func newTelegramTariffDTO(t *model.TelegramTariff) *TelegramTariffDTO {
 		PriceMinor:   t.PriceMinor,
 		Currency:     t.Currency,
 		DurationDays: t.DurationDays,
+		Volume:       t.Volume,
+		Inbounds:     t.GetInbounds(),
 		SortOrder:    t.SortOrder,
 		Active:       t.Active,
 		Buttons:      make([]TelegramButtonDTO, 0, len(t.Buttons)),
@@ -1069,6 +1157,7 @@ func newTelegramButtonDTO(b *model.TelegramTariffButton) *TelegramButtonDTO {
 
 func normalizeAudience(a TelegramBroadcastAudience) TelegramBroadcastAudience {
 	result := a
+	result.Expression = strings.TrimSpace(result.Expression)
 	if len(result.TariffIDs) > 0 {
 		ids := make([]uint, 0, len(result.TariffIDs))
 		seen := make(map[uint]struct{})
@@ -1113,25 +1202,41 @@ func newTelegramBroadcastDTO(b *model.TelegramBroadcast, deliveries []model.Tele
 		return nil
 	}
 	dto := &TelegramBroadcastDTO{
-		ID:       b.ID,
-		Title:    b.Title,
-		Body:     b.Body,
-		Editable: b.Editable,
-		Status:   b.Status,
-		Audience: decodeAudience(b.Audience),
-		SentAt:   b.SentAt,
-	}
+		ID:          b.ID,
+		Title:       b.Title,
+		Body:        b.Body,
+		ParseMode:   b.ParseMode,
+		Media:       broadcastMedia(b),
+		Buttons:     decodeBroadcastButtons(b.Buttons),
+		Editable:    b.Editable,
+		Status:      b.Status,
+		Audience:    decodeAudience(b.Audience),
+		SentAt:      b.SentAt,
+		ScheduledAt: b.ScheduledAt,
+		Recurrence:  b.Recurrence,
+		ParentID:    b.ParentID,
+	}
+	queued := 0
 	successes := 0
 	failures := 0
 	for _, d := range deliveries {
-		if d.Status == "sent" {
-			successes++
+		switch d.EditStatus {
+		case editQueued:
+			dto.Editing++
+		case editFailed:
+			dto.EditFailed++
 		}
-		if d.Status == "failed" {
+		switch d.Status {
+		case deliveryQueued:
+			queued++
+		case deliverySent:
+			successes++
+		case deliveryFailed:
 			failures++
 		}
 	}
 	dto.Deliveries = len(deliveries)
+	dto.Queued = queued
 	dto.Success = successes
 	dto.Failed = failures
 	return dto
@@ -1154,22 +1259,26 @@ func newTelegramPromoCodeDTO(m *model.TelegramPromoCode) *TelegramPromoCodeDTO {
 	}
 }
 
-func newTelegramUserDTO(u *model.TelegramUserProfile) TelegramUserDTO {
+func newTelegramUserDTO(u *model.TelegramUserProfile, sub *TelegramSubscription) TelegramUserDTO {
 	if u == nil {
 		return TelegramUserDTO{}
 	}
-	return TelegramUserDTO{
-		ID:                 u.ID,
-		TelegramID:         u.TelegramID,
-		Username:           u.Username,
-		FirstName:          u.FirstName,
-		LastName:           u.LastName,
-		Language:           u.Language,
-		EverPaid:           u.EverPaid,
-		ActiveSubscription: u.ActiveSubscription,
-		SubscriptionEnds:   u.SubscriptionExpiresAt,
-		LastTariffID:       u.LastTariffID,
+	dto := TelegramUserDTO{
+		ID:           u.ID,
+		TelegramID:   u.TelegramID,
+		Username:     u.Username,
+		FirstName:    u.FirstName,
+		LastName:     u.LastName,
+		Language:     u.Language,
+		EverPaid:     u.EverPaid,
+		LastTariffID: u.LastTariffID,
+		Subscription: sub,
+	}
+	if sub != nil {
+		dto.ActiveSubscription = sub.Active
+		dto.SubscriptionEnds = sub.ExpiresAt
 	}
+	return dto
 }
 
 func newTelegramConversationMessageDTO(m *model.TelegramUserMessage) *TelegramConversationMessage {
@@ -1182,6 +1291,11 @@ func newTelegramConversationMessageDTO(m *model.TelegramUserMessage) *TelegramCo
 		Body:              m.Body,
 		TelegramMessageID: m.TelegramMessageID,
 		Seen:              m.Seen,
+		Author:            m.Author,
+		AttachmentType:    m.AttachmentType,
+		AttachmentName:    m.AttachmentName,
+		AttachmentStored:  m.AttachmentFile != "",
+		AttachmentError:   m.AttachmentError,
 		CreatedAt:         m.CreatedAt,
 	}
 }
@@ -1210,15 +1324,45 @@ func (s *TelegramService) LoadBotState(data json.RawMessage) error {
 		}
 		if state.Config != nil {
 			cfg.Enabled = state.Config.Enabled
+			cfg.APIBaseURL = state.Config.APIBaseURL
 			cfg.WebhookDomain = state.Config.WebhookDomain
 			cfg.WebhookSecret = state.Config.WebhookSecret
 			cfg.YooKassaShopID = state.Config.YooKassaShopID
+			cfg.YooKassaAPIURL = state.Config.YooKassaAPIURL
 			cfg.SuccessRedirectURL = state.Config.SuccessRedirectURL
 			cfg.FailureRedirectURL = state.Config.FailureRedirectURL
 			cfg.MiniAppURL = state.Config.MiniAppURL
 			if err := cfg.SetDownloadLinks(state.Config.DownloadLinks); err != nil {
 				return err
 			}
+			cfg.RemindersEnabled = state.Config.RemindersEnabled
+			if err := cfg.SetReminderDays(state.Config.ReminderDays); err != nil {
+				return err
+			}
+			if err := cfg.SetReminderVolume(state.Config.ReminderVolume); err != nil {
+				return err
+			}
+			cfg.ReferralEnabled = state.Config.ReferralEnabled
+			cfg.ReferralReward = state.Config.ReferralReward
+			cfg.ReferralDays = state.Config.ReferralDays
+			cfg.ReferralPercent = state.Config.ReferralPercent
+			cfg.TrialEnabled = state.Config.TrialEnabled
+			if err := cfg.SetTrialInbounds(state.Config.TrialInbounds); err != nil {
+				return err
+			}
+			cfg.TrialDays = state.Config.TrialDays
+			cfg.TrialVolume = state.Config.TrialVolume
+			cfg.TrialMinAccountAge = state.Config.TrialMinAccountAge
+			cfg.DefaultLanguage = state.Config.DefaultLanguage
+			if err := cfg.SetAdminChatIDs(state.Config.AdminChatIDs); err != nil {
+				return err
+			}
+			if err := cfg.SetAdminEvents(state.Config.AdminEvents); err != nil {
+				return err
+			}
+			if err := cfg.SetAdminUserIDs(state.Config.AdminUserIDs); err != nil {
+				return err
+			}
 			if err := tx.Save(cfg).Error; err != nil {
 				return err
 			}
@@ -1235,6 +1379,12 @@ func (s *TelegramService) LoadBotState(data json.RawMessage) error {
 		if err := tx.Exec("DELETE FROM telegram_broadcasts").Error; err != nil {
 			return err
 		}
+		if err := tx.Exec("DELETE FROM telegram_reminders").Error; err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM telegram_promo_redemptions").Error; err != nil {
+			return err
+		}
 		if err := tx.Exec("DELETE FROM telegram_promo_codes").Error; err != nil {
 			return err
 		}
@@ -1245,9 +1395,13 @@ func (s *TelegramService) LoadBotState(data json.RawMessage) error {
 				PriceMinor:   tariffDTO.PriceMinor,
 				Currency:     tariffDTO.Currency,
 				DurationDays: tariffDTO.DurationDays,
+				Volume:       tariffDTO.Volume,
 				SortOrder:    tariffDTO.SortOrder,
 				Active:       tariffDTO.Active,
 			}
+			if err := tariff.SetInbounds(tariffDTO.Inbounds); err != nil {
+				return err
+			}
 			if err := tx.Omit(clause.Associations).Save(&tariff).Error; err != nil {
 				return err
 			}
@@ -1269,13 +1423,22 @@ func (s *TelegramService) LoadBotState(data json.RawMessage) error {
 			if err != nil {
 				return err
 			}
+			status := broadcastDTO.Status
+			if status == broadcastQueued || status == broadcastSending {
+				// Deliveries are not part of the state, an unfinished send cannot be resumed
+				status = broadcastDraft
+			}
 			broadcast := model.TelegramBroadcast{
-				Title:    broadcastDTO.Title,
-				Body:     broadcastDTO.Body,
-				Editable: broadcastDTO.Editable,
-				Status:   broadcastDTO.Status,
-				Audience: audienceRaw,
-				SentAt:   broadcastDTO.SentAt,
+				Title:       broadcastDTO.Title,
+				Editable:    broadcastDTO.Editable,
+				Status:      status,
+				Audience:    audienceRaw,
+				SentAt:      broadcastDTO.SentAt,
+				ScheduledAt: broadcastDTO.ScheduledAt,
+				Recurrence:  broadcastDTO.Recurrence,
+			}
+			if err := setBroadcastContent(&broadcast, broadcastDTO.Body, broadcastDTO.ParseMode, broadcastDTO.Media, broadcastDTO.Buttons); err != nil {
+				return err
 			}
 			if err := tx.Omit(clause.Associations).Save(&broadcast).Error; err != nil {
 				return err
@@ -1296,6 +1459,34 @@ func (s *TelegramService) LoadBotState(data json.RawMessage) error {
 				return err
 			}
 		}
+		if state.Templates != nil {
+			if err := tx.Exec("DELETE FROM telegram_message_templates").Error; err != nil {
+				return err
+			}
+			for _, tmpl := range state.Templates {
+				payload := TelegramMessageTemplatePayload{Key: tmpl.Key, Language: tmpl.Language, Text: tmpl.Text}
+				if err := payload.Validate(); err != nil {
+					return fmt.Errorf("template %s (%s): %w", tmpl.Key, tmpl.Language, err)
+				}
+				if err := tx.Create(&model.TelegramMessageTemplate{Key: payload.Key, Language: payload.Language, Text: payload.Text}).Error; err != nil {
+					return err
+				}
+			}
+		}
+		if state.CannedReplies != nil {
+			if err := tx.Exec("DELETE FROM telegram_canned_replies").Error; err != nil {
+				return err
+			}
+			for _, reply := range state.CannedReplies {
+				payload := TelegramCannedReplyPayload{Title: reply.Title, Body: reply.Body}
+				if err := payload.Validate(); err != nil {
+					return fmt.Errorf("canned reply %q: %w", reply.Title, err)
+				}
+				if err := tx.Create(&model.TelegramCannedReply{Title: payload.Title, Body: payload.Body}).Error; err != nil {
+					return err
+				}
+			}
+		}
 		return nil
 	})
 	if err == nil {
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/telegram/botapi"
+
+	"gorm.io/gorm"
+)
+
+// TelegramAdminClientPayload describes a client an admin creates with /add.
+type TelegramAdminClientPayload struct {
+	Name     string
+	Days     int
+	Volume   int64
+	Inbounds []uint
+}
+
+// IsBotAdmin reports whether the Telegram user may manage clients from the bot.
+func (s *TelegramService) IsBotAdmin(telegramID int64) bool {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return false
+	}
+	for _, id := range cfg.GetAdminUserIDs() {
+		if id == telegramID {
+			return true
+		}
+	}
+	return false
+}
+
+// TelegramAdminActor is how changes made from the bot appear in the panel's change log.
+func TelegramAdminActor(user *botapi.User) string {
+	actor := fmt.Sprintf("telegram:%d", user.ID)
+	if user.Username != "" {
+		actor += " @" + user.Username
+	}
+	return actor
+}
+
+func (s *TelegramService) findClientByName(name string) (*model.Client, error) {
+	var client model.Client
+	err := database.GetDB().Where("name = ?", strings.TrimSpace(name)).First(&client).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("client %q not found", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+// saveAdminClient stores the client through ConfigService.Save, so the change is logged with the admin as actor.
+func (s *TelegramService) saveAdminClient(actor string, act string, client *model.Client) (*TelegramClientAccess, error) {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(client)
+	if err != nil {
+		return nil, err
+	}
+	configService := ConfigService{}
+	if _, err := configService.Save("clients", act, data, "", actor, telegramHostname(cfg)); err != nil {
+		return nil, err
+	}
+	return s.AdminGetClient(client.Name)
+}
+
+// AdminGetClient returns the usage and links of a client.
+func (s *TelegramService) AdminGetClient(name string) (*TelegramClientAccess, error) {
+	client, err := s.findClientByName(name)
+	if err != nil {
+		return nil, err
+	}
+	subURI, err := s.subscriptionURI()
+	if err != nil {
+		return nil, err
+	}
+	return newClientAccess(client, subURI)
+}
+
+func (s *TelegramService) AdminAddClient(actor string, payload *TelegramAdminClientPayload) (*TelegramClientAccess, error) {
+	name := strings.TrimSpace(payload.Name)
+	if name == "" || strings.ContainsAny(name, " \t\n") {
+		return nil, errors.New("client name must be a single word")
+	}
+	if payload.Days < 0 || payload.Days > 3650 {
+		return nil, errors.New("days must be between 0 and 3650")
+	}
+	if payload.Volume < 0 {
+		return nil, errors.New("volume cannot be negative")
+	}
+	if len(payload.Inbounds) == 0 {
+		return nil, errors.New("at least one inbound is required")
+	}
+	db := database.GetDB()
+	var count int64
+	if err := db.Model(model.Client{}).Where("name = ?", name).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("client %q already exists", name)
+	}
+	if err := db.Model(model.Inbound{}).Where("id IN ?", payload.Inbounds).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if int(count) != len(payload.Inbounds) {
+		return nil, errors.New("unknown inbound id")
+	}
+
+	client := &model.Client{
+		Enable: true,
+		Name:   name,
+		Links:  json.RawMessage("[]"),
+		Volume: payload.Volume,
+	}
+	var err error
+	if client.Config, err = newClientConfig(name); err != nil {
+		return nil, err
+	}
+	if client.Inbounds, err = json.Marshal(payload.Inbounds); err != nil {
+		return nil, err
+	}
+	if payload.Days > 0 {
+		client.Expiry = time.Now().AddDate(0, 0, payload.Days).Unix()
+	}
+	return s.saveAdminClient(actor, "new", client)
+}
+
+// AdminExtendClient moves the expiry of a client by days, starting from now when it has already expired.
+// A client DepleteJob disabled is enabled again once it is within its limits.
+func (s *TelegramService) AdminExtendClient(actor string, name string, days int) (*TelegramClientAccess, error) {
+	if days < 1 || days > 3650 {
+		return nil, errors.New("days must be between 1 and 3650")
+	}
+	client, err := s.findClientByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if client.Expiry == 0 {
+		return nil, fmt.Errorf("client %q does not expire", client.Name)
+	}
+	now := time.Now()
+	wasDepleted := clientDepleted(client, now)
+	start := now
+	if client.Expiry > now.Unix() {
+		start = time.Unix(client.Expiry, 0)
+	}
+	client.Expiry = start.AddDate(0, 0, days).Unix()
+	if !client.Enable && wasDepleted && !clientDepleted(client, now) {
+		client.Enable = true
+	}
+	return s.saveAdminClient(actor, "edit", client)
+}
+
+// AdminResetClient clears the traffic of a client, enabling it again like AdminExtendClient.
+func (s *TelegramService) AdminResetClient(actor string, name string) (*TelegramClientAccess, error) {
+	client, err := s.findClientByName(name)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	wasDepleted := clientDepleted(client, now)
+	client.Up = 0
+	client.Down = 0
+	if !client.Enable && wasDepleted && !clientDepleted(client, now) {
+		client.Enable = true
+	}
+	return s.saveAdminClient(actor, "edit", client)
+}
+
+func (s *TelegramService) AdminSetClientEnabled(actor string, name string, enable bool) (*TelegramClientAccess, error) {
+	client, err := s.findClientByName(name)
+	if err != nil {
+		return nil, err
+	}
+	if client.Enable == enable {
+		return s.AdminGetClient(client.Name)
+	}
+	client.Enable = enable
+	return s.saveAdminClient(actor, "edit", client)
+}
+
+// AdminRestartCore restarts sing-box by saving the current core config again, which records the restart.
+func (s *TelegramService) AdminRestartCore(actor string) error {
+	settingService := SettingService{}
+	config, err := settingService.GetConfig()
+	if err != nil {
+		return err
+	}
+	configService := ConfigService{}
+	_, err = configService.Save("config", "restart", json.RawMessage(config), "", actor, "")
+	return err
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+// Panel events the admin chats can subscribe to
+const (
+	AdminEventClientDisabled = "client_disabled"
+	AdminEventCoreFailure    = "core_failure"
+	AdminEventPayment        = "payment"
+	AdminEventSupport        = "support_message"
+	AdminEventLoginFailed    = "login_failed"
+	AdminEventDailySummary   = "daily_summary"
+)
+
+var adminEvents = []string{
+	AdminEventClientDisabled,
+	AdminEventCoreFailure,
+	AdminEventPayment,
+	AdminEventSupport,
+	AdminEventLoginFailed,
+	AdminEventDailySummary,
+}
+
+const (
+	// CheckCoreJob retries every few seconds, a core that keeps failing is reported again after this
+	coreFailureRepeat = 30 * time.Minute
+	// Failed logins are reported once per address in this interval, so brute force does not flood the chat
+	loginFailureRepeat  = time.Minute
+	adminNoticeMaxItems = 20
+	adminNoticeMaxBody  = 500
+)
+
+var (
+	adminNoticeMu   sync.Mutex
+	adminNoticeLast = make(map[string]time.Time)
+	coreFailing     bool
+)
+
+func isAdminEvent(event string) bool {
+	for _, known := range adminEvents {
+		if known == event {
+			return true
+		}
+	}
+	return false
+}
+
+// allowAdminNotice reports whether a notice with the key was not sent within the interval.
+func allowAdminNotice(key string, interval time.Duration) bool {
+	adminNoticeMu.Lock()
+	defer adminNoticeMu.Unlock()
+	now := time.Now()
+	if last, ok := adminNoticeLast[key]; ok && now.Sub(last) < interval {
+		return false
+	}
+	adminNoticeLast[key] = now
+	return true
+}
+
+func truncateNotice(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return string([]rune(text)[:limit]) + "…"
+}
+
+// adminChatsFor returns the chats subscribed to the event, none when the bot is off.
+func (s *TelegramService) adminChatsFor(event string) (*model.TelegramBotConfig, []int64) {
+	cfg, err := s.GetConfig()
+	if err != nil || !cfg.Enabled {
+		return nil, nil
+	}
+	chats := cfg.GetAdminChatIDs()
+	if len(chats) == 0 {
+		return nil, nil
+	}
+	for _, subscribed := range cfg.GetAdminEvents() {
+		if subscribed == event {
+			return cfg, chats
+		}
+	}
+	return nil, nil
+}
+
+// NotifyAdmins sends the text to the admin chats subscribed to the event. It does not wait
+// for Telegram, as it is called from login, payment and core start paths.
+func (s *TelegramService) NotifyAdmins(event string, text string) {
+	cfg, chats := s.adminChatsFor(event)
+	if len(chats) == 0 {
+		return
+	}
+	go func() {
+		if err := s.sendAdminNotice(cfg, chats, text); err != nil {
+			logger.Warningf("telegram admin notice %s failed: %v", event, err)
+		}
+	}()
+}
+
+func (s *TelegramService) sendAdminNotice(cfg *model.TelegramBotConfig, chats []int64, text string) error {
+	api, err := s.botAPIFor(cfg)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, chatID := range chats {
+		ctx, cancel := telegramContext()
+		_, err := api.SendMessage(ctx, &botapi.SendMessageParams{
+			ChatID:                chatID,
+			Text:                  text,
+			DisableWebPagePreview: true,
+		})
+		cancel()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("chat %d: %w", chatID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SendAdminTest sends a test notice to every admin chat and reports the chats that failed.
+func (s *TelegramService) SendAdminTest() error {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return err
+	}
+	chats := cfg.GetAdminChatIDs()
+	if len(chats) == 0 {
+		return errors.New("no admin chats configured")
+	}
+	return s.sendAdminNotice(cfg, chats, "Test notification from the panel. This chat receives the selected panel events.")
+}
+
+func describeTelegramUser(profile *model.TelegramUserProfile) string {
+	name := strings.TrimSpace(profile.FirstName + " " + profile.LastName)
+	if profile.Username != "" {
+		name = strings.TrimSpace(name + " @" + profile.Username)
+	}
+	if name == "" {
+		return fmt.Sprintf("%d", profile.TelegramID)
+	}
+	return fmt.Sprintf("%s (%d)", name, profile.TelegramID)
+}
+
+// NotifyClientsDisabled reports the clients DepleteJob has just disabled.
+func (s *TelegramService) NotifyClientsDisabled(clients []model.Client, now int64) {
+	if len(clients) == 0 {
+		return
+	}
+	lines := []string{fmt.Sprintf("%d client(s) disabled by DepleteJob:", len(clients))}
+	for i, client := range clients {
+		if i == adminNoticeMaxItems {
+			lines = append(lines, fmt.Sprintf("…and %d more", len(clients)-i))
+			break
+		}
+		reason := fmt.Sprintf("traffic used up, %s of %s", formatTelegramBytes(client.Up+client.Down), formatTelegramBytes(client.Volume))
+		if client.Expiry > 0 && client.Expiry < now {
+			expiry := time.Unix(client.Expiry, 0)
+			reason = "expired " + formatTelegramTime(&expiry)
+		}
+		lines = append(lines, fmt.Sprintf("• %s: %s", client.Name, reason))
+	}
+	s.NotifyAdmins(AdminEventClientDisabled, strings.Join(lines, "\n"))
+}
+
+// NotifyCoreStatus reports a sing-box start failure, and the recovery once it starts again.
+func (s *TelegramService) NotifyCoreStatus(startErr error) {
+	adminNoticeMu.Lock()
+	wasFailing := coreFailing
+	coreFailing = startErr != nil
+	adminNoticeMu.Unlock()
+	if startErr == nil {
+		if wasFailing {
+			s.NotifyAdmins(AdminEventCoreFailure, "sing-box core is running again.")
+		}
+		return
+	}
+	if !wasFailing {
+		// A new outage is always reported, repeats of the same one are throttled
+		adminNoticeMu.Lock()
+		delete(adminNoticeLast, "core")
+		adminNoticeMu.Unlock()
+	}
+	if allowAdminNotice("core", coreFailureRepeat) {
+		s.NotifyAdmins(AdminEventCoreFailure, "sing-box core failed to start: "+truncateNotice(startErr.Error(), adminNoticeMaxBody))
+	}
+}
+
+func (s *TelegramService) NotifyFailedLogin(username string, remoteIP string) {
+	if !allowAdminNotice("login:"+remoteIP, loginFailureRepeat) {
+		return
+	}
+	s.NotifyAdmins(AdminEventLoginFailed, fmt.Sprintf("Failed panel login as %q from %s.", truncateNotice(username, 64), remoteIP))
+}
+
+func (s *TelegramService) notifyAdminsPayment(payment *model.TelegramPayment, result *provisionResult) {
+	db := database.GetDB()
+	var profile model.TelegramUserProfile
+	if err := db.First(&profile, payment.UserID).Error; err != nil {
+		return
+	}
+	var tariff model.TelegramTariff
+	title := fmt.Sprintf("tariff %d", payment.TariffID)
+	if err := db.First(&tariff, payment.TariffID).Error; err == nil {
+		title = tariff.Title
+	}
+	text := fmt.Sprintf("Payment #%d: %s for %s by %s.\nClient %s",
+		payment.ID, formatTelegramPrice(payment.AmountMinor, payment.Currency), title, describeTelegramUser(&profile), result.ClientName)
+	if !result.ExpiresAt.IsZero() {
+		text += " is active until " + formatTelegramTime(&result.ExpiresAt)
+	}
+	s.NotifyAdmins(AdminEventPayment, text+".")
+}
+
+func (s *TelegramService) notifyAdminsSupport(profile *model.TelegramUserProfile, msg *model.TelegramUserMessage) {
+	body := truncateNotice(supportNoticeBody(msg), adminNoticeMaxBody)
+	s.NotifyAdmins(AdminEventSupport, fmt.Sprintf("New message from %s:\n%s", describeTelegramUser(profile), body))
+}
+
+type adminTrafficRow struct {
+	Tag       string
+	Direction bool
+	Traffic   int64
+}
+
+// SendAdminDailySummary reports the traffic, clients and payments of the previous day.
+func (s *TelegramService) SendAdminDailySummary() error {
+	cfg, chats := s.adminChatsFor(AdminEventDailySummary)
+	if len(chats) == 0 {
+		return nil
+	}
+	text, err := s.adminDailySummary(time.Now())
+	if err != nil {
+		return err
+	}
+	return s.sendAdminNotice(cfg, chats, text)
+}
+
+func (s *TelegramService) adminDailySummary(now time.Time) (string, error) {
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return "", err
+	}
+	now = now.In(loc)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	start := end.AddDate(0, 0, -1)
+	db := database.GetDB()
+
+	var rows []adminTrafficRow
+	err = db.Model(model.Stats{}).Select("tag, direction, SUM(traffic) AS traffic").
+		Where("resource = ? AND date_time >= ? AND date_time < ?", "user", start.Unix(), end.Unix()).
+		Group("tag, direction").Scan(&rows).Error
+	if err != nil {
+		return "", err
+	}
+	var up, down int64
+	perClient := make(map[string]int64)
+	for _, row := range rows {
+		if row.Direction {
+			up += row.Traffic
+		} else {
+			down += row.Traffic
+		}
+		perClient[row.Tag] += row.Traffic
+	}
+	names := make([]string, 0, len(perClient))
+	for name := range perClient {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return perClient[names[i]] > perClient[names[j]] })
+
+	lines := []string{
+		"Daily summary for " + start.Format("2006-01-02"),
+		fmt.Sprintf("Traffic: ↑ %s ↓ %s", formatTelegramBytes(up), formatTelegramBytes(down)),
+	}
+	for i, name := range names {
+		if i == 5 {
+			break
+		}
+		if i == 0 {
+			lines = append(lines, "Top clients:")
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s: %s", i+1, name, formatTelegramBytes(perClient[name])))
+	}
+
+	var total, enabled, disabled int64
+	if err := db.Model(model.Client{}).Count(&total).Error; err != nil {
+		return "", err
+	}
+	if err := db.Model(model.Client{}).Where("enable = ?", true).Count(&enabled).Error; err != nil {
+		return "", err
+	}
+	if err := db.Model(model.Changes{}).Where("actor = ? AND `key` = ? AND action = ? AND date_time >= ? AND date_time < ?",
+		"DepleteJob", "clients", "disable", start.Unix(), end.Unix()).Count(&disabled).Error; err != nil {
+		return "", err
+	}
+	lines = append(lines, fmt.Sprintf("Clients: %d enabled of %d, %d disabled by DepleteJob", enabled, total, disabled))
+
+	var payments []model.TelegramPayment
+	if err := db.Where("status IN ? AND paid_at >= ? AND paid_at < ?",
+		[]string{PaymentStatusSucceeded, PaymentStatusRefunded}, start, end).Find(&payments).Error; err != nil {
+		return "", err
+	}
+	revenue := make(map[string]int64)
+	for _, payment := range payments {
+		revenue[payment.Currency] += payment.AmountMinor - payment.RefundedMinor
+	}
+	var amounts []string
+	for currency, amount := range revenue {
+		amounts = append(amounts, formatTelegramPrice(amount, currency))
+	}
+	sort.Strings(amounts)
+	paymentLine := fmt.Sprintf("Payments: %d", len(payments))
+	if len(amounts) > 0 {
+		paymentLine += ", " + strings.Join(amounts, ", ")
+	}
+	lines = append(lines, paymentLine)
+
+	var newUsers int64
+	if err := db.Model(model.TelegramUserProfile{}).Where("created_at >= ? AND created_at < ?", start, end).Count(&newUsers).Error; err != nil {
+		return "", err
+	}
+	lines = append(lines, fmt.Sprintf("New bot users: %d", newUsers))
+	return strings.Join(lines, "\n"), nil
+}
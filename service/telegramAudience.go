@@ -0,0 +1,498 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+// Audience expressions select Telegram users with conditions combined by and, or, not and parentheses:
+//
+//	language in (ru, uk) and (expires_within 3 or remaining < 1GB)
+//	group = vip and not promo
+//	last_interaction < 2025-01-01 or never_subscribed
+//
+// Conditions:
+//
+//	all, active, expired, never_subscribed
+//	paid                                           made a payment at least once
+//	trial                                          claimed the free trial
+//	language = CODE | language in (CODE, ...)      profile language, "en" also matches "en-gb"
+//	group = NAME | group in (NAME, ...)            group of a linked client
+//	tariff = ID | tariff in (ID, ...)              last bought tariff
+//	promo | promo = CODE | promo in (CODE, ...)    redeemed any or one of the promo codes
+//	last_interaction < DATE | last_interaction > DATE
+//	expires_within DAYS                            active subscription ends within DAYS days
+//	remaining < SIZE                               active limited subscription has less traffic left, like 500MB
+//
+// Expressions are compiled to a single SQL condition on telegram_user_profiles.
+
+type audienceToken struct {
+	kind  string // word, string, punct
+	value string
+	pos   int
+}
+
+func lexAudience(input string) ([]audienceToken, error) {
+	var tokens []audienceToken
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case strings.ContainsRune("(),=<>", r):
+			tokens = append(tokens, audienceToken{kind: "punct", value: string(r), pos: i})
+			i++
+		case r == '"' || r == '\'':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end == len(runes) {
+				return nil, fmt.Errorf("unterminated string at %d", i+1)
+			}
+			tokens = append(tokens, audienceToken{kind: "string", value: string(runes[i+1 : end]), pos: i})
+			i = end + 1
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("(),=<>\"'", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, audienceToken{kind: "word", value: string(runes[start:i]), pos: start})
+		}
+	}
+	return tokens, nil
+}
+
+type audienceNode struct {
+	op       string // and, or, not, cond
+	children []*audienceNode
+	field    string
+	cmp      string
+	values   []string
+}
+
+type audienceParser struct {
+	tokens []audienceToken
+	pos    int
+}
+
+func parseAudienceExpression(input string) (*audienceNode, error) {
+	tokens, err := lexAudience(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("audience expression is empty")
+	}
+	p := &audienceParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, p.errorf("unexpected %q", p.tokens[p.pos].value)
+	}
+	if err := checkAudienceNode(node); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (p *audienceParser) errorf(format string, args ...interface{}) error {
+	position := "end of expression"
+	if p.pos < len(p.tokens) {
+		position = fmt.Sprintf("position %d", p.tokens[p.pos].pos+1)
+	}
+	return fmt.Errorf("audience: "+format+" at %s", append(args, position)...)
+}
+
+func (p *audienceParser) peekKeyword(keyword string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == "word" && strings.EqualFold(p.tokens[p.pos].value, keyword)
+}
+
+func (p *audienceParser) peekPunct(punct string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == "punct" && p.tokens[p.pos].value == punct
+}
+
+func (p *audienceParser) parseOr() (*audienceNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	node := &audienceNode{op: "or", children: []*audienceNode{left}}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, right)
+	}
+	if len(node.children) == 1 {
+		return left, nil
+	}
+	return node, nil
+}
+
+func (p *audienceParser) parseAnd() (*audienceNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	node := &audienceNode{op: "and", children: []*audienceNode{left}}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		node.children = append(node.children, right)
+	}
+	if len(node.children) == 1 {
+		return left, nil
+	}
+	return node, nil
+}
+
+func (p *audienceParser) parseUnary() (*audienceNode, error) {
+	if p.peekKeyword("not") {
+		p.pos++
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &audienceNode{op: "not", children: []*audienceNode{child}}, nil
+	}
+	if p.peekPunct("(") {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekPunct(")") {
+			return nil, p.errorf("missing )")
+		}
+		p.pos++
+		return node, nil
+	}
+	return p.parseCondition()
+}
+
+func (p *audienceParser) parseCondition() (*audienceNode, error) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != "word" {
+		return nil, p.errorf("condition expected")
+	}
+	node := &audienceNode{op: "cond", field: strings.ToLower(p.tokens[p.pos].value)}
+	p.pos++
+	switch {
+	case p.peekPunct("=") || p.peekPunct("<") || p.peekPunct(">"):
+		node.cmp = p.tokens[p.pos].value
+		p.pos++
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.values = []string{value}
+	case p.peekKeyword("in"):
+		node.cmp = "in"
+		p.pos++
+		if !p.peekPunct("(") {
+			return nil, p.errorf("( expected after in")
+		}
+		p.pos++
+		for {
+			value, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			node.values = append(node.values, value)
+			if p.peekPunct(",") {
+				p.pos++
+				continue
+			}
+			if !p.peekPunct(")") {
+				return nil, p.errorf(", or ) expected")
+			}
+			p.pos++
+			break
+		}
+	case node.field == "expires_within":
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		node.values = []string{value}
+	}
+	return node, nil
+}
+
+func (p *audienceParser) parseValue() (string, error) {
+	if p.pos >= len(p.tokens) || p.tokens[p.pos].kind == "punct" {
+		return "", p.errorf("value expected")
+	}
+	value := p.tokens[p.pos].value
+	p.pos++
+	return value, nil
+}
+
+// checkAudienceNode validates fields, operators and values so that compiling cannot fail later.
+func checkAudienceNode(n *audienceNode) error {
+	if n.op != "cond" {
+		for _, child := range n.children {
+			if err := checkAudienceNode(child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	allowed := map[string][]string{
+		"all":              {""},
+		"active":           {""},
+		"expired":          {""},
+		"never_subscribed": {""},
+		"paid":             {""},
+		"trial":            {""},
+		"language":         {"=", "in"},
+		"group":            {"=", "in"},
+		"tariff":           {"=", "in"},
+		"promo":            {"", "=", "in"},
+		"last_interaction": {"<", ">"},
+		"expires_within":   {""},
+		"remaining":        {"<"},
+	}
+	cmps, ok := allowed[n.field]
+	if !ok {
+		return fmt.Errorf("audience: unknown condition %q", n.field)
+	}
+	valid := false
+	for _, cmp := range cmps {
+		valid = valid || cmp == n.cmp
+	}
+	if !valid {
+		if n.cmp == "" {
+			return fmt.Errorf("audience: %s needs a comparison", n.field)
+		}
+		return fmt.Errorf("audience: %s does not support %q", n.field, n.cmp)
+	}
+	var err error
+	switch n.field {
+	case "tariff":
+		for _, v := range n.values {
+			if _, parseErr := strconv.ParseUint(v, 10, 32); parseErr != nil {
+				err = fmt.Errorf("audience: invalid tariff id %q", v)
+			}
+		}
+	case "last_interaction":
+		_, err = parseAudienceDate(n.values[0])
+	case "expires_within":
+		if len(n.values) == 0 {
+			return errors.New("audience: expires_within needs a number of days")
+		}
+		if days, parseErr := strconv.Atoi(n.values[0]); parseErr != nil || days <= 0 {
+			err = fmt.Errorf("audience: invalid number of days %q", n.values[0])
+		}
+	case "remaining":
+		_, err = parseAudienceSize(n.values[0])
+	}
+	return err
+}
+
+func parseAudienceDate(value string) (time.Time, error) {
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02T15:04", "2006-01-02 15:04"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			// Stored times are local, keep the same offset for the comparison
+			return t.Local(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("audience: invalid date %q, use YYYY-MM-DD", value)
+}
+
+func parseAudienceSize(value string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   int64
+	}{{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}}
+	upper := strings.ToUpper(value)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			number, err := strconv.ParseFloat(strings.TrimSuffix(upper, unit.suffix), 64)
+			if err != nil || number < 0 {
+				break
+			}
+			return int64(number * float64(unit.size)), nil
+		}
+	}
+	return 0, fmt.Errorf("audience: invalid size %q, use a unit like 500MB or 2GB", value)
+}
+
+const audienceLinkedClients = "SELECT 1 FROM telegram_user_clients JOIN clients ON clients.id = telegram_user_clients.client_id " +
+	"WHERE telegram_user_clients.user_id = telegram_user_profiles.id"
+
+// audienceActiveClient mirrors clientActive.
+const audienceActiveClient = " AND clients.enable = ? AND (clients.expiry = 0 OR clients.expiry > ?) " +
+	"AND (clients.volume = 0 OR clients.up + clients.down < clients.volume)"
+
+type audienceCompiler struct {
+	now  time.Time
+	args []interface{}
+}
+
+func (c *audienceCompiler) active(extra string, args ...interface{}) string {
+	c.args = append(c.args, true, c.now.Unix())
+	c.args = append(c.args, args...)
+	return "EXISTS (" + audienceLinkedClients + audienceActiveClient + extra + ")"
+}
+
+func (c *audienceCompiler) compile(n *audienceNode) string {
+	switch n.op {
+	case "and", "or":
+		parts := make([]string, 0, len(n.children))
+		for _, child := range n.children {
+			parts = append(parts, c.compile(child))
+		}
+		return "(" + strings.Join(parts, " "+strings.ToUpper(n.op)+" ") + ")"
+	case "not":
+		return "NOT " + c.compile(n.children[0])
+	}
+	switch n.field {
+	case "all":
+		return "1 = 1"
+	case "active":
+		return c.active("")
+	case "expired":
+		return "(EXISTS (" + audienceLinkedClients + ") AND NOT " + c.active("") + ")"
+	case "never_subscribed":
+		c.args = append(c.args, false)
+		return "(telegram_user_profiles.ever_paid = ? AND NOT EXISTS (" + audienceLinkedClients + "))"
+	case "paid":
+		c.args = append(c.args, true)
+		return "telegram_user_profiles.ever_paid = ?"
+	case "trial":
+		return "EXISTS (SELECT 1 FROM telegram_trials WHERE telegram_trials.telegram_id = telegram_user_profiles.telegram_id)"
+	case "language":
+		parts := make([]string, 0, len(n.values))
+		for _, v := range n.values {
+			v = strings.ToLower(v)
+			parts = append(parts, "(LOWER(telegram_user_profiles.language) = ? OR LOWER(telegram_user_profiles.language) LIKE ?)")
+			c.args = append(c.args, v, v+"-%")
+		}
+		return "(" + strings.Join(parts, " OR ") + ")"
+	case "group":
+		c.args = append(c.args, n.values)
+		return "EXISTS (" + audienceLinkedClients + " AND clients.`group` IN ?)"
+	case "tariff":
+		ids := make([]uint, 0, len(n.values))
+		for _, v := range n.values {
+			id, _ := strconv.ParseUint(v, 10, 32)
+			ids = append(ids, uint(id))
+		}
+		c.args = append(c.args, ids)
+		return "telegram_user_profiles.last_tariff_id IN ?"
+	case "promo":
+		query := "EXISTS (SELECT 1 FROM telegram_promo_redemptions JOIN telegram_promo_codes ON telegram_promo_codes.id = telegram_promo_redemptions.promo_code_id " +
+			"WHERE telegram_promo_redemptions.user_id = telegram_user_profiles.id"
+		if len(n.values) > 0 {
+			codes := make([]string, 0, len(n.values))
+			for _, v := range n.values {
+				codes = append(codes, strings.ToUpper(v))
+			}
+			c.args = append(c.args, codes)
+			query += " AND telegram_promo_codes.code IN ?"
+		}
+		return query + ")"
+	case "last_interaction":
+		at, _ := parseAudienceDate(n.values[0])
+		c.args = append(c.args, at)
+		return "telegram_user_profiles.last_interaction_at " + n.cmp + " ?"
+	case "expires_within":
+		// Ends within the window when no active client lasts longer or has no end
+		days, _ := strconv.Atoi(n.values[0])
+		limit := c.now.AddDate(0, 0, days).Unix()
+		return "(" + c.active("") + " AND NOT " + c.active(" AND (clients.expiry = 0 OR clients.expiry > ?)", limit) + ")"
+	case "remaining":
+		size, _ := parseAudienceSize(n.values[0])
+		query := "(" + c.active("") + " AND NOT " + c.active(" AND clients.volume = 0")
+		c.args = append(c.args, true, c.now.Unix(), size)
+		return query + " AND (SELECT COALESCE(SUM(clients.volume - clients.up - clients.down), 0) FROM telegram_user_clients " +
+			"JOIN clients ON clients.id = telegram_user_clients.client_id WHERE telegram_user_clients.user_id = telegram_user_profiles.id" +
+			audienceActiveClient + ") < ?)"
+	}
+	return "1 = 0"
+}
+
+// compileAudience turns an audience into a SQL condition, legacy filters are combined with or.
+func compileAudience(a TelegramBroadcastAudience, now time.Time) (string, []interface{}, error) {
+	var node *audienceNode
+	if strings.TrimSpace(a.Expression) != "" {
+		parsed, err := parseAudienceExpression(a.Expression)
+		if err != nil {
+			return "", nil, err
+		}
+		node = parsed
+	} else {
+		node = &audienceNode{op: "or"}
+		if a.AllUsers {
+			node.children = append(node.children, &audienceNode{op: "cond", field: "all"})
+		}
+		if len(a.TariffIDs) > 0 {
+			tariff := &audienceNode{op: "cond", field: "tariff", cmp: "in"}
+			for _, id := range a.TariffIDs {
+				tariff.values = append(tariff.values, strconv.FormatUint(uint64(id), 10))
+			}
+			node.children = append(node.children, tariff)
+		}
+		if a.IncludeNeverSubscribed {
+			node.children = append(node.children, &audienceNode{op: "cond", field: "never_subscribed"})
+		}
+		if a.IncludeExpired {
+			node.children = append(node.children, &audienceNode{op: "cond", field: "expired"})
+		}
+		if a.IncludeTrial {
+			node.children = append(node.children, &audienceNode{op: "cond", field: "trial"})
+		}
+		if len(node.children) == 0 {
+			return "", nil, errors.New("select at least one audience filter")
+		}
+	}
+	compiler := &audienceCompiler{now: now}
+	query := compiler.compile(node)
+	return query, compiler.args, nil
+}
+
+func (s *TelegramService) selectAudienceUsers(a TelegramBroadcastAudience) ([]model.TelegramUserProfile, error) {
+	query, args, err := compileAudience(a, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	var users []model.TelegramUserProfile
+	err = database.GetDB().Where(query, args...).Order("updated_at desc").Find(&users).Error
+	return users, err
+}
+
+// PreviewAudience counts the users a broadcast with this audience would be sent to now.
+func (s *TelegramService) PreviewAudience(a TelegramBroadcastAudience) (int64, error) {
+	a = normalizeAudience(a)
+	if err := a.Validate(); err != nil {
+		return 0, err
+	}
+	query, args, err := compileAudience(a, time.Now())
+	if err != nil {
+		return 0, err
+	}
+	var count int64
+	err = database.GetDB().Model(&model.TelegramUserProfile{}).Where(query, args...).Count(&count).Error
+	return count, err
+}
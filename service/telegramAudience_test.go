@@ -0,0 +1,258 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+
+	"github.com/op/go-logging"
+)
+
+func initTestDB(t *testing.T) {
+	t.Helper()
+	logger.InitLogger(logging.WARNING)
+	if err := database.InitDB(filepath.Join(t.TempDir(), "s-ui.db")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAudienceParseErrors(t *testing.T) {
+	initTestDB(t)
+	tests := []struct {
+		input string
+		err   string
+	}{
+		{"", "audience expression is empty"},
+		{"   ", "audience expression is empty"},
+		{"paid and", "condition expected at end of expression"},
+		{"not", "condition expected at end of expression"},
+		{"(paid", "missing ) at end of expression"},
+		{"paid)", `unexpected ")" at position 5`},
+		{"paid trial", `unexpected "trial" at position 6`},
+		{"= paid", "condition expected at position 1"},
+		{"language in ru", "( expected after in at position 13"},
+		{"language in (ru en)", ", or ) expected at position 17"},
+		{"language in ()", "value expected at position 14"},
+		{"language =", "value expected at end of expression"},
+		{`language = "ru`, "unterminated string at 12"},
+		{"foo", `unknown condition "foo"`},
+		{"language", "language needs a comparison"},
+		{"paid = 1", `paid does not support "="`},
+		{"remaining > 1GB", `remaining does not support ">"`},
+		{"last_interaction = 2025-01-01", `last_interaction does not support "="`},
+		{"tariff in (1, x)", `invalid tariff id "x"`},
+		{"expires_within", "value expected at end of expression"},
+		{"expires_within 0", `invalid number of days "0"`},
+		{"expires_within soon", `invalid number of days "soon"`},
+		{"remaining < 5XB", `invalid size "5XB"`},
+		{"remaining < -1GB", `invalid size "-1GB"`},
+		{"last_interaction < yesterday", `invalid date "yesterday"`},
+	}
+	for _, tt := range tests {
+		_, err := parseAudienceExpression(tt.input)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("parse %q: got error %v, want %q", tt.input, err, tt.err)
+		}
+	}
+}
+
+// formatAudienceNode prints a parsed expression with explicit grouping
+func formatAudienceNode(n *audienceNode) string {
+	if n.op == "cond" {
+		if n.cmp == "" {
+			return strings.Join(append([]string{n.field}, n.values...), " ")
+		}
+		return n.field + " " + n.cmp + " " + strings.Join(n.values, ",")
+	}
+	parts := []string{n.op}
+	for _, child := range n.children {
+		parts = append(parts, formatAudienceNode(child))
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+func TestAudiencePrecedence(t *testing.T) {
+	tests := []struct {
+		input string
+		tree  string
+	}{
+		{"paid", "paid"},
+		{"paid or trial and active", "(or paid (and trial active))"},
+		{"paid and trial or active", "(or (and paid trial) active)"},
+		{"(paid or trial) and active", "(and (or paid trial) active)"},
+		{"paid and trial and active", "(and paid trial active)"},
+		{"paid or trial or active", "(or paid trial active)"},
+		{"not paid and trial", "(and (not paid) trial)"},
+		{"not (paid and trial)", "(not (and paid trial))"},
+		{"not not paid", "(not (not paid))"},
+		{"((paid))", "paid"},
+		{"PAID OR Trial", "(or paid trial)"},
+		{"language in (ru, 'en-gb') and (expires_within 3 or remaining < 1GB)",
+			"(and language in ru,en-gb (or expires_within 3 remaining < 1GB))"},
+		{"group=vip and not promo", "(and group = vip (not promo))"},
+		{`promo in ("a b", c)`, "promo in a b,c"},
+	}
+	for _, tt := range tests {
+		node, err := parseAudienceExpression(tt.input)
+		if err != nil {
+			t.Errorf("parse %q: %v", tt.input, err)
+			continue
+		}
+		if tree := formatAudienceNode(node); tree != tt.tree {
+			t.Errorf("parse %q: got %s, want %s", tt.input, tree, tt.tree)
+		}
+	}
+}
+
+func TestAudienceCompile(t *testing.T) {
+	initTestDB(t)
+	now := time.Date(2026, 3, 10, 12, 0, 0, 0, time.UTC)
+	ts := now.Unix()
+	loc, _ := (&SettingService{}).GetTimeLocation()
+	date, _ := time.ParseInLocation("2006-01-02", "2025-01-01", loc)
+
+	linked := "SELECT 1 FROM telegram_user_clients JOIN clients ON clients.id = telegram_user_clients.client_id " +
+		"WHERE telegram_user_clients.user_id = telegram_user_profiles.id"
+	activeClient := " AND clients.enable = ? AND (clients.expiry = 0 OR clients.expiry > ?) " +
+		"AND (clients.volume = 0 OR clients.up + clients.down < clients.volume)"
+	active := "EXISTS (" + linked + activeClient + ")"
+	language := "(LOWER(telegram_user_profiles.language) = ? OR LOWER(telegram_user_profiles.language) LIKE ?)"
+	promo := "EXISTS (SELECT 1 FROM telegram_promo_redemptions JOIN telegram_promo_codes ON telegram_promo_codes.id = telegram_promo_redemptions.promo_code_id " +
+		"WHERE telegram_promo_redemptions.user_id = telegram_user_profiles.id"
+	trial := "EXISTS (SELECT 1 FROM telegram_trials WHERE telegram_trials.telegram_id = telegram_user_profiles.telegram_id)"
+
+	tests := []struct {
+		input string
+		sql   string
+		args  []interface{}
+	}{
+		{"all", "1 = 1", nil},
+		{"active", active, []interface{}{true, ts}},
+		{"expired", "(EXISTS (" + linked + ") AND NOT " + active + ")", []interface{}{true, ts}},
+		{"never_subscribed", "(telegram_user_profiles.ever_paid = ? AND NOT EXISTS (" + linked + "))", []interface{}{false}},
+		{"paid", "telegram_user_profiles.ever_paid = ?", []interface{}{true}},
+		{"trial", trial, nil},
+		{"language = RU", "(" + language + ")", []interface{}{"ru", "ru-%"}},
+		{"language in (ru, en)", "(" + language + " OR " + language + ")", []interface{}{"ru", "ru-%", "en", "en-%"}},
+		{"group = vip", "EXISTS (" + linked + " AND clients.`group` IN ?)", []interface{}{[]string{"vip"}}},
+		{"group in (vip, 'old users')", "EXISTS (" + linked + " AND clients.`group` IN ?)", []interface{}{[]string{"vip", "old users"}}},
+		{"tariff in (1, 2)", "telegram_user_profiles.last_tariff_id IN ?", []interface{}{[]uint{1, 2}}},
+		{"promo", promo + ")", nil},
+		{"promo = spring", promo + " AND telegram_promo_codes.code IN ?)", []interface{}{[]string{"SPRING"}}},
+		{"last_interaction < 2025-01-01", "telegram_user_profiles.last_interaction_at < ?", []interface{}{date.Local()}},
+		{"last_interaction > 2025-01-01", "telegram_user_profiles.last_interaction_at > ?", []interface{}{date.Local()}},
+		{"expires_within 3",
+			"(" + active + " AND NOT EXISTS (" + linked + activeClient + " AND (clients.expiry = 0 OR clients.expiry > ?)))",
+			[]interface{}{true, ts, true, ts, now.AddDate(0, 0, 3).Unix()}},
+		{"remaining < 1GB",
+			"(" + active + " AND NOT EXISTS (" + linked + activeClient + " AND clients.volume = 0)" +
+				" AND (SELECT COALESCE(SUM(clients.volume - clients.up - clients.down), 0) FROM telegram_user_clients " +
+				"JOIN clients ON clients.id = telegram_user_clients.client_id WHERE telegram_user_clients.user_id = telegram_user_profiles.id" +
+				activeClient + ") < ?)",
+			[]interface{}{true, ts, true, ts, true, ts, int64(1 << 30)}},
+		{"remaining < 1.5kb", "", []interface{}{true, ts, true, ts, true, ts, int64(1536)}},
+		{"paid and not (trial or group = vip)",
+			"(telegram_user_profiles.ever_paid = ? AND NOT (" + trial + " OR EXISTS (" + linked + " AND clients.`group` IN ?)))",
+			[]interface{}{true, []string{"vip"}}},
+	}
+	for _, tt := range tests {
+		sql, args, err := compileAudience(TelegramBroadcastAudience{Expression: tt.input}, now)
+		if err != nil {
+			t.Errorf("compile %q: %v", tt.input, err)
+			continue
+		}
+		if tt.sql != "" && sql != tt.sql {
+			t.Errorf("compile %q:\n got %s\nwant %s", tt.input, sql, tt.sql)
+		}
+		if !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("compile %q: got args %#v, want %#v", tt.input, args, tt.args)
+		}
+	}
+
+	// Filters without an expression are combined with or
+	sql, args, err := compileAudience(TelegramBroadcastAudience{AllUsers: true, TariffIDs: []uint{4}, IncludeTrial: true}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "(1 = 1 OR telegram_user_profiles.last_tariff_id IN ? OR " + trial + ")"; sql != want {
+		t.Errorf("legacy audience:\n got %s\nwant %s", sql, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{[]uint{4}}) {
+		t.Errorf("legacy audience: got args %#v", args)
+	}
+	if _, _, err := compileAudience(TelegramBroadcastAudience{}, now); err == nil {
+		t.Error("empty legacy audience compiled")
+	}
+}
+
+func TestAudienceSelectsExpiringAndRemaining(t *testing.T) {
+	initTestDB(t)
+	db := database.GetDB()
+	now := time.Now()
+	day := int64(24 * 60 * 60)
+	// Each user has the clients listed, a client is {expiry in days from now, volume, used}
+	users := []struct {
+		name    string
+		clients [][3]int64
+	}{
+		{"ends-in-2-days", [][3]int64{{2, 0, 0}}},
+		{"ends-in-10-days", [][3]int64{{10, 0, 0}}},
+		{"one-client-never-ends", [][3]int64{{2, 0, 0}, {0, 0, 0}}},
+		{"expired", [][3]int64{{-1, 0, 0}}},
+		{"100MB-left", [][3]int64{{0, 1 << 30, 924 << 20}}},
+		{"2GB-left-on-two-clients", [][3]int64{{0, 1 << 30, 0}, {0, 1 << 30, 0}}},
+		{"one-client-unlimited", [][3]int64{{0, 1 << 30, 1000 << 20}, {0, 0, 0}}},
+		{"volume-used-up", [][3]int64{{0, 1 << 30, 1 << 30}}},
+	}
+	for i, u := range users {
+		profile := model.TelegramUserProfile{TelegramID: int64(100 + i), FirstName: u.name}
+		if err := db.Create(&profile).Error; err != nil {
+			t.Fatal(err)
+		}
+		for j, c := range u.clients {
+			client := model.Client{Name: u.name + string(rune('a'+j)), Enable: true, Volume: c[1], Up: c[2],
+				Config: json.RawMessage("{}"), Inbounds: json.RawMessage("[]"), Links: json.RawMessage("[]")}
+			if c[0] != 0 {
+				client.Expiry = now.Unix() + c[0]*day
+			}
+			if err := db.Create(&client).Error; err != nil {
+				t.Fatal(err)
+			}
+			db.Create(&model.TelegramUserClient{UserID: profile.ID, ClientID: client.Id})
+		}
+	}
+	service := TelegramService{}
+	tests := []struct {
+		expression string
+		users      []string
+	}{
+		{"expires_within 3", []string{"ends-in-2-days"}},
+		{"expires_within 30", []string{"ends-in-10-days", "ends-in-2-days"}},
+		{"remaining < 500MB", []string{"100MB-left"}},
+		{"remaining < 3GB", []string{"100MB-left", "2GB-left-on-two-clients"}},
+		{"expired", []string{"expired", "volume-used-up"}},
+	}
+	for _, tt := range tests {
+		selected, err := service.selectAudienceUsers(TelegramBroadcastAudience{Expression: tt.expression})
+		if err != nil {
+			t.Errorf("%s: %v", tt.expression, err)
+			continue
+		}
+		names := []string{}
+		for _, u := range selected {
+			names = append(names, u.FirstName)
+		}
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, tt.users) {
+			t.Errorf("%s: got %v, want %v", tt.expression, names, tt.users)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+const telegramAPITimeout = 15 * time.Second
+
+var (
+	telegramAPIMu      sync.RWMutex
+	telegramAPIFactory = defaultTelegramAPIFactory
+
+	// Bot usernames by token, they only change with the token
+	telegramUsernames   = make(map[string]string)
+	telegramUsernamesMu sync.Mutex
+)
+
+func defaultTelegramAPIFactory(cfg *model.TelegramBotConfig) botapi.API {
+	return botapi.NewClient(cfg.BotToken, cfg.APIBaseURL)
+}
+
+// SetTelegramAPIFactory replaces the Bot API transport. Passing nil restores the HTTP client.
+func SetTelegramAPIFactory(factory func(cfg *model.TelegramBotConfig) botapi.API) {
+	telegramAPIMu.Lock()
+	defer telegramAPIMu.Unlock()
+	if factory == nil {
+		factory = defaultTelegramAPIFactory
+	}
+	telegramAPIFactory = factory
+}
+
+func (s *TelegramService) BotAPI() (botapi.API, error) {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	return s.botAPIFor(cfg)
+}
+
+func (s *TelegramService) botAPIFor(cfg *model.TelegramBotConfig) (botapi.API, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, errors.New("telegram bot is disabled")
+	}
+	return newTelegramAPI(cfg)
+}
+
+// newTelegramAPI builds a client without checking whether the bot is enabled,
+// which is needed to clean up after a bot that has just been disabled.
+func newTelegramAPI(cfg *model.TelegramBotConfig) (botapi.API, error) {
+	if cfg == nil || strings.TrimSpace(cfg.BotToken) == "" {
+		return nil, errors.New("telegram bot token is not configured")
+	}
+	telegramAPIMu.RLock()
+	factory := telegramAPIFactory
+	telegramAPIMu.RUnlock()
+	return factory(cfg), nil
+}
+
+// botUsername returns the username of the configured bot, needed for t.me links.
+func (s *TelegramService) botUsername() (string, error) {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	telegramUsernamesMu.Lock()
+	username, ok := telegramUsernames[cfg.BotToken]
+	telegramUsernamesMu.Unlock()
+	if ok {
+		return username, nil
+	}
+	api, err := s.botAPIFor(cfg)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := telegramContext()
+	defer cancel()
+	me, err := api.GetMe(ctx)
+	if err != nil {
+		return "", err
+	}
+	if me.Username == "" {
+		return "", errors.New("telegram bot has no username")
+	}
+	telegramUsernamesMu.Lock()
+	telegramUsernames[cfg.BotToken] = me.Username
+	telegramUsernamesMu.Unlock()
+	return me.Username, nil
+}
+
+func telegramContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), telegramAPITimeout)
+}
+
+func formatTelegramMessageID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+func parseTelegramMessageID(id string) (int64, error) {
+	messageID, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+	if err != nil || messageID <= 0 {
+		return 0, errors.New("delivery has no telegram message id")
+	}
+	return messageID, nil
+}
@@ -0,0 +1,497 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+
+	"gorm.io/gorm"
+)
+
+const (
+	broadcastDraft           = "draft"
+	broadcastScheduled       = "scheduled"
+	broadcastQueued          = "queued"
+	broadcastSending         = "sending"
+	broadcastSent            = "sent"
+	broadcastPartiallyFailed = "partially_failed"
+	broadcastFailed          = "failed"
+
+	deliveryQueued = "queued"
+	deliverySent   = "sent"
+	deliveryFailed = "failed"
+
+	editQueued = "queued"
+	editDone   = "edited"
+	editFailed = "failed"
+)
+
+const (
+	// Telegram allows about 30 messages per second to different chats
+	broadcastRate       = 30
+	broadcastBatchSize  = 100
+	broadcastMaxRetries = 5
+	// Attempts to store the result of a delivery before the worker gives up
+	deliverySaveAttempts = 3
+)
+
+type broadcastQueue struct {
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	wake    chan struct{}
+	nextAt  time.Time
+	limiter sync.Mutex
+}
+
+var telegramBroadcastQueue = &broadcastQueue{wake: make(chan struct{}, 1)}
+
+// StartBroadcastQueue runs the delivery worker. Broadcasts left queued or sending by a previous run are resumed.
+func (s *TelegramService) StartBroadcastQueue() {
+	q := telegramBroadcastQueue
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.done = make(chan struct{})
+	go s.runBroadcastQueue(ctx, q.done)
+	q.signal()
+}
+
+func (s *TelegramService) StopBroadcastQueue() {
+	q := telegramBroadcastQueue
+	q.mu.Lock()
+	cancel, done := q.cancel, q.done
+	q.cancel, q.done = nil, nil
+	q.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (q *broadcastQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *TelegramService) runBroadcastQueue(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	// Also retries broadcasts that waited for the bot to be enabled
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-telegramBroadcastQueue.wake:
+		case <-ticker.C:
+		}
+		s.processBroadcasts(ctx)
+	}
+}
+
+// processBroadcasts sends pending broadcasts and then edits sent messages.
+func (s *TelegramService) processBroadcasts(ctx context.Context) {
+	if s.sendBroadcasts(ctx) {
+		s.editBroadcasts(ctx)
+	}
+}
+
+// sendBroadcasts sends pending broadcasts one after another, oldest first, and reports whether all are sent.
+func (s *TelegramService) sendBroadcasts(ctx context.Context) bool {
+	db := database.GetDB()
+	for ctx.Err() == nil {
+		var broadcast model.TelegramBroadcast
+		err := db.Where("status IN ?", []string{broadcastQueued, broadcastSending}).Order("id asc").First(&broadcast).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return true
+		}
+		if err != nil {
+			logger.Warning("telegram broadcast: unable to load queue:", err)
+			return false
+		}
+		api, err := s.BotAPI()
+		if err != nil {
+			logger.Debugf("telegram broadcast %d waits: %v", broadcast.ID, err)
+			return false
+		}
+		if broadcast.Status == broadcastQueued {
+			if err := db.Model(&broadcast).Update("status", broadcastSending).Error; err != nil {
+				logger.Warning("telegram broadcast: unable to start sending:", err)
+				return false
+			}
+			s.notifyChange()
+		}
+		if err := s.deliverBroadcast(ctx, api, &broadcast); err != nil {
+			if ctx.Err() == nil {
+				logger.Warningf("telegram broadcast %d interrupted: %v", broadcast.ID, err)
+			}
+			return false
+		}
+		if err := s.finishBroadcast(&broadcast); err != nil {
+			logger.Warningf("telegram broadcast %d: unable to finish: %v", broadcast.ID, err)
+			return false
+		}
+	}
+	return false
+}
+
+// deliverBroadcast sends the queued deliveries in batches. Every delivery is stored on its own,
+// so no write lock is held while waiting for Telegram and a restart continues where it stopped.
+func (s *TelegramService) deliverBroadcast(ctx context.Context, api botapi.API, broadcast *model.TelegramBroadcast) error {
+	db := database.GetDB()
+	lastID := uint(0)
+	for {
+		var deliveries []model.TelegramBroadcastDelivery
+		err := db.Where("broadcast_id = ? AND status = ? AND id > ?", broadcast.ID, deliveryQueued, lastID).
+			Order("id asc").Limit(broadcastBatchSize).Find(&deliveries).Error
+		if err != nil {
+			return err
+		}
+		if len(deliveries) == 0 {
+			return nil
+		}
+		userIDs := make([]uint, 0, len(deliveries))
+		for _, d := range deliveries {
+			userIDs = append(userIDs, d.UserID)
+		}
+		var users []model.TelegramUserProfile
+		if err := db.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+			return err
+		}
+		usersByID := make(map[uint]*model.TelegramUserProfile, len(users))
+		for i := range users {
+			usersByID[users[i].ID] = &users[i]
+		}
+		for i := range deliveries {
+			delivery := &deliveries[i]
+			lastID = delivery.ID
+			var messageID string
+			var sendErr error
+			if user, ok := usersByID[delivery.UserID]; ok {
+				messageID, sendErr = s.sendBroadcastWithRetry(ctx, api, broadcast, user)
+			} else {
+				sendErr = errors.New("user not found")
+			}
+			if sendErr != nil && errors.Is(sendErr, ctx.Err()) {
+				// Not sent, stays queued and is sent again after the restart
+				return ctx.Err()
+			}
+			// The message id is stored first, so a sent message is never sent again
+			now := time.Now()
+			updates := map[string]interface{}{
+				"status":              deliverySent,
+				"telegram_message_id": messageID,
+				"error_message":       "",
+				"sent_at":             &now,
+			}
+			if sendErr != nil {
+				updates["status"] = deliveryFailed
+				updates["error_message"] = sendErr.Error()
+			}
+			if err := saveDelivery(ctx, delivery, updates); err != nil {
+				return err
+			}
+			if sendErr == nil {
+				if err := s.recordBroadcastMessage(broadcast, delivery.UserID, messageID); err != nil {
+					logger.Warningf("telegram broadcast %d: unable to store message for user %d: %v", broadcast.ID, delivery.UserID, err)
+				}
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+		}
+		s.notifyChange()
+	}
+}
+
+// editBroadcasts edits the sent messages of broadcasts with queued edits, one broadcast after another.
+func (s *TelegramService) editBroadcasts(ctx context.Context) {
+	db := database.GetDB()
+	for ctx.Err() == nil {
+		var next model.TelegramBroadcastDelivery
+		err := db.Where("edit_status = ?", editQueued).Order("id asc").First(&next).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return
+		}
+		if err != nil {
+			logger.Warning("telegram broadcast: unable to load edits:", err)
+			return
+		}
+		var broadcast model.TelegramBroadcast
+		if err := db.First(&broadcast, next.BroadcastID).Error; err != nil {
+			logger.Warningf("telegram broadcast %d: unable to load for edits: %v", next.BroadcastID, err)
+			return
+		}
+		api, err := s.BotAPI()
+		if err != nil {
+			logger.Debugf("telegram broadcast %d edits wait: %v", broadcast.ID, err)
+			return
+		}
+		edited, err := s.editBroadcastDeliveries(ctx, api, &broadcast)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Warningf("telegram broadcast %d: edits interrupted: %v", broadcast.ID, err)
+			}
+			return
+		}
+		if edited == 0 {
+			// Edited again meanwhile, the edit wakes the queue for the new revision
+			return
+		}
+		logger.Infof("telegram broadcast %d: edits of %d messages done", broadcast.ID, edited)
+	}
+}
+
+// editBroadcastDeliveries edits the queued messages of the broadcast to its current content in batches.
+// Every result is stored on its own, so no write lock is held while waiting for Telegram.
+func (s *TelegramService) editBroadcastDeliveries(ctx context.Context, api botapi.API, broadcast *model.TelegramBroadcast) (int, error) {
+	db := database.GetDB()
+	edited := 0
+	lastID := uint(0)
+	for {
+		var deliveries []model.TelegramBroadcastDelivery
+		err := db.Where("broadcast_id = ? AND edit_status = ? AND edit_revision <= ? AND id > ?",
+			broadcast.ID, editQueued, broadcast.EditRevision, lastID).
+			Order("id asc").Limit(broadcastBatchSize).Find(&deliveries).Error
+		if err != nil {
+			return edited, err
+		}
+		if len(deliveries) == 0 {
+			return edited, nil
+		}
+		userIDs := make([]uint, 0, len(deliveries))
+		for _, d := range deliveries {
+			userIDs = append(userIDs, d.UserID)
+		}
+		var users []model.TelegramUserProfile
+		if err := db.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+			return edited, err
+		}
+		usersByID := make(map[uint]*model.TelegramUserProfile, len(users))
+		for i := range users {
+			usersByID[users[i].ID] = &users[i]
+		}
+		for i := range deliveries {
+			delivery := &deliveries[i]
+			lastID = delivery.ID
+			var editErr error
+			if user, ok := usersByID[delivery.UserID]; ok {
+				editErr = s.editBroadcastMessage(ctx, api, broadcast, delivery, user)
+			} else {
+				editErr = errors.New("user not found")
+			}
+			if editErr != nil && errors.Is(editErr, ctx.Err()) {
+				// Stays queued and is edited after the restart
+				return edited, ctx.Err()
+			}
+			updates := map[string]interface{}{
+				"edit_status":   editDone,
+				"edit_media":    false,
+				"error_message": "",
+			}
+			if editErr != nil {
+				updates = map[string]interface{}{
+					"edit_status":   editFailed,
+					"error_message": editErr.Error(),
+				}
+			}
+			// An edit queued for a newer revision meanwhile stays queued
+			result := db.Model(&model.TelegramBroadcastDelivery{}).
+				Where("id = ? AND edit_revision = ?", delivery.ID, delivery.EditRevision).
+				Updates(updates)
+			if result.Error != nil {
+				return edited, result.Error
+			}
+			edited += int(result.RowsAffected)
+			if ctx.Err() != nil {
+				return edited, ctx.Err()
+			}
+		}
+		s.notifyChange()
+	}
+}
+
+func (s *TelegramService) editBroadcastMessage(ctx context.Context, api botapi.API, broadcast *model.TelegramBroadcast, delivery *model.TelegramBroadcastDelivery, user *model.TelegramUserProfile) error {
+	messageID, err := parseTelegramMessageID(delivery.TelegramMessageID)
+	if err != nil {
+		return err
+	}
+	fileID := broadcast.MediaFileID
+	err = withBroadcastRetry(ctx, broadcast.ID, func() error {
+		callCtx, cancel := telegramContext()
+		defer cancel()
+		return editBroadcastContent(callCtx, api, broadcast, delivery.EditMedia, user.TelegramID, messageID)
+	})
+	if err != nil {
+		return err
+	}
+	db := database.GetDB()
+	if broadcast.MediaFileID != fileID {
+		// Only for the media it was uploaded for, the broadcast may have been edited meanwhile
+		if err := db.Model(&model.TelegramBroadcast{}).Where("id = ? AND media_file = ?", broadcast.ID, broadcast.MediaFile).
+			Update("media_file_id", broadcast.MediaFileID).Error; err != nil {
+			logger.Warningf("telegram broadcast %d: unable to store media file id: %v", broadcast.ID, err)
+		}
+	}
+	// The message is edited, a transcript that is not updated is only logged
+	if err := updateBroadcastTranscript(broadcast, delivery); err != nil {
+		logger.Warningf("telegram broadcast %d: unable to update message for user %d: %v", broadcast.ID, delivery.UserID, err)
+	}
+	return nil
+}
+
+// updateBroadcastTranscript replaces the conversation entry of an edited message.
+func updateBroadcastTranscript(broadcast *model.TelegramBroadcast, delivery *model.TelegramBroadcastDelivery) error {
+	db := database.GetDB()
+	now := time.Now()
+	result := db.Model(&model.TelegramUserMessage{}).
+		Where("user_id = ? AND telegram_message_id = ?", delivery.UserID, delivery.TelegramMessageID).
+		Updates(map[string]interface{}{
+			"body":       broadcastTranscript(broadcast),
+			"updated_at": now,
+		})
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	return db.Create(&model.TelegramUserMessage{
+		UserID:            delivery.UserID,
+		Direction:         "outbound",
+		Body:              broadcastTranscript(broadcast),
+		TelegramMessageID: delivery.TelegramMessageID,
+		Seen:              true,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}).Error
+}
+
+// saveDelivery stores the result of a delivery, again for a while when the database is busy,
+// as a delivery that stays queued would be sent twice.
+func saveDelivery(ctx context.Context, delivery *model.TelegramBroadcastDelivery, updates map[string]interface{}) error {
+	var err error
+	for attempt := 0; attempt < deliverySaveAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+		if err = database.GetDB().Model(delivery).Updates(updates).Error; err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// sendBroadcastWithRetry waits for the rate limit and retries as long as Telegram asks to slow down.
+func (s *TelegramService) sendBroadcastWithRetry(ctx context.Context, api botapi.API, broadcast *model.TelegramBroadcast, user *model.TelegramUserProfile) (string, error) {
+	var messageID string
+	err := withBroadcastRetry(ctx, broadcast.ID, func() error {
+		var err error
+		messageID, err = s.sendBroadcastMessage(api, broadcast, user)
+		return err
+	})
+	return messageID, err
+}
+
+// withBroadcastRetry calls the Bot API within the broadcast rate limit, again as long as Telegram
+// asks to slow down.
+func withBroadcastRetry(ctx context.Context, broadcastID uint, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := telegramBroadcastQueue.wait(ctx); err != nil {
+			return err
+		}
+		err := call()
+		var apiErr *botapi.Error
+		if err == nil || !errors.As(err, &apiErr) || apiErr.Code != 429 || attempt >= broadcastMaxRetries {
+			return err
+		}
+		retryAfter := time.Duration(apiErr.RetryAfter) * time.Second
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+		logger.Debugf("telegram broadcast %d: rate limited, retrying in %s", broadcastID, retryAfter)
+		telegramBroadcastQueue.delay(retryAfter)
+	}
+}
+
+// wait blocks until the next message fits into the rate limit.
+func (q *broadcastQueue) wait(ctx context.Context) error {
+	q.limiter.Lock()
+	now := time.Now()
+	at := q.nextAt
+	if at.Before(now) {
+		at = now
+	}
+	q.nextAt = at.Add(time.Second / broadcastRate)
+	q.limiter.Unlock()
+
+	timer := time.NewTimer(time.Until(at))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// delay holds back all messages, as a 429 applies to the whole bot.
+func (q *broadcastQueue) delay(d time.Duration) {
+	q.limiter.Lock()
+	defer q.limiter.Unlock()
+	if at := time.Now().Add(d); at.After(q.nextAt) {
+		q.nextAt = at
+	}
+}
+
+func (s *TelegramService) finishBroadcast(broadcast *model.TelegramBroadcast) error {
+	db := database.GetDB()
+	var counts []struct {
+		Status string
+		Total  int64
+	}
+	if err := db.Model(&model.TelegramBroadcastDelivery{}).Select("status, count(*) as total").
+		Where("broadcast_id = ?", broadcast.ID).Group("status").Scan(&counts).Error; err != nil {
+		return err
+	}
+	var sent, failed int64
+	for _, c := range counts {
+		switch c.Status {
+		case deliverySent:
+			sent = c.Total
+		case deliveryFailed:
+			failed = c.Total
+		}
+	}
+	status := broadcastSent
+	switch {
+	case failed > 0 && sent == 0:
+		status = broadcastFailed
+	case failed > 0:
+		status = broadcastPartiallyFailed
+	}
+	now := time.Now()
+	if err := db.Model(broadcast).Updates(map[string]interface{}{
+		"status":  status,
+		"sent_at": &now,
+	}).Error; err != nil {
+		return err
+	}
+	logger.Infof("telegram broadcast %d finished: %d sent, %d failed", broadcast.ID, sent, failed)
+	s.notifyChange()
+	return nil
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var telegramScheduleMu sync.Mutex
+
+type TelegramBroadcastSchedulePayload struct {
+	ID          uint       `json:"id"`
+	ScheduledAt *time.Time `json:"scheduledAt"`
+	Recurrence  string     `json:"recurrence"`
+}
+
+// parseRecurrence accepts five field cron specs and descriptors like @weekly, evaluated in the panel time zone.
+func parseRecurrence(spec string) (cron.Schedule, error) {
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+	schedule, err := cron.ParseStandard("CRON_TZ=" + loc.String() + " " + spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid recurrence %q: %w", spec, err)
+	}
+	return schedule, nil
+}
+
+// ScheduleBroadcast sends a draft at ScheduledAt, or on every occurrence of Recurrence.
+// Without ScheduledAt a recurring broadcast starts at its next occurrence.
+func (s *TelegramService) ScheduleBroadcast(payload *TelegramBroadcastSchedulePayload) (*TelegramBroadcastDTO, error) {
+	if payload == nil || payload.ID == 0 {
+		return nil, errors.New("broadcast id is required")
+	}
+	recurrence := strings.TrimSpace(payload.Recurrence)
+	now := time.Now()
+	var next time.Time
+	if recurrence != "" {
+		schedule, err := parseRecurrence(recurrence)
+		if err != nil {
+			return nil, err
+		}
+		next = schedule.Next(now)
+	}
+	if payload.ScheduledAt != nil {
+		next = *payload.ScheduledAt
+	}
+	if next.IsZero() {
+		return nil, errors.New("send time or recurrence is required")
+	}
+	if recurrence == "" && !next.After(now) {
+		return nil, errors.New("send time must be in the future")
+	}
+
+	db := database.GetDB()
+	telegramScheduleMu.Lock()
+	defer telegramScheduleMu.Unlock()
+	var broadcast model.TelegramBroadcast
+	if err := db.First(&broadcast, payload.ID).Error; err != nil {
+		return nil, err
+	}
+	if broadcast.Status != broadcastDraft && broadcast.Status != broadcastScheduled {
+		return nil, errors.New("only draft broadcasts can be scheduled")
+	}
+	if broadcast.ParentID != nil {
+		return nil, errors.New("occurrences of a recurring broadcast cannot be scheduled")
+	}
+	if err := decodeAudience(broadcast.Audience).Validate(); err != nil {
+		return nil, err
+	}
+	if err := db.Model(&broadcast).Updates(map[string]interface{}{
+		"status":       broadcastScheduled,
+		"scheduled_at": &next,
+		"recurrence":   recurrence,
+	}).Error; err != nil {
+		return nil, err
+	}
+	s.notifyChange()
+	return s.getBroadcastDTO(broadcast.ID)
+}
+
+// CancelBroadcastSchedule turns a pending scheduled broadcast back into a draft.
+func (s *TelegramService) CancelBroadcastSchedule(id uint) (*TelegramBroadcastDTO, error) {
+	if id == 0 {
+		return nil, errors.New("broadcast id is required")
+	}
+	telegramScheduleMu.Lock()
+	defer telegramScheduleMu.Unlock()
+	db := database.GetDB()
+	result := db.Model(&model.TelegramBroadcast{}).Where("id = ? AND status = ?", id, broadcastScheduled).
+		Updates(map[string]interface{}{
+			"status":       broadcastDraft,
+			"scheduled_at": nil,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, errors.New("broadcast is not scheduled")
+	}
+	s.notifyChange()
+	return s.getBroadcastDTO(id)
+}
+
+// DispatchScheduledBroadcasts queues every scheduled broadcast that is due.
+func (s *TelegramService) DispatchScheduledBroadcasts() error {
+	telegramScheduleMu.Lock()
+	defer telegramScheduleMu.Unlock()
+	db := database.GetDB()
+	now := time.Now()
+	var due []model.TelegramBroadcast
+	if err := db.Where("status = ? AND scheduled_at <= ?", broadcastScheduled, now).
+		Order("scheduled_at asc").Find(&due).Error; err != nil {
+		return err
+	}
+	queued := false
+	for i := range due {
+		broadcast := &due[i]
+		var err error
+		if broadcast.Recurrence == "" {
+			err = s.dispatchScheduledOnce(db, broadcast)
+		} else {
+			err = s.dispatchOccurrence(db, broadcast, now)
+		}
+		if err != nil {
+			logger.Warningf("telegram broadcast %d: scheduled send failed: %v", broadcast.ID, err)
+			continue
+		}
+		queued = true
+	}
+	if len(due) > 0 {
+		if queued {
+			telegramBroadcastQueue.signal()
+		}
+		s.notifyChange()
+	}
+	return nil
+}
+
+func (s *TelegramService) dispatchScheduledOnce(db *gorm.DB, broadcast *model.TelegramBroadcast) error {
+	err := s.queueBroadcast(db, broadcast)
+	if err != nil {
+		// Back to draft, so the admin sees it was not sent
+		db.Model(broadcast).Updates(map[string]interface{}{"status": broadcastDraft, "scheduled_at": nil})
+	}
+	return err
+}
+
+// dispatchOccurrence sends a copy of a recurring broadcast and moves it to its next occurrence.
+// Occurrences missed while the panel was down are not sent again.
+func (s *TelegramService) dispatchOccurrence(db *gorm.DB, template *model.TelegramBroadcast, now time.Time) error {
+	schedule, err := parseRecurrence(template.Recurrence)
+	if err != nil {
+		db.Model(template).Updates(map[string]interface{}{"status": broadcastDraft, "scheduled_at": nil})
+		return err
+	}
+	next := schedule.Next(now)
+	if err := db.Model(template).Update("scheduled_at", &next).Error; err != nil {
+		return err
+	}
+	parentID := template.ID
+	occurrence := model.TelegramBroadcast{
+		Title:       fmt.Sprintf("%s (%s)", template.Title, now.Format("2006-01-02")),
+		Body:        template.Body,
+		ParseMode:   template.ParseMode,
+		MediaType:   template.MediaType,
+		MediaFile:   template.MediaFile,
+		MediaName:   template.MediaName,
+		MediaFileID: template.MediaFileID,
+		Buttons:     template.Buttons,
+		Editable:    template.Editable,
+		Status:      broadcastDraft,
+		Audience:    template.Audience,
+		ParentID:    &parentID,
+	}
+	if err := db.Omit(clause.Associations).Create(&occurrence).Error; err != nil {
+		return err
+	}
+	if err := s.queueBroadcast(db, &occurrence); err != nil {
+		db.Delete(&occurrence)
+		return err
+	}
+	return nil
+}
+
+func (s *TelegramService) getBroadcastDTO(id uint) (*TelegramBroadcastDTO, error) {
+	var broadcast model.TelegramBroadcast
+	if err := database.GetDB().Preload("Deliveries").First(&broadcast, id).Error; err != nil {
+		return nil, err
+	}
+	return newTelegramBroadcastDTO(&broadcast, broadcast.Deliveries), nil
+}
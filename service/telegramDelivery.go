@@ -0,0 +1,94 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+// TelegramClientAccess is what a Telegram user needs to connect with one of their clients.
+// Up, Down, Volume and Expiry are the numbers the subscription endpoint reports in its userinfo header.
+type TelegramClientAccess struct {
+	ClientID uint     `json:"clientId"`
+	Name     string   `json:"name"`
+	Enable   bool     `json:"enable"`
+	SubURL   string   `json:"subUrl"`
+	Links    []string `json:"links"`
+	Up       int64    `json:"up"`
+	Down     int64    `json:"down"`
+	Volume   int64    `json:"volume"`
+	Expiry   int64    `json:"expiry"`
+}
+
+type telegramClientLink struct {
+	Type   string `json:"type"`
+	Remark string `json:"remark"`
+	Uri    string `json:"uri"`
+}
+
+// GetClientAccess returns the subscription of every client linked to the Telegram user.
+func (s *TelegramService) GetClientAccess(telegramID int64) ([]TelegramClientAccess, error) {
+	profile, err := s.EnsureUserProfile(telegramID)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	clients, err := s.linkedClients(db, profile.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(clients) == 0 {
+		return []TelegramClientAccess{}, nil
+	}
+	subURI, err := s.subscriptionURI()
+	if err != nil {
+		return nil, err
+	}
+	result := make([]TelegramClientAccess, 0, len(clients))
+	for i := range clients {
+		access, err := newClientAccess(&clients[i], subURI)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *access)
+	}
+	return result, nil
+}
+
+func (s *TelegramService) subscriptionURI() (string, error) {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return "", err
+	}
+	settingService := SettingService{}
+	return settingService.GetFinalSubURI(telegramHostname(cfg))
+}
+
+func newClientAccess(client *model.Client, subURI string) (*TelegramClientAccess, error) {
+	access := &TelegramClientAccess{
+		ClientID: client.Id,
+		Name:     client.Name,
+		Enable:   client.Enable,
+		SubURL:   subURI + client.Name,
+		Links:    []string{},
+		Up:       client.Up,
+		Down:     client.Down,
+		Volume:   client.Volume,
+		Expiry:   client.Expiry,
+	}
+	var links []telegramClientLink
+	if len(client.Links) > 0 {
+		if err := json.Unmarshal(client.Links, &links); err != nil {
+			return nil, errors.New("invalid links of client " + client.Name)
+		}
+	}
+	for _, link := range links {
+		// External subscriptions are fetched by the sub endpoint, only direct links are listed
+		if link.Type != "sub" && link.Uri != "" {
+			access.Links = append(access.Links, link.Uri)
+		}
+	}
+	return access, nil
+}
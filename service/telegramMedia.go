@@ -0,0 +1,409 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+const (
+	broadcastMediaPhoto    = "photo"
+	broadcastMediaDocument = "document"
+
+	// Upload limits of the Bot API
+	maxBroadcastPhotoSize    = 10 << 20
+	maxBroadcastDocumentSize = 50 << 20
+
+	maxBroadcastTextLength    = 4096
+	maxBroadcastCaptionLength = 1024
+)
+
+var broadcastParseModes = map[string]bool{"": true, "HTML": true, "MarkdownV2": true, "Markdown": true}
+
+var broadcastPhotoExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+
+// TelegramBroadcastMedia references an uploaded file, File is the name in the media folder.
+type TelegramBroadcastMedia struct {
+	Type string `json:"type"`
+	File string `json:"file"`
+	Name string `json:"name"`
+}
+
+// TelegramBroadcastButton opens URL or sends Callback, a router action such as "buy:1".
+type TelegramBroadcastButton struct {
+	Text     string `json:"text"`
+	URL      string `json:"url,omitempty"`
+	Callback string `json:"callback,omitempty"`
+}
+
+// telegramMediaDir keeps broadcast uploads next to the database, so they are part of the same backup.
+func telegramMediaDir() string {
+	return filepath.Join(config.GetDBFolderPath(), "telegram-media")
+}
+
+func telegramMediaPath(file string) (string, error) {
+	if file == "" || file != filepath.Base(file) || strings.HasPrefix(file, ".") {
+		return "", fmt.Errorf("invalid media file %q", file)
+	}
+	return filepath.Join(telegramMediaDir(), file), nil
+}
+
+// SaveBroadcastMedia stores an upload for later use by UpsertBroadcast or EditBroadcast.
+func (s *TelegramService) SaveBroadcastMedia(mediaType string, name string, r io.Reader) (*TelegramBroadcastMedia, error) {
+	ext := strings.ToLower(filepath.Ext(name))
+	limit := int64(maxBroadcastDocumentSize)
+	switch mediaType {
+	case broadcastMediaPhoto:
+		if !broadcastPhotoExtensions[ext] {
+			return nil, errors.New("photo must be a jpg, png or webp image")
+		}
+		limit = maxBroadcastPhotoSize
+	case broadcastMediaDocument:
+	default:
+		return nil, fmt.Errorf("unknown media type %q", mediaType)
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return nil, err
+	}
+	file := hex.EncodeToString(random) + ext
+	if err := os.MkdirAll(telegramMediaDir(), 0o755); err != nil {
+		return nil, err
+	}
+	path, _ := telegramMediaPath(file)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	written, err := io.Copy(out, io.LimitReader(r, limit+1))
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && written > limit {
+		err = fmt.Errorf("%s is larger than %d MB", mediaType, limit>>20)
+	}
+	if err == nil && written == 0 {
+		err = errors.New("file is empty")
+	}
+	if err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return &TelegramBroadcastMedia{Type: mediaType, File: file, Name: filepath.Base(name)}, nil
+}
+
+// BroadcastMediaPath resolves an uploaded file for the admin preview.
+func (s *TelegramService) BroadcastMediaPath(file string) (string, error) {
+	path, err := telegramMediaPath(file)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// removeUnusedMedia deletes a media file once no broadcast, including recurring copies, refers to it.
+func removeUnusedMedia(file string) {
+	if file == "" {
+		return
+	}
+	var count int64
+	if err := database.GetDB().Model(&model.TelegramBroadcast{}).Where("media_file = ?", file).Count(&count).Error; err != nil || count > 0 {
+		return
+	}
+	if path, err := telegramMediaPath(file); err == nil {
+		os.Remove(path)
+	}
+}
+
+func (m *TelegramBroadcastMedia) Validate() error {
+	if m == nil {
+		return nil
+	}
+	if m.Type != broadcastMediaPhoto && m.Type != broadcastMediaDocument {
+		return fmt.Errorf("unknown media type %q", m.Type)
+	}
+	path, err := telegramMediaPath(m.File)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return errors.New("media file is missing, upload it again")
+	}
+	return nil
+}
+
+func validateBroadcastContent(body string, parseMode string, media *TelegramBroadcastMedia, buttons [][]TelegramBroadcastButton) error {
+	if !broadcastParseModes[parseMode] {
+		return fmt.Errorf("unknown parse mode %q", parseMode)
+	}
+	length := utf8.RuneCountInString(strings.TrimSpace(body))
+	if media == nil {
+		if length == 0 {
+			return errors.New("broadcast body is required")
+		}
+		if length > maxBroadcastTextLength {
+			return fmt.Errorf("broadcast text is limited to %d characters", maxBroadcastTextLength)
+		}
+	} else {
+		if err := media.Validate(); err != nil {
+			return err
+		}
+		if length > maxBroadcastCaptionLength {
+			return fmt.Errorf("caption is limited to %d characters", maxBroadcastCaptionLength)
+		}
+	}
+	for _, row := range buttons {
+		if len(row) == 0 || len(row) > 8 {
+			return errors.New("a button row must have 1 to 8 buttons")
+		}
+		for _, button := range row {
+			if strings.TrimSpace(button.Text) == "" {
+				return errors.New("button text is required")
+			}
+			switch {
+			case button.URL != "" && button.Callback != "":
+				return fmt.Errorf("button %q needs either a link or an action", button.Text)
+			case button.URL != "":
+				if !strings.HasPrefix(button.URL, "https://") && !strings.HasPrefix(button.URL, "http://") && !strings.HasPrefix(button.URL, "tg://") {
+					return fmt.Errorf("button %q link must start with https://, http:// or tg://", button.Text)
+				}
+			case button.Callback != "":
+				if len(button.Callback) > 64 {
+					return fmt.Errorf("button %q action is longer than 64 bytes", button.Text)
+				}
+			default:
+				return fmt.Errorf("button %q needs a link or an action", button.Text)
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeBroadcastButtons(buttons [][]TelegramBroadcastButton) [][]TelegramBroadcastButton {
+	var rows [][]TelegramBroadcastButton
+	for _, row := range buttons {
+		var normalized []TelegramBroadcastButton
+		for _, button := range row {
+			button.Text = strings.TrimSpace(button.Text)
+			button.URL = strings.TrimSpace(button.URL)
+			button.Callback = strings.TrimSpace(button.Callback)
+			if button.Text == "" && button.URL == "" && button.Callback == "" {
+				continue
+			}
+			normalized = append(normalized, button)
+		}
+		if len(normalized) > 0 {
+			rows = append(rows, normalized)
+		}
+	}
+	return rows
+}
+
+func encodeBroadcastButtons(buttons [][]TelegramBroadcastButton) (string, error) {
+	if len(buttons) == 0 {
+		return "", nil
+	}
+	raw, err := json.Marshal(buttons)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+func decodeBroadcastButtons(raw string) [][]TelegramBroadcastButton {
+	if strings.TrimSpace(raw) == "" {
+		return nil
+	}
+	var buttons [][]TelegramBroadcastButton
+	if err := json.Unmarshal([]byte(raw), &buttons); err != nil {
+		return nil
+	}
+	return buttons
+}
+
+func broadcastMedia(b *model.TelegramBroadcast) *TelegramBroadcastMedia {
+	if b.MediaType == "" {
+		return nil
+	}
+	return &TelegramBroadcastMedia{Type: b.MediaType, File: b.MediaFile, Name: b.MediaName}
+}
+
+// setBroadcastContent copies content into the broadcast, a new media file drops the cached file_id.
+func setBroadcastContent(b *model.TelegramBroadcast, body string, parseMode string, media *TelegramBroadcastMedia, buttons [][]TelegramBroadcastButton) error {
+	buttonsRaw, err := encodeBroadcastButtons(buttons)
+	if err != nil {
+		return err
+	}
+	b.Body = strings.TrimSpace(body)
+	b.ParseMode = parseMode
+	b.Buttons = buttonsRaw
+	if media == nil {
+		b.MediaType, b.MediaFile, b.MediaName, b.MediaFileID = "", "", "", ""
+		return nil
+	}
+	if media.File != b.MediaFile {
+		b.MediaFileID = ""
+	}
+	b.MediaType, b.MediaFile, b.MediaName = media.Type, media.File, media.Name
+	return nil
+}
+
+func broadcastKeyboard(b *model.TelegramBroadcast) *botapi.InlineKeyboardMarkup {
+	buttons := decodeBroadcastButtons(b.Buttons)
+	if len(buttons) == 0 {
+		return nil
+	}
+	keyboard := make([][]botapi.InlineKeyboardButton, 0, len(buttons))
+	for _, row := range buttons {
+		keyboardRow := make([]botapi.InlineKeyboardButton, 0, len(row))
+		for _, button := range row {
+			keyboardRow = append(keyboardRow, botapi.InlineKeyboardButton{
+				Text:         button.Text,
+				URL:          button.URL,
+				CallbackData: button.Callback,
+			})
+		}
+		keyboard = append(keyboard, keyboardRow)
+	}
+	return &botapi.InlineKeyboardMarkup{InlineKeyboard: keyboard}
+}
+
+// broadcastInputFile prefers the file_id Telegram returned for an earlier send over uploading again.
+func broadcastInputFile(b *model.TelegramBroadcast) (botapi.InputFile, error) {
+	if b.MediaFileID != "" {
+		return botapi.InputFile{FileID: b.MediaFileID}, nil
+	}
+	path, err := telegramMediaPath(b.MediaFile)
+	if err != nil {
+		return botapi.InputFile{}, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return botapi.InputFile{}, err
+	}
+	return botapi.InputFile{Name: b.MediaName, Data: data}, nil
+}
+
+// rememberMediaFileID keeps the file_id of a fresh upload, so the next users get the media
+// without another upload. The caller stores it with the broadcast.
+func rememberMediaFileID(b *model.TelegramBroadcast, sent *botapi.Message) {
+	if b.MediaFileID == "" {
+		b.MediaFileID = sent.FileID()
+	}
+}
+
+func sendBroadcastContent(ctx context.Context, api botapi.API, b *model.TelegramBroadcast, chatID int64) (*botapi.Message, error) {
+	keyboard := broadcastKeyboard(b)
+	if b.MediaType == "" {
+		return api.SendMessage(ctx, &botapi.SendMessageParams{
+			ChatID:      chatID,
+			Text:        b.Body,
+			ParseMode:   b.ParseMode,
+			ReplyMarkup: keyboard,
+		})
+	}
+	file, err := broadcastInputFile(b)
+	if err != nil {
+		return nil, err
+	}
+	var sent *botapi.Message
+	if b.MediaType == broadcastMediaPhoto {
+		sent, err = api.SendPhoto(ctx, &botapi.SendPhotoParams{
+			ChatID:      chatID,
+			Photo:       file,
+			Caption:     b.Body,
+			ParseMode:   b.ParseMode,
+			ReplyMarkup: keyboard,
+		})
+	} else {
+		sent, err = api.SendDocument(ctx, &botapi.SendDocumentParams{
+			ChatID:      chatID,
+			Document:    file,
+			Caption:     b.Body,
+			ParseMode:   b.ParseMode,
+			ReplyMarkup: keyboard,
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	rememberMediaFileID(b, sent)
+	return sent, nil
+}
+
+// editBroadcastContent updates a sent message in place. Text messages cannot get media,
+// so the media is replaced only when the file changed and the caption is edited otherwise.
+func editBroadcastContent(ctx context.Context, api botapi.API, b *model.TelegramBroadcast, mediaChanged bool, chatID int64, messageID int64) error {
+	keyboard := broadcastKeyboard(b)
+	var err error
+	switch {
+	case b.MediaType == "":
+		_, err = api.EditMessageText(ctx, &botapi.EditMessageTextParams{
+			ChatID:      chatID,
+			MessageID:   messageID,
+			Text:        b.Body,
+			ParseMode:   b.ParseMode,
+			ReplyMarkup: keyboard,
+		})
+	case mediaChanged:
+		var file botapi.InputFile
+		if file, err = broadcastInputFile(b); err != nil {
+			return err
+		}
+		var edited *botapi.Message
+		edited, err = api.EditMessageMedia(ctx, &botapi.EditMessageMediaParams{
+			ChatID:    chatID,
+			MessageID: messageID,
+			Media: botapi.InputMedia{
+				Type:      b.MediaType,
+				Media:     file,
+				Caption:   b.Body,
+				ParseMode: b.ParseMode,
+			},
+			ReplyMarkup: keyboard,
+		})
+		if err == nil {
+			rememberMediaFileID(b, edited)
+		}
+	default:
+		_, err = api.EditMessageCaption(ctx, &botapi.EditMessageCaptionParams{
+			ChatID:      chatID,
+			MessageID:   messageID,
+			Caption:     b.Body,
+			ParseMode:   b.ParseMode,
+			ReplyMarkup: keyboard,
+		})
+	}
+	if err != nil && !botapi.IsNotModified(err) {
+		return err
+	}
+	return nil
+}
+
+// broadcastTranscript is what the conversation history shows for a broadcast message.
+func broadcastTranscript(b *model.TelegramBroadcast) string {
+	if b.MediaType == "" {
+		return b.Body
+	}
+	label := fmt.Sprintf("[%s: %s]", b.MediaType, b.MediaName)
+	if b.Body == "" {
+		return label
+	}
+	return label + "\n" + b.Body
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+const (
+	// miniAppInitDataTTL bounds how long a mini app session can be replayed
+	miniAppInitDataTTL = 24 * time.Hour
+	miniAppUsageDays   = 30
+)
+
+var ErrMiniAppUnauthorized = errors.New("invalid mini app init data")
+
+type TelegramMiniAppTariff struct {
+	ID           uint   `json:"id"`
+	Title        string `json:"title"`
+	Description  string `json:"description"`
+	PriceMinor   int64  `json:"priceMinor"`
+	Currency     string `json:"currency"`
+	DurationDays int    `json:"durationDays"`
+	Volume       int64  `json:"volume"`
+}
+
+// TelegramMiniAppUsage is the traffic of the user's clients in one day of the panel time zone.
+type TelegramMiniAppUsage struct {
+	Date string `json:"date"`
+	Up   int64  `json:"up"`
+	Down int64  `json:"down"`
+}
+
+type TelegramMiniAppData struct {
+	User           botapi.User             `json:"user"`
+	Subscription   *TelegramSubscription   `json:"subscription"`
+	Clients        []TelegramClientAccess  `json:"clients"`
+	Usage          []TelegramMiniAppUsage  `json:"usage"`
+	Tariffs        []TelegramMiniAppTariff `json:"tariffs"`
+	TrialAvailable bool                    `json:"trialAvailable"`
+	DownloadLinks  map[string]string       `json:"downloadLinks"`
+}
+
+// TelegramMiniAppPurchase tells the mini app where to pay, an empty URL means nothing was left to pay.
+type TelegramMiniAppPurchase struct {
+	PaymentID       uint   `json:"paymentId"`
+	Status          string `json:"status"`
+	AmountMinor     int64  `json:"amountMinor"`
+	Currency        string `json:"currency"`
+	ConfirmationURL string `json:"confirmationUrl"`
+}
+
+// ValidateMiniAppInitData checks the initData string Telegram passes to a mini app and returns its user.
+// The hash is an HMAC-SHA256 of the sorted fields keyed by HMAC-SHA256("WebAppData", bot token).
+func (s *TelegramService) ValidateMiniAppInitData(initData string) (*botapi.User, error) {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled || cfg.BotToken == "" {
+		return nil, errors.New("telegram bot is disabled")
+	}
+	values, err := url.ParseQuery(strings.TrimSpace(initData))
+	if err != nil {
+		return nil, ErrMiniAppUnauthorized
+	}
+	hash := values.Get("hash")
+	if hash == "" {
+		return nil, ErrMiniAppUnauthorized
+	}
+	fields := make([]string, 0, len(values))
+	for key := range values {
+		if key != "hash" {
+			fields = append(fields, key+"="+values.Get(key))
+		}
+	}
+	sort.Strings(fields)
+	secret := hmac.New(sha256.New, []byte("WebAppData"))
+	secret.Write([]byte(cfg.BotToken))
+	mac := hmac.New(sha256.New, secret.Sum(nil))
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	expected := hex.EncodeToString(mac.Sum(nil))
+	if !hmac.Equal([]byte(expected), []byte(strings.ToLower(hash))) {
+		return nil, ErrMiniAppUnauthorized
+	}
+
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if err != nil || time.Since(time.Unix(authDate, 0)) > miniAppInitDataTTL {
+		return nil, errors.New("mini app session expired, reopen the app")
+	}
+	var user botapi.User
+	if err := json.Unmarshal([]byte(values.Get("user")), &user); err != nil || user.ID == 0 {
+		return nil, errors.New("mini app init data has no user")
+	}
+	return &user, nil
+}
+
+// MiniAppData returns everything the mini app shows for the user.
+func (s *TelegramService) MiniAppData(user *botapi.User) (*TelegramMiniAppData, error) {
+	profile, err := s.EnsureUserProfile(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	if err := db.Model(profile).Updates(map[string]interface{}{
+		"username":            user.Username,
+		"first_name":          user.FirstName,
+		"last_name":           user.LastName,
+		"language":            user.LanguageCode,
+		"last_interaction_at": time.Now(),
+	}).Error; err != nil {
+		return nil, err
+	}
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	data := &TelegramMiniAppData{
+		User:           *user,
+		Tariffs:        []TelegramMiniAppTariff{},
+		TrialAvailable: s.TrialAvailable(user.ID),
+		DownloadLinks:  cfg.GetDownloadLinks(),
+	}
+	if data.Subscription, err = s.GetSubscription(profile.ID); err != nil {
+		return nil, err
+	}
+	if data.Clients, err = s.GetClientAccess(user.ID); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(data.Clients))
+	for _, client := range data.Clients {
+		names = append(names, client.Name)
+	}
+	if data.Usage, err = s.clientUsage(names, miniAppUsageDays); err != nil {
+		return nil, err
+	}
+
+	tariffs, err := s.ListTariffs()
+	if err != nil {
+		return nil, err
+	}
+	for _, tariff := range tariffs {
+		if !tariff.Active {
+			continue
+		}
+		data.Tariffs = append(data.Tariffs, TelegramMiniAppTariff{
+			ID:           tariff.ID,
+			Title:        tariff.Title,
+			Description:  tariff.Description,
+			PriceMinor:   tariff.PriceMinor,
+			Currency:     tariff.Currency,
+			DurationDays: tariff.DurationDays,
+			Volume:       tariff.Volume,
+		})
+	}
+	return data, nil
+}
+
+// clientUsage sums the user traffic stats of the clients per day, oldest day first.
+func (s *TelegramService) clientUsage(names []string, days int) ([]TelegramMiniAppUsage, error) {
+	usage := []TelegramMiniAppUsage{}
+	if len(names) == 0 {
+		return usage, nil
+	}
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().In(loc)
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc).AddDate(0, 0, 1-days)
+	var stats []model.Stats
+	err = database.GetDB().Model(model.Stats{}).
+		Where("resource = ? AND tag IN ? AND date_time >= ?", "user", names, start.Unix()).
+		Order("date_time asc").Find(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	// Indexes, not pointers, as appending may move the slice
+	byDay := make(map[string]int)
+	for _, stat := range stats {
+		day := time.Unix(stat.DateTime, 0).In(loc).Format("2006-01-02")
+		i, ok := byDay[day]
+		if !ok {
+			usage = append(usage, TelegramMiniAppUsage{Date: day})
+			i = len(usage) - 1
+			byDay[day] = i
+		}
+		// Direction true is upload, like in the panel charts
+		if stat.Direction {
+			usage[i].Up += stat.Traffic
+		} else {
+			usage[i].Down += stat.Traffic
+		}
+	}
+	return usage, nil
+}
+
+// MiniAppBuy starts a payment of a tariff for the mini app user.
+func (s *TelegramService) MiniAppBuy(user *botapi.User, tariffID uint) (*TelegramMiniAppPurchase, error) {
+	payment, err := s.CreatePayment(user.ID, tariffID)
+	if err != nil {
+		return nil, err
+	}
+	return &TelegramMiniAppPurchase{
+		PaymentID:       payment.ID,
+		Status:          payment.Status,
+		AmountMinor:     payment.AmountMinor,
+		Currency:        payment.Currency,
+		ConfirmationURL: payment.ConfirmationURL,
+	}, nil
+}
@@ -0,0 +1,444 @@
+package service
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/yookassa"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+// TelegramPaymentFilter narrows down the order list, zero values match everything.
+// From and To are dates in the panel time zone, To is inclusive.
+type TelegramPaymentFilter struct {
+	UserID      uint   `form:"userId" json:"userId"`
+	TelegramID  int64  `form:"telegramId" json:"telegramId"`
+	TariffID    uint   `form:"tariffId" json:"tariffId"`
+	PromoCodeID uint   `form:"promoCodeId" json:"promoCodeId"`
+	Status      string `form:"status" json:"status"`
+	Currency    string `form:"currency" json:"currency"`
+	From        string `form:"from" json:"from"`
+	To          string `form:"to" json:"to"`
+	Limit       int    `form:"limit" json:"limit"`
+	Offset      int    `form:"offset" json:"offset"`
+}
+
+type TelegramPaymentDTO struct {
+	model.TelegramPayment
+	TelegramID  int64  `json:"telegramId"`
+	Username    string `json:"username"`
+	TariffTitle string `json:"tariffTitle"`
+	PromoCode   string `json:"promoCode"`
+}
+
+type TelegramPaymentList struct {
+	Total    int64                `json:"total"`
+	Payments []TelegramPaymentDTO `json:"payments"`
+}
+
+type TelegramRevenueRow struct {
+	Key           string `json:"key"`
+	Title         string `json:"title"`
+	Currency      string `json:"currency"`
+	Count         int    `json:"count"`
+	AmountMinor   int64  `json:"amountMinor"`
+	RefundedMinor int64  `json:"refundedMinor"`
+	NetMinor      int64  `json:"netMinor"`
+}
+
+// TelegramRevenueReport sums paid orders, amounts in different currencies are never added together.
+type TelegramRevenueReport struct {
+	ByDay      []TelegramRevenueRow `json:"byDay"`
+	ByTariff   []TelegramRevenueRow `json:"byTariff"`
+	ByCurrency []TelegramRevenueRow `json:"byCurrency"`
+}
+
+type TelegramPaymentDetail struct {
+	TelegramPaymentDTO
+	Events []model.TelegramPaymentEvent `json:"events"`
+}
+
+func recordPaymentEvent(db *gorm.DB, event *model.TelegramPaymentEvent) {
+	if err := db.Create(event).Error; err != nil {
+		logger.Warning("telegram payment: unable to record event:", err)
+	}
+}
+
+func (f *TelegramPaymentFilter) apply(db *gorm.DB) (*gorm.DB, error) {
+	query := db.Model(&model.TelegramPayment{})
+	if f.UserID != 0 {
+		query = query.Where("telegram_payments.user_id = ?", f.UserID)
+	}
+	if f.TelegramID != 0 {
+		query = query.Where("telegram_payments.user_id IN (?)",
+			db.Model(&model.TelegramUserProfile{}).Select("id").Where("telegram_id = ?", f.TelegramID))
+	}
+	if f.TariffID != 0 {
+		query = query.Where("telegram_payments.tariff_id = ?", f.TariffID)
+	}
+	if f.PromoCodeID != 0 {
+		query = query.Where("telegram_payments.promo_code_id = ?", f.PromoCodeID)
+	}
+	if status := strings.TrimSpace(f.Status); status != "" {
+		query = query.Where("telegram_payments.status IN ?", strings.Split(status, ","))
+	}
+	if currency := strings.TrimSpace(f.Currency); currency != "" {
+		query = query.Where("UPPER(telegram_payments.currency) = ?", strings.ToUpper(currency))
+	}
+	if f.From != "" {
+		from, err := parseAudienceDate(f.From)
+		if err != nil {
+			return nil, errors.New("invalid from date, use YYYY-MM-DD")
+		}
+		query = query.Where("telegram_payments.created_at >= ?", from)
+	}
+	if f.To != "" {
+		to, err := parseAudienceDate(f.To)
+		if err != nil {
+			return nil, errors.New("invalid to date, use YYYY-MM-DD")
+		}
+		query = query.Where("telegram_payments.created_at < ?", to.AddDate(0, 0, 1))
+	}
+	return query, nil
+}
+
+// paymentDTOs adds the names an admin needs to read an order without further lookups.
+func paymentDTOs(db *gorm.DB, payments []model.TelegramPayment) ([]TelegramPaymentDTO, error) {
+	userIDs := make([]uint, 0, len(payments))
+	tariffIDs := make([]uint, 0, len(payments))
+	promoIDs := make([]uint, 0)
+	for _, p := range payments {
+		userIDs = append(userIDs, p.UserID)
+		tariffIDs = append(tariffIDs, p.TariffID)
+		if p.PromoCodeID != nil {
+			promoIDs = append(promoIDs, *p.PromoCodeID)
+		}
+	}
+	var users []model.TelegramUserProfile
+	if err := db.Select("id", "telegram_id", "username").Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	var tariffs []model.TelegramTariff
+	if err := db.Select("id", "title").Where("id IN ?", tariffIDs).Find(&tariffs).Error; err != nil {
+		return nil, err
+	}
+	var promos []model.TelegramPromoCode
+	if len(promoIDs) > 0 {
+		if err := db.Select("id", "code").Where("id IN ?", promoIDs).Find(&promos).Error; err != nil {
+			return nil, err
+		}
+	}
+	usersByID := make(map[uint]model.TelegramUserProfile, len(users))
+	for _, u := range users {
+		usersByID[u.ID] = u
+	}
+	tariffTitles := make(map[uint]string, len(tariffs))
+	for _, t := range tariffs {
+		tariffTitles[t.ID] = t.Title
+	}
+	promoCodes := make(map[uint]string, len(promos))
+	for _, p := range promos {
+		promoCodes[p.ID] = p.Code
+	}
+	result := make([]TelegramPaymentDTO, 0, len(payments))
+	for _, p := range payments {
+		dto := TelegramPaymentDTO{
+			TelegramPayment: p,
+			TelegramID:      usersByID[p.UserID].TelegramID,
+			Username:        usersByID[p.UserID].Username,
+			TariffTitle:     tariffTitles[p.TariffID],
+		}
+		if p.PromoCodeID != nil {
+			dto.PromoCode = promoCodes[*p.PromoCodeID]
+		}
+		result = append(result, dto)
+	}
+	return result, nil
+}
+
+// ListPayments returns one page of orders, newest first.
+func (s *TelegramService) ListPayments(filter TelegramPaymentFilter) (*TelegramPaymentList, error) {
+	db := database.GetDB()
+	query, err := filter.apply(db)
+	if err != nil {
+		return nil, err
+	}
+	result := &TelegramPaymentList{}
+	if err := query.Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 50
+	}
+	var payments []model.TelegramPayment
+	if err := query.Order("telegram_payments.id desc").Limit(filter.Limit).Offset(filter.Offset).
+		Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	result.Payments, err = paymentDTOs(db, payments)
+	return result, err
+}
+
+// GetPayment returns an order with its history.
+func (s *TelegramService) GetPayment(id uint) (*TelegramPaymentDetail, error) {
+	db := database.GetDB()
+	var payment model.TelegramPayment
+	if err := db.First(&payment, id).Error; err != nil {
+		return nil, err
+	}
+	dtos, err := paymentDTOs(db, []model.TelegramPayment{payment})
+	if err != nil {
+		return nil, err
+	}
+	detail := &TelegramPaymentDetail{TelegramPaymentDTO: dtos[0]}
+	err = db.Where("payment_id = ?", id).Order("id asc").Find(&detail.Events).Error
+	return detail, err
+}
+
+// PaymentReport aggregates paid orders matching the filter. Days are counted in the panel time
+// zone by the time the order was paid, refunds are subtracted from the order they belong to.
+func (s *TelegramService) PaymentReport(filter TelegramPaymentFilter) (*TelegramRevenueReport, error) {
+	filter.Status = PaymentStatusSucceeded + "," + PaymentStatusRefunded
+	db := database.GetDB()
+	query, err := filter.apply(db)
+	if err != nil {
+		return nil, err
+	}
+	var payments []model.TelegramPayment
+	if err := query.Find(&payments).Error; err != nil {
+		return nil, err
+	}
+	var tariffs []model.TelegramTariff
+	if err := db.Select("id", "title").Find(&tariffs).Error; err != nil {
+		return nil, err
+	}
+	tariffTitles := make(map[uint]string, len(tariffs))
+	for _, t := range tariffs {
+		tariffTitles[t.ID] = t.Title
+	}
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return nil, err
+	}
+
+	type groupKey struct{ key, currency string }
+	groups := map[string]map[groupKey]*TelegramRevenueRow{"day": {}, "tariff": {}, "currency": {}}
+	add := func(group string, key string, title string, p *model.TelegramPayment) {
+		currency := strings.ToUpper(p.Currency)
+		k := groupKey{key, currency}
+		row, ok := groups[group][k]
+		if !ok {
+			row = &TelegramRevenueRow{Key: key, Title: title, Currency: currency}
+			groups[group][k] = row
+		}
+		row.Count++
+		row.AmountMinor += p.AmountMinor
+		row.RefundedMinor += p.RefundedMinor
+		row.NetMinor += p.AmountMinor - p.RefundedMinor
+	}
+	for i := range payments {
+		p := &payments[i]
+		paidAt := p.CreatedAt
+		if p.PaidAt != nil {
+			paidAt = *p.PaidAt
+		}
+		day := paidAt.In(loc).Format("2006-01-02")
+		tariffKey := strconv.FormatUint(uint64(p.TariffID), 10)
+		title := tariffTitles[p.TariffID]
+		if title == "" {
+			title = "#" + tariffKey
+		}
+		add("day", day, day, p)
+		add("tariff", tariffKey, title, p)
+		add("currency", strings.ToUpper(p.Currency), strings.ToUpper(p.Currency), p)
+	}
+	rows := func(group string) []TelegramRevenueRow {
+		list := make([]TelegramRevenueRow, 0, len(groups[group]))
+		for _, row := range groups[group] {
+			list = append(list, *row)
+		}
+		sort.Slice(list, func(i, j int) bool {
+			if list[i].Key != list[j].Key {
+				if group == "tariff" {
+					a, _ := strconv.Atoi(list[i].Key)
+					b, _ := strconv.Atoi(list[j].Key)
+					return a < b
+				}
+				return list[i].Key < list[j].Key
+			}
+			return list[i].Currency < list[j].Currency
+		})
+		return list
+	}
+	return &TelegramRevenueReport{
+		ByDay:      rows("day"),
+		ByTariff:   rows("tariff"),
+		ByCurrency: rows("currency"),
+	}, nil
+}
+
+// ExportPaymentsCSV writes every order matching the filter, the limit and offset are ignored.
+func (s *TelegramService) ExportPaymentsCSV(w io.Writer, filter TelegramPaymentFilter) error {
+	db := database.GetDB()
+	query, err := filter.apply(db)
+	if err != nil {
+		return err
+	}
+	settingService := SettingService{}
+	loc, err := settingService.GetTimeLocation()
+	if err != nil {
+		return err
+	}
+	formatTime := func(t *time.Time) string {
+		if t == nil || t.IsZero() {
+			return ""
+		}
+		return t.In(loc).Format("2006-01-02 15:04:05")
+	}
+	out := csv.NewWriter(w)
+	if err := out.Write([]string{"id", "created_at", "paid_at", "status", "user_id", "telegram_id", "username",
+		"tariff_id", "tariff", "promo_code", "amount", "refunded", "currency", "provider_payment_id", "refunded_at"}); err != nil {
+		return err
+	}
+	var batch []model.TelegramPayment
+	err = query.Order("telegram_payments.id asc").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		dtos, err := paymentDTOs(db, batch)
+		if err != nil {
+			return err
+		}
+		for _, p := range dtos {
+			created := p.CreatedAt
+			if err := out.Write([]string{
+				strconv.FormatUint(uint64(p.ID), 10),
+				formatTime(&created),
+				formatTime(p.PaidAt),
+				p.Status,
+				strconv.FormatUint(uint64(p.UserID), 10),
+				strconv.FormatInt(p.TelegramID, 10),
+				p.Username,
+				strconv.FormatUint(uint64(p.TariffID), 10),
+				p.TariffTitle,
+				p.PromoCode,
+				yookassa.FormatAmount(p.AmountMinor),
+				yookassa.FormatAmount(p.RefundedMinor),
+				strings.ToUpper(p.Currency),
+				p.ProviderPaymentID,
+				formatTime(p.RefundedAt),
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return err
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// RefundPayment returns money of a successful order through YooKassa. A zero amount refunds
+// what is left, the order becomes refunded once nothing is left. Access is not revoked.
+func (s *TelegramService) RefundPayment(id uint, amountMinor int64, reason string, actor string) (*model.TelegramPayment, error) {
+	telegramPaymentMu.Lock()
+	defer telegramPaymentMu.Unlock()
+
+	db := database.GetDB()
+	var payment model.TelegramPayment
+	if err := db.First(&payment, id).Error; err != nil {
+		return nil, err
+	}
+	if payment.Status != PaymentStatusSucceeded {
+		return nil, fmt.Errorf("only succeeded payments can be refunded, payment %d is %s", id, payment.Status)
+	}
+	if payment.ProviderPaymentID == "" {
+		return nil, errors.New("payment was not charged through yookassa")
+	}
+	left := payment.AmountMinor - payment.RefundedMinor
+	if amountMinor == 0 {
+		amountMinor = left
+	}
+	if amountMinor < 0 || amountMinor > left {
+		return nil, fmt.Errorf("refund must be between 0.01 and %s", yookassa.FormatAmount(left))
+	}
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := yooKassaClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	reason = strings.TrimSpace(reason)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	refund, err := client.CreateRefund(ctx, uuid.Must(uuid.NewV4()).String(), &yookassa.CreateRefundRequest{
+		PaymentID: payment.ProviderPaymentID,
+		Amount: yookassa.Amount{
+			Value:    yookassa.FormatAmount(amountMinor),
+			Currency: payment.Currency,
+		},
+		Description: reason,
+	})
+	if err != nil {
+		return nil, err
+	}
+	note := "refund " + refund.ID
+	if reason != "" {
+		note += ": " + reason
+	}
+	if refund.Status == yookassa.StatusCanceled {
+		recordPaymentEvent(db, &model.TelegramPaymentEvent{
+			PaymentID:   payment.ID,
+			FromStatus:  payment.Status,
+			Status:      payment.Status,
+			AmountMinor: amountMinor,
+			Actor:       actor,
+			Note:        note + " was canceled",
+		})
+		return nil, fmt.Errorf("yookassa canceled refund %s", refund.ID)
+	}
+
+	now := time.Now()
+	previous := payment.Status
+	status := payment.Status
+	if payment.RefundedMinor+amountMinor >= payment.AmountMinor {
+		status = PaymentStatusRefunded
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&payment).Updates(map[string]interface{}{
+			"status":         status,
+			"refunded_minor": payment.RefundedMinor + amountMinor,
+			"refunded_at":    &now,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.TelegramPaymentEvent{
+			PaymentID:   payment.ID,
+			FromStatus:  previous,
+			Status:      status,
+			AmountMinor: amountMinor,
+			Actor:       actor,
+			Note:        note,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("telegram payment %d refunded %s %s by %s", payment.ID, yookassa.FormatAmount(amountMinor), payment.Currency, actor)
+	s.notifyChange()
+	return &payment, db.First(&payment, payment.ID).Error
+}
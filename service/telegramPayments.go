@@ -0,0 +1,374 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+	"github.com/alireza0/s-ui/telegram/yookassa"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusWaiting   = "waiting_for_capture"
+	PaymentStatusSucceeded = "succeeded"
+	PaymentStatusCanceled  = "canceled"
+	PaymentStatusFailed    = "failed"
+	PaymentStatusRefunded  = "refunded"
+
+	yooKassaActor = "YooKassa"
+)
+
+// Notifications and the bot may report the same payment concurrently
+var telegramPaymentMu sync.Mutex
+
+// ErrPaymentIgnored marks notifications that retrying cannot help, like ones for unknown payments
+var ErrPaymentIgnored = errors.New("payment notification ignored")
+
+func yooKassaClient(cfg *model.TelegramBotConfig) (*yookassa.Client, error) {
+	if cfg == nil || cfg.YooKassaShopID == "" || cfg.YooKassaSecretKey == "" {
+		return nil, errors.New("yookassa credentials are not configured")
+	}
+	return yookassa.NewClient(cfg.YooKassaShopID, cfg.YooKassaSecretKey, cfg.YooKassaAPIURL), nil
+}
+
+// CreatePayment starts a YooKassa payment for a tariff and returns the stored payment with its confirmation URL.
+func (s *TelegramService) CreatePayment(telegramID int64, tariffID uint) (*model.TelegramPayment, error) {
+	if telegramID == 0 {
+		return nil, errors.New("telegram id is required")
+	}
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.Enabled {
+		return nil, errors.New("telegram bot is disabled")
+	}
+	if cfg.SuccessRedirectURL == "" {
+		return nil, errors.New("success redirect url is not configured")
+	}
+	client, err := yooKassaClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	var tariff model.TelegramTariff
+	if err := db.Where("id = ? AND active = ?", tariffID, true).First(&tariff).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("tariff %d is not available", tariffID)
+		}
+		return nil, err
+	}
+	if len(tariff.GetInbounds()) == 0 {
+		return nil, fmt.Errorf("tariff %d has no inbounds to provision", tariffID)
+	}
+	profile, err := s.EnsureUserProfile(telegramID)
+	if err != nil {
+		return nil, err
+	}
+
+	payment := model.TelegramPayment{
+		UserID:         profile.ID,
+		TariffID:       tariff.ID,
+		IdempotenceKey: uuid.Must(uuid.NewV4()).String(),
+		AmountMinor:    tariff.PriceMinor,
+		Currency:       tariff.Currency,
+		Status:         PaymentStatusPending,
+	}
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := s.createPaymentWithDiscount(tx, &payment); err != nil {
+			return err
+		}
+		recordPaymentEvent(tx, &model.TelegramPaymentEvent{
+			PaymentID:   payment.ID,
+			Status:      PaymentStatusPending,
+			AmountMinor: payment.AmountMinor,
+			Actor:       telegramActor,
+		})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if payment.AmountMinor == 0 {
+		// Fully discounted, there is nothing to charge
+		if err := s.completeFreePayment(payment.ID); err != nil {
+			return nil, err
+		}
+		return &payment, db.First(&payment, payment.ID).Error
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	remote, err := client.CreatePayment(ctx, payment.IdempotenceKey, &yookassa.CreatePaymentRequest{
+		Amount: yookassa.Amount{
+			Value:    yookassa.FormatAmount(payment.AmountMinor),
+			Currency: payment.Currency,
+		},
+		Capture: true,
+		Confirmation: &yookassa.Confirmation{
+			Type:      "redirect",
+			ReturnURL: cfg.SuccessRedirectURL,
+		},
+		Description: tariff.Title,
+		Metadata: map[string]string{
+			"payment_id":  strconv.FormatUint(uint64(payment.ID), 10),
+			"telegram_id": strconv.FormatInt(telegramID, 10),
+			"tariff_id":   strconv.FormatUint(uint64(tariff.ID), 10),
+		},
+	})
+	if err != nil {
+		db.Model(&payment).Updates(map[string]interface{}{
+			"status":        PaymentStatusFailed,
+			"error_message": err.Error(),
+		})
+		recordPaymentEvent(db, &model.TelegramPaymentEvent{
+			PaymentID:  payment.ID,
+			FromStatus: PaymentStatusPending,
+			Status:     PaymentStatusFailed,
+			Actor:      yooKassaActor,
+			Note:       err.Error(),
+		})
+		s.releasePromoDiscount(db, payment.ID)
+		return nil, err
+	}
+	payment.ProviderPaymentID = remote.ID
+	if remote.Status != payment.Status {
+		recordPaymentEvent(db, &model.TelegramPaymentEvent{
+			PaymentID:  payment.ID,
+			FromStatus: payment.Status,
+			Status:     remote.Status,
+			Actor:      yooKassaActor,
+		})
+	}
+	payment.Status = remote.Status
+	if remote.Confirmation != nil {
+		payment.ConfirmationURL = remote.Confirmation.ConfirmationURL
+	}
+	if err := db.Model(&payment).Updates(map[string]interface{}{
+		"provider_payment_id": payment.ProviderPaymentID,
+		"status":              payment.Status,
+		"confirmation_url":    payment.ConfirmationURL,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// HandleYooKassaNotification applies a YooKassa notification. The body is not trusted:
+// the payment is fetched again with the shop credentials and only that answer is used.
+func (s *TelegramService) HandleYooKassaNotification(notification *yookassa.Notification) error {
+	if notification == nil || notification.Object.ID == "" {
+		return errors.New("notification has no payment id")
+	}
+	if !strings.HasPrefix(notification.Event, "payment.") {
+		logger.Debugf("yookassa: ignoring %s notification", notification.Event)
+		return nil
+	}
+	return s.SyncPayment(notification.Object.ID)
+}
+
+// SyncPayment pulls the current state of a payment from YooKassa and provisions access once it succeeded.
+func (s *TelegramService) SyncPayment(providerPaymentID string) error {
+	db := database.GetDB()
+	var payment model.TelegramPayment
+	if err := db.Where("provider_payment_id = ?", providerPaymentID).First(&payment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return fmt.Errorf("%w: unknown payment %s", ErrPaymentIgnored, providerPaymentID)
+		}
+		return err
+	}
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return err
+	}
+	client, err := yooKassaClient(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	remote, err := client.GetPayment(ctx, providerPaymentID)
+	if err != nil {
+		return err
+	}
+	return s.applyPaymentStatus(payment.ID, remote)
+}
+
+func (s *TelegramService) applyPaymentStatus(paymentID uint, remote *yookassa.Payment) error {
+	payment, result, err := s.updatePaymentStatus(paymentID, remote)
+	if result != nil {
+		s.notifyPaymentProvisioned(payment, result)
+	}
+	return err
+}
+
+func (s *TelegramService) updatePaymentStatus(paymentID uint, remote *yookassa.Payment) (*model.TelegramPayment, *provisionResult, error) {
+	telegramPaymentMu.Lock()
+	defer telegramPaymentMu.Unlock()
+
+	db := database.GetDB()
+	var payment model.TelegramPayment
+	if err := db.First(&payment, paymentID).Error; err != nil {
+		return nil, nil, err
+	}
+	if payment.ProvisionedAt != nil {
+		return nil, nil, nil
+	}
+	if remote.Status != PaymentStatusSucceeded {
+		if remote.Status == payment.Status {
+			return nil, nil, nil
+		}
+		event := model.TelegramPaymentEvent{
+			PaymentID:  payment.ID,
+			FromStatus: payment.Status,
+			Status:     remote.Status,
+			Actor:      yooKassaActor,
+		}
+		updates := map[string]interface{}{"status": remote.Status}
+		if remote.CancellationDetails != nil {
+			event.Note = remote.CancellationDetails.Party + ": " + remote.CancellationDetails.Reason
+			updates["error_message"] = event.Note
+		}
+		if err := db.Model(&payment).Updates(updates).Error; err != nil {
+			return nil, nil, err
+		}
+		recordPaymentEvent(db, &event)
+		if remote.Status == PaymentStatusCanceled {
+			s.releasePromoDiscount(db, payment.ID)
+		}
+		return nil, nil, nil
+	}
+
+	amount, err := yookassa.ParseAmount(remote.Amount.Value)
+	if err != nil {
+		return nil, nil, err
+	}
+	if amount != payment.AmountMinor || !strings.EqualFold(remote.Amount.Currency, payment.Currency) {
+		err = fmt.Errorf("%w: paid %s %s does not match expected %s %s", ErrPaymentIgnored, remote.Amount.Value, remote.Amount.Currency,
+			yookassa.FormatAmount(payment.AmountMinor), payment.Currency)
+		db.Model(&payment).Update("error_message", err.Error())
+		return nil, nil, err
+	}
+
+	if payment.PaidAt == nil {
+		payment.PaidAt = remote.CapturedAt
+	}
+	result, err := s.completePayment(&payment)
+	return &payment, result, err
+}
+
+func (s *TelegramService) completeFreePayment(paymentID uint) error {
+	payment, result, err := func() (*model.TelegramPayment, *provisionResult, error) {
+		telegramPaymentMu.Lock()
+		defer telegramPaymentMu.Unlock()
+
+		var payment model.TelegramPayment
+		if err := database.GetDB().First(&payment, paymentID).Error; err != nil {
+			return nil, nil, err
+		}
+		result, err := s.completePayment(&payment)
+		return &payment, result, err
+	}()
+	if result != nil {
+		s.notifyPaymentProvisioned(payment, result)
+	}
+	return err
+}
+
+// completePayment marks a payment succeeded and provisions it, the caller holds telegramPaymentMu
+// and sends the notifications with notifyPaymentProvisioned after releasing it.
+func (s *TelegramService) completePayment(payment *model.TelegramPayment) (*provisionResult, error) {
+	db := database.GetDB()
+	now := time.Now()
+	if payment.PaidAt == nil {
+		payment.PaidAt = &now
+	}
+	previous := payment.Status
+	if err := db.Model(payment).Updates(map[string]interface{}{
+		"status":  PaymentStatusSucceeded,
+		"paid_at": payment.PaidAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if previous != PaymentStatusSucceeded {
+		actor := yooKassaActor
+		if payment.AmountMinor == 0 {
+			actor = telegramActor
+		}
+		recordPaymentEvent(db, &model.TelegramPaymentEvent{
+			PaymentID:   payment.ID,
+			FromStatus:  previous,
+			Status:      PaymentStatusSucceeded,
+			AmountMinor: payment.AmountMinor,
+			Actor:       actor,
+		})
+	}
+
+	result, err := s.provisionPayment(payment)
+	if err != nil {
+		// Kept unprovisioned, so the next notification for this payment retries
+		db.Model(payment).Update("error_message", err.Error())
+		return nil, err
+	}
+	if err := db.Model(payment).Updates(map[string]interface{}{
+		"client_id":      result.ClientID,
+		"provisioned_at": &now,
+		"error_message":  "",
+	}).Error; err != nil {
+		return nil, err
+	}
+	logger.Infof("telegram payment %d provisioned client %s", payment.ID, result.ClientName)
+	s.rewardReferral(payment)
+	// Free days earned before the payer had a subscription can be granted now
+	s.retryReferralRewards(payment.UserID)
+	s.notifyChange()
+	return result, nil
+}
+
+// notifyPaymentProvisioned tells the payer and the admins, without holding telegramPaymentMu
+// while the Bot API answers.
+func (s *TelegramService) notifyPaymentProvisioned(payment *model.TelegramPayment, result *provisionResult) {
+	s.notifyPaymentSucceeded(payment, result)
+	s.notifyAdminsPayment(payment, result)
+}
+
+func (s *TelegramService) notifyPaymentSucceeded(payment *model.TelegramPayment, result *provisionResult) {
+	api, err := s.BotAPI()
+	if err != nil {
+		return
+	}
+	var profile model.TelegramUserProfile
+	if err := database.GetDB().First(&profile, payment.UserID).Error; err != nil {
+		logger.Warning("telegram payment: unable to load user:", err)
+		return
+	}
+	data := profileMessageData(&profile)
+	data.Active = true
+	data.Expiry = formatTelegramTime(&result.ExpiresAt)
+	data.Amount = formatTelegramPrice(payment.AmountMinor, payment.Currency)
+	var tariff model.TelegramTariff
+	if err := database.GetDB().First(&tariff, payment.TariffID).Error; err == nil {
+		data.Tariff = NewTelegramMessageTariff(&tariff)
+	}
+	text := s.RenderMessage("payment.succeeded", data)
+	ctx, cancel := telegramContext()
+	defer cancel()
+	_, err = api.SendMessage(ctx, &botapi.SendMessageParams{
+		ChatID: profile.TelegramID,
+		Text:   text,
+	})
+	if err != nil {
+		logger.Warning("telegram payment: unable to notify user:", err)
+	}
+}
@@ -0,0 +1,279 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+
+	"gorm.io/gorm"
+)
+
+var errPromoAlreadyRedeemed = errors.New("promo code has already been used")
+
+type TelegramPromoRedemptionDTO struct {
+	Code            string     `json:"code"`
+	DiscountPercent int        `json:"discountPercent"`
+	FreeDays        int        `json:"freeDays"`
+	ExpiresAt       *time.Time `json:"expiresAt"`
+	// FreeDaysError tells why the free days of a code with a discount were not added
+	FreeDaysError string `json:"freeDaysError,omitempty"`
+}
+
+func (s *TelegramService) EnsureUserProfile(telegramID int64) (*model.TelegramUserProfile, error) {
+	if telegramID == 0 {
+		return nil, errors.New("telegram id is required")
+	}
+	var profile model.TelegramUserProfile
+	err := database.GetDB().Where(model.TelegramUserProfile{TelegramID: telegramID}).FirstOrCreate(&profile).Error
+	if err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// RedeemPromoCode consumes one use of a code for the user. Free days are added to the user's client
+// right away, a discount is kept until the next payment. When a code has both and the free days
+// cannot be added, the discount is still granted.
+func (s *TelegramService) RedeemPromoCode(userID uint, code string) (*TelegramPromoRedemptionDTO, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if userID == 0 {
+		return nil, errors.New("user id is required")
+	}
+	if code == "" {
+		return nil, errors.New("promo code is required")
+	}
+	db := database.GetDB()
+	var promo model.TelegramPromoCode
+	var redemption model.TelegramPromoRedemption
+	var target *model.Client
+	var freeDaysErr error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&model.TelegramUserProfile{}, userID).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("code = ?", code).First(&promo).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errors.New("promo code not found")
+			}
+			return err
+		}
+		if !promo.Active {
+			return errors.New("promo code is not active")
+		}
+		if promo.ExpiresAt != nil && !promo.ExpiresAt.After(time.Now()) {
+			return errors.New("promo code has expired")
+		}
+		if promo.DiscountPercent == 0 && promo.FreeDays == 0 {
+			return errors.New("promo code grants nothing")
+		}
+		var used int64
+		if err := tx.Model(&model.TelegramPromoRedemption{}).
+			Where("promo_code_id = ? AND user_id = ?", promo.ID, userID).Count(&used).Error; err != nil {
+			return err
+		}
+		if used > 0 {
+			return errPromoAlreadyRedeemed
+		}
+		if promo.FreeDays > 0 {
+			clients, err := s.linkedClients(tx, userID)
+			if err != nil {
+				return err
+			}
+			target, freeDaysErr = freeDaysClient(clients, time.Now().Unix())
+			// A code that would grant nothing is not used up
+			if freeDaysErr != nil && promo.DiscountPercent == 0 {
+				return freeDaysErr
+			}
+		}
+		// The limit is checked by the update itself, so concurrent redemptions cannot exceed it
+		result := tx.Model(&model.TelegramPromoCode{}).
+			Where("id = ? AND (max_uses = 0 OR used_count < max_uses)", promo.ID).
+			Update("used_count", gorm.Expr("used_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("promo code has no uses left")
+		}
+		redemption = model.TelegramPromoRedemption{
+			PromoCodeID:     promo.ID,
+			UserID:          userID,
+			DiscountPercent: promo.DiscountPercent,
+			FreeDays:        promo.FreeDays,
+		}
+		if freeDaysErr != nil {
+			// The stored redemption only keeps what was granted
+			redemption.FreeDays = 0
+		}
+		if err := tx.Create(&redemption).Error; err != nil {
+			// The unique index catches a parallel redemption by the same user
+			if strings.Contains(err.Error(), "UNIQUE") {
+				return errPromoAlreadyRedeemed
+			}
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	dto := &TelegramPromoRedemptionDTO{
+		Code:            promo.Code,
+		DiscountPercent: redemption.DiscountPercent,
+		FreeDays:        redemption.FreeDays,
+	}
+	if freeDaysErr != nil {
+		dto.FreeDaysError = freeDaysErr.Error()
+	}
+	if redemption.FreeDays > 0 {
+		expiresAt, err := s.extendClientDays(target.Id, redemption.FreeDays)
+		if err != nil {
+			if redemption.DiscountPercent == 0 {
+				s.revokeRedemption(&redemption)
+				return nil, err
+			}
+			// The stored redemption only keeps what was granted
+			if updateErr := db.Model(&redemption).Update("free_days", 0).Error; updateErr != nil {
+				logger.Warning("telegram promo: unable to drop free days of redemption:", updateErr)
+			}
+			dto.FreeDays = 0
+			dto.FreeDaysError = err.Error()
+		}
+		dto.ExpiresAt = expiresAt
+	}
+	s.notifyChange()
+	return dto, nil
+}
+
+// revokeRedemption gives the use back when the promo could not be applied.
+func (s *TelegramService) revokeRedemption(redemption *model.TelegramPromoRedemption) {
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(redemption).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.TelegramPromoCode{}).Where("id = ? AND used_count > 0", redemption.PromoCodeID).
+			Update("used_count", gorm.Expr("used_count - 1")).Error
+	})
+	if err != nil {
+		logger.Warning("telegram promo: unable to revoke redemption:", err)
+	}
+}
+
+// createPaymentWithDiscount stores the payment, reserving the user's oldest unused discount for it.
+func (s *TelegramService) createPaymentWithDiscount(tx *gorm.DB, payment *model.TelegramPayment) error {
+	var redemption model.TelegramPromoRedemption
+	err := tx.Where("user_id = ? AND discount_percent > 0 AND payment_id IS NULL", payment.UserID).
+		Order("id asc").First(&redemption).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+	found := err == nil
+	if found {
+		payment.AmountMinor = payment.AmountMinor * int64(100-redemption.DiscountPercent) / 100
+		payment.PromoCodeID = &redemption.PromoCodeID
+	}
+	if err := tx.Create(payment).Error; err != nil {
+		return err
+	}
+	if !found {
+		return nil
+	}
+	result := tx.Model(&model.TelegramPromoRedemption{}).
+		Where("id = ? AND payment_id IS NULL", redemption.ID).
+		Update("payment_id", payment.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("promo discount is already used by another payment")
+	}
+	return nil
+}
+
+// releasePromoDiscount makes a reserved discount available again after its payment failed.
+func (s *TelegramService) releasePromoDiscount(db *gorm.DB, paymentID uint) {
+	err := db.Model(&model.TelegramPromoRedemption{}).Where("payment_id = ?", paymentID).
+		Update("payment_id", nil).Error
+	if err != nil {
+		logger.Warning("telegram promo: unable to release discount:", err)
+	}
+}
+
+// freeDaysClient picks the client that sets the end of the subscription: the active client that
+// ends last, or the client that ended last when none is active.
+func freeDaysClient(clients []model.Client, now int64) (*model.Client, error) {
+	if len(clients) == 0 {
+		return nil, errors.New("free days need an existing subscription")
+	}
+	candidates := make([]*model.Client, 0, len(clients))
+	for i := range clients {
+		if clientActive(&clients[i], now) {
+			if clients[i].Expiry == 0 {
+				return nil, errors.New("your subscription has no end date, free days cannot be added")
+			}
+			candidates = append(candidates, &clients[i])
+		}
+	}
+	if len(candidates) == 0 {
+		for i := range clients {
+			candidates = append(candidates, &clients[i])
+		}
+	}
+	var target *model.Client
+	for _, client := range candidates {
+		if client.Expiry > 0 && (target == nil || client.Expiry > target.Expiry) {
+			target = client
+		}
+	}
+	if target == nil {
+		return nil, errors.New("your subscription has no end date, free days cannot be added")
+	}
+	return target, nil
+}
+
+// extendClientDays moves the expiry of a client, counting from now if it already expired. A client
+// DepleteJob disabled because it expired is enabled again, a client disabled by an admin stays off.
+func (s *TelegramService) extendClientDays(clientID uint, days int) (*time.Time, error) {
+	db := database.GetDB()
+	var client model.Client
+	if err := db.First(&client, clientID).Error; err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	start := now
+	if client.Expiry > now.Unix() {
+		start = time.Unix(client.Expiry, 0)
+	}
+	expired := client.Expiry > 0 && client.Expiry <= now.Unix()
+	volumeLeft := client.Volume == 0 || client.Up+client.Down < client.Volume
+	if !client.Enable && expired && volumeLeft {
+		byDeplete, err := disabledByDeplete(db, client.Name)
+		if err != nil {
+			return nil, err
+		}
+		client.Enable = byDeplete
+	}
+	expiresAt := start.AddDate(0, 0, days)
+	client.Expiry = expiresAt.Unix()
+	data, err := json.Marshal(client)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	configService := ConfigService{}
+	if _, err := configService.Save("clients", "edit", data, "", telegramActor, telegramHostname(cfg)); err != nil {
+		return nil, fmt.Errorf("extend client %s: %w", client.Name, err)
+	}
+	return &expiresAt, nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alireza0/s-ui/core"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func TestRedeemPromoWithoutClient(t *testing.T) {
+	initTestDB(t)
+	db := database.GetDB()
+	service := TelegramService{}
+	profile, err := service.EnsureUserProfile(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, promo := range []model.TelegramPromoCode{
+		{Code: "MIXED", DiscountPercent: 20, FreeDays: 7, Active: true},
+		{Code: "FREE", FreeDays: 7, Active: true},
+	} {
+		if err := db.Create(&promo).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The discount of a mixed code is kept, the free days are skipped
+	dto, err := service.RedeemPromoCode(profile.ID, "mixed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dto.DiscountPercent != 20 || dto.FreeDays != 0 || dto.FreeDaysError == "" {
+		t.Fatalf("mixed code redeemed as %+v", dto)
+	}
+	var redemption model.TelegramPromoRedemption
+	if err := db.Where("user_id = ?", profile.ID).First(&redemption).Error; err != nil {
+		t.Fatal(err)
+	}
+	if redemption.DiscountPercent != 20 || redemption.FreeDays != 0 {
+		t.Fatalf("mixed code stored as %+v", redemption)
+	}
+
+	// A code with only free days grants nothing, so its use is given back
+	if _, err := service.RedeemPromoCode(profile.ID, "free"); err == nil {
+		t.Fatal("free days redeemed without a client")
+	}
+	var promos []model.TelegramPromoCode
+	db.Order("code").Find(&promos)
+	if promos[0].Code != "FREE" || promos[0].UsedCount != 0 || promos[1].UsedCount != 1 {
+		t.Fatalf("used counts after redemption: %+v", promos)
+	}
+	var redeemed int64
+	db.Model(&model.TelegramPromoRedemption{}).Count(&redeemed)
+	if redeemed != 1 {
+		t.Fatalf("%d redemptions stored, want 1", redeemed)
+	}
+}
+
+func TestRedeemPromoFreeDaysClient(t *testing.T) {
+	initTestDB(t)
+	NewConfigService(core.NewCore())
+	db := database.GetDB()
+	service := TelegramService{}
+	now := time.Now()
+	day := int64(24 * 60 * 60)
+	link := func(telegramID int64, clients ...model.Client) uint {
+		profile, err := service.EnsureUserProfile(telegramID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, client := range clients {
+			client.Config, client.Inbounds, client.Links = json.RawMessage("{}"), json.RawMessage("[]"), json.RawMessage("[]")
+			if err := db.Create(&client).Error; err != nil {
+				t.Fatal(err)
+			}
+			db.Create(&model.TelegramUserClient{UserID: profile.ID, ClientID: client.Id})
+		}
+		return profile.ID
+	}
+	if err := db.Create(&model.TelegramPromoCode{Code: "FREE", FreeDays: 7, Active: true}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The active client that ends last is extended, not the first one linked
+	twoClients := link(1,
+		model.Client{Name: "ended", Enable: false, Expiry: now.Unix() - 10*day},
+		model.Client{Name: "active", Enable: true, Expiry: now.Unix() + 3*day})
+	if _, err := service.RedeemPromoCode(twoClients, "FREE"); err != nil {
+		t.Fatal(err)
+	}
+	if client := loadTestClient(t, "active"); client.Expiry != now.Unix()+10*day {
+		t.Fatalf("active client ends in %d days", (client.Expiry-now.Unix())/day)
+	}
+	if client := loadTestClient(t, "ended"); client.Expiry != now.Unix()-10*day {
+		t.Fatal("ended client was extended")
+	}
+
+	// An unlimited subscription is rejected before the code is used
+	unlimited := link(2, model.Client{Name: "unlimited", Enable: true})
+	if _, err := service.RedeemPromoCode(unlimited, "FREE"); err == nil {
+		t.Fatal("free days redeemed for an unlimited subscription")
+	}
+	var promo model.TelegramPromoCode
+	db.Where("code = ?", "FREE").First(&promo)
+	if promo.UsedCount != 1 {
+		t.Fatalf("used count %d, want 1", promo.UsedCount)
+	}
+
+	// Only a client DepleteJob disabled is enabled again
+	depleted := link(3, model.Client{Name: "depleted", Enable: false, Expiry: now.Unix() - day})
+	db.Create(&model.Changes{Actor: "DepleteJob", Key: "clients", Action: "disable", Obj: json.RawMessage(`"depleted"`)})
+	manual := link(4, model.Client{Name: "manual", Enable: false, Expiry: now.Unix() - day})
+	for _, userID := range []uint{depleted, manual} {
+		if _, err := service.RedeemPromoCode(userID, "FREE"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if client := loadTestClient(t, "depleted"); !client.Enable {
+		t.Fatal("client disabled for expiry stays disabled")
+	}
+	if client := loadTestClient(t, "manual"); client.Enable || client.Expiry <= now.Unix() {
+		t.Fatalf("client disabled by the admin: enable %v, expiry in %d days", client.Enable, (client.Expiry-now.Unix())/day)
+	}
+}
@@ -0,0 +1,199 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/util/common"
+
+	"github.com/gofrs/uuid/v5"
+	"gorm.io/gorm"
+)
+
+const telegramActor = "TelegramBot"
+
+type provisionResult struct {
+	ClientID   uint
+	ClientName string
+	ExpiresAt  time.Time
+}
+
+func telegramClientName(telegramID int64) string {
+	return fmt.Sprintf("tg%d", telegramID)
+}
+
+// newClientConfig generates credentials for every protocol, like the panel does for a new client.
+func newClientConfig(name string) (json.RawMessage, error) {
+	password := common.Random(10)
+	ssPassword16, err := randomBase64(16)
+	if err != nil {
+		return nil, err
+	}
+	ssPassword32, err := randomBase64(32)
+	if err != nil {
+		return nil, err
+	}
+	id := uuid.Must(uuid.NewV4()).String()
+	return json.Marshal(map[string]map[string]interface{}{
+		"mixed":         {"username": name, "password": password},
+		"socks":         {"username": name, "password": password},
+		"http":          {"username": name, "password": password},
+		"shadowsocks":   {"name": name, "password": ssPassword32},
+		"shadowsocks16": {"name": name, "password": ssPassword16},
+		"shadowtls":     {"name": name, "password": ssPassword32},
+		"vmess":         {"name": name, "uuid": id, "alterId": 0},
+		"vless":         {"name": name, "uuid": id, "flow": "xtls-rprx-vision"},
+		"anytls":        {"name": name, "password": password},
+		"trojan":        {"name": name, "password": password},
+		"naive":         {"username": name, "password": password},
+		"hysteria":      {"name": name, "auth_str": password},
+		"tuic":          {"name": name, "uuid": id, "password": password},
+		"hysteria2":     {"name": name, "password": password},
+	})
+}
+
+func randomBase64(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// telegramHostname picks the host used in generated links, as there is no HTTP request to take it from.
+func telegramHostname(cfg *model.TelegramBotConfig) string {
+	settingService := SettingService{}
+	if host, _ := settingService.GetSubDomain(); host != "" {
+		return host
+	}
+	if host, _ := settingService.GetWebDomain(); host != "" {
+		return host
+	}
+	if cfg != nil && cfg.WebhookDomain != "" {
+		domain := cfg.WebhookDomain
+		if !strings.Contains(domain, "://") {
+			domain = "https://" + domain
+		}
+		if u, err := url.Parse(domain); err == nil && u.Hostname() != "" {
+			return u.Hostname()
+		}
+	}
+	return "localhost"
+}
+
+// provisionPayment creates the user's client or extends it by the tariff duration.
+// A renewal starts a new period: the tariff volume is applied and usage is reset.
+func (s *TelegramService) provisionPayment(payment *model.TelegramPayment) (*provisionResult, error) {
+	db := database.GetDB()
+	var tariff model.TelegramTariff
+	if err := db.First(&tariff, payment.TariffID).Error; err != nil {
+		return nil, fmt.Errorf("tariff %d: %w", payment.TariffID, err)
+	}
+	var profile model.TelegramUserProfile
+	if err := db.First(&profile, payment.UserID).Error; err != nil {
+		return nil, err
+	}
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	linked, err := s.linkedClients(db, profile.ID)
+	if err != nil {
+		return nil, err
+	}
+	name := telegramClientName(profile.TelegramID)
+	now := time.Now()
+	act := "edit"
+	var client model.Client
+	if len(linked) > 0 {
+		client = linked[0]
+	} else {
+		err = db.Where("name = ?", name).First(&client).Error
+	}
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		act = "new"
+		client = model.Client{
+			Name:  name,
+			Links: json.RawMessage("[]"),
+			Group: "telegram",
+		}
+		client.Config, err = newClientConfig(name)
+		if err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	}
+
+	inbounds := tariff.GetInbounds()
+	if act == "edit" {
+		var current []uint
+		_ = json.Unmarshal(client.Inbounds, &current)
+		inbounds = common.UnionUintArray(current, inbounds)
+		sort.Slice(inbounds, func(i, j int) bool { return inbounds[i] < inbounds[j] })
+	}
+	client.Inbounds, err = json.Marshal(inbounds)
+	if err != nil {
+		return nil, err
+	}
+	start := now
+	if client.Enable && client.Expiry > now.Unix() {
+		start = time.Unix(client.Expiry, 0)
+	}
+	var expiresAt time.Time
+	if tariff.DurationDays > 0 {
+		expiresAt = start.AddDate(0, 0, tariff.DurationDays)
+		client.Expiry = expiresAt.Unix()
+	} else {
+		client.Expiry = 0
+	}
+	client.Enable = true
+	client.Volume = tariff.Volume
+	client.Up = 0
+	client.Down = 0
+	client.Desc = fmt.Sprintf("Telegram %d", profile.TelegramID)
+	if profile.Username != "" {
+		client.Desc += " @" + profile.Username
+	}
+
+	data, err := json.Marshal(client)
+	if err != nil {
+		return nil, err
+	}
+	configService := ConfigService{}
+	if _, err := configService.Save("clients", act, data, "", telegramActor, telegramHostname(cfg)); err != nil {
+		return nil, err
+	}
+	if client.Id == 0 {
+		if err := db.Where("name = ?", name).First(&client).Error; err != nil {
+			return nil, err
+		}
+	}
+	link := model.TelegramUserClient{UserID: profile.ID, ClientID: client.Id}
+	if err := db.Where("client_id = ?", client.Id).FirstOrCreate(&link).Error; err != nil {
+		return nil, err
+	}
+
+	if err := db.Model(&model.TelegramUserProfile{}).Where("id = ?", profile.ID).Updates(map[string]interface{}{
+		"ever_paid":      true,
+		"last_tariff_id": tariff.ID,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return &provisionResult{
+		ClientID:   client.Id,
+		ClientName: client.Name,
+		ExpiresAt:  expiresAt,
+	}, nil
+}
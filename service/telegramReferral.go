@@ -0,0 +1,376 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+	"github.com/alireza0/s-ui/util/common"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ReferralRewardDays     = "days"
+	ReferralRewardDiscount = "discount"
+
+	referralPending = "pending"
+	referralGranted = "granted"
+
+	// ReferralStartPrefix marks a referral code in the /start deep link parameter.
+	ReferralStartPrefix = "ref_"
+)
+
+var ErrReferralDisabled = errors.New("referral program is not active")
+
+type TelegramReferralNode struct {
+	UserID       uint                    `json:"userId"`
+	TelegramID   int64                   `json:"telegramId"`
+	Username     string                  `json:"username"`
+	FirstName    string                  `json:"firstName"`
+	ReferralCode string                  `json:"referralCode"`
+	ReferredAt   *time.Time              `json:"referredAt"`
+	EverPaid     bool                    `json:"everPaid"`
+	Rewarded     bool                    `json:"rewarded"`
+	Referrals    []*TelegramReferralNode `json:"referrals"`
+}
+
+type TelegramReferralTree struct {
+	// Upline lists the referrers of the user, the direct referrer first
+	Upline []TelegramReferralNode `json:"upline"`
+	Root   *TelegramReferralNode  `json:"root"`
+	// Total counts the users invited directly or indirectly
+	Total int `json:"total"`
+}
+
+// ReferralCode returns the user's own invitation code, creating it on first use.
+func (s *TelegramService) ReferralCode(userID uint) (string, error) {
+	db := database.GetDB()
+	for attempt := 0; attempt < 5; attempt++ {
+		var profile model.TelegramUserProfile
+		if err := db.First(&profile, userID).Error; err != nil {
+			return "", err
+		}
+		if profile.ReferralCode != "" {
+			return profile.ReferralCode, nil
+		}
+		code := common.Random(8)
+		var taken int64
+		if err := db.Model(&model.TelegramUserProfile{}).Where("referral_code = ?", code).Count(&taken).Error; err != nil {
+			return "", err
+		}
+		if taken > 0 {
+			continue
+		}
+		if err := db.Model(&model.TelegramUserProfile{}).Where("id = ? AND referral_code = ?", userID, "").
+			Update("referral_code", code).Error; err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("unable to create a referral code")
+}
+
+// ReferralLink is the t.me deep link that starts the bot with the user's code.
+func (s *TelegramService) ReferralLink(userID uint) (string, error) {
+	code, err := s.ReferralCode(userID)
+	if err != nil {
+		return "", err
+	}
+	username, err := s.botUsername()
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("https://t.me/%s?start=%s%s", username, ReferralStartPrefix, code), nil
+}
+
+// ApplyReferral records who invited the user. Only users who have not paid yet can be invited,
+// and only once.
+func (s *TelegramService) ApplyReferral(userID uint, code string) (*model.TelegramUserProfile, error) {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	if !cfg.ReferralEnabled {
+		return nil, ErrReferralDisabled
+	}
+	code = strings.TrimSpace(code)
+	if userID == 0 || code == "" {
+		return nil, errors.New("user id and referral code are required")
+	}
+	db := database.GetDB()
+	var referrer model.TelegramUserProfile
+	if err := db.Where("referral_code = ?", code).First(&referrer).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("invitation link is not valid")
+		}
+		return nil, err
+	}
+	if referrer.ID == userID {
+		return nil, errors.New("you cannot invite yourself")
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var user model.TelegramUserProfile
+		if err := tx.First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.ReferredByID != nil {
+			return errors.New("you have already been invited")
+		}
+		var payments int64
+		if err := tx.Model(&model.TelegramPayment{}).Where("user_id = ? AND status = ?", userID, PaymentStatusSucceeded).
+			Count(&payments).Error; err != nil {
+			return err
+		}
+		if user.EverPaid || payments > 0 {
+			return errors.New("invitations only work for new users")
+		}
+		// A referrer invited by this user, directly or not, would close a cycle
+		for next := referrer.ReferredByID; next != nil; {
+			if *next == userID {
+				return errors.New("you cannot be invited by someone you invited")
+			}
+			var upline model.TelegramUserProfile
+			if err := tx.Select("id", "referred_by_id").First(&upline, *next).Error; err != nil {
+				return err
+			}
+			next = upline.ReferredByID
+		}
+		now := time.Now()
+		result := tx.Model(&model.TelegramUserProfile{}).Where("id = ? AND referred_by_id IS NULL", userID).
+			Updates(map[string]interface{}{"referred_by_id": referrer.ID, "referred_at": &now})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("you have already been invited")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notifyChange()
+	return &referrer, nil
+}
+
+// rewardReferral grants the referrer's reward after the referee's first paid payment.
+// Payments fully covered by a discount do not count.
+func (s *TelegramService) rewardReferral(payment *model.TelegramPayment) {
+	cfg, err := s.GetConfig()
+	if err != nil || !cfg.ReferralEnabled || payment.AmountMinor <= 0 {
+		return
+	}
+	db := database.GetDB()
+	var referee model.TelegramUserProfile
+	if err := db.First(&referee, payment.UserID).Error; err != nil || referee.ReferredByID == nil {
+		return
+	}
+	var earlier int64
+	if err := db.Model(&model.TelegramPayment{}).
+		Where("user_id = ? AND id <> ? AND status = ? AND amount_minor > 0", payment.UserID, payment.ID, PaymentStatusSucceeded).
+		Count(&earlier).Error; err != nil || earlier > 0 {
+		return
+	}
+	reward := model.TelegramReferralReward{
+		ReferrerID: *referee.ReferredByID,
+		RefereeID:  referee.ID,
+		PaymentID:  payment.ID,
+		Kind:       cfg.ReferralReward,
+		Status:     referralPending,
+	}
+	if reward.Kind == ReferralRewardDays {
+		reward.FreeDays = cfg.ReferralDays
+	} else {
+		reward.DiscountPercent = cfg.ReferralPercent
+	}
+	if err := db.Create(&reward).Error; err != nil {
+		// The unique index on the referee rejects a second reward
+		if !strings.Contains(err.Error(), "UNIQUE") {
+			logger.Warning("telegram referral: unable to record reward:", err)
+		}
+		return
+	}
+	s.grantReferralReward(&reward)
+}
+
+// retryReferralRewards grants pending rewards of a referrer, free days wait for a subscription to extend.
+func (s *TelegramService) retryReferralRewards(referrerID uint) {
+	var pending []model.TelegramReferralReward
+	if err := database.GetDB().Where("referrer_id = ? AND status = ?", referrerID, referralPending).
+		Order("id asc").Find(&pending).Error; err != nil {
+		logger.Warning("telegram referral: unable to load pending rewards:", err)
+		return
+	}
+	for i := range pending {
+		s.grantReferralReward(&pending[i])
+	}
+}
+
+func (s *TelegramService) grantReferralReward(reward *model.TelegramReferralReward) {
+	db := database.GetDB()
+	data := &TelegramMessageData{}
+	var err error
+	switch reward.Kind {
+	case ReferralRewardDays:
+		var expiresAt *time.Time
+		expiresAt, err = s.extendClientDays(reward.ReferrerID, reward.FreeDays)
+		data.Days = reward.FreeDays
+		data.Expiry = formatTelegramTime(expiresAt)
+	case ReferralRewardDiscount:
+		err = db.Transaction(func(tx *gorm.DB) error {
+			promo := model.TelegramPromoCode{
+				Code:            "REF" + strings.ToUpper(common.Random(8)),
+				Description:     fmt.Sprintf("Referral reward for user %d", reward.RefereeID),
+				DiscountPercent: reward.DiscountPercent,
+				MaxUses:         1,
+				UsedCount:       1,
+				Active:          true,
+			}
+			if err := tx.Create(&promo).Error; err != nil {
+				return err
+			}
+			// Redeemed right away, so the discount applies to the referrer's next payment
+			if err := tx.Create(&model.TelegramPromoRedemption{
+				PromoCodeID:     promo.ID,
+				UserID:          reward.ReferrerID,
+				DiscountPercent: promo.DiscountPercent,
+			}).Error; err != nil {
+				return err
+			}
+			reward.PromoCodeID = &promo.ID
+			return nil
+		})
+		data.Discount = reward.DiscountPercent
+	default:
+		err = fmt.Errorf("unknown reward %q", reward.Kind)
+	}
+	updates := map[string]interface{}{"status": referralGranted, "error_message": ""}
+	if err != nil {
+		logger.Warningf("telegram referral reward %d is pending: %v", reward.ID, err)
+		updates = map[string]interface{}{"status": referralPending, "error_message": err.Error()}
+	} else {
+		now := time.Now()
+		updates["granted_at"] = &now
+		updates["promo_code_id"] = reward.PromoCodeID
+	}
+	if dbErr := db.Model(reward).Updates(updates).Error; dbErr != nil {
+		logger.Warning("telegram referral: unable to store reward:", dbErr)
+		return
+	}
+	if err == nil {
+		s.notifyReferrer(reward.ReferrerID, data)
+	}
+}
+
+func (s *TelegramService) notifyReferrer(referrerID uint, data *TelegramMessageData) {
+	api, err := s.BotAPI()
+	if err != nil {
+		return
+	}
+	var referrer model.TelegramUserProfile
+	if err := database.GetDB().First(&referrer, referrerID).Error; err != nil {
+		return
+	}
+	data.User = profileMessageData(&referrer).User
+	text := s.RenderMessage("referral.reward", data)
+	ctx, cancel := telegramContext()
+	defer cancel()
+	if _, err := api.SendMessage(ctx, &botapi.SendMessageParams{ChatID: referrer.TelegramID, Text: text}); err != nil {
+		logger.Warning("telegram referral: unable to notify referrer:", err)
+	}
+}
+
+// GetReferralTree returns everyone the user invited, directly or through the invited users.
+func (s *TelegramService) GetReferralTree(userID uint) (*TelegramReferralTree, error) {
+	if userID == 0 {
+		return nil, errors.New("user id is required")
+	}
+	db := database.GetDB()
+	var profiles []model.TelegramUserProfile
+	if err := db.Where("id = ? OR referred_by_id IS NOT NULL", userID).Find(&profiles).Error; err != nil {
+		return nil, err
+	}
+	var rewarded []uint
+	if err := db.Model(&model.TelegramReferralReward{}).Where("status = ?", referralGranted).
+		Pluck("referee_id", &rewarded).Error; err != nil {
+		return nil, err
+	}
+	rewardedSet := make(map[uint]bool, len(rewarded))
+	for _, id := range rewarded {
+		rewardedSet[id] = true
+	}
+	nodes := make(map[uint]*TelegramReferralNode, len(profiles))
+	children := make(map[uint][]uint)
+	for i := range profiles {
+		p := &profiles[i]
+		nodes[p.ID] = &TelegramReferralNode{
+			UserID:       p.ID,
+			TelegramID:   p.TelegramID,
+			Username:     p.Username,
+			FirstName:    p.FirstName,
+			ReferralCode: p.ReferralCode,
+			ReferredAt:   p.ReferredAt,
+			EverPaid:     p.EverPaid,
+			Rewarded:     rewardedSet[p.ID],
+			Referrals:    []*TelegramReferralNode{},
+		}
+		if p.ReferredByID != nil {
+			children[*p.ReferredByID] = append(children[*p.ReferredByID], p.ID)
+		}
+	}
+	root, ok := nodes[userID]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	tree := &TelegramReferralTree{Root: root, Upline: []TelegramReferralNode{}}
+	queue := []*TelegramReferralNode{root}
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		for _, id := range children[node.UserID] {
+			child := nodes[id]
+			node.Referrals = append(node.Referrals, child)
+			queue = append(queue, child)
+			tree.Total++
+		}
+	}
+
+	var current model.TelegramUserProfile
+	if err := db.First(&current, userID).Error; err != nil {
+		return nil, err
+	}
+	for next := current.ReferredByID; next != nil; {
+		var upline model.TelegramUserProfile
+		if err := db.First(&upline, *next).Error; err != nil {
+			break
+		}
+		tree.Upline = append(tree.Upline, TelegramReferralNode{
+			UserID:       upline.ID,
+			TelegramID:   upline.TelegramID,
+			Username:     upline.Username,
+			FirstName:    upline.FirstName,
+			ReferralCode: upline.ReferralCode,
+			ReferredAt:   upline.ReferredAt,
+			EverPaid:     upline.EverPaid,
+			Rewarded:     rewardedSet[upline.ID],
+		})
+		next = upline.ReferredByID
+	}
+	return tree, nil
+}
+
+// ListReferralRewards returns the reward ledger, newest first, optionally for one referrer.
+func (s *TelegramService) ListReferralRewards(referrerID uint) ([]model.TelegramReferralReward, error) {
+	query := database.GetDB().Order("id desc")
+	if referrerID != 0 {
+		query = query.Where("referrer_id = ?", referrerID)
+	}
+	var rewards []model.TelegramReferralReward
+	err := query.Find(&rewards).Error
+	return rewards, err
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	reminderExpiry   = "expiry"
+	reminderVolume   = "volume"
+	reminderDisabled = "disabled"
+)
+
+// Only clients disabled by DepleteJob within this window get the final notice,
+// so enabling reminders does not message users whose access ended long ago.
+const reminderDisabledWindow = 24 * time.Hour
+
+type telegramReminderTarget struct {
+	UserID       uint
+	TelegramID   int64
+	LastTariffID *uint
+	Language     string
+	FirstName    string
+	Username     string
+	model.Client
+}
+
+type pendingReminder struct {
+	kind      string
+	threshold int
+	// covered thresholds are recorded together, so a user first seen at 12 hours left gets one message
+	covered []int
+}
+
+// telegramRemindersMu skips a run while the previous one is still sending
+var telegramRemindersMu sync.Mutex
+
+// SendReminders messages linked Telegram users about upcoming expiry, volume usage and depletion.
+func (s *TelegramService) SendReminders() error {
+	if !telegramRemindersMu.TryLock() {
+		return nil
+	}
+	defer telegramRemindersMu.Unlock()
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.RemindersEnabled {
+		return nil
+	}
+	api, err := s.botAPIFor(cfg)
+	if err != nil {
+		return nil
+	}
+	db := database.GetDB()
+	var targets []telegramReminderTarget
+	err = db.Table("telegram_user_clients").
+		Select("telegram_user_clients.user_id, telegram_user_profiles.telegram_id, telegram_user_profiles.last_tariff_id, " +
+			"telegram_user_profiles.language, telegram_user_profiles.first_name, telegram_user_profiles.username, clients.*").
+		Joins("JOIN clients ON clients.id = telegram_user_clients.client_id").
+		Joins("JOIN telegram_user_profiles ON telegram_user_profiles.id = telegram_user_clients.user_id").
+		Scan(&targets).Error
+	if err != nil {
+		return err
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	var sent []model.TelegramReminder
+	if err := db.Find(&sent).Error; err != nil {
+		return err
+	}
+	sentByClient := make(map[uint][]model.TelegramReminder)
+	for _, r := range sent {
+		sentByClient[r.ClientID] = append(sentByClient[r.ClientID], r)
+	}
+	depleted, err := recentlyDepletedClients(db)
+	if err != nil {
+		return err
+	}
+
+	days := cfg.GetReminderDays()
+	percents := cfg.GetReminderVolume()
+	now := time.Now()
+	for i := range targets {
+		target := &targets[i]
+		existing := sentByClient[target.Id]
+		if err := s.resetReminders(db, target, existing, days, percents, now); err != nil {
+			return err
+		}
+		recorded := make(map[string]bool, len(existing))
+		for _, r := range existing {
+			recorded[reminderKey(r.Kind, r.Threshold)] = true
+		}
+		for _, reminder := range dueReminders(target, days, percents, depleted, now) {
+			if recorded[reminderKey(reminder.kind, reminder.threshold)] {
+				continue
+			}
+			claimed, ok, err := claimReminder(db, target, reminder, recorded)
+			if err != nil {
+				return err
+			}
+			for _, threshold := range reminder.covered {
+				recorded[reminderKey(reminder.kind, threshold)] = true
+			}
+			if !ok {
+				continue
+			}
+			if err := s.sendReminder(api, target, reminder, now); err != nil {
+				var apiErr *botapi.Error
+				if errors.As(err, &apiErr) && apiErr.Code == 429 {
+					// Try again on the next run
+					return db.Delete(&model.TelegramReminder{}, claimed).Error
+				}
+				logger.Warningf("telegram reminder for client %s failed: %v", target.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// claimReminder records a reminder and the thresholds it covers before it is sent, so a send is
+// never repeated. It reports false when the reminder was recorded in the meantime.
+func claimReminder(db *gorm.DB, target *telegramReminderTarget, reminder pendingReminder, recorded map[string]bool) ([]uint, bool, error) {
+	var claimed []uint
+	ok := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, threshold := range reminder.covered {
+			if recorded[reminderKey(reminder.kind, threshold)] {
+				continue
+			}
+			record := model.TelegramReminder{UserID: target.UserID, ClientID: target.Id, Kind: reminder.kind, Threshold: threshold}
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&record)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				claimed = append(claimed, record.ID)
+				ok = ok || threshold == reminder.threshold
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return claimed, ok, nil
+}
+
+func reminderKey(kind string, threshold int) string {
+	return fmt.Sprintf("%s:%d", kind, threshold)
+}
+
+func recentlyDepletedClients(db *gorm.DB) (map[string]bool, error) {
+	var changes []model.Changes
+	err := db.Model(model.Changes{}).Where("actor = ? AND `key` = ? AND action = ? AND date_time > ?",
+		"DepleteJob", "clients", "disable", time.Now().Add(-reminderDisabledWindow).Unix()).
+		Scan(&changes).Error
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(changes))
+	for _, change := range changes {
+		var name string
+		if json.Unmarshal(change.Obj, &name) == nil {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// dueReminders returns at most one reminder per kind, the most urgent threshold reached.
+func dueReminders(c *telegramReminderTarget, days []int, percents []int, depleted map[string]bool, now time.Time) []pendingReminder {
+	var result []pendingReminder
+	if !c.Enable {
+		if depleted[c.Name] && clientDepleted(&c.Client, now) {
+			result = append(result, pendingReminder{kind: reminderDisabled, covered: []int{0}})
+		}
+		return result
+	}
+	if c.Expiry > 0 && c.Expiry > now.Unix() {
+		left := time.Unix(c.Expiry, 0).Sub(now)
+		if r, ok := reachedThreshold(reminderExpiry, days, func(d int) bool { return left <= time.Duration(d)*24*time.Hour }); ok {
+			result = append(result, r)
+		}
+	}
+	if c.Volume > 0 && c.Up+c.Down < c.Volume {
+		used := (c.Up + c.Down) * 100 / c.Volume
+		if r, ok := reachedThreshold(reminderVolume, percents, func(p int) bool { return used >= int64(p) }); ok {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// reachedThreshold picks the most urgent reached threshold, thresholds are sorted by urgency first.
+func reachedThreshold(kind string, thresholds []int, reached func(int) bool) (pendingReminder, bool) {
+	sorted := append([]int(nil), thresholds...)
+	if kind == reminderExpiry {
+		sort.Ints(sorted)
+	} else {
+		sort.Sort(sort.Reverse(sort.IntSlice(sorted)))
+	}
+	reminder := pendingReminder{kind: kind}
+	for _, t := range sorted {
+		if !reached(t) {
+			continue
+		}
+		if len(reminder.covered) == 0 {
+			reminder.threshold = t
+		}
+		reminder.covered = append(reminder.covered, t)
+	}
+	return reminder, len(reminder.covered) > 0
+}
+
+func clientDepleted(c *model.Client, now time.Time) bool {
+	return (c.Expiry > 0 && c.Expiry <= now.Unix()) || (c.Volume > 0 && c.Up+c.Down >= c.Volume)
+}
+
+// resetReminders forgets reminders whose condition does not hold anymore, so they fire again next period.
+func (s *TelegramService) resetReminders(db *gorm.DB, c *telegramReminderTarget, existing []model.TelegramReminder, days []int, percents []int, now time.Time) error {
+	var stale []uint
+	for _, r := range existing {
+		holds := true
+		switch r.Kind {
+		case reminderExpiry:
+			holds = c.Expiry > 0 && time.Unix(c.Expiry, 0).Sub(now) <= time.Duration(r.Threshold)*24*time.Hour
+		case reminderVolume:
+			holds = c.Volume > 0 && (c.Up+c.Down)*100/c.Volume >= int64(r.Threshold)
+		case reminderDisabled:
+			holds = !c.Enable
+		}
+		if !holds {
+			stale = append(stale, r.ID)
+		}
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+	return db.Delete(&model.TelegramReminder{}, stale).Error
+}
+
+func (s *TelegramService) sendReminder(api botapi.API, c *telegramReminderTarget, reminder pendingReminder, now time.Time) error {
+	data := &TelegramMessageData{User: TelegramMessageUser{
+		ID:        c.TelegramID,
+		FirstName: c.FirstName,
+		Username:  c.Username,
+		Language:  c.Language,
+	}}
+	if c.Expiry > 0 {
+		expiry := time.Unix(c.Expiry, 0)
+		data.Expiry = formatTelegramTime(&expiry)
+	}
+	if c.Volume > 0 {
+		data.Volume = formatTelegramBytes(c.Volume)
+		data.Used = formatTelegramBytes(c.Up + c.Down)
+		data.Remaining = formatTelegramBytes(max(c.Volume-c.Up-c.Down, 0))
+		data.Percent = (c.Up + c.Down) * 100 / c.Volume
+	}
+	key := "reminder." + reminder.kind
+	if reminder.kind == reminderDisabled {
+		key = "reminder.depleted"
+		if c.Expiry > 0 && c.Expiry <= now.Unix() {
+			key = "reminder.expired"
+		}
+	}
+	text := s.RenderMessage(key, data)
+	params := &botapi.SendMessageParams{
+		ChatID:      c.TelegramID,
+		Text:        text,
+		ReplyMarkup: s.renewKeyboard(c.LastTariffID),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), telegramAPITimeout)
+	defer cancel()
+	if err := telegramBroadcastQueue.wait(ctx); err != nil {
+		return err
+	}
+	_, err := api.SendMessage(ctx, params)
+	return err
+}
+
+// renewKeyboard offers the last bought tariff, or the tariff list when it is gone.
+func (s *TelegramService) renewKeyboard(lastTariffID *uint) *botapi.InlineKeyboardMarkup {
+	button := botapi.InlineKeyboardButton{Text: "Renew", CallbackData: "tariffs:"}
+	if lastTariffID != nil {
+		var tariff model.TelegramTariff
+		if err := database.GetDB().Where("id = ? AND active = ?", *lastTariffID, true).First(&tariff).Error; err == nil {
+			button.CallbackData = fmt.Sprintf("buy:%d", tariff.ID)
+			button.Text = fmt.Sprintf("Renew %s", tariff.Title)
+		}
+	}
+	return &botapi.InlineKeyboardMarkup{InlineKeyboard: [][]botapi.InlineKeyboardButton{{button}}}
+}
@@ -0,0 +1,174 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+
+	"gorm.io/gorm"
+)
+
+// TelegramSubscription is derived from the clients linked to a profile, never stored.
+type TelegramSubscription struct {
+	Active    bool       `json:"active"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Volume    int64      `json:"volume"`
+	Used      int64      `json:"used"`
+	Remaining int64      `json:"remaining"`
+	ClientIDs []uint     `json:"clientIds"`
+}
+
+type telegramLinkedClient struct {
+	UserID uint
+	model.Client
+}
+
+// clientActive mirrors the conditions DepleteClients uses to disable a client.
+func clientActive(c *model.Client, now int64) bool {
+	return c.Enable &&
+		(c.Expiry == 0 || c.Expiry > now) &&
+		(c.Volume == 0 || c.Up+c.Down < c.Volume)
+}
+
+// computeSubscription merges linked clients: the latest expiry wins, volumes of limited clients add up,
+// and Volume 0 means at least one active client has no traffic limit.
+func computeSubscription(clients []model.Client, now time.Time) *TelegramSubscription {
+	sub := &TelegramSubscription{ClientIDs: make([]uint, 0, len(clients))}
+	var latest int64
+	unlimitedTime := false
+	unlimitedVolume := false
+	for i := range clients {
+		c := &clients[i]
+		sub.ClientIDs = append(sub.ClientIDs, c.Id)
+		if !clientActive(c, now.Unix()) {
+			continue
+		}
+		sub.Active = true
+		if c.Expiry == 0 {
+			unlimitedTime = true
+		} else if c.Expiry > latest {
+			latest = c.Expiry
+		}
+		sub.Used += c.Up + c.Down
+		if c.Volume == 0 {
+			unlimitedVolume = true
+		} else {
+			sub.Volume += c.Volume
+		}
+	}
+	if !sub.Active {
+		// Report when the access ended so expired users can be told apart
+		for i := range clients {
+			if clients[i].Expiry > latest {
+				latest = clients[i].Expiry
+			}
+		}
+	}
+	if latest > 0 && !unlimitedTime {
+		expiresAt := time.Unix(latest, 0)
+		sub.ExpiresAt = &expiresAt
+	}
+	if unlimitedVolume {
+		sub.Volume = 0
+	}
+	if sub.Volume > 0 && sub.Volume > sub.Used {
+		sub.Remaining = sub.Volume - sub.Used
+	}
+	return sub
+}
+
+// loadSubscriptions computes subscriptions for the given profiles with a single query, nil ids means all profiles.
+func (s *TelegramService) loadSubscriptions(db *gorm.DB, userIDs []uint) (map[uint]*TelegramSubscription, error) {
+	var rows []telegramLinkedClient
+	query := db.Table("telegram_user_clients").
+		Select("telegram_user_clients.user_id, clients.*").
+		Joins("JOIN clients ON clients.id = telegram_user_clients.client_id").
+		Order("clients.id asc")
+	if userIDs != nil {
+		query = query.Where("telegram_user_clients.user_id IN ?", userIDs)
+	}
+	if err := query.Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	grouped := make(map[uint][]model.Client)
+	for _, row := range rows {
+		grouped[row.UserID] = append(grouped[row.UserID], row.Client)
+	}
+	now := time.Now()
+	result := make(map[uint]*TelegramSubscription, len(grouped))
+	for userID, clients := range grouped {
+		result[userID] = computeSubscription(clients, now)
+	}
+	return result, nil
+}
+
+func (s *TelegramService) GetSubscription(userID uint) (*TelegramSubscription, error) {
+	subs, err := s.loadSubscriptions(database.GetDB(), []uint{userID})
+	if err != nil {
+		return nil, err
+	}
+	return subscriptionOf(subs, userID), nil
+}
+
+func subscriptionOf(subs map[uint]*TelegramSubscription, userID uint) *TelegramSubscription {
+	if sub, ok := subs[userID]; ok {
+		return sub
+	}
+	return &TelegramSubscription{ClientIDs: []uint{}}
+}
+
+// linkedClients returns the clients of a profile, oldest first.
+func (s *TelegramService) linkedClients(db *gorm.DB, userID uint) ([]model.Client, error) {
+	var clients []model.Client
+	err := db.Model(model.Client{}).
+		Where("id IN (?)", db.Model(&model.TelegramUserClient{}).Select("client_id").Where("user_id = ?", userID)).
+		Order("id asc").Find(&clients).Error
+	return clients, err
+}
+
+func (s *TelegramService) LinkClient(userID uint, clientID uint) (*TelegramSubscription, error) {
+	if userID == 0 || clientID == 0 {
+		return nil, errors.New("user id and client id are required")
+	}
+	db := database.GetDB()
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.First(&model.TelegramUserProfile{}, userID).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&model.Client{}, clientID).Error; err != nil {
+			return err
+		}
+		var link model.TelegramUserClient
+		err := tx.Where("client_id = ?", clientID).First(&link).Error
+		if err == nil {
+			if link.UserID == userID {
+				return nil
+			}
+			return fmt.Errorf("client %d is already linked to another telegram user", clientID)
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return tx.Create(&model.TelegramUserClient{UserID: userID, ClientID: clientID}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.notifyChange()
+	return s.GetSubscription(userID)
+}
+
+func (s *TelegramService) UnlinkClient(userID uint, clientID uint) (*TelegramSubscription, error) {
+	if userID == 0 || clientID == 0 {
+		return nil, errors.New("user id and client id are required")
+	}
+	db := database.GetDB()
+	if err := db.Where("user_id = ? AND client_id = ?", userID, clientID).Delete(&model.TelegramUserClient{}).Error; err != nil {
+		return nil, err
+	}
+	s.notifyChange()
+	return s.GetSubscription(userID)
+}
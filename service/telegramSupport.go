@@ -0,0 +1,728 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/alireza0/s-ui/config"
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+
+	"gorm.io/gorm"
+)
+
+// Support ticket states: open waits for an admin, pending waits for the user
+const (
+	TicketStatusOpen    = "open"
+	TicketStatusPending = "pending"
+	TicketStatusClosed  = "closed"
+)
+
+const (
+	attachmentPhoto    = "photo"
+	attachmentDocument = "document"
+
+	// getFile only serves files up to this size
+	maxAttachmentDownload = 20 << 20
+	attachmentTimeout     = 2 * time.Minute
+	// Failed downloads are retried for a day, Telegram keeps file ids valid at least that long
+	attachmentRetryWindow = 24 * time.Hour
+	attachmentRetryBatch  = 20
+
+	maxConversationTags = 10
+	maxReplyLength      = 4096
+	maxCannedTitle      = 64
+)
+
+var conversationTagPattern = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,32}$`)
+
+var attachmentExtPattern = regexp.MustCompile(`^\.[a-z0-9]{1,8}$`)
+
+var errAttachmentTooLarge = errors.New("telegram does not serve files larger than 20 MB to bots")
+
+// attachmentMu keeps the download on arrival and the retry job from storing a file twice
+var attachmentMu sync.Mutex
+
+// TelegramTicket is the support state shown with a conversation.
+type TelegramTicket struct {
+	Status   string   `json:"status"`
+	Assignee string   `json:"assignee"`
+	Tags     []string `json:"tags"`
+}
+
+// TelegramConversationFilter selects conversations, Status is a comma separated list.
+type TelegramConversationFilter struct {
+	Status     string `form:"status" json:"status"`
+	Assignee   string `form:"assignee" json:"assignee"`
+	Unassigned bool   `form:"unassigned" json:"unassigned"`
+	Tag        string `form:"tag" json:"tag"`
+	Search     string `form:"search" json:"search"`
+	Limit      int    `form:"limit" json:"limit"`
+	Offset     int    `form:"offset" json:"offset"`
+}
+
+type TelegramConversationList struct {
+	Total         int64                         `json:"total"`
+	Conversations []TelegramConversationSummary `json:"conversations"`
+}
+
+type TelegramConversationReply struct {
+	ID    uint   `json:"id"`
+	Text  string `json:"text"`
+	Close bool   `json:"close"`
+}
+
+// TelegramConversationUpdate changes the fields that are set and keeps the others.
+type TelegramConversationUpdate struct {
+	ID       uint      `json:"id"`
+	Status   *string   `json:"status"`
+	Assignee *string   `json:"assignee"`
+	Tags     *[]string `json:"tags"`
+}
+
+type TelegramCannedReplyPayload struct {
+	ID    uint   `json:"id"`
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
+
+func newTelegramTicket(c *model.TelegramConversation) TelegramTicket {
+	if c == nil {
+		return TelegramTicket{Status: TicketStatusClosed, Tags: []string{}}
+	}
+	return TelegramTicket{Status: c.Status, Assignee: c.Assignee, Tags: c.GetTags()}
+}
+
+func (f *TelegramConversationFilter) apply(db *gorm.DB) *gorm.DB {
+	query := db.Model(&model.TelegramConversation{})
+	if status := strings.TrimSpace(f.Status); status != "" {
+		query = query.Where("status IN ?", strings.Split(status, ","))
+	}
+	if f.Unassigned {
+		query = query.Where("assignee = ?", "")
+	} else if assignee := strings.TrimSpace(f.Assignee); assignee != "" {
+		query = query.Where("assignee = ?", assignee)
+	}
+	if tag := strings.ToLower(strings.TrimSpace(f.Tag)); tag != "" {
+		// Tags are stored as a JSON list and cannot contain quotes
+		query = query.Where("tags LIKE ?", `%"`+tag+`"%`)
+	}
+	if search := strings.TrimSpace(f.Search); search != "" {
+		like := "%" + strings.TrimPrefix(search, "@") + "%"
+		query = query.Where("user_id IN (?)", db.Model(&model.TelegramUserProfile{}).Select("id").
+			Where("username LIKE ? OR first_name LIKE ? OR last_name LIKE ? OR CAST(telegram_id AS TEXT) = ?", like, like, like, search))
+	}
+	return query
+}
+
+// ListConversations returns one page of support conversations, the most recent first.
+// Users, last messages and subscriptions are loaded in one query each for the whole page.
+func (s *TelegramService) ListConversations(filter TelegramConversationFilter) (*TelegramConversationList, error) {
+	db := database.GetDB()
+	query := filter.apply(db)
+	result := &TelegramConversationList{Conversations: []TelegramConversationSummary{}}
+	if err := query.Count(&result.Total).Error; err != nil {
+		return nil, err
+	}
+	if filter.Limit <= 0 || filter.Limit > 500 {
+		filter.Limit = 50
+	}
+	var conversations []model.TelegramConversation
+	if err := query.Order("last_message_at desc, id desc").Limit(filter.Limit).Offset(filter.Offset).
+		Find(&conversations).Error; err != nil {
+		return nil, err
+	}
+	if len(conversations) == 0 {
+		return result, nil
+	}
+	userIDs := make([]uint, 0, len(conversations))
+	messageIDs := make([]uint, 0, len(conversations))
+	for _, c := range conversations {
+		userIDs = append(userIDs, c.UserID)
+		messageIDs = append(messageIDs, c.LastMessageID)
+	}
+	var users []model.TelegramUserProfile
+	if err := db.Where("id IN ?", userIDs).Find(&users).Error; err != nil {
+		return nil, err
+	}
+	var messages []model.TelegramUserMessage
+	if err := db.Where("id IN ?", messageIDs).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	subs, err := s.loadSubscriptions(db, userIDs)
+	if err != nil {
+		return nil, err
+	}
+	usersByID := make(map[uint]*model.TelegramUserProfile, len(users))
+	for i := range users {
+		usersByID[users[i].ID] = &users[i]
+	}
+	messagesByID := make(map[uint]*model.TelegramUserMessage, len(messages))
+	for i := range messages {
+		messagesByID[messages[i].ID] = &messages[i]
+	}
+	for i := range conversations {
+		c := &conversations[i]
+		user, ok := usersByID[c.UserID]
+		if !ok {
+			continue
+		}
+		result.Conversations = append(result.Conversations, TelegramConversationSummary{
+			User:           newTelegramUserDTO(user, subscriptionOf(subs, user.ID)),
+			TelegramTicket: newTelegramTicket(c),
+			LastMessage:    newTelegramConversationMessageDTO(messagesByID[c.LastMessageID]),
+			LastMessageAt:  c.LastMessageAt,
+			UnreadCount:    c.Unread,
+		})
+	}
+	return result, nil
+}
+
+func (s *TelegramService) GetConversation(userID uint) (*TelegramConversationDTO, error) {
+	return s.loadConversation(userID, true)
+}
+
+func (s *TelegramService) loadConversation(userID uint, markSeen bool) (*TelegramConversationDTO, error) {
+	if userID == 0 {
+		return nil, errors.New("user id is required")
+	}
+	db := database.GetDB()
+	var user model.TelegramUserProfile
+	if err := db.First(&user, userID).Error; err != nil {
+		return nil, err
+	}
+	var messages []model.TelegramUserMessage
+	if err := db.Where("user_id = ?", user.ID).Order("created_at asc, id asc").Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	sub, err := s.GetSubscription(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	var conversation *model.TelegramConversation
+	var row model.TelegramConversation
+	err = db.Where("user_id = ?", user.ID).First(&row).Error
+	if err == nil {
+		conversation = &row
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	dto := &TelegramConversationDTO{
+		User:           newTelegramUserDTO(&user, sub),
+		TelegramTicket: newTelegramTicket(conversation),
+		Messages:       make([]TelegramConversationMessage, 0, len(messages)),
+	}
+	for i := range messages {
+		dto.Messages = append(dto.Messages, *newTelegramConversationMessageDTO(&messages[i]))
+	}
+	if markSeen {
+		_ = db.Model(&model.TelegramUserMessage{}).
+			Where("user_id = ? AND direction = ? AND seen = ?", user.ID, "inbound", false).
+			Update("seen", true).Error
+		if conversation != nil && conversation.Unread > 0 {
+			_ = db.Model(conversation).Update("unread", 0).Error
+		}
+	}
+	return dto, nil
+}
+
+// findConversation returns the ticket of the user, creating a closed one for users who never wrote to support.
+func findConversation(tx *gorm.DB, userID uint) (*model.TelegramConversation, error) {
+	conversation := model.TelegramConversation{UserID: userID}
+	err := tx.Where("user_id = ?", userID).
+		Attrs(model.TelegramConversation{Status: TicketStatusClosed, Tags: "[]"}).
+		FirstOrCreate(&conversation).Error
+	if err != nil {
+		return nil, err
+	}
+	return &conversation, nil
+}
+
+// touchConversation moves the ticket after a message: an inbound message opens it, a reply
+// leaves it pending the user, or closes it.
+func touchConversation(tx *gorm.DB, msg *model.TelegramUserMessage, status string) error {
+	conversation, err := findConversation(tx, msg.UserID)
+	if err != nil {
+		return err
+	}
+	updates := map[string]interface{}{
+		"status":          status,
+		"last_message_id": msg.ID,
+		"last_message_at": msg.CreatedAt,
+	}
+	if msg.Direction == "inbound" {
+		updates["unread"] = gorm.Expr("unread + 1")
+	} else {
+		updates["unread"] = 0
+	}
+	if status == TicketStatusClosed {
+		updates["closed_at"] = msg.CreatedAt
+	} else {
+		updates["closed_at"] = nil
+	}
+	return tx.Model(conversation).Updates(updates).Error
+}
+
+// ReplyToConversation sends the reply through the bot and stores it once Telegram accepted it.
+func (s *TelegramService) ReplyToConversation(payload *TelegramConversationReply, author string) (*TelegramConversationDTO, error) {
+	if payload.ID == 0 {
+		return nil, errors.New("user id is required")
+	}
+	text := strings.TrimSpace(payload.Text)
+	if text == "" {
+		return nil, errors.New("message body is required")
+	}
+	if utf8.RuneCountInString(text) > maxReplyLength {
+		return nil, fmt.Errorf("reply is limited to %d characters", maxReplyLength)
+	}
+	db := database.GetDB()
+	var user model.TelegramUserProfile
+	if err := db.First(&user, payload.ID).Error; err != nil {
+		return nil, err
+	}
+	api, err := s.BotAPI()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := telegramContext()
+	sent, err := api.SendMessage(ctx, &botapi.SendMessageParams{ChatID: user.TelegramID, Text: text})
+	cancel()
+	if err != nil {
+		return nil, fmt.Errorf("telegram did not deliver the reply: %w", err)
+	}
+	now := time.Now()
+	msg := model.TelegramUserMessage{
+		UserID:            user.ID,
+		Direction:         "outbound",
+		Body:              text,
+		TelegramMessageID: formatTelegramMessageID(sent.MessageID),
+		Seen:              true,
+		Author:            author,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	status := TicketStatusPending
+	if payload.Close {
+		status = TicketStatusClosed
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&msg).Error; err != nil {
+			return err
+		}
+		if err := touchConversation(tx, &msg, status); err != nil {
+			return err
+		}
+		// The reply answers everything the user wrote before
+		if err := tx.Model(&model.TelegramUserMessage{}).
+			Where("user_id = ? AND direction = ? AND seen = ?", user.ID, "inbound", false).
+			Update("seen", true).Error; err != nil {
+			return err
+		}
+		return tx.Model(&model.TelegramUserProfile{}).
+			Where("id = ?", user.ID).
+			Updates(map[string]interface{}{
+				"updated_at":          now,
+				"last_interaction_at": now,
+			}).Error
+	})
+	if err != nil {
+		// The user already has the message, so only the history is incomplete
+		logger.Warning("telegram support: reply sent but not stored:", err)
+		return nil, err
+	}
+	return s.loadConversation(user.ID, false)
+}
+
+func normalizeConversationTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if !conversationTagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q, use up to 32 letters, digits, _ or -", tag)
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	if len(normalized) > maxConversationTags {
+		return nil, fmt.Errorf("a conversation can have at most %d tags", maxConversationTags)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// UpdateConversation changes the status, assignee or tags of a ticket. The assignee is a panel user,
+// an empty one unassigns the ticket.
+func (s *TelegramService) UpdateConversation(payload *TelegramConversationUpdate) (*TelegramConversationDTO, error) {
+	if payload.ID == 0 {
+		return nil, errors.New("user id is required")
+	}
+	db := database.GetDB()
+	var user model.TelegramUserProfile
+	if err := db.First(&user, payload.ID).Error; err != nil {
+		return nil, err
+	}
+	updates := map[string]interface{}{}
+	if payload.Status != nil {
+		switch status := *payload.Status; status {
+		case TicketStatusOpen, TicketStatusPending:
+			updates["status"] = status
+			updates["closed_at"] = nil
+		case TicketStatusClosed:
+			updates["status"] = status
+			updates["closed_at"] = time.Now()
+		default:
+			return nil, fmt.Errorf("unknown status %q", status)
+		}
+	}
+	if payload.Assignee != nil {
+		assignee := strings.TrimSpace(*payload.Assignee)
+		if assignee != "" {
+			var count int64
+			if err := db.Model(&model.User{}).Where("username = ?", assignee).Count(&count).Error; err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, fmt.Errorf("panel user %q not found", assignee)
+			}
+		}
+		updates["assignee"] = assignee
+	}
+	if payload.Tags != nil {
+		tags, err := normalizeConversationTags(*payload.Tags)
+		if err != nil {
+			return nil, err
+		}
+		var conversation model.TelegramConversation
+		if err := conversation.SetTags(tags); err != nil {
+			return nil, err
+		}
+		updates["tags"] = conversation.Tags
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		conversation, err := findConversation(tx, user.ID)
+		if err != nil {
+			return err
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(conversation).Updates(updates).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.loadConversation(user.ID, false)
+}
+
+func (s *TelegramService) RecordInboundMessage(input *TelegramInboundMessage) (*TelegramConversationDTO, error) {
+	if input == nil {
+		return nil, errors.New("input is required")
+	}
+	if input.TelegramID == 0 {
+		return nil, errors.New("telegram id is required")
+	}
+	body := strings.TrimSpace(input.Message)
+	if body == "" && input.AttachmentType == "" {
+		return nil, errors.New("message body is required")
+	}
+	if input.ActiveSubscription || input.SubscriptionExpiresAt != nil {
+		logger.Warning("telegram: activeSubscription and subscriptionExpiresAt of inbound messages are ignored, the subscription comes from the linked clients")
+	}
+	db := database.GetDB()
+	now := time.Now()
+	var profile model.TelegramUserProfile
+	var msg model.TelegramUserMessage
+	var isSupport bool
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(model.TelegramUserProfile{TelegramID: input.TelegramID}).FirstOrCreate(&profile).Error; err != nil {
+			return err
+		}
+		profile.Username = input.Username
+		profile.FirstName = input.FirstName
+		profile.LastName = input.LastName
+		profile.Language = input.Language
+		profile.EverPaid = profile.EverPaid || input.EverPaid
+		if input.TariffID != nil {
+			profile.LastTariffID = input.TariffID
+		}
+		profile.LastInteractionAt = now
+		if err := tx.Save(&profile).Error; err != nil {
+			return err
+		}
+		// Bot commands are kept for history but are not support requests
+		isSupport = input.AttachmentType != "" || !strings.HasPrefix(body, "/")
+		msg = model.TelegramUserMessage{
+			UserID:            profile.ID,
+			Direction:         "inbound",
+			Body:              body,
+			TelegramMessageID: input.MessageID,
+			Seen:              !isSupport,
+			AttachmentType:    input.AttachmentType,
+			AttachmentName:    input.AttachmentName,
+			AttachmentFileID:  input.AttachmentFileID,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		}
+		if err := tx.Create(&msg).Error; err != nil {
+			return err
+		}
+		if !isSupport {
+			return nil
+		}
+		return touchConversation(tx, &msg, TicketStatusOpen)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if isSupport {
+		s.notifyAdminsSupport(&profile, &msg)
+	}
+	if msg.AttachmentFileID != "" {
+		go func() {
+			if err := s.storeAttachment(msg.ID); err != nil {
+				logger.Warning("telegram support: unable to download attachment:", err)
+			}
+		}()
+	}
+	return s.loadConversation(profile.ID, false)
+}
+
+// telegramAttachmentDir keeps the files users send to support next to the database.
+func telegramAttachmentDir() string {
+	return filepath.Join(config.GetDBFolderPath(), "telegram-attachments")
+}
+
+func telegramAttachmentPath(file string) (string, error) {
+	if file == "" || file != filepath.Base(file) || strings.HasPrefix(file, ".") {
+		return "", fmt.Errorf("invalid attachment file %q", file)
+	}
+	return filepath.Join(telegramAttachmentDir(), file), nil
+}
+
+// DownloadPendingAttachments stores the files of recent messages whose download failed.
+func (s *TelegramService) DownloadPendingAttachments() error {
+	if _, err := s.BotAPI(); err != nil {
+		// Nothing can be downloaded while the bot is disabled
+		return nil
+	}
+	var ids []uint
+	err := database.GetDB().Model(&model.TelegramUserMessage{}).
+		Where("attachment_file_id <> '' AND attachment_file = '' AND created_at > ?", time.Now().Add(-attachmentRetryWindow)).
+		Order("id asc").Limit(attachmentRetryBatch).Pluck("id", &ids).Error
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if err := s.storeAttachment(id); err != nil {
+			logger.Warningf("telegram support: unable to download attachment of message %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// storeAttachment downloads the file of a message unless it is stored already, and records
+// why it failed. A file Telegram will never serve is not retried.
+func (s *TelegramService) storeAttachment(messageID uint) error {
+	attachmentMu.Lock()
+	defer attachmentMu.Unlock()
+	db := database.GetDB()
+	var msg model.TelegramUserMessage
+	if err := db.First(&msg, messageID).Error; err != nil {
+		return err
+	}
+	if msg.AttachmentFile != "" || msg.AttachmentFileID == "" {
+		return nil
+	}
+	err := s.downloadAttachment(&msg)
+	if err == nil {
+		return nil
+	}
+	updates := map[string]interface{}{"attachment_error": err.Error()}
+	if errors.Is(err, errAttachmentTooLarge) {
+		updates["attachment_file_id"] = ""
+	}
+	if updateErr := db.Model(&msg).Updates(updates).Error; updateErr != nil {
+		logger.Warning("telegram support: unable to save attachment error:", updateErr)
+	}
+	return err
+}
+
+// downloadAttachment fetches the file of a message from Telegram and stores its name with the message.
+func (s *TelegramService) downloadAttachment(msg *model.TelegramUserMessage) error {
+	api, err := s.BotAPI()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), attachmentTimeout)
+	defer cancel()
+	file, err := api.GetFile(ctx, &botapi.GetFileParams{FileID: msg.AttachmentFileID})
+	if err != nil {
+		return err
+	}
+	if file.FileSize > maxAttachmentDownload {
+		return errAttachmentTooLarge
+	}
+	ext := strings.ToLower(filepath.Ext(msg.AttachmentName))
+	if ext == "" {
+		ext = strings.ToLower(filepath.Ext(file.FilePath))
+	}
+	if !attachmentExtPattern.MatchString(ext) {
+		ext = ""
+	}
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return err
+	}
+	name := hex.EncodeToString(random) + ext
+	if err := os.MkdirAll(telegramAttachmentDir(), 0o755); err != nil {
+		return err
+	}
+	path, _ := telegramAttachmentPath(name)
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	err = api.DownloadFile(ctx, file.FilePath, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	err = database.GetDB().Model(&model.TelegramUserMessage{}).Where("id = ?", msg.ID).
+		Updates(map[string]interface{}{"attachment_file": name, "attachment_error": ""}).Error
+	if err != nil {
+		os.Remove(path)
+		return err
+	}
+	msg.AttachmentFile = name
+	return nil
+}
+
+// AttachmentPath resolves the stored file of a message.
+func (s *TelegramService) AttachmentPath(messageID uint) (string, *model.TelegramUserMessage, error) {
+	var msg model.TelegramUserMessage
+	if err := database.GetDB().First(&msg, messageID).Error; err != nil {
+		return "", nil, err
+	}
+	if msg.AttachmentType == "" {
+		return "", nil, errors.New("message has no attachment")
+	}
+	if msg.AttachmentFile == "" {
+		if msg.AttachmentError != "" {
+			return "", nil, fmt.Errorf("attachment was not downloaded: %s", msg.AttachmentError)
+		}
+		return "", nil, errors.New("attachment is not downloaded yet")
+	}
+	path, err := telegramAttachmentPath(msg.AttachmentFile)
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return "", nil, errors.New("attachment file is missing")
+	}
+	return path, &msg, nil
+}
+
+// attachmentOf describes the photo or document of a message for RecordInboundMessage.
+func attachmentOf(msg *botapi.Message) (string, string, string) {
+	if len(msg.Photo) > 0 {
+		return attachmentPhoto, msg.FileID(), "photo.jpg"
+	}
+	if msg.Document != nil {
+		name := filepath.Base(msg.Document.FileName)
+		if name == "." || name == "/" {
+			name = ""
+		}
+		if name == "" {
+			name = "document"
+		}
+		return attachmentDocument, msg.Document.FileID, name
+	}
+	return "", "", ""
+}
+
+// supportNoticeBody is what the admin notice shows for a message, files have no text of their own.
+func supportNoticeBody(msg *model.TelegramUserMessage) string {
+	if msg.AttachmentType == "" {
+		return msg.Body
+	}
+	label := fmt.Sprintf("[%s: %s]", msg.AttachmentType, msg.AttachmentName)
+	if msg.Body == "" {
+		return label
+	}
+	return label + "\n" + msg.Body
+}
+
+func (p *TelegramCannedReplyPayload) Validate() error {
+	p.Title = strings.TrimSpace(p.Title)
+	p.Body = strings.TrimSpace(p.Body)
+	if p.Title == "" {
+		return errors.New("title is required")
+	}
+	if utf8.RuneCountInString(p.Title) > maxCannedTitle {
+		return fmt.Errorf("title is limited to %d characters", maxCannedTitle)
+	}
+	if p.Body == "" {
+		return errors.New("reply text is required")
+	}
+	if utf8.RuneCountInString(p.Body) > maxReplyLength {
+		return fmt.Errorf("reply text is limited to %d characters", maxReplyLength)
+	}
+	return nil
+}
+
+func (s *TelegramService) ListCannedReplies() ([]model.TelegramCannedReply, error) {
+	replies := []model.TelegramCannedReply{}
+	err := database.GetDB().Order("title asc, id asc").Find(&replies).Error
+	return replies, err
+}
+
+func (s *TelegramService) SaveCannedReply(payload *TelegramCannedReplyPayload) (*model.TelegramCannedReply, error) {
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	var reply model.TelegramCannedReply
+	if payload.ID != 0 {
+		if err := db.First(&reply, payload.ID).Error; err != nil {
+			return nil, err
+		}
+	}
+	reply.Title = payload.Title
+	reply.Body = payload.Body
+	if err := db.Save(&reply).Error; err != nil {
+		return nil, err
+	}
+	s.notifyChange()
+	return &reply, nil
+}
+
+func (s *TelegramService) DeleteCannedReply(id uint) error {
+	if id == 0 {
+		return errors.New("id is required")
+	}
+	if err := database.GetDB().Delete(&model.TelegramCannedReply{}, id).Error; err != nil {
+		return err
+	}
+	s.notifyChange()
+	return nil
+}
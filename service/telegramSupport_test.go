@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+)
+
+func TestRecordInboundMessageDeprecatedFields(t *testing.T) {
+	initTestDB(t)
+	service := TelegramService{}
+	tariffID := uint(3)
+	_, err := service.RecordInboundMessage(&TelegramInboundMessage{
+		TelegramID: 42, Message: "hello", EverPaid: true, TariffID: &tariffID, ActiveSubscription: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A later message without them keeps what was recorded
+	if _, err := service.RecordInboundMessage(&TelegramInboundMessage{TelegramID: 42, Message: "again"}); err != nil {
+		t.Fatal(err)
+	}
+	var profile model.TelegramUserProfile
+	if err := database.GetDB().Where("telegram_id = ?", 42).First(&profile).Error; err != nil {
+		t.Fatal(err)
+	}
+	if !profile.EverPaid || profile.LastTariffID == nil || *profile.LastTariffID != tariffID {
+		t.Fatalf("profile after deprecated inputs: everPaid %v, lastTariffId %v", profile.EverPaid, profile.LastTariffID)
+	}
+}
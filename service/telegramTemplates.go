@@ -0,0 +1,409 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+
+	"gorm.io/gorm/clause"
+)
+
+// Built-in texts are English, so "en" is the last language tried.
+const builtinMessageLanguage = "en"
+
+const telegramMessageTimeLayout = "2006-01-02 15:04"
+
+var messageLanguagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{1,8})*$`)
+
+type telegramMessageDefault struct {
+	Key         string
+	Description string
+	Text        string
+}
+
+// telegramMessages lists every templated bot message with its built-in text.
+var telegramMessages = []telegramMessageDefault{
+	{"menu", "Main menu of /start", "Welcome! Choose what you need:"},
+	{"help", "Header of the command list", "Available commands:"},
+	{"command.unknown", "Reply to an unknown command, the command list follows", "Unknown command."},
+	{"error", "Reply when a command or a button fails", "Something went wrong, please try again later."},
+	{"button.unavailable", "Popup for a deleted tariff button", "This button is no longer available."},
+	{"tariffs.empty", "Reply to /tariffs without active tariffs", "No tariffs are available yet."},
+	{"tariff.card", "Card of a tariff in /tariffs", "{{.Tariff.Title}} - {{.Tariff.Price}}{{if .Tariff.Description}}\n{{.Tariff.Description}}{{end}}"},
+	{"subscription.none", "Reply when the user has no subscription", "You have no subscription yet. Use /tariffs to buy one."},
+	{"status", "Reply to /status", "{{if .Active}}{{if .Expiry}}Your subscription is active until {{.Expiry}}.{{else}}Your subscription is active with no end date.{{end}}\n" +
+		"{{if .Volume}}Traffic left: {{.Remaining}} of {{.Volume}}.{{else}}Traffic used: {{.Used}}, no limit.{{end}}" +
+		"{{else if .Expiry}}Your subscription ended on {{.Expiry}}. Use /tariffs to renew it.{{else}}Your subscription is not active. Use /tariffs to renew it.{{end}}"},
+	{"promo.usage", "Reply to /promo without a code", "Usage: /promo CODE"},
+	{"promo.failed", "Reply when a promo code is rejected", "Promo code was not applied: {{.Error}}"},
+	{"promo.applied", "Reply when a promo code is redeemed", "Promo code {{.Code}} applied: " +
+		"{{if .Discount}}{{.Discount}}% discount on your next payment{{end}}{{if and .Discount .Days}}; {{end}}" +
+		"{{if .Days}}{{.Days}} free days{{if .Expiry}}, subscription active until {{.Expiry}}{{end}}{{end}}." +
+		"{{if .Error}} Free days were not added: {{.Error}}.{{end}}"},
+	{"referral.applied", "Reply to an invitation link", "You were invited by {{or .Name \"a friend\"}}. Welcome!"},
+	{"referral.failed", "Reply when an invitation is rejected", "Invitation was not applied: {{.Error}}"},
+	{"referral.disabled", "Reply to /referral when the program is off", "The referral program is not available."},
+	{"referral.link", "Reply to /referral", "Invite friends with your link:\n{{.Link}}\n\nWhen a friend makes their first payment, you get " +
+		"{{if .Days}}{{.Days}} free days{{else}}{{.Discount}}% off your next payment{{end}}."},
+	{"referral.reward", "Notice to the referrer about a reward", "A friend you invited made their first payment. " +
+		"{{if .Days}}You got {{.Days}} free days.{{if .Expiry}} Your subscription is active until {{.Expiry}}.{{end}}{{else}}You got {{.Discount}}% off your next payment.{{end}}"},
+	{"payment.link", "Message with the payment button", "Amount to pay: {{.Amount}}. Access is granted as soon as the payment is confirmed."},
+	{"payment.succeeded", "Purchase confirmation", "Payment received. Your subscription is active{{if .Expiry}} until {{.Expiry}}{{end}}."},
+	{"trial.started", "Reply when the free trial starts", "Your free trial is active until {{.Expiry}}{{if .Volume}} with {{.Volume}} of traffic{{end}}."},
+	{"trial.failed", "Popup when the free trial is refused", "Free trial was not started: {{.Error}}"},
+	{"downloads", "Header of the app download buttons", "Download an app for your device:"},
+	{"downloads.empty", "Reply when no download links are set", "No download links are available yet."},
+	{"support", "Reply to the support button without own text", "Write your question in this chat and our support team will answer here."},
+	{"reminder.expiry", "Reminder before the subscription expires", "Your subscription expires on {{.Expiry}}. Renew it to keep your access."},
+	{"reminder.volume", "Reminder when a traffic threshold is reached", "You have used {{.Percent}}% of your traffic, {{.Remaining}} left. Renew to get a new traffic limit."},
+	{"reminder.expired", "Notice when an expired client is disabled", "Your subscription has expired and your access is disabled."},
+	{"reminder.depleted", "Notice when a client without traffic is disabled", "Your traffic limit is used up and your access is disabled."},
+}
+
+// TelegramMessageData holds the values a message template can use.
+type TelegramMessageData struct {
+	User   TelegramMessageUser
+	Tariff *TelegramMessageTariff
+	Active bool
+	// Expiry is formatted, empty when there is no end date
+	Expiry string
+	// Traffic values are formatted, Volume is empty for unlimited traffic
+	Used      string
+	Remaining string
+	Volume    string
+	Percent   int64
+	Amount    string
+	Code      string
+	Link      string
+	Name      string
+	Error     string
+	Days      int
+	Discount  int
+}
+
+type TelegramMessageUser struct {
+	ID        int64
+	FirstName string
+	LastName  string
+	Username  string
+	Language  string
+}
+
+type TelegramMessageTariff struct {
+	Title       string
+	Description string
+	Price       string
+	Days        int
+	Volume      string
+}
+
+type TelegramMessageField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+var telegramMessageFields = []TelegramMessageField{
+	{"{{.User.FirstName}} {{.User.LastName}} {{.User.Username}} {{.User.ID}}", "Telegram user"},
+	{"{{.Tariff.Title}} {{.Tariff.Price}} {{.Tariff.Days}} {{.Tariff.Volume}}", "Tariff of the message"},
+	{"{{.Expiry}}", "Expiry date, empty without an end date"},
+	{"{{.Used}} {{.Remaining}} {{.Volume}} {{.Percent}}", "Traffic, Volume is empty when unlimited"},
+	{"{{.Amount}}", "Amount to pay"},
+	{"{{.Days}} {{.Discount}} {{.Code}}", "Free days, discount percent and promo code"},
+	{"{{.Link}} {{.Name}}", "Referral link and the inviting user"},
+	{"{{.Error}}", "Reason of a rejection"},
+}
+
+type TelegramMessageTemplatePayload struct {
+	Key      string `json:"key" form:"key"`
+	Language string `json:"language" form:"language"`
+	Text     string `json:"text" form:"text"`
+}
+
+type TelegramMessageOverride struct {
+	ID        uint      `json:"id"`
+	Language  string    `json:"language"`
+	Text      string    `json:"text"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type TelegramMessageTemplateDTO struct {
+	Key         string                    `json:"key"`
+	Description string                    `json:"description"`
+	Default     string                    `json:"default"`
+	Overrides   []TelegramMessageOverride `json:"overrides"`
+}
+
+type TelegramMessageTemplates struct {
+	DefaultLanguage string                       `json:"defaultLanguage"`
+	Messages        []TelegramMessageTemplateDTO `json:"messages"`
+	Fields          []TelegramMessageField       `json:"fields"`
+}
+
+func findTelegramMessage(key string) (*telegramMessageDefault, bool) {
+	for i := range telegramMessages {
+		if telegramMessages[i].Key == key {
+			return &telegramMessages[i], true
+		}
+	}
+	return nil, false
+}
+
+func normalizeMessageLanguage(language string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(language), "_", "-"))
+}
+
+// messageLanguages is the fallback order: the user's language, its base language, the default language and English.
+func messageLanguages(userLanguage string, defaultLanguage string) []string {
+	var languages []string
+	add := func(language string) {
+		language = normalizeMessageLanguage(language)
+		if language == "" {
+			return
+		}
+		for _, existing := range languages {
+			if existing == language {
+				return
+			}
+		}
+		languages = append(languages, language)
+	}
+	add(userLanguage)
+	if base, _, ok := strings.Cut(normalizeMessageLanguage(userLanguage), "-"); ok {
+		add(base)
+	}
+	add(defaultLanguage)
+	add(builtinMessageLanguage)
+	return languages
+}
+
+func executeMessageTemplate(text string, data *TelegramMessageData) (string, error) {
+	tmpl, err := template.New("message").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// RenderMessage renders the bot message key in the language of data.User.
+// Broken overrides are skipped, so users always get at least the built-in text.
+func (s *TelegramService) RenderMessage(key string, data *TelegramMessageData) string {
+	message, ok := findTelegramMessage(key)
+	if !ok {
+		logger.Warningf("telegram message %q is not defined", key)
+		return key
+	}
+	if data == nil {
+		data = &TelegramMessageData{}
+	}
+	defaultLanguage := ""
+	if cfg, err := s.GetConfig(); err == nil {
+		defaultLanguage = cfg.DefaultLanguage
+	}
+	languages := messageLanguages(data.User.Language, defaultLanguage)
+	var overrides []model.TelegramMessageTemplate
+	if err := database.GetDB().Where("`key` = ? AND language IN ?", key, languages).Find(&overrides).Error; err != nil {
+		logger.Warning("telegram message templates: unable to load:", err)
+	}
+	for _, language := range languages {
+		for _, override := range overrides {
+			if override.Language != language {
+				continue
+			}
+			text, err := executeMessageTemplate(override.Text, data)
+			if err == nil && text != "" {
+				return text
+			}
+			logger.Warningf("telegram message %s (%s) failed, falling back: %v", key, language, err)
+		}
+	}
+	text, err := executeMessageTemplate(message.Text, data)
+	if err != nil {
+		logger.Warningf("telegram message %s failed: %v", key, err)
+		return message.Text
+	}
+	return text
+}
+
+// profileMessageData starts the template data of a message to a known user.
+func profileMessageData(profile *model.TelegramUserProfile) *TelegramMessageData {
+	return &TelegramMessageData{User: TelegramMessageUser{
+		ID:        profile.TelegramID,
+		FirstName: profile.FirstName,
+		LastName:  profile.LastName,
+		Username:  profile.Username,
+		Language:  profile.Language,
+	}}
+}
+
+func NewTelegramMessageTariff(tariff *model.TelegramTariff) *TelegramMessageTariff {
+	result := &TelegramMessageTariff{
+		Title:       tariff.Title,
+		Description: tariff.Description,
+		Price:       formatTelegramPrice(tariff.PriceMinor, tariff.Currency),
+		Days:        tariff.DurationDays,
+	}
+	if tariff.Volume > 0 {
+		result.Volume = formatTelegramBytes(tariff.Volume)
+	}
+	return result
+}
+
+func formatTelegramPrice(minor int64, currency string) string {
+	return fmt.Sprintf("%d.%02d %s", minor/100, minor%100, currency)
+}
+
+func formatTelegramBytes(n int64) string {
+	const gb = 1 << 30
+	if n >= gb {
+		return fmt.Sprintf("%.2f GB", float64(n)/gb)
+	}
+	return fmt.Sprintf("%.2f MB", float64(n)/(1<<20))
+}
+
+func formatTelegramTime(t *time.Time) string {
+	if t == nil || t.IsZero() {
+		return ""
+	}
+	return t.Format(telegramMessageTimeLayout)
+}
+
+// sampleMessageData fills every field, so a template is checked against all of them.
+func sampleMessageData(language string) *TelegramMessageData {
+	expiry := time.Now().AddDate(0, 1, 0)
+	return &TelegramMessageData{
+		User:      TelegramMessageUser{ID: 123456789, FirstName: "Alex", LastName: "Smith", Username: "alex", Language: language},
+		Tariff:    &TelegramMessageTariff{Title: "Month", Description: "30 days, 50 GB", Price: formatTelegramPrice(19900, "RUB"), Days: 30, Volume: formatTelegramBytes(50 << 30)},
+		Active:    true,
+		Expiry:    formatTelegramTime(&expiry),
+		Used:      formatTelegramBytes(10 << 30),
+		Remaining: formatTelegramBytes(40 << 30),
+		Volume:    formatTelegramBytes(50 << 30),
+		Percent:   20,
+		Amount:    formatTelegramPrice(19900, "RUB"),
+		Code:      "SPRING",
+		Link:      "https://t.me/bot?start=" + ReferralStartPrefix + "Ab3dE6gH",
+		Name:      "Sam",
+		Error:     "the code has expired",
+		Days:      7,
+		Discount:  10,
+	}
+}
+
+func (p *TelegramMessageTemplatePayload) Validate() error {
+	p.Key = strings.TrimSpace(p.Key)
+	p.Language = normalizeMessageLanguage(p.Language)
+	if _, ok := findTelegramMessage(p.Key); !ok {
+		return fmt.Errorf("unknown message %q", p.Key)
+	}
+	if !messageLanguagePattern.MatchString(p.Language) {
+		return errors.New("language must be a code like en, ru or pt-br")
+	}
+	if strings.TrimSpace(p.Text) == "" {
+		return errors.New("template text is required")
+	}
+	_, err := p.Preview()
+	return err
+}
+
+// Preview renders the template with sample values.
+func (p *TelegramMessageTemplatePayload) Preview() (string, error) {
+	text, err := executeMessageTemplate(p.Text, sampleMessageData(p.Language))
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	if text == "" {
+		return "", errors.New("template renders an empty message")
+	}
+	return text, nil
+}
+
+// ListMessageTemplates returns every bot message with its built-in text and overrides.
+func (s *TelegramService) ListMessageTemplates() (*TelegramMessageTemplates, error) {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	var overrides []model.TelegramMessageTemplate
+	if err := database.GetDB().Order("language asc").Find(&overrides).Error; err != nil {
+		return nil, err
+	}
+	byKey := make(map[string][]TelegramMessageOverride)
+	for _, override := range overrides {
+		byKey[override.Key] = append(byKey[override.Key], TelegramMessageOverride{
+			ID:        override.ID,
+			Language:  override.Language,
+			Text:      override.Text,
+			UpdatedAt: override.UpdatedAt,
+		})
+	}
+	result := &TelegramMessageTemplates{
+		DefaultLanguage: cfg.DefaultLanguage,
+		Messages:        make([]TelegramMessageTemplateDTO, 0, len(telegramMessages)),
+		Fields:          telegramMessageFields,
+	}
+	for _, message := range telegramMessages {
+		dto := TelegramMessageTemplateDTO{
+			Key:         message.Key,
+			Description: message.Description,
+			Default:     message.Text,
+			Overrides:   byKey[message.Key],
+		}
+		if dto.Overrides == nil {
+			dto.Overrides = []TelegramMessageOverride{}
+		}
+		result.Messages = append(result.Messages, dto)
+	}
+	return result, nil
+}
+
+// SaveMessageTemplate creates or replaces the text of a message in one language.
+func (s *TelegramService) SaveMessageTemplate(payload *TelegramMessageTemplatePayload) (*model.TelegramMessageTemplate, error) {
+	if payload == nil {
+		return nil, errors.New("payload is required")
+	}
+	if err := payload.Validate(); err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	tmpl := model.TelegramMessageTemplate{Key: payload.Key, Language: payload.Language, Text: payload.Text}
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}, {Name: "language"}},
+		DoUpdates: clause.AssignmentColumns([]string{"text", "updated_at"}),
+	}).Create(&tmpl).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Where("`key` = ? AND language = ?", tmpl.Key, tmpl.Language).First(&tmpl).Error; err != nil {
+		return nil, err
+	}
+	return &tmpl, nil
+}
+
+func (s *TelegramService) DeleteMessageTemplate(id uint) error {
+	if id == 0 {
+		return errors.New("template id is required")
+	}
+	return database.GetDB().Delete(&model.TelegramMessageTemplate{}, id).Error
+}
+
+// PreviewMessageTemplate renders an unsaved template with sample values.
+func (s *TelegramService) PreviewMessageTemplate(payload *TelegramMessageTemplatePayload) (string, error) {
+	if payload == nil {
+		return "", errors.New("payload is required")
+	}
+	if err := payload.Validate(); err != nil {
+		return "", err
+	}
+	return payload.Preview()
+}
@@ -0,0 +1,201 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+
+	"gorm.io/gorm"
+)
+
+var ErrTrialDisabled = errors.New("free trial is not available")
+
+type TelegramTrialResult struct {
+	ClientName string
+	ExpiresAt  time.Time
+	Volume     int64
+}
+
+// telegramIDDates maps known Telegram user ids to the approximate month they were issued.
+// Ids grow with registrations, so the account creation date can be estimated in between.
+var telegramIDDates = []struct {
+	id   int64
+	date time.Time
+}{
+	{1_000_000, time.Date(2013, 8, 1, 0, 0, 0, 0, time.UTC)},
+	{100_000_000, time.Date(2015, 4, 1, 0, 0, 0, 0, time.UTC)},
+	{300_000_000, time.Date(2016, 12, 1, 0, 0, 0, 0, time.UTC)},
+	{500_000_000, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{1_000_000_000, time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)},
+	{1_500_000_000, time.Date(2020, 12, 1, 0, 0, 0, 0, time.UTC)},
+	{2_000_000_000, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)},
+	{5_000_000_000, time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)},
+	{6_000_000_000, time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC)},
+	{7_000_000_000, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+	{8_000_000_000, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+}
+
+// estimateTelegramAccountCreated interpolates the creation date of an account from its id.
+// Ids past the last known point are extrapolated with the latest growth rate.
+func estimateTelegramAccountCreated(telegramID int64, now time.Time) time.Time {
+	points := telegramIDDates
+	if telegramID <= points[0].id {
+		return points[0].date
+	}
+	for i := 1; i < len(points); i++ {
+		if telegramID <= points[i].id || i == len(points)-1 {
+			prev, next := points[i-1], points[i]
+			ratio := float64(telegramID-prev.id) / float64(next.id-prev.id)
+			created := prev.date.Add(time.Duration(ratio * float64(next.date.Sub(prev.date))))
+			if created.After(now) {
+				return now
+			}
+			return created
+		}
+	}
+	return now
+}
+
+// checkTrialAllowed tells why the user cannot claim the trial, or nil when they can.
+func (s *TelegramService) checkTrialAllowed(db *gorm.DB, cfg *model.TelegramBotConfig, profile *model.TelegramUserProfile) error {
+	if !cfg.TrialEnabled || len(cfg.GetTrialInbounds()) == 0 {
+		return ErrTrialDisabled
+	}
+	var claimed int64
+	if err := db.Model(&model.TelegramTrial{}).Where("telegram_id = ?", profile.TelegramID).Count(&claimed).Error; err != nil {
+		return err
+	}
+	if claimed > 0 {
+		return errors.New("you have already used your free trial")
+	}
+	linked, err := s.linkedClients(db, profile.ID)
+	if err != nil {
+		return err
+	}
+	if profile.EverPaid || len(linked) > 0 {
+		return errors.New("the free trial is only for new users")
+	}
+	if cfg.TrialMinAccountAge > 0 {
+		now := time.Now()
+		created := estimateTelegramAccountCreated(profile.TelegramID, now)
+		if now.Sub(created) < time.Duration(cfg.TrialMinAccountAge)*24*time.Hour {
+			return errors.New("your Telegram account is too new for the free trial")
+		}
+	}
+	return nil
+}
+
+// TrialAvailable reports whether the user may claim the free trial.
+func (s *TelegramService) TrialAvailable(telegramID int64) bool {
+	cfg, err := s.GetConfig()
+	if err != nil || !cfg.TrialEnabled {
+		return false
+	}
+	db := database.GetDB()
+	var profile model.TelegramUserProfile
+	if err := db.Where("telegram_id = ?", telegramID).First(&profile).Error; err != nil {
+		// Users get a profile on their first message, a missing one has nothing claimed yet
+		profile = model.TelegramUserProfile{TelegramID: telegramID}
+	}
+	return s.checkTrialAllowed(db, cfg, &profile) == nil
+}
+
+// ClaimTrial creates a trial client from the configured template, once per Telegram ID.
+func (s *TelegramService) ClaimTrial(telegramID int64) (*TelegramTrialResult, error) {
+	telegramPaymentMu.Lock()
+	defer telegramPaymentMu.Unlock()
+
+	profile, err := s.EnsureUserProfile(telegramID)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return nil, err
+	}
+	db := database.GetDB()
+	if err := s.checkTrialAllowed(db, cfg, profile); err != nil {
+		return nil, err
+	}
+	// Reserved first, the unique telegram id stops a second claim even if provisioning races
+	trial := model.TelegramTrial{TelegramID: telegramID, UserID: profile.ID}
+	if err := db.Create(&trial).Error; err != nil {
+		return nil, errors.New("you have already used your free trial")
+	}
+
+	name := telegramClientName(telegramID)
+	expiresAt := time.Now().AddDate(0, 0, cfg.TrialDays)
+	client := model.Client{
+		Name:   name,
+		Enable: true,
+		Links:  json.RawMessage("[]"),
+		Group:  "telegram",
+		Volume: cfg.TrialVolume,
+		Expiry: expiresAt.Unix(),
+		Desc:   fmt.Sprintf("Telegram %d trial", telegramID),
+	}
+	if profile.Username != "" {
+		client.Desc += " @" + profile.Username
+	}
+	provision := func() error {
+		var existing int64
+		if err := db.Model(&model.Client{}).Where("name = ?", name).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing > 0 {
+			return fmt.Errorf("client %s already exists", name)
+		}
+		var err error
+		client.Config, err = newClientConfig(name)
+		if err != nil {
+			return err
+		}
+		client.Inbounds, err = json.Marshal(cfg.GetTrialInbounds())
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(client)
+		if err != nil {
+			return err
+		}
+		configService := ConfigService{}
+		if _, err := configService.Save("clients", "new", data, "", telegramActor, telegramHostname(cfg)); err != nil {
+			return err
+		}
+		if err := db.Where("name = ?", name).First(&client).Error; err != nil {
+			return err
+		}
+		return db.Create(&model.TelegramUserClient{UserID: profile.ID, ClientID: client.Id}).Error
+	}
+	if err := provision(); err != nil {
+		// Released, so the user can try again after the problem is fixed
+		db.Delete(&trial)
+		logger.Warning("telegram trial: unable to create client:", err)
+		return nil, errors.New("unable to start the free trial, please contact support")
+	}
+	if err := db.Model(&trial).Updates(map[string]interface{}{
+		"client_id":  client.Id,
+		"expires_at": &expiresAt,
+	}).Error; err != nil {
+		return nil, err
+	}
+	logger.Infof("telegram trial started for %d with client %s", telegramID, name)
+	s.notifyChange()
+	return &TelegramTrialResult{
+		ClientName: name,
+		ExpiresAt:  expiresAt,
+		Volume:     cfg.TrialVolume,
+	}, nil
+}
+
+// ListTrials returns the claimed trials, newest first.
+func (s *TelegramService) ListTrials() ([]model.TelegramTrial, error) {
+	var trials []model.TelegramTrial
+	err := database.GetDB().Order("id desc").Find(&trials).Error
+	return trials, err
+}
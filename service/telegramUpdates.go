@@ -0,0 +1,190 @@
+package service
+
+import (
+	"crypto/subtle"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alireza0/s-ui/database"
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+const TelegramWebhookPath = "telegram/webhook"
+
+var (
+	telegramUpdateHandlersMu sync.RWMutex
+	telegramUpdateHandlers   []func(update *botapi.Update)
+)
+
+func (s *TelegramService) RegisterUpdateHandler(fn func(update *botapi.Update)) {
+	if fn == nil {
+		return
+	}
+	telegramUpdateHandlersMu.Lock()
+	defer telegramUpdateHandlersMu.Unlock()
+	telegramUpdateHandlers = append(telegramUpdateHandlers, fn)
+}
+
+// HandleUpdate stores what users write to the bot and hands the update to the bot handlers.
+func (s *TelegramService) HandleUpdate(update *botapi.Update) error {
+	if update == nil {
+		return errors.New("update is required")
+	}
+	var err error
+	if msg := update.Message; msg != nil && msg.From != nil && msg.Chat.Type == "private" {
+		text := msg.Text
+		if text == "" {
+			text = msg.Caption
+		}
+		attachmentType, fileID, fileName := attachmentOf(msg)
+		if strings.TrimSpace(text) != "" || attachmentType != "" {
+			_, err = s.RecordInboundMessage(&TelegramInboundMessage{
+				TelegramID:       msg.From.ID,
+				Username:         msg.From.Username,
+				FirstName:        msg.From.FirstName,
+				LastName:         msg.From.LastName,
+				Language:         msg.From.LanguageCode,
+				Message:          text,
+				MessageID:        strconv.FormatInt(msg.MessageID, 10),
+				AttachmentType:   attachmentType,
+				AttachmentFileID: fileID,
+				AttachmentName:   fileName,
+			})
+		}
+	}
+
+	telegramUpdateHandlersMu.RLock()
+	handlers := make([]func(update *botapi.Update), len(telegramUpdateHandlers))
+	copy(handlers, telegramUpdateHandlers)
+	telegramUpdateHandlersMu.RUnlock()
+	for _, fn := range handlers {
+		fn(update)
+	}
+	return err
+}
+
+func (s *TelegramService) VerifyWebhookSecret(token string) bool {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		logger.Warning("telegram webhook: unable to load config:", err)
+		return false
+	}
+	if !cfg.Enabled || cfg.WebhookSecret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.WebhookSecret)) == 1
+}
+
+func (s *TelegramService) webhookURL(cfg *model.TelegramBotConfig) (string, error) {
+	base := strings.TrimRight(strings.TrimSpace(cfg.WebhookDomain), "/")
+	if base == "" {
+		return "", errors.New("webhook domain is empty")
+	}
+	if !strings.Contains(base, "://") {
+		base = "https://" + base
+	}
+	settingService := SettingService{}
+	webPath, err := settingService.GetWebPath()
+	if err != nil {
+		return "", err
+	}
+	return base + webPath + TelegramWebhookPath, nil
+}
+
+func webhookActive(cfg *model.TelegramBotConfig) bool {
+	return cfg != nil && cfg.Enabled && cfg.BotToken != "" && cfg.WebhookDomain != ""
+}
+
+// syncWebhook registers or removes the Telegram webhook after a configuration change.
+func (s *TelegramService) syncWebhook(previous *model.TelegramBotConfig, cfg *model.TelegramBotConfig) error {
+	wasActive := webhookActive(previous)
+	active := webhookActive(cfg)
+	tokenChanged := previous.BotToken != cfg.BotToken || previous.APIBaseURL != cfg.APIBaseURL
+
+	if wasActive && (!active || tokenChanged) {
+		if err := s.deleteWebhook(previous); err != nil {
+			return err
+		}
+	}
+	if active && (!wasActive || tokenChanged ||
+		previous.WebhookDomain != cfg.WebhookDomain ||
+		previous.WebhookSecret != cfg.WebhookSecret) {
+		return s.setWebhook(cfg)
+	}
+	return nil
+}
+
+func (s *TelegramService) setWebhook(cfg *model.TelegramBotConfig) error {
+	url, err := s.webhookURL(cfg)
+	if err != nil {
+		return err
+	}
+	api, err := newTelegramAPI(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := telegramContext()
+	defer cancel()
+	err = api.SetWebhook(ctx, &botapi.SetWebhookParams{
+		URL:            url,
+		SecretToken:    cfg.WebhookSecret,
+		AllowedUpdates: botapi.AllowedUpdates,
+	})
+	if err != nil {
+		return err
+	}
+	logger.Info("telegram webhook set to", url)
+	return nil
+}
+
+func (s *TelegramService) deleteWebhook(cfg *model.TelegramBotConfig) error {
+	api, err := newTelegramAPI(cfg)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := telegramContext()
+	defer cancel()
+	if err := api.DeleteWebhook(ctx, &botapi.DeleteWebhookParams{}); err != nil {
+		return err
+	}
+	logger.Info("telegram webhook removed")
+	return nil
+}
+
+// EnsureWebhook registers the webhook when the bot runs in webhook mode.
+func (s *TelegramService) EnsureWebhook() error {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return err
+	}
+	if !webhookActive(cfg) {
+		return nil
+	}
+	return s.setWebhook(cfg)
+}
+
+// DeleteWebhook removes any registered webhook, getUpdates is refused while one exists.
+func (s *TelegramService) DeleteWebhook() error {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return err
+	}
+	return s.deleteWebhook(cfg)
+}
+
+func (s *TelegramService) GetUpdateOffset() (int64, error) {
+	cfg, err := s.GetConfig()
+	if err != nil {
+		return 0, err
+	}
+	return cfg.UpdateOffset, nil
+}
+
+func (s *TelegramService) SaveUpdateOffset(offset int64) error {
+	db := database.GetDB()
+	return db.Model(&model.TelegramBotConfig{}).Where("id = ?", 1).Update("update_offset", offset).Error
+}
@@ -64,6 +64,7 @@ func (s *UserService) CheckUser(username string, password string, remoteIP strin
 		First(user).
 		Error
 	if database.IsNotFound(err) {
+		SharedTelegramService().NotifyFailedLogin(username, remoteIP)
 		return nil
 	} else if err != nil {
 		logger.Warning("check user err:", err, " IP: ", remoteIP)
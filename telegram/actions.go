@@ -0,0 +1,156 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+func init() {
+	buy := Action{Handle: buyAction}
+	link := Action{Button: urlButton}
+	RegisterAction("buy", buy)
+	RegisterAction("purchase", buy)
+	RegisterAction("url", link)
+	RegisterAction("link", link)
+	RegisterAction("miniapp", Action{Button: miniAppButton})
+	RegisterAction("download", Action{Handle: downloadAction})
+	RegisterAction("support", Action{Handle: supportAction})
+	RegisterAction("mysub", Action{Handle: func(c *Context, _ string) error { return sendStatus(c) }})
+	RegisterAction("sub", Action{Handle: func(c *Context, _ string) error { return sendSubscription(c) }})
+	RegisterAction("tariffs", Action{Handle: func(c *Context, _ string) error { return sendTariffs(c) }})
+	RegisterAction("trial", Action{Button: trialButton, Handle: trialAction})
+}
+
+// buyAction starts a payment for the tariff in the payload, or for the tariff the button belongs to.
+func buyAction(c *Context, payload string) error {
+	var tariffID uint
+	if payload = strings.TrimSpace(payload); payload != "" {
+		id, err := strconv.ParseUint(payload, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid tariff id %q", payload)
+		}
+		tariffID = uint(id)
+	} else if c.Tariff != nil {
+		tariffID = c.Tariff.ID
+	} else {
+		return errors.New("buy needs a tariff")
+	}
+	payment, err := c.Bot.service.CreatePayment(c.From.ID, tariffID)
+	if err != nil {
+		return err
+	}
+	if payment.AmountMinor == 0 {
+		// Fully covered by a promo code, the success notice is sent by the service
+		return nil
+	}
+	if payment.ConfirmationURL == "" {
+		return fmt.Errorf("payment %d has no confirmation url", payment.ID)
+	}
+	c.Send(&botapi.SendMessageParams{
+		Text: c.Text("payment.link", &service.TelegramMessageData{Amount: formatPrice(payment.AmountMinor, payment.Currency)}),
+		ReplyMarkup: &botapi.InlineKeyboardMarkup{InlineKeyboard: [][]botapi.InlineKeyboardButton{{
+			{Text: "Pay", URL: payment.ConfirmationURL},
+		}}},
+	})
+	return nil
+}
+
+// trialButton is shown only to users who can still claim the free trial.
+func trialButton(c *Context, label string, _ string) (botapi.InlineKeyboardButton, bool) {
+	if !c.Bot.service.TrialAvailable(c.From.ID) {
+		return botapi.InlineKeyboardButton{}, false
+	}
+	return botapi.InlineKeyboardButton{Text: label, CallbackData: "trial:"}, true
+}
+
+func trialAction(c *Context, _ string) error {
+	trial, err := c.Bot.service.ClaimTrial(c.From.ID)
+	if err != nil {
+		c.Notify(c.Text("trial.failed", &service.TelegramMessageData{Error: err.Error()}))
+		return nil
+	}
+	data := &service.TelegramMessageData{Active: true, Expiry: formatTime(&trial.ExpiresAt)}
+	if trial.Volume > 0 {
+		data.Volume = formatBytes(trial.Volume)
+	}
+	c.Send(&botapi.SendMessageParams{
+		Text: c.Text("trial.started", data),
+		ReplyMarkup: &botapi.InlineKeyboardMarkup{InlineKeyboard: [][]botapi.InlineKeyboardButton{{
+			{Text: "Get links and QR code", CallbackData: "sub:"},
+		}}},
+	})
+	return nil
+}
+
+func urlButton(_ *Context, label string, payload string) (botapi.InlineKeyboardButton, bool) {
+	payload = strings.TrimSpace(payload)
+	if payload == "" {
+		return botapi.InlineKeyboardButton{}, false
+	}
+	return botapi.InlineKeyboardButton{Text: label, URL: payload}, true
+}
+
+// miniAppButton opens the payload URL as a mini app, falling back to the configured mini app.
+func miniAppButton(c *Context, label string, payload string) (botapi.InlineKeyboardButton, bool) {
+	url := strings.TrimSpace(payload)
+	if url == "" {
+		cfg, err := c.Bot.service.GetConfig()
+		if err != nil {
+			return botapi.InlineKeyboardButton{}, false
+		}
+		url = cfg.MiniAppURL
+	}
+	if !strings.HasPrefix(url, "https://") {
+		// Telegram accepts only https mini apps
+		return botapi.InlineKeyboardButton{}, false
+	}
+	return botapi.InlineKeyboardButton{Text: label, WebApp: &botapi.WebAppInfo{URL: url}}, true
+}
+
+// downloadAction lists the configured client apps, the payload narrows it down to one platform.
+func downloadAction(c *Context, payload string) error {
+	cfg, err := c.Bot.service.GetConfig()
+	if err != nil {
+		return err
+	}
+	links := cfg.GetDownloadLinks()
+	platforms := make([]string, 0, len(links))
+	for platform, link := range links {
+		if link == "" {
+			continue
+		}
+		if payload != "" && !strings.EqualFold(platform, strings.TrimSpace(payload)) {
+			continue
+		}
+		platforms = append(platforms, platform)
+	}
+	if len(platforms) == 0 {
+		c.Reply(c.Text("downloads.empty", nil))
+		return nil
+	}
+	sort.Strings(platforms)
+	keyboard := make([][]botapi.InlineKeyboardButton, 0, len(platforms))
+	for _, platform := range platforms {
+		keyboard = append(keyboard, []botapi.InlineKeyboardButton{{Text: platform, URL: links[platform]}})
+	}
+	c.Send(&botapi.SendMessageParams{
+		Text:        c.Text("downloads", nil),
+		ReplyMarkup: &botapi.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+	return nil
+}
+
+func supportAction(c *Context, payload string) error {
+	text := strings.TrimSpace(payload)
+	if text == "" {
+		text = c.Text("support", nil)
+	}
+	c.Reply(text)
+	return nil
+}
@@ -0,0 +1,182 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/service"
+)
+
+// adminUsage lists the admin commands for /admin, they have no description so /help does not show them to users
+var adminUsage []string
+
+func registerAdminCommand(name string, usage string, handle CommandHandler) {
+	adminUsage = append(adminUsage, usage)
+	RegisterCommand(name, "", adminOnly(handle))
+}
+
+func init() {
+	registerAdminCommand("admin", "/admin - this list", sendAdminHelp)
+	registerAdminCommand("client", "/client <name> - usage and links", sendAdminClient)
+	registerAdminCommand("add", "/add <name> <days> <GB> <inbound ids> - new client, 0 days or GB for no limit", addAdminClient)
+	registerAdminCommand("extend", "/extend <name> <days> - extend the expiry", extendAdminClient)
+	registerAdminCommand("reset", "/reset <name> - reset the traffic", resetAdminClient)
+	registerAdminCommand("disable", "/disable <name> - disable a client", setAdminClientEnabled(false))
+	registerAdminCommand("enable", "/enable <name> - enable a client", setAdminClientEnabled(true))
+	registerAdminCommand("restart_core", "/restart_core - restart sing-box", restartAdminCore)
+}
+
+// adminOnly answers other users as if the command did not exist.
+func adminOnly(handle CommandHandler) CommandHandler {
+	return func(c *Context) error {
+		if !c.Bot.service.IsBotAdmin(c.From.ID) {
+			c.Reply(c.Text("command.unknown", nil) + "\n" + helpText(c))
+			return nil
+		}
+		return handle(c)
+	}
+}
+
+func adminActor(c *Context) string {
+	return service.TelegramAdminActor(&c.From)
+}
+
+func sendAdminHelp(c *Context) error {
+	c.Reply(strings.Join(adminUsage, "\n"))
+	return nil
+}
+
+func adminClientText(client *service.TelegramClientAccess) string {
+	state := "enabled"
+	if !client.Enable {
+		state = "disabled"
+	}
+	used := formatBytes(client.Up + client.Down)
+	if client.Volume > 0 {
+		used += " of " + formatBytes(client.Volume)
+	}
+	expiry := "never"
+	if client.Expiry > 0 {
+		t := time.Unix(client.Expiry, 0)
+		expiry = formatTime(&t)
+	}
+	lines := []string{
+		fmt.Sprintf("%s (%s)", client.Name, state),
+		fmt.Sprintf("Traffic: %s (↑ %s ↓ %s)", used, formatBytes(client.Up), formatBytes(client.Down)),
+		"Expires: " + expiry,
+		"Subscription: " + client.SubURL,
+	}
+	lines = append(lines, client.Links...)
+	return strings.Join(lines, "\n")
+}
+
+// replyAdminClient shows the client after a command, or why the command failed.
+func replyAdminClient(c *Context, client *service.TelegramClientAccess, err error) error {
+	if err != nil {
+		c.Reply("Failed: " + err.Error())
+		return nil
+	}
+	c.Reply(adminClientText(client))
+	return nil
+}
+
+// adminArgs returns the command arguments when there are at least min of them.
+func adminArgs(c *Context, min int, usage string) ([]string, bool) {
+	args := strings.Fields(c.Args)
+	if len(args) < min {
+		c.Reply("Usage: " + usage)
+		return nil, false
+	}
+	return args, true
+}
+
+func sendAdminClient(c *Context) error {
+	args, ok := adminArgs(c, 1, "/client <name>")
+	if !ok {
+		return nil
+	}
+	client, err := c.Bot.service.AdminGetClient(args[0])
+	return replyAdminClient(c, client, err)
+}
+
+func addAdminClient(c *Context) error {
+	usage := "/add <name> <days> <GB> <inbound ids>, e.g. /add alice 30 50 1,2"
+	args, ok := adminArgs(c, 4, usage)
+	if !ok {
+		return nil
+	}
+	days, err := strconv.Atoi(args[1])
+	if err != nil {
+		c.Reply("Usage: " + usage)
+		return nil
+	}
+	volume, err := strconv.ParseFloat(args[2], 64)
+	if err != nil {
+		c.Reply("Usage: " + usage)
+		return nil
+	}
+	payload := &service.TelegramAdminClientPayload{
+		Name:   args[0],
+		Days:   days,
+		Volume: int64(volume * (1 << 30)),
+	}
+	for _, part := range strings.FieldsFunc(strings.Join(args[3:], ","), func(r rune) bool { return r == ',' }) {
+		id, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			c.Reply("Usage: " + usage)
+			return nil
+		}
+		payload.Inbounds = append(payload.Inbounds, uint(id))
+	}
+	client, err := c.Bot.service.AdminAddClient(adminActor(c), payload)
+	return replyAdminClient(c, client, err)
+}
+
+func extendAdminClient(c *Context) error {
+	args, ok := adminArgs(c, 2, "/extend <name> <days>")
+	if !ok {
+		return nil
+	}
+	days, err := strconv.Atoi(args[1])
+	if err != nil {
+		c.Reply("Usage: /extend <name> <days>")
+		return nil
+	}
+	client, err := c.Bot.service.AdminExtendClient(adminActor(c), args[0], days)
+	return replyAdminClient(c, client, err)
+}
+
+func resetAdminClient(c *Context) error {
+	args, ok := adminArgs(c, 1, "/reset <name>")
+	if !ok {
+		return nil
+	}
+	client, err := c.Bot.service.AdminResetClient(adminActor(c), args[0])
+	return replyAdminClient(c, client, err)
+}
+
+func setAdminClientEnabled(enable bool) CommandHandler {
+	usage := "/disable <name>"
+	if enable {
+		usage = "/enable <name>"
+	}
+	return func(c *Context) error {
+		args, ok := adminArgs(c, 1, usage)
+		if !ok {
+			return nil
+		}
+		client, err := c.Bot.service.AdminSetClientEnabled(adminActor(c), args[0], enable)
+		return replyAdminClient(c, client, err)
+	}
+}
+
+func restartAdminCore(c *Context) error {
+	if err := c.Bot.service.AdminRestartCore(adminActor(c)); err != nil {
+		c.Reply("Failed to restart sing-box: " + err.Error())
+		return nil
+	}
+	c.Reply("sing-box restarted.")
+	return nil
+}
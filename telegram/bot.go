@@ -15,6 +15,8 @@ type Bot struct {
 	cancel  context.CancelFunc
 	running bool
 	refresh chan struct{}
+	poller  *poller
+	mode    string
 }
 
 func NewBot(s *service.TelegramService) *Bot {
@@ -26,6 +28,7 @@ func NewBot(s *service.TelegramService) *Bot {
 		refresh: make(chan struct{}, 1),
 	}
 	s.RegisterChangeListener(bot.TriggerRefresh)
+	s.RegisterUpdateHandler(bot.HandleUpdate)
 	return bot
 }
 
@@ -39,33 +42,36 @@ func (b *Bot) Start() error {
 	b.cancel = cancel
 	b.running = true
 	go b.loop(ctx)
+	b.service.StartBroadcastQueue()
 	logger.Info("telegram bot background worker started")
 	return nil
 }
 
 func (b *Bot) loop(ctx context.Context) {
 	defer func() {
+		b.stopPolling()
 		b.mu.Lock()
 		b.running = false
+		b.mode = ""
 		b.mu.Unlock()
 	}()
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
-	b.sync()
+	b.sync(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Info("telegram bot background worker stopped")
 			return
 		case <-ticker.C:
-			b.sync()
+			b.sync(ctx)
 		case <-b.refresh:
-			b.sync()
+			b.sync(ctx)
 		}
 	}
 }
 
-func (b *Bot) sync() {
+func (b *Bot) sync(ctx context.Context) {
 	state, err := b.service.GetAdminState()
 	if err != nil {
 		logger.Warning("telegram bot state refresh failed:", err)
@@ -76,12 +82,50 @@ func (b *Bot) sync() {
 		return
 	}
 	if !state.Config.Enabled {
+		b.setMode(ctx, "", "")
 		logger.Debug("telegram bot is disabled; skipping synchronization")
 		return
 	}
+	cfg, err := b.service.GetConfig()
+	if err != nil {
+		logger.Warning("telegram bot state refresh failed:", err)
+		return
+	}
+	switch {
+	case cfg.BotToken == "":
+		b.setMode(ctx, "", "")
+	case cfg.WebhookDomain == "":
+		// Without a webhook domain Telegram cannot reach the panel, so updates are pulled
+		b.setMode(ctx, "polling", cfg.APIBaseURL+"|"+cfg.BotToken)
+	default:
+		b.setMode(ctx, "webhook", "")
+	}
 	logger.Debugf("telegram bot synchronized with %d tariffs", len(state.Tariffs))
 }
 
+func (b *Bot) setMode(ctx context.Context, mode string, key string) {
+	b.mu.Lock()
+	previous := b.mode
+	b.mode = mode
+	b.mu.Unlock()
+	switch mode {
+	case "polling":
+		b.startPolling(ctx, key)
+	case "webhook":
+		b.stopPolling()
+		if previous != mode {
+			if err := b.service.EnsureWebhook(); err != nil {
+				logger.Warning("telegram bot: unable to register webhook:", err)
+			}
+		}
+	default:
+		b.stopPolling()
+	}
+	if previous != mode && mode != "" {
+		logger.Infof("telegram bot receives updates via %s", mode)
+	}
+}
+
 func (b *Bot) Stop() {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -89,6 +133,7 @@ func (b *Bot) Stop() {
 		b.cancel()
 		b.cancel = nil
 	}
+	b.service.StopBroadcastQueue()
 }
 
 func (b *Bot) TriggerRefresh() {
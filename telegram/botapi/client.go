@@ -0,0 +1,355 @@
+package botapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultBaseURL = "https://api.telegram.org"
+
+// API is the subset of the Telegram Bot API used by the panel. It is an
+// interface so that tests and alternative transports can replace the HTTP client.
+type API interface {
+	GetMe(ctx context.Context) (*User, error)
+	SendMessage(ctx context.Context, params *SendMessageParams) (*Message, error)
+	SendPhoto(ctx context.Context, params *SendPhotoParams) (*Message, error)
+	SendDocument(ctx context.Context, params *SendDocumentParams) (*Message, error)
+	EditMessageText(ctx context.Context, params *EditMessageTextParams) (*Message, error)
+	EditMessageCaption(ctx context.Context, params *EditMessageCaptionParams) (*Message, error)
+	EditMessageMedia(ctx context.Context, params *EditMessageMediaParams) (*Message, error)
+	AnswerCallbackQuery(ctx context.Context, params *AnswerCallbackQueryParams) error
+	AnswerPreCheckoutQuery(ctx context.Context, params *AnswerPreCheckoutQueryParams) error
+	SetWebhook(ctx context.Context, params *SetWebhookParams) error
+	DeleteWebhook(ctx context.Context, params *DeleteWebhookParams) error
+	GetUpdates(ctx context.Context, params *GetUpdatesParams) ([]Update, error)
+	GetFile(ctx context.Context, params *GetFileParams) (*File, error)
+	DownloadFile(ctx context.Context, filePath string, w io.Writer) error
+}
+
+// AllowedUpdates lists the update kinds the bot subscribes to.
+var AllowedUpdates = []string{"message", "callback_query", "pre_checkout_query"}
+
+type Client struct {
+	token      string
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewClient(token string, baseURL string) *Client {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		token:   token,
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			// Must stay above the long polling timeout of getUpdates
+			Timeout: 60 * time.Second,
+		},
+	}
+}
+
+// Error is returned when Telegram answers with ok=false.
+type Error struct {
+	Method      string
+	Code        int
+	Description string
+	RetryAfter  int
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("telegram %s failed (%d): %s", e.Method, e.Code, e.Description)
+}
+
+// IsNotModified reports whether err is the harmless "message is not modified" answer
+// Telegram gives when an edit does not change the message.
+func IsNotModified(err error) bool {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusBadRequest && strings.Contains(apiErr.Description, "message is not modified")
+	}
+	return false
+}
+
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	if c.token == "" {
+		return errors.New("telegram bot token is empty")
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return c.do(req, method, result)
+}
+
+// callWithFile sends params as multipart form data when file has content to upload,
+// otherwise the file is referenced by its file_id or URL in a regular call.
+func (c *Client) callWithFile(ctx context.Context, method string, params interface{}, field string, file *InputFile, result interface{}) error {
+	if c.token == "" {
+		return errors.New("telegram bot token is empty")
+	}
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return err
+	}
+	if file == nil || file.Data == nil {
+		if file == nil || file.FileID == "" {
+			return fmt.Errorf("telegram %s: %s is required", method, field)
+		}
+		fields[field], _ = json.Marshal(file.FileID)
+		return c.Call(ctx, method, fields, result)
+	}
+
+	var buf bytes.Buffer
+	form := multipart.NewWriter(&buf)
+	for name, raw := range fields {
+		value := string(raw)
+		var str string
+		if json.Unmarshal(raw, &str) == nil {
+			value = str
+		}
+		if err := form.WriteField(name, value); err != nil {
+			return err
+		}
+	}
+	name := file.Name
+	if name == "" {
+		name = field
+	}
+	part, err := form.CreateFormFile(field, name)
+	if err != nil {
+		return err
+	}
+	if _, err := part.Write(file.Data); err != nil {
+		return err
+	}
+	if err := form.Close(); err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/bot%s/%s", c.baseURL, c.token, method)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	return c.do(req, method, result)
+}
+
+func (c *Client) do(req *http.Request, method string, result interface{}) error {
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		// Do not leak the token through the request URL in error messages
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("telegram %s: %w", method, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	var apiResp apiResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return fmt.Errorf("telegram %s: invalid response (HTTP %d): %w", method, resp.StatusCode, err)
+	}
+	if !apiResp.Ok {
+		apiErr := &Error{
+			Method:      method,
+			Code:        apiResp.ErrorCode,
+			Description: apiResp.Description,
+		}
+		if apiErr.Code == 0 {
+			apiErr.Code = resp.StatusCode
+		}
+		if apiResp.Parameters != nil {
+			apiErr.RetryAfter = apiResp.Parameters.RetryAfter
+		}
+		return apiErr
+	}
+	if result == nil || len(apiResp.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(apiResp.Result, result)
+}
+
+func (c *Client) GetMe(ctx context.Context) (*User, error) {
+	var user User
+	if err := c.Call(ctx, "getMe", struct{}{}, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (c *Client) SendMessage(ctx context.Context, params *SendMessageParams) (*Message, error) {
+	var msg Message
+	if err := c.Call(ctx, "sendMessage", params, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (c *Client) SendPhoto(ctx context.Context, params *SendPhotoParams) (*Message, error) {
+	var msg Message
+	if err := c.callWithFile(ctx, "sendPhoto", params, "photo", &params.Photo, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (c *Client) SendDocument(ctx context.Context, params *SendDocumentParams) (*Message, error) {
+	var msg Message
+	if err := c.callWithFile(ctx, "sendDocument", params, "document", &params.Document, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (c *Client) EditMessageText(ctx context.Context, params *EditMessageTextParams) (*Message, error) {
+	var raw json.RawMessage
+	if err := c.Call(ctx, "editMessageText", params, &raw); err != nil {
+		return nil, err
+	}
+	return decodeEditResult(raw)
+}
+
+func (c *Client) EditMessageCaption(ctx context.Context, params *EditMessageCaptionParams) (*Message, error) {
+	var raw json.RawMessage
+	if err := c.Call(ctx, "editMessageCaption", params, &raw); err != nil {
+		return nil, err
+	}
+	return decodeEditResult(raw)
+}
+
+// EditMessageMedia attaches new content as a separate multipart field, as the media
+// object itself is JSON and can only reference uploads by name.
+func (c *Client) EditMessageMedia(ctx context.Context, params *EditMessageMediaParams) (*Message, error) {
+	input := params.Media
+	media := map[string]string{
+		"type":  input.Type,
+		"media": input.Media.FileID,
+	}
+	if input.Caption != "" {
+		media["caption"] = input.Caption
+	}
+	if input.ParseMode != "" {
+		media["parse_mode"] = input.ParseMode
+	}
+	if input.Media.Data != nil {
+		media["media"] = "attach://upload"
+	} else if input.Media.FileID == "" {
+		return nil, errors.New("telegram editMessageMedia: media is required")
+	}
+	mediaRaw, err := json.Marshal(media)
+	if err != nil {
+		return nil, err
+	}
+	fields := struct {
+		*EditMessageMediaParams
+		Media string `json:"media"`
+	}{params, string(mediaRaw)}
+	var raw json.RawMessage
+	if input.Media.Data == nil {
+		err = c.Call(ctx, "editMessageMedia", fields, &raw)
+	} else {
+		err = c.callWithFile(ctx, "editMessageMedia", fields, "upload", &input.Media, &raw)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeEditResult(raw)
+}
+
+func (c *Client) AnswerCallbackQuery(ctx context.Context, params *AnswerCallbackQueryParams) error {
+	return c.Call(ctx, "answerCallbackQuery", params, nil)
+}
+
+func (c *Client) AnswerPreCheckoutQuery(ctx context.Context, params *AnswerPreCheckoutQueryParams) error {
+	return c.Call(ctx, "answerPreCheckoutQuery", params, nil)
+}
+
+func (c *Client) SetWebhook(ctx context.Context, params *SetWebhookParams) error {
+	return c.Call(ctx, "setWebhook", params, nil)
+}
+
+func (c *Client) DeleteWebhook(ctx context.Context, params *DeleteWebhookParams) error {
+	if params == nil {
+		params = &DeleteWebhookParams{}
+	}
+	return c.Call(ctx, "deleteWebhook", params, nil)
+}
+
+func (c *Client) GetUpdates(ctx context.Context, params *GetUpdatesParams) ([]Update, error) {
+	var updates []Update
+	if err := c.Call(ctx, "getUpdates", params, &updates); err != nil {
+		return nil, err
+	}
+	return updates, nil
+}
+
+func (c *Client) GetFile(ctx context.Context, params *GetFileParams) (*File, error) {
+	var file File
+	if err := c.Call(ctx, "getFile", params, &file); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// DownloadFile copies a file returned by GetFile to w.
+func (c *Client) DownloadFile(ctx context.Context, filePath string, w io.Writer) error {
+	if c.token == "" {
+		return errors.New("telegram bot token is empty")
+	}
+	if filePath == "" {
+		return errors.New("telegram file path is empty")
+	}
+	endpoint := fmt.Sprintf("%s/file/bot%s/%s", c.baseURL, c.token, filePath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("telegram file download: %w", urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("telegram file download failed (HTTP %d)", resp.StatusCode)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Edits of inline messages return true instead of the edited message
+func decodeEditResult(raw json.RawMessage) (*Message, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("true")) {
+		return nil, nil
+	}
+	var msg Message
+	if err := json.Unmarshal(raw, &msg); err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
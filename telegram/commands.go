@@ -0,0 +1,187 @@
+package telegram
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+func init() {
+	RegisterCommand("start", "main menu", sendMenu)
+	RegisterCommand("tariffs", "buy a subscription", sendTariffs)
+	RegisterCommand("status", "your subscription", sendStatus)
+	RegisterCommand("sub", "subscription links and QR code", sendSubscription)
+	RegisterCommand("promo", "redeem a promo code: /promo CODE", redeemPromo)
+	RegisterCommand("referral", "invite friends", sendReferral)
+	RegisterCommand("help", "this help", func(c *Context) error {
+		c.Reply(helpText(c))
+		return nil
+	})
+}
+
+func sendMenu(c *Context) error {
+	if code, ok := strings.CutPrefix(c.Args, service.ReferralStartPrefix); ok {
+		applyReferral(c, code)
+	}
+	menu := []struct{ action, label string }{
+		{"trial", "Free trial"},
+		{"tariffs", "Tariffs"},
+		{"mysub", "My subscription"},
+		{"download", "Download apps"},
+		{"miniapp", "Open app"},
+		{"support", "Support"},
+	}
+	var keyboard [][]botapi.InlineKeyboardButton
+	for _, item := range menu {
+		if button, ok := actionButton(c, item.action, item.label, "", item.action+":"); ok {
+			keyboard = append(keyboard, []botapi.InlineKeyboardButton{button})
+		}
+	}
+	c.Send(&botapi.SendMessageParams{
+		Text:        c.Text("menu", nil),
+		ReplyMarkup: &botapi.InlineKeyboardMarkup{InlineKeyboard: keyboard},
+	})
+	return nil
+}
+
+// sendTariffs sends a card per active tariff with the tariff's buttons.
+func sendTariffs(c *Context) error {
+	tariffs, err := c.Bot.service.ListTariffs()
+	if err != nil {
+		return err
+	}
+	sent := 0
+	for i := range tariffs {
+		tariff := &tariffs[i]
+		if !tariff.Active {
+			continue
+		}
+		text := c.Text("tariff.card", &service.TelegramMessageData{Tariff: service.NewTelegramMessageTariff(tariff)})
+		card := &Context{Bot: c.Bot, API: c.API, ChatID: c.ChatID, From: c.From, Tariff: tariff}
+		c.Send(&botapi.SendMessageParams{
+			Text:        text,
+			ReplyMarkup: &botapi.InlineKeyboardMarkup{InlineKeyboard: tariffKeyboard(card, tariff)},
+		})
+		sent++
+	}
+	if sent == 0 {
+		c.Reply(c.Text("tariffs.empty", nil))
+	}
+	return nil
+}
+
+func sendStatus(c *Context) error {
+	profile, err := c.Bot.service.EnsureUserProfile(c.From.ID)
+	if err != nil {
+		return err
+	}
+	sub, err := c.Bot.service.GetSubscription(profile.ID)
+	if err != nil {
+		return err
+	}
+	if len(sub.ClientIDs) == 0 {
+		c.Reply(c.Text("subscription.none", nil))
+		return nil
+	}
+	data := &service.TelegramMessageData{
+		Active: sub.Active,
+		Expiry: formatTime(sub.ExpiresAt),
+		Used:   formatBytes(sub.Used),
+	}
+	if sub.Volume > 0 {
+		data.Volume = formatBytes(sub.Volume)
+		data.Remaining = formatBytes(sub.Remaining)
+	}
+	c.Send(&botapi.SendMessageParams{
+		Text: c.Text("status", data),
+		ReplyMarkup: &botapi.InlineKeyboardMarkup{InlineKeyboard: [][]botapi.InlineKeyboardButton{{
+			{Text: "Get links and QR code", CallbackData: "sub:"},
+		}}},
+	})
+	return nil
+}
+
+func redeemPromo(c *Context) error {
+	if c.Args == "" {
+		c.Reply(c.Text("promo.usage", nil))
+		return nil
+	}
+	profile, err := c.Bot.service.EnsureUserProfile(c.From.ID)
+	if err != nil {
+		return err
+	}
+	redemption, err := c.Bot.service.RedeemPromoCode(profile.ID, c.Args)
+	if err != nil {
+		c.Reply(c.Text("promo.failed", &service.TelegramMessageData{Error: err.Error()}))
+		return nil
+	}
+	data := &service.TelegramMessageData{
+		Code:     redemption.Code,
+		Days:     redemption.FreeDays,
+		Discount: redemption.DiscountPercent,
+		Expiry:   formatTime(redemption.ExpiresAt),
+		Error:    redemption.FreeDaysError,
+	}
+	c.Reply(c.Text("promo.applied", data))
+	return nil
+}
+
+// applyReferral handles the /start ref_CODE deep link of an invitation.
+func applyReferral(c *Context, code string) {
+	profile, err := c.Bot.service.EnsureUserProfile(c.From.ID)
+	if err != nil {
+		return
+	}
+	referrer, err := c.Bot.service.ApplyReferral(profile.ID, code)
+	if err != nil {
+		if !errors.Is(err, service.ErrReferralDisabled) {
+			c.Reply(c.Text("referral.failed", &service.TelegramMessageData{Error: err.Error()}))
+		}
+		return
+	}
+	c.Reply(c.Text("referral.applied", &service.TelegramMessageData{Name: referrer.FirstName}))
+}
+
+func sendReferral(c *Context) error {
+	cfg, err := c.Bot.service.GetConfig()
+	if err != nil {
+		return err
+	}
+	if !cfg.ReferralEnabled {
+		c.Reply(c.Text("referral.disabled", nil))
+		return nil
+	}
+	profile, err := c.Bot.service.EnsureUserProfile(c.From.ID)
+	if err != nil {
+		return err
+	}
+	link, err := c.Bot.service.ReferralLink(profile.ID)
+	if err != nil {
+		return err
+	}
+	data := &service.TelegramMessageData{Link: link, Discount: cfg.ReferralPercent}
+	if cfg.ReferralReward == service.ReferralRewardDays {
+		data.Days = cfg.ReferralDays
+	}
+	c.Reply(c.Text("referral.link", data))
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format("2006-01-02 15:04")
+}
+
+func formatBytes(n int64) string {
+	const gb = 1 << 30
+	if n >= gb {
+		return fmt.Sprintf("%.2f GB", float64(n)/gb)
+	}
+	return fmt.Sprintf("%.2f MB", float64(n)/(1<<20))
+}
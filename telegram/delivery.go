@@ -0,0 +1,107 @@
+package telegram
+
+import (
+	"fmt"
+	"html"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/telegram/botapi"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Telegram rejects longer messages
+const maxMessageLength = 4096
+
+// sendSubscription sends the sub URL, the direct links and a QR code for each linked client.
+func sendSubscription(c *Context) error {
+	accesses, err := c.Bot.service.GetClientAccess(c.From.ID)
+	if err != nil {
+		return err
+	}
+	if len(accesses) == 0 {
+		c.Reply(c.Text("subscription.none", nil))
+		return nil
+	}
+	for i := range accesses {
+		access := &accesses[i]
+		for _, text := range splitMessage(describeAccess(access)) {
+			c.Send(&botapi.SendMessageParams{
+				Text:                  text,
+				ParseMode:             "HTML",
+				DisableWebPagePreview: true,
+			})
+		}
+		png, err := qrcode.Encode(access.SubURL, qrcode.Medium, 512)
+		if err != nil {
+			return err
+		}
+		c.Bot.sendPhoto(c.API, &botapi.SendPhotoParams{
+			ChatID:  c.ChatID,
+			Photo:   botapi.InputFile{Name: access.Name + ".png", Data: png},
+			Caption: "Scan to import " + access.Name,
+		})
+	}
+	return nil
+}
+
+func describeAccess(access *service.TelegramClientAccess) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<b>%s</b>\n", html.EscapeString(access.Name))
+	if !access.Enable {
+		b.WriteString("This subscription is disabled, use /tariffs to renew it.\n")
+	}
+	fmt.Fprintf(&b, "Subscription URL:\n<code>%s</code>\n\n", html.EscapeString(access.SubURL))
+	fmt.Fprintf(&b, "Upload: %s\nDownload: %s\n", formatBytes(access.Up), formatBytes(access.Down))
+	if access.Volume > 0 {
+		fmt.Fprintf(&b, "Total: %s of %s\n", formatBytes(access.Up+access.Down), formatBytes(access.Volume))
+	} else {
+		b.WriteString("Total: unlimited\n")
+	}
+	if access.Expiry > 0 {
+		fmt.Fprintf(&b, "Expires: %s\n", time.Unix(access.Expiry, 0).Format("2006-01-02 15:04"))
+	} else {
+		b.WriteString("Expires: never\n")
+	}
+	if len(access.Links) > 0 {
+		b.WriteString("\nLinks:\n")
+		for _, link := range access.Links {
+			fmt.Fprintf(&b, "<code>%s</code>\n\n", html.EscapeString(link))
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// splitMessage cuts text at blank lines so that no part exceeds the message limit and tags stay closed.
+func splitMessage(text string) []string {
+	if len(text) <= maxMessageLength {
+		return []string{text}
+	}
+	var parts []string
+	var current strings.Builder
+	for _, block := range strings.Split(text, "\n\n") {
+		if current.Len() > 0 && current.Len()+2+len(block) > maxMessageLength {
+			parts = append(parts, current.String())
+			current.Reset()
+		}
+		if current.Len() > 0 {
+			current.WriteString("\n\n")
+		}
+		current.WriteString(block)
+	}
+	if current.Len() > 0 {
+		parts = append(parts, current.String())
+	}
+	return parts
+}
+
+func (b *Bot) sendPhoto(api botapi.API, params *botapi.SendPhotoParams) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	if _, err := api.SendPhoto(ctx, params); err != nil {
+		logger.Warning("telegram bot: send photo failed:", err)
+	}
+}
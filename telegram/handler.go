@@ -0,0 +1,109 @@
+package telegram
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+const requestTimeout = 15 * time.Second
+
+func requestContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), requestTimeout)
+}
+
+// HandleUpdate reacts to an update that has already been recorded by the service.
+func (b *Bot) HandleUpdate(update *botapi.Update) {
+	if update == nil {
+		return
+	}
+	api, err := b.service.BotAPI()
+	if err != nil {
+		logger.Debugf("telegram bot ignores update %d: %v", update.UpdateID, err)
+		return
+	}
+	switch {
+	case update.Message != nil:
+		b.handleMessage(api, update.Message)
+	case update.CallbackQuery != nil:
+		b.handleCallback(api, update.CallbackQuery)
+	case update.PreCheckoutQuery != nil:
+		b.handlePreCheckout(api, update.PreCheckoutQuery)
+	}
+}
+
+func (b *Bot) handleMessage(api botapi.API, msg *botapi.Message) {
+	if msg.From == nil || msg.Chat.Type != "private" {
+		return
+	}
+	command, args := parseCommand(msg.Text)
+	if command == "" {
+		// Plain text is kept as a support conversation by the service
+		return
+	}
+	c := &Context{Bot: b, API: api, ChatID: msg.Chat.ID, From: *msg.From, Args: args}
+	b.dispatchCommand(c, command)
+}
+
+func (b *Bot) handleCallback(api botapi.API, query *botapi.CallbackQuery) {
+	answer := &botapi.AnswerCallbackQueryParams{
+		CallbackQueryID: query.ID,
+	}
+	chatID := query.From.ID
+	if query.Message != nil {
+		chatID = query.Message.Chat.ID
+	}
+	c := &Context{Bot: b, API: api, ChatID: chatID, From: query.From, answer: answer}
+	b.dispatchCallback(c, query.Data)
+	ctx, cancel := requestContext()
+	defer cancel()
+	if err := api.AnswerCallbackQuery(ctx, answer); err != nil {
+		logger.Warning("telegram bot: answer callback query failed:", err)
+	}
+}
+
+func (b *Bot) handlePreCheckout(api botapi.API, query *botapi.PreCheckoutQuery) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	err := api.AnswerPreCheckoutQuery(ctx, &botapi.AnswerPreCheckoutQueryParams{
+		PreCheckoutQueryID: query.ID,
+		Ok:                 true,
+	})
+	if err != nil {
+		logger.Warning("telegram bot: answer pre-checkout query failed:", err)
+	}
+}
+
+func (b *Bot) reply(api botapi.API, chatID int64, text string) {
+	b.send(api, &botapi.SendMessageParams{
+		ChatID: chatID,
+		Text:   text,
+	})
+}
+
+func (b *Bot) send(api botapi.API, params *botapi.SendMessageParams) {
+	ctx, cancel := requestContext()
+	defer cancel()
+	if _, err := api.SendMessage(ctx, params); err != nil {
+		logger.Warning("telegram bot: send message failed:", err)
+	}
+}
+
+func formatPrice(minor int64, currency string) string {
+	return fmt.Sprintf("%d.%02d %s", minor/100, minor%100, currency)
+}
+
+// parseCommand splits "/cmd@bot args" into "cmd" and "args"
+func parseCommand(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "/") {
+		return "", ""
+	}
+	command, args, _ := strings.Cut(text[1:], " ")
+	command, _, _ = strings.Cut(command, "@")
+	return strings.ToLower(command), strings.TrimSpace(args)
+}
@@ -0,0 +1,124 @@
+package telegram
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+const (
+	pollTimeout    = 30 // seconds, handled by Telegram
+	pollMinBackoff = time.Second
+	pollMaxBackoff = time.Minute
+)
+
+type poller struct {
+	key    string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (p *poller) stop() {
+	p.cancel()
+	<-p.done
+}
+
+// startPolling runs getUpdates for the given bot identity, restarting when the identity changes.
+func (b *Bot) startPolling(ctx context.Context, key string) {
+	b.mu.Lock()
+	current := b.poller
+	b.mu.Unlock()
+	if current != nil {
+		if current.key == key {
+			return
+		}
+		b.stopPolling()
+	}
+	pollCtx, cancel := context.WithCancel(ctx)
+	p := &poller{
+		key:    key,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	b.mu.Lock()
+	b.poller = p
+	b.mu.Unlock()
+	go func() {
+		defer close(p.done)
+		b.poll(pollCtx)
+		// After an early exit the next sync starts polling again
+		b.mu.Lock()
+		if b.poller == p {
+			b.poller = nil
+		}
+		b.mu.Unlock()
+	}()
+	logger.Info("telegram bot long polling started")
+}
+
+func (b *Bot) stopPolling() {
+	b.mu.Lock()
+	p := b.poller
+	b.poller = nil
+	b.mu.Unlock()
+	if p == nil {
+		return
+	}
+	p.stop()
+	logger.Info("telegram bot long polling stopped")
+}
+
+func (b *Bot) poll(ctx context.Context) {
+	api, err := b.service.BotAPI()
+	if err != nil {
+		logger.Warning("telegram bot polling not started:", err)
+		return
+	}
+	if err := b.service.DeleteWebhook(); err != nil {
+		logger.Warning("telegram bot: unable to remove webhook before polling:", err)
+	}
+	offset, err := b.service.GetUpdateOffset()
+	if err != nil {
+		logger.Warning("telegram bot: unable to load update offset:", err)
+	}
+	backoff := pollMinBackoff
+	for ctx.Err() == nil {
+		updates, err := api.GetUpdates(ctx, &botapi.GetUpdatesParams{
+			Offset:         offset,
+			Timeout:        pollTimeout,
+			AllowedUpdates: botapi.AllowedUpdates,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			wait := backoff
+			var apiErr *botapi.Error
+			if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+				wait = time.Duration(apiErr.RetryAfter) * time.Second
+			}
+			logger.Warningf("telegram bot polling failed, retrying in %s: %v", wait, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			backoff = min(backoff*2, pollMaxBackoff)
+			continue
+		}
+		backoff = pollMinBackoff
+		for i := range updates {
+			if err := b.service.HandleUpdate(&updates[i]); err != nil {
+				logger.Warningf("telegram bot: update %d failed: %v", updates[i].UpdateID, err)
+			}
+			offset = updates[i].UpdateID + 1
+			// Persist after every update so a restart does not replay handled ones
+			if err := b.service.SaveUpdateOffset(offset); err != nil {
+				logger.Warning("telegram bot: unable to save update offset:", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package telegram
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alireza0/s-ui/database/model"
+	"github.com/alireza0/s-ui/logger"
+	"github.com/alireza0/s-ui/service"
+	"github.com/alireza0/s-ui/telegram/botapi"
+)
+
+// Context carries what a command or an action needs to answer the user.
+type Context struct {
+	Bot    *Bot
+	API    botapi.API
+	ChatID int64
+	From   botapi.User
+	// Args is the text after a command
+	Args string
+	// Tariff is set when the action comes from a button of a tariff
+	Tariff *model.TelegramTariff
+	answer *botapi.AnswerCallbackQueryParams
+}
+
+func (c *Context) Reply(text string) {
+	c.Send(&botapi.SendMessageParams{Text: text})
+}
+
+func (c *Context) Send(params *botapi.SendMessageParams) {
+	if params.ChatID == 0 {
+		params.ChatID = c.ChatID
+	}
+	c.Bot.send(c.API, params)
+}
+
+// Text renders a message template in the language of the user.
+func (c *Context) Text(key string, data *service.TelegramMessageData) string {
+	if data == nil {
+		data = &service.TelegramMessageData{}
+	}
+	data.User = service.TelegramMessageUser{
+		ID:        c.From.ID,
+		FirstName: c.From.FirstName,
+		LastName:  c.From.LastName,
+		Username:  c.From.Username,
+		Language:  c.From.LanguageCode,
+	}
+	return c.Bot.service.RenderMessage(key, data)
+}
+
+// Notify shows a short popup for callbacks and falls back to a message otherwise.
+func (c *Context) Notify(text string) {
+	if c.answer != nil {
+		c.answer.Text = text
+		c.answer.ShowAlert = true
+		return
+	}
+	c.Reply(text)
+}
+
+type CommandHandler func(c *Context) error
+
+// Action interprets TelegramTariffButton.Action with the button payload.
+type Action struct {
+	// Button renders a button Telegram handles by itself, like links and mini apps.
+	// Without it the button calls back and Handle runs.
+	Button func(c *Context, label string, payload string) (botapi.InlineKeyboardButton, bool)
+	Handle func(c *Context, payload string) error
+}
+
+type command struct {
+	name        string
+	description string
+	handle      CommandHandler
+}
+
+var (
+	routerMu sync.RWMutex
+	commands []command
+	actions  = map[string]Action{}
+)
+
+// RegisterCommand adds or replaces a bot command, the help text lists commands in registration order.
+func RegisterCommand(name string, description string, handle CommandHandler) {
+	name = strings.ToLower(strings.TrimPrefix(name, "/"))
+	routerMu.Lock()
+	defer routerMu.Unlock()
+	for i := range commands {
+		if commands[i].name == name {
+			commands[i] = command{name, description, handle}
+			return
+		}
+	}
+	commands = append(commands, command{name, description, handle})
+}
+
+// RegisterAction makes an action usable in tariff buttons and menus.
+func RegisterAction(name string, action Action) {
+	routerMu.Lock()
+	defer routerMu.Unlock()
+	actions[strings.ToLower(name)] = action
+}
+
+func lookupCommand(name string) (command, bool) {
+	routerMu.RLock()
+	defer routerMu.RUnlock()
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+func lookupAction(name string) (Action, bool) {
+	routerMu.RLock()
+	defer routerMu.RUnlock()
+	action, ok := actions[strings.ToLower(strings.TrimSpace(name))]
+	return action, ok
+}
+
+func helpText(c *Context) string {
+	header := c.Text("help", nil)
+	routerMu.RLock()
+	defer routerMu.RUnlock()
+	lines := []string{header}
+	for _, cmd := range commands {
+		if cmd.description != "" {
+			lines = append(lines, "/"+cmd.name+" - "+cmd.description)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (b *Bot) dispatchCommand(c *Context, name string) {
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		c.Reply(c.Text("command.unknown", nil) + "\n" + helpText(c))
+		return
+	}
+	if err := cmd.handle(c); err != nil {
+		logger.Warningf("telegram bot: /%s failed: %v", name, err)
+		c.Reply(c.Text("error", nil))
+	}
+}
+
+// dispatchCallback runs the action in callback data "name:payload". Tariff buttons use "btn:<id>",
+// so their payload is read from the database and is not limited by Telegram's 64 bytes.
+func (b *Bot) dispatchCallback(c *Context, data string) {
+	name, payload, _ := strings.Cut(data, ":")
+	if name == "btn" {
+		button, tariff, err := b.findButton(payload)
+		if err != nil {
+			logger.Debugf("telegram bot: callback %q: %v", data, err)
+			c.Notify(c.Text("button.unavailable", nil))
+			return
+		}
+		name, payload, c.Tariff = button.Action, button.Payload, tariff
+	}
+	action, ok := lookupAction(name)
+	if !ok || action.Handle == nil {
+		logger.Debugf("telegram bot: no handler for action %q", name)
+		return
+	}
+	if err := action.Handle(c, payload); err != nil {
+		logger.Warningf("telegram bot: action %s for user %d failed: %v", name, c.From.ID, err)
+		c.Notify(c.Text("error", nil))
+	}
+}
+
+func (b *Bot) findButton(id string) (*model.TelegramTariffButton, *model.TelegramTariff, error) {
+	buttonID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, nil, err
+	}
+	tariffs, err := b.service.ListTariffs()
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range tariffs {
+		for j := range tariffs[i].Buttons {
+			if tariffs[i].Buttons[j].ID == uint(buttonID) {
+				return &tariffs[i].Buttons[j], &tariffs[i], nil
+			}
+		}
+	}
+	return nil, nil, fmt.Errorf("button %d not found", buttonID)
+}
+
+// actionButton renders one button for an action, the callback data is only used when the action has no own button.
+func actionButton(c *Context, name string, label string, payload string, callbackData string) (botapi.InlineKeyboardButton, bool) {
+	action, ok := lookupAction(name)
+	if !ok {
+		logger.Debugf("telegram bot: unknown action %q", name)
+		return botapi.InlineKeyboardButton{}, false
+	}
+	if action.Button != nil {
+		return action.Button(c, label, payload)
+	}
+	if action.Handle == nil || len(callbackData) > 64 {
+		return botapi.InlineKeyboardButton{}, false
+	}
+	return botapi.InlineKeyboardButton{Text: label, CallbackData: callbackData}, true
+}
+
+// tariffKeyboard renders the buttons of a tariff, already ordered by ListTariffs, and adds a buy button if none is configured.
+func tariffKeyboard(c *Context, tariff *model.TelegramTariff) [][]botapi.InlineKeyboardButton {
+	var keyboard [][]botapi.InlineKeyboardButton
+	hasBuy := false
+	for _, button := range tariff.Buttons {
+		name := strings.ToLower(strings.TrimSpace(button.Action))
+		if name == "buy" || name == "purchase" {
+			hasBuy = true
+		}
+		rendered, ok := actionButton(c, name, button.Label, button.Payload, fmt.Sprintf("btn:%d", button.ID))
+		if ok {
+			keyboard = append(keyboard, []botapi.InlineKeyboardButton{rendered})
+		}
+	}
+	if !hasBuy {
+		buy := botapi.InlineKeyboardButton{
+			Text:         "Buy for " + formatPrice(tariff.PriceMinor, tariff.Currency),
+			CallbackData: fmt.Sprintf("buy:%d", tariff.ID),
+		}
+		keyboard = append([][]botapi.InlineKeyboardButton{{buy}}, keyboard...)
+	}
+	return keyboard
+}
@@ -0,0 +1,139 @@
+package yookassa
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const DefaultBaseURL = "https://api.yookassa.ru/v3"
+
+type Client struct {
+	shopID     string
+	secretKey  string
+	baseURL    string
+	httpClient *http.Client
+}
+
+func NewClient(shopID string, secretKey string, baseURL string) *Client {
+	baseURL = strings.TrimRight(strings.TrimSpace(baseURL), "/")
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		shopID:    shopID,
+		secretKey: secretKey,
+		baseURL:   baseURL,
+		httpClient: &http.Client{
+			Timeout: 30 * time.Second,
+		},
+	}
+}
+
+// Error is returned when YooKassa answers with a non-2xx status.
+type Error struct {
+	Status      int    `json:"-"`
+	Type        string `json:"type"`
+	Code        string `json:"code"`
+	Description string `json:"description"`
+	Parameter   string `json:"parameter"`
+}
+
+func (e *Error) Error() string {
+	msg := e.Description
+	if msg == "" {
+		msg = e.Code
+	}
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	if e.Parameter != "" {
+		msg += " (" + e.Parameter + ")"
+	}
+	return fmt.Sprintf("yookassa: %d %s", e.Status, msg)
+}
+
+func (c *Client) CreatePayment(ctx context.Context, idempotenceKey string, req *CreatePaymentRequest) (*Payment, error) {
+	if idempotenceKey == "" {
+		return nil, errors.New("yookassa: idempotence key is required")
+	}
+	var payment Payment
+	if err := c.call(ctx, http.MethodPost, "/payments", idempotenceKey, req, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (c *Client) GetPayment(ctx context.Context, id string) (*Payment, error) {
+	if id == "" {
+		return nil, errors.New("yookassa: payment id is required")
+	}
+	var payment Payment
+	if err := c.call(ctx, http.MethodGet, "/payments/"+url.PathEscape(id), "", nil, &payment); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// CreateRefund returns a successful payment fully or partially, refunds usually succeed right away.
+func (c *Client) CreateRefund(ctx context.Context, idempotenceKey string, req *CreateRefundRequest) (*Refund, error) {
+	if idempotenceKey == "" {
+		return nil, errors.New("yookassa: idempotence key is required")
+	}
+	var refund Refund
+	if err := c.call(ctx, http.MethodPost, "/refunds", idempotenceKey, req, &refund); err != nil {
+		return nil, err
+	}
+	return &refund, nil
+}
+
+func (c *Client) call(ctx context.Context, method string, path string, idempotenceKey string, body interface{}, result interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		raw, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(raw)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.shopID, c.secretKey)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if idempotenceKey != "" {
+		req.Header.Set("Idempotence-Key", idempotenceKey)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{Status: resp.StatusCode}
+		_ = json.Unmarshal(raw, apiErr)
+		return apiErr
+	}
+	if result == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, result); err != nil {
+		return fmt.Errorf("yookassa: invalid response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package yookassa
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StatusPending           = "pending"
+	StatusWaitingForCapture = "waiting_for_capture"
+	StatusSucceeded         = "succeeded"
+	StatusCanceled          = "canceled"
+)
+
+type Amount struct {
+	Value    string `json:"value"`
+	Currency string `json:"currency"`
+}
+
+type Confirmation struct {
+	Type            string `json:"type"`
+	ReturnURL       string `json:"return_url,omitempty"`
+	ConfirmationURL string `json:"confirmation_url,omitempty"`
+}
+
+type CancellationDetails struct {
+	Party  string `json:"party"`
+	Reason string `json:"reason"`
+}
+
+type Payment struct {
+	ID                  string               `json:"id"`
+	Status              string               `json:"status"`
+	Paid                bool                 `json:"paid"`
+	Amount              Amount               `json:"amount"`
+	Description         string               `json:"description,omitempty"`
+	Confirmation        *Confirmation        `json:"confirmation,omitempty"`
+	Metadata            map[string]string    `json:"metadata,omitempty"`
+	CancellationDetails *CancellationDetails `json:"cancellation_details,omitempty"`
+	CreatedAt           time.Time            `json:"created_at"`
+	CapturedAt          *time.Time           `json:"captured_at,omitempty"`
+}
+
+type CreatePaymentRequest struct {
+	Amount       Amount            `json:"amount"`
+	Capture      bool              `json:"capture"`
+	Confirmation *Confirmation     `json:"confirmation,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+}
+
+type Refund struct {
+	ID          string    `json:"id"`
+	PaymentID   string    `json:"payment_id"`
+	Status      string    `json:"status"`
+	Amount      Amount    `json:"amount"`
+	Description string    `json:"description,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type CreateRefundRequest struct {
+	PaymentID   string `json:"payment_id"`
+	Amount      Amount `json:"amount"`
+	Description string `json:"description,omitempty"`
+}
+
+// Notification is the body YooKassa posts to the HTTP notification URL.
+type Notification struct {
+	Type   string  `json:"type"`
+	Event  string  `json:"event"`
+	Object Payment `json:"object"`
+}
+
+// FormatAmount converts minor units (kopecks, cents) into the decimal string YooKassa expects.
+func FormatAmount(minor int64) string {
+	sign := ""
+	if minor < 0 {
+		sign = "-"
+		minor = -minor
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, minor/100, minor%100)
+}
+
+// ParseAmount is the inverse of FormatAmount.
+func ParseAmount(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	whole, frac, _ := strings.Cut(value, ".")
+	if whole == "" || len(frac) > 2 {
+		return 0, errors.New("invalid amount: " + value)
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, errors.New("invalid amount: " + value)
+	}
+	cents, err := strconv.ParseInt(frac, 10, 64)
+	if err != nil || cents < 0 {
+		return 0, errors.New("invalid amount: " + value)
+	}
+	if strings.HasPrefix(whole, "-") {
+		return units*100 - cents, nil
+	}
+	return units*100 + cents, nil
+}
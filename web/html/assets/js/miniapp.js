@@ -0,0 +1,256 @@
+const baseUrl = document.body.dataset.baseUrl || "/";
+const apiBase = `${baseUrl}telegram/miniapp/`;
+const GB = 1024 * 1024 * 1024;
+const webApp = window.Telegram ? window.Telegram.WebApp : null;
+
+const el = {
+    status: document.getElementById("status"),
+    content: document.getElementById("content"),
+    greeting: document.getElementById("greeting"),
+    subscriptionState: document.getElementById("subscription-state"),
+    subscriptionExpires: document.getElementById("subscription-expires"),
+    subscriptionUsed: document.getElementById("subscription-used"),
+    subscriptionRemaining: document.getElementById("subscription-remaining"),
+    trialButton: document.getElementById("trial-button"),
+    clients: document.getElementById("clients"),
+    usage: document.getElementById("usage"),
+    tariffs: document.getElementById("tariffs"),
+    downloadsCard: document.getElementById("downloads-card"),
+    downloads: document.getElementById("downloads"),
+};
+
+async function request(path, options = {}) {
+    const response = await fetch(`${apiBase}${path}`, {
+        method: options.method || "GET",
+        headers: {
+            // The panel checks the signed init data instead of a session cookie
+            Authorization: `tma ${webApp ? webApp.initData : ""}`,
+            "Content-Type": "application/json",
+        },
+        body: options.body ? JSON.stringify(options.body) : undefined,
+    });
+    const data = await response.json().catch(() => null);
+    if (response.status === 401) {
+        throw new Error((data && data.msg) || "Open this page from the Telegram bot.");
+    }
+    if (!response.ok || !data) {
+        throw new Error(`Request failed (HTTP ${response.status})`);
+    }
+    if (!data.success) {
+        throw new Error(data.msg || "Request failed");
+    }
+    return data.obj;
+}
+
+function node(tag, className, text) {
+    const element = document.createElement(tag);
+    if (className) {
+        element.className = className;
+    }
+    if (text !== undefined) {
+        element.textContent = text;
+    }
+    return element;
+}
+
+function formatVolume(bytes) {
+    if (!bytes) {
+        return "0 GB";
+    }
+    return `${(bytes / GB).toLocaleString(undefined, { maximumFractionDigits: 2 })} GB`;
+}
+
+function formatPrice(priceMinor, currency) {
+    const major = priceMinor / 100;
+    return `${major.toLocaleString(undefined, { minimumFractionDigits: 2, maximumFractionDigits: 2 })} ${currency || ""}`.trim();
+}
+
+function formatDate(value) {
+    if (!value) {
+        return "—";
+    }
+    const date = new Date(value);
+    return Number.isNaN(date.getTime()) ? "—" : date.toLocaleDateString();
+}
+
+function showStatus(message, isError = false) {
+    el.status.textContent = message;
+    el.status.classList.toggle("error", isError);
+    el.status.hidden = !message;
+}
+
+function notify(message) {
+    if (webApp && webApp.showAlert) {
+        webApp.showAlert(message);
+    } else {
+        window.alert(message);
+    }
+}
+
+function renderSubscription(data) {
+    const user = data.user || {};
+    el.greeting.textContent = `Hi, ${user.first_name || user.username || "there"}!`;
+    const sub = data.subscription || {};
+    const hasClients = (sub.clientIds || []).length > 0;
+    el.subscriptionState.textContent = sub.active ? "Active" : hasClients ? "Expired" : "No subscription";
+    el.subscriptionState.className = sub.active ? "badge success" : "badge";
+    el.subscriptionExpires.textContent = hasClients ? (sub.expiresAt ? formatDate(sub.expiresAt) : "Never") : "—";
+    el.subscriptionUsed.textContent = hasClients ? formatVolume(sub.used) : "—";
+    el.subscriptionRemaining.textContent = hasClients ? (sub.volume > 0 ? formatVolume(sub.remaining) : "Unlimited") : "—";
+    el.trialButton.hidden = !data.trialAvailable;
+}
+
+function renderClients(clients) {
+    el.clients.replaceChildren();
+    if (!clients.length) {
+        el.clients.append(node("p", "muted", "Buy a tariff to get your connection."));
+        return;
+    }
+    clients.forEach((client) => {
+        const item = node("div", "item");
+        item.append(node("div", "item-title", client.name));
+        const used = `${formatVolume(client.up + client.down)}${client.volume > 0 ? ` of ${formatVolume(client.volume)}` : ""}`;
+        item.append(node("div", "muted", `${client.enable ? "Enabled" : "Disabled"} · ${used}`));
+        if (client.subUrl) {
+            const copy = node("button", "btn primary", "Copy subscription link");
+            copy.type = "button";
+            copy.addEventListener("click", () => copyText(client.subUrl));
+            item.append(copy);
+        }
+        (client.links || []).forEach((link, index) => {
+            const button = node("button", "btn", `Copy config #${index + 1}`);
+            button.type = "button";
+            button.addEventListener("click", () => copyText(link));
+            item.append(button);
+        });
+        el.clients.append(item);
+    });
+}
+
+function renderUsage(usage) {
+    el.usage.replaceChildren();
+    if (!usage.length) {
+        el.usage.append(node("p", "muted", "No traffic yet."));
+        return;
+    }
+    const max = Math.max(...usage.map((day) => day.up + day.down), 1);
+    usage.forEach((day) => {
+        const bar = node("div", "usage-bar");
+        bar.title = `${day.date}: ↓ ${formatVolume(day.down)} ↑ ${formatVolume(day.up)}`;
+        const down = node("div", "down");
+        down.style.height = `${(day.down / max) * 120}px`;
+        const up = node("div", "up");
+        up.style.height = `${(day.up / max) * 120}px`;
+        bar.append(down, up);
+        el.usage.append(bar);
+    });
+}
+
+function renderTariffs(tariffs) {
+    el.tariffs.replaceChildren();
+    if (!tariffs.length) {
+        el.tariffs.append(node("p", "muted", "No tariffs are available right now."));
+        return;
+    }
+    tariffs.forEach((tariff) => {
+        const item = node("div", "item");
+        item.append(node("div", "item-title", tariff.title));
+        const volume = tariff.volume > 0 ? formatVolume(tariff.volume) : "unlimited traffic";
+        item.append(node("div", "muted", `${tariff.durationDays} days · ${volume}`));
+        if (tariff.description) {
+            item.append(node("p", "muted", tariff.description));
+        }
+        const buy = node("button", "btn primary", `Buy for ${formatPrice(tariff.priceMinor, tariff.currency)}`);
+        buy.type = "button";
+        buy.addEventListener("click", () => buyTariff(tariff, buy));
+        item.append(buy);
+        el.tariffs.append(item);
+    });
+}
+
+function renderDownloads(links) {
+    el.downloads.replaceChildren();
+    const platforms = Object.keys(links || {}).filter((platform) => links[platform]);
+    el.downloadsCard.hidden = platforms.length === 0;
+    platforms.forEach((platform) => {
+        const link = node("a", "btn", platform);
+        link.href = links[platform];
+        link.addEventListener("click", (event) => {
+            if (webApp && webApp.openLink) {
+                event.preventDefault();
+                webApp.openLink(links[platform]);
+            }
+        });
+        el.downloads.append(link);
+    });
+}
+
+async function copyText(text) {
+    try {
+        await navigator.clipboard.writeText(text);
+        notify("Copied to clipboard.");
+    } catch {
+        window.prompt("Copy the link:", text);
+    }
+}
+
+async function buyTariff(tariff, button) {
+    button.disabled = true;
+    try {
+        const purchase = await request("buy", { method: "POST", body: { tariffId: tariff.id } });
+        if (purchase.confirmationUrl) {
+            webApp && webApp.openLink ? webApp.openLink(purchase.confirmationUrl) : window.open(purchase.confirmationUrl, "_blank");
+        } else {
+            notify("Your subscription has been extended.");
+            await load();
+        }
+    } catch (error) {
+        notify(error.message);
+    } finally {
+        button.disabled = false;
+    }
+}
+
+async function claimTrial() {
+    el.trialButton.disabled = true;
+    try {
+        await request("trial", { method: "POST" });
+        notify("Your free trial has started.");
+        await load();
+    } catch (error) {
+        notify(error.message);
+    } finally {
+        el.trialButton.disabled = false;
+    }
+}
+
+async function load() {
+    const data = await request("data");
+    renderSubscription(data);
+    renderClients(data.clients || []);
+    renderUsage(data.usage || []);
+    renderTariffs(data.tariffs || []);
+    renderDownloads(data.downloadLinks);
+    showStatus("");
+    el.content.hidden = false;
+}
+
+el.trialButton.addEventListener("click", claimTrial);
+
+if (webApp) {
+    webApp.ready();
+    webApp.expand();
+}
+
+if (!webApp || !webApp.initData) {
+    showStatus("Open this page from the Telegram bot.", true);
+} else {
+    load().catch((error) => showStatus(error.message, true));
+}
+
+// Payments finish outside the app, so the data is refreshed when the user comes back
+document.addEventListener("visibilitychange", () => {
+    if (document.visibilityState === "visible" && webApp && webApp.initData) {
+        load().catch(() => {});
+    }
+});
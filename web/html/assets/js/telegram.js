@@ -1,15 +1,23 @@
 const baseUrl = document.body.dataset.baseUrl || "/";
 const apiBase = `${baseUrl}api/`;
+const GB = 1024 * 1024 * 1024;
 
 const state = {
     config: null,
     tariffs: [],
     broadcasts: [],
+    broadcastMedia: null,
     promos: [],
     conversations: [],
+    conversationsTotal: 0,
+    cannedReplies: [],
+    panelUsers: [],
     selectedTariffId: null,
     selectedBroadcastId: null,
     activeConversation: null,
+    payments: [],
+    paymentsTotal: 0,
+    templates: null,
 };
 
 const el = {
@@ -19,13 +27,27 @@ const el = {
     configEnabled: document.getElementById("config-enabled"),
     botTokenInput: document.getElementById("config-bot-token"),
     botTokenMask: document.getElementById("bot-token-mask"),
+    apiBaseUrl: document.getElementById("config-api-base-url"),
     webhookDomain: document.getElementById("config-webhook-domain"),
     webhookSecret: document.getElementById("config-webhook-secret"),
     shopId: document.getElementById("config-shop-id"),
     secretKey: document.getElementById("config-secret-key"),
+    yooKassaApiUrl: document.getElementById("config-yookassa-api-url"),
     successUrl: document.getElementById("config-success-url"),
     failureUrl: document.getElementById("config-failure-url"),
     miniAppUrl: document.getElementById("config-mini-app"),
+    remindersEnabled: document.getElementById("config-reminders-enabled"),
+    reminderDays: document.getElementById("config-reminder-days"),
+    reminderVolume: document.getElementById("config-reminder-volume"),
+    trialEnabled: document.getElementById("config-trial-enabled"),
+    trialInbounds: document.getElementById("config-trial-inbounds"),
+    trialDays: document.getElementById("config-trial-days"),
+    trialVolume: document.getElementById("config-trial-volume"),
+    trialAccountAge: document.getElementById("config-trial-account-age"),
+    referralEnabled: document.getElementById("config-referral-enabled"),
+    referralReward: document.getElementById("config-referral-reward"),
+    referralDays: document.getElementById("config-referral-days"),
+    referralPercent: document.getElementById("config-referral-percent"),
     downloadLinksBody: document.getElementById("download-links-body"),
     addDownloadLink: document.getElementById("add-download-link"),
     tariffTableBody: document.getElementById("tariff-table-body"),
@@ -38,6 +60,8 @@ const el = {
     tariffPrice: document.getElementById("tariff-price"),
     tariffCurrency: document.getElementById("tariff-currency"),
     tariffDuration: document.getElementById("tariff-duration"),
+    tariffVolume: document.getElementById("tariff-volume"),
+    tariffInbounds: document.getElementById("tariff-inbounds"),
     tariffSort: document.getElementById("tariff-sort"),
     tariffActive: document.getElementById("tariff-active"),
     buttonsSubtitle: document.getElementById("buttons-subtitle"),
@@ -57,14 +81,29 @@ const el = {
     broadcastTitle: document.getElementById("broadcast-title"),
     broadcastBody: document.getElementById("broadcast-body"),
     broadcastEditable: document.getElementById("broadcast-editable"),
+    broadcastParseMode: document.getElementById("broadcast-parse-mode"),
+    broadcastMediaFile: document.getElementById("broadcast-media-file"),
+    broadcastMediaAsDocument: document.getElementById("broadcast-media-as-document"),
+    broadcastMediaInfo: document.getElementById("broadcast-media-info"),
+    broadcastMediaRemove: document.getElementById("broadcast-media-remove"),
+    broadcastButtonsBody: document.getElementById("broadcast-buttons-body"),
+    broadcastAddButton: document.getElementById("broadcast-add-button"),
     broadcastAllUsers: document.getElementById("broadcast-all-users"),
     broadcastIncludeNever: document.getElementById("broadcast-include-never"),
     broadcastIncludeExpired: document.getElementById("broadcast-include-expired"),
+    broadcastIncludeTrial: document.getElementById("broadcast-include-trial"),
     broadcastTariffOptions: document.getElementById("broadcast-tariff-options"),
+    broadcastAudienceExpression: document.getElementById("broadcast-audience-expression"),
+    broadcastAudiencePreview: document.getElementById("broadcast-audience-preview"),
+    broadcastAudienceCount: document.getElementById("broadcast-audience-count"),
     broadcastCreate: document.getElementById("create-broadcast"),
     broadcastSend: document.getElementById("broadcast-send"),
     broadcastRefresh: document.getElementById("refresh-broadcasts"),
     broadcastEditSent: document.getElementById("broadcast-edit-sent"),
+    broadcastScheduledAt: document.getElementById("broadcast-scheduled-at"),
+    broadcastRecurrence: document.getElementById("broadcast-recurrence"),
+    broadcastSchedule: document.getElementById("broadcast-schedule"),
+    broadcastCancelSchedule: document.getElementById("broadcast-cancel-schedule"),
     broadcastStatus: document.getElementById("broadcast-status"),
     promoTableBody: document.getElementById("promo-table-body"),
     promoForm: document.getElementById("promo-form"),
@@ -83,7 +122,55 @@ const el = {
     conversationEmpty: document.getElementById("conversation-empty"),
     conversationReplyForm: document.getElementById("conversation-reply-form"),
     conversationReply: document.getElementById("conversation-reply"),
+    conversationCanned: document.getElementById("conversation-canned"),
+    conversationClose: document.getElementById("conversation-close"),
+    conversationTicketForm: document.getElementById("conversation-ticket-form"),
+    conversationStatus: document.getElementById("conversation-status"),
+    conversationAssignee: document.getElementById("conversation-assignee"),
+    conversationTags: document.getElementById("conversation-tags"),
+    conversationFilterForm: document.getElementById("conversation-filter-form"),
+    conversationFilterStatus: document.getElementById("conversation-filter-status"),
+    conversationFilterAssignee: document.getElementById("conversation-filter-assignee"),
+    conversationFilterTag: document.getElementById("conversation-filter-tag"),
+    conversationFilterSearch: document.getElementById("conversation-filter-search"),
+    loadMoreConversations: document.getElementById("load-more-conversations"),
     refreshConversations: document.getElementById("refresh-conversations"),
+    cannedReplyTableBody: document.getElementById("canned-reply-table-body"),
+    cannedReplyForm: document.getElementById("canned-reply-form"),
+    cannedReplyTitle: document.getElementById("canned-reply-title"),
+    cannedReplyBody: document.getElementById("canned-reply-body"),
+    cannedReplyReset: document.getElementById("canned-reply-reset"),
+    paymentFilterForm: document.getElementById("payment-filter-form"),
+    paymentFilterStatus: document.getElementById("payment-filter-status"),
+    paymentFilterTariff: document.getElementById("payment-filter-tariff"),
+    paymentFilterCurrency: document.getElementById("payment-filter-currency"),
+    paymentFilterTelegram: document.getElementById("payment-filter-telegram"),
+    paymentFilterFrom: document.getElementById("payment-filter-from"),
+    paymentFilterTo: document.getElementById("payment-filter-to"),
+    paymentReport: document.getElementById("payment-report"),
+    paymentTableBody: document.getElementById("payment-table-body"),
+    loadMorePayments: document.getElementById("load-more-payments"),
+    exportPayments: document.getElementById("export-payments"),
+    referralForm: document.getElementById("referral-form"),
+    referralUserId: document.getElementById("referral-user-id"),
+    referralTree: document.getElementById("referral-tree"),
+    referralRewardsBody: document.getElementById("referral-rewards-body"),
+    refreshReferrals: document.getElementById("refresh-referrals"),
+    defaultLanguage: document.getElementById("config-default-language"),
+    adminUsers: document.getElementById("config-admin-users"),
+    adminChats: document.getElementById("config-admin-chats"),
+    adminEvents: document.getElementById("config-admin-events"),
+    adminNotifyTest: document.getElementById("admin-notify-test"),
+    templateTableBody: document.getElementById("template-table-body"),
+    templateForm: document.getElementById("template-form"),
+    templateKey: document.getElementById("template-key"),
+    templateLanguage: document.getElementById("template-language"),
+    templateText: document.getElementById("template-text"),
+    templateDefault: document.getElementById("template-default"),
+    templateFields: document.getElementById("template-fields"),
+    templatePreview: document.getElementById("template-preview"),
+    previewTemplate: document.getElementById("preview-template"),
+    deleteTemplate: document.getElementById("delete-template"),
 };
 
 function showToast(message, isError = false) {
@@ -109,10 +196,12 @@ function setLoading(isLoading) {
 
 async function request(path, options = {}) {
     const { headers, ...rest } = options;
+    // The browser sets the multipart boundary for uploads
+    const contentType = rest.body instanceof FormData ? {} : { "Content-Type": "application/json" };
     const response = await fetch(`${apiBase}${path}`, {
         credentials: "include",
         headers: {
-            "Content-Type": "application/json",
+            ...contentType,
             ...(headers || {}),
         },
         ...rest,
@@ -138,7 +227,7 @@ async function loadState(preserveSelection = true) {
         state.tariffs = Array.isArray(obj?.tariffs) ? obj.tariffs : [];
         state.broadcasts = Array.isArray(obj?.broadcasts) ? obj.broadcasts : [];
         state.promos = Array.isArray(obj?.promoCodes) ? obj.promoCodes : [];
-        state.conversations = Array.isArray(obj?.conversations) ? obj.conversations : [];
+        state.cannedReplies = Array.isArray(obj?.cannedReplies) ? obj.cannedReplies : [];
         if (preserveSelection && state.selectedTariffId) {
             const exists = state.tariffs.some((t) => t.id === state.selectedTariffId);
             if (!exists) {
@@ -155,8 +244,11 @@ async function loadState(preserveSelection = true) {
         renderButtons();
         renderBroadcasts();
         renderBroadcastAudienceOptions();
+        watchBroadcastProgress();
         renderPromos();
-        renderConversations();
+        renderPaymentTariffOptions();
+        renderCannedReplies();
+        await loadConversations();
         if (state.activeConversation) {
             await loadConversation(state.activeConversation.user.id, false);
         }
@@ -171,13 +263,35 @@ async function loadState(preserveSelection = true) {
 function renderConfig() {
     const cfg = state.config || {};
     el.configEnabled.checked = Boolean(cfg.enabled);
+    el.apiBaseUrl.value = cfg.apiBaseUrl || "";
     el.webhookDomain.value = cfg.webhookDomain || "";
     el.webhookSecret.value = cfg.webhookSecret || "";
     el.shopId.value = cfg.yooKassaShopId || "";
     el.secretKey.value = "";
+    el.yooKassaApiUrl.value = cfg.yooKassaApiUrl || "";
     el.successUrl.value = cfg.successRedirectUrl || "";
     el.failureUrl.value = cfg.failureRedirectUrl || "";
     el.miniAppUrl.value = cfg.miniAppUrl || "";
+    el.remindersEnabled.checked = Boolean(cfg.remindersEnabled);
+    el.reminderDays.value = (cfg.reminderDays || []).join(", ");
+    el.reminderVolume.value = (cfg.reminderVolume || []).join(", ");
+    el.trialEnabled.checked = Boolean(cfg.trialEnabled);
+    el.trialInbounds.value = (cfg.trialInbounds || []).join(", ");
+    el.trialDays.value = cfg.trialDays || 3;
+    el.trialVolume.value = cfg.trialVolume ? +(cfg.trialVolume / GB).toFixed(2) : "";
+    el.trialAccountAge.value = cfg.trialMinAccountAge || 0;
+    el.defaultLanguage.value = cfg.defaultLanguage || "";
+    el.adminUsers.value = (cfg.adminUserIds || []).join(", ");
+    el.adminChats.value = (cfg.adminChatIds || []).join(", ");
+    const adminEvents = cfg.adminEvents || [];
+    for (const input of el.adminEvents.querySelectorAll("input[type=checkbox]")) {
+        input.checked = adminEvents.includes(input.value);
+    }
+    el.referralEnabled.checked = Boolean(cfg.referralEnabled);
+    el.referralReward.value = cfg.referralReward || "days";
+    el.referralDays.value = cfg.referralDays || 7;
+    el.referralPercent.value = cfg.referralPercent || 20;
+    updateReferralRewardControls();
     el.botTokenInput.value = "";
     if (cfg.botTokenMasked) {
         el.botTokenMask.textContent = `Сохранён токен: ${cfg.botTokenMasked}`;
@@ -239,6 +353,21 @@ function formatPrice(priceMinor, currency) {
     return `${major.toLocaleString(undefined, { minimumFractionDigits: 2, maximumFractionDigits: 2 })} ${currency || ""}`.trim();
 }
 
+function parseIdList(value) {
+    return (value || "")
+        .split(/[\s,]+/)
+        .map((part) => Number(part))
+        .filter((id) => Number.isInteger(id) && id > 0);
+}
+
+// Group chat IDs are negative, so they can't go through parseIdList
+function parseChatIdList(value) {
+    return (value || "")
+        .split(/[\s,]+/)
+        .map((part) => Number(part))
+        .filter((id) => Number.isSafeInteger(id) && id !== 0);
+}
+
 function formatDateTime(value) {
     if (!value) {
         return "";
@@ -250,7 +379,37 @@ function formatDateTime(value) {
     return date.toLocaleString();
 }
 
+// toDateTimeLocal formats a timestamp for datetime-local inputs, which expect local time without a zone
+function toDateTimeLocal(value) {
+    if (!value) {
+        return "";
+    }
+    const date = new Date(value);
+    if (Number.isNaN(date.getTime())) {
+        return "";
+    }
+    const pad = (n) => String(n).padStart(2, "0");
+    return `${date.getFullYear()}-${pad(date.getMonth() + 1)}-${pad(date.getDate())}T${pad(date.getHours())}:${pad(date.getMinutes())}`;
+}
+
+function describeSubscription(sub) {
+    if (!sub || !Array.isArray(sub.clientIds) || !sub.clientIds.length) {
+        return "нет подписки";
+    }
+    if (!sub.active) {
+        return sub.expiresAt ? `подписка истекла ${formatDateTime(sub.expiresAt)}` : "подписка неактивна";
+    }
+    const parts = [sub.expiresAt ? `подписка до ${formatDateTime(sub.expiresAt)}` : "бессрочная подписка"];
+    if (sub.volume > 0) {
+        parts.push(`осталось ${(sub.remaining / GB).toFixed(2)} ГБ`);
+    }
+    return parts.join(", ");
+}
+
 function describeAudience(audience) {
+    if (audience?.expression) {
+        return audience.expression;
+    }
     if (!audience || audience.allUsers) {
         return "Все пользователи";
     }
@@ -269,6 +428,9 @@ function describeAudience(audience) {
     if (audience.includeExpired) {
         parts.push("С истёкшей подпиской");
     }
+    if (audience.includeTrial) {
+        parts.push("Бравшие пробный период");
+    }
     return parts.join(", ") || "Выбранные пользователи";
 }
 
@@ -383,6 +545,8 @@ function resetTariffForm() {
     el.tariffForm.reset();
     el.tariffId.value = "";
     el.tariffPrice.value = "";
+    el.tariffVolume.value = "";
+    el.tariffInbounds.value = "";
     el.tariffSort.value = "0";
     el.tariffActive.checked = true;
 }
@@ -394,6 +558,8 @@ function populateTariffForm(tariff) {
     el.tariffPrice.value = tariff.priceMinor ? (tariff.priceMinor / 100).toFixed(2) : "";
     el.tariffCurrency.value = tariff.currency || "";
     el.tariffDuration.value = typeof tariff.durationDays === "number" ? tariff.durationDays : "";
+    el.tariffVolume.value = tariff.volume ? +(tariff.volume / GB).toFixed(2) : "";
+    el.tariffInbounds.value = Array.isArray(tariff.inbounds) ? tariff.inbounds.join(", ") : "";
     el.tariffSort.value = typeof tariff.sortOrder === "number" ? tariff.sortOrder : "0";
     el.tariffActive.checked = Boolean(tariff.active);
 }
@@ -527,11 +693,11 @@ function renderBroadcasts() {
             <td>${escapeHtml(broadcast.title || "Без названия")}</td>
             <td>${escapeHtml(describeAudience(broadcast.audience))}</td>
             <td>
-                <span class="badge ${broadcast.status === "sent" ? "success" : "muted"}">
-                    ${broadcast.status === "sent" ? "Отправлено" : "Черновик"}
+                <span class="badge ${broadcastStatusBadge(broadcast.status)}">
+                    ${describeBroadcastStatus(broadcast.status)}
                 </span>
             </td>
-            <td>${deliveries ? `${success}/${deliveries}` : "—"}</td>
+            <td>${deliveries ? `${success}/${deliveries}${failed ? ` (ошибки: ${failed})` : ""}` : "—"}</td>
             <td class="actions"></td>
         `;
         const actionsCell = row.querySelector(".actions");
@@ -542,15 +708,16 @@ function renderBroadcasts() {
         editBtn.dataset.action = "edit";
         editBtn.dataset.id = String(broadcast.id);
         actionsCell.appendChild(editBtn);
-        if (broadcast.status !== "sent") {
+        if (canSendBroadcast(broadcast)) {
             const sendBtn = document.createElement("button");
             sendBtn.type = "button";
             sendBtn.className = "btn subtle";
-            sendBtn.textContent = "Отправить";
+            sendBtn.textContent = broadcast.status === "draft" ? "Отправить" : "Повторить ошибки";
             sendBtn.dataset.action = "send";
             sendBtn.dataset.id = String(broadcast.id);
             actionsCell.appendChild(sendBtn);
-        } else if (broadcast.editable) {
+        }
+        if (isBroadcastFinished(broadcast) && broadcast.editable) {
             const editMessageBtn = document.createElement("button");
             editMessageBtn.type = "button";
             editMessageBtn.className = "btn subtle";
@@ -577,6 +744,65 @@ function renderBroadcasts() {
     }
 }
 
+const broadcastStatusLabels = {
+    draft: "Черновик",
+    scheduled: "Запланировано",
+    queued: "В очереди",
+    sending: "Отправляется",
+    sent: "Отправлено",
+    partially_failed: "Отправлено с ошибками",
+    failed: "Не доставлено",
+};
+
+function describeBroadcastStatus(status) {
+    return broadcastStatusLabels[status] || status || "Черновик";
+}
+
+function broadcastStatusBadge(status) {
+    switch (status) {
+        case "sent":
+            return "success";
+        case "partially_failed":
+        case "failed":
+            return "warning";
+        default:
+            return "muted";
+    }
+}
+
+let broadcastProgressTimer = null;
+
+// Broadcasts are sent and edited in the background, refresh their progress until the queue is empty
+function watchBroadcastProgress() {
+    clearTimeout(broadcastProgressTimer);
+    const inProgress = state.broadcasts.some((b) => b.status === "queued" || b.status === "sending" || b.editing > 0);
+    if (!inProgress) {
+        return;
+    }
+    broadcastProgressTimer = setTimeout(async () => {
+        try {
+            const obj = await request("telegramState", { method: "GET" });
+            state.broadcasts = Array.isArray(obj?.broadcasts) ? obj.broadcasts : [];
+            const selected = state.broadcasts.find((b) => b.id === state.selectedBroadcastId);
+            // Do not overwrite a draft that is being edited
+            if (!selected || selected.status !== "draft") {
+                renderBroadcasts();
+            }
+        } catch (error) {
+            console.error(error);
+        }
+        watchBroadcastProgress();
+    }, 3000);
+}
+
+function isBroadcastFinished(broadcast) {
+    return ["sent", "partially_failed", "failed"].includes(broadcast?.status);
+}
+
+function canSendBroadcast(broadcast) {
+    return ["draft", "partially_failed", "failed"].includes(broadcast?.status);
+}
+
 function resetBroadcastForm() {
     if (!el.broadcastForm) {
         return;
@@ -594,6 +820,11 @@ function resetBroadcastForm() {
     if (el.broadcastEditable) {
         el.broadcastEditable.checked = true;
     }
+    if (el.broadcastParseMode) {
+        el.broadcastParseMode.value = "";
+    }
+    setBroadcastMedia(null);
+    renderBroadcastButtons([]);
     if (el.broadcastAllUsers) {
         el.broadcastAllUsers.checked = true;
     }
@@ -603,6 +834,21 @@ function resetBroadcastForm() {
     if (el.broadcastIncludeExpired) {
         el.broadcastIncludeExpired.checked = false;
     }
+    if (el.broadcastIncludeTrial) {
+        el.broadcastIncludeTrial.checked = false;
+    }
+    if (el.broadcastAudienceExpression) {
+        el.broadcastAudienceExpression.value = "";
+    }
+    if (el.broadcastAudienceCount) {
+        el.broadcastAudienceCount.textContent = "";
+    }
+    if (el.broadcastScheduledAt) {
+        el.broadcastScheduledAt.value = "";
+    }
+    if (el.broadcastRecurrence) {
+        el.broadcastRecurrence.value = "";
+    }
     renderBroadcastAudienceOptions([]);
     updateBroadcastActionButtons(null);
     if (el.broadcastStatus) {
@@ -618,31 +864,56 @@ function updateBroadcastActionButtons(broadcast) {
         el.broadcastSend.disabled = true;
         el.broadcastSend.hidden = true;
         el.broadcastEditSent.hidden = true;
+        if (el.broadcastSchedule && el.broadcastCancelSchedule) {
+            el.broadcastSchedule.hidden = true;
+            el.broadcastCancelSchedule.hidden = true;
+        }
         if (el.broadcastStatus) {
             el.broadcastStatus.textContent = "Черновик не сохранён.";
         }
         return;
     }
-    const isDraft = broadcast.status !== "sent";
+    const canEdit = isBroadcastFinished(broadcast) && broadcast.editable;
     el.broadcastSend.hidden = false;
-    el.broadcastSend.disabled = !isDraft;
+    el.broadcastSend.disabled = !canSendBroadcast(broadcast);
+    el.broadcastSend.textContent = broadcast.status === "draft" ? "Отправить" : "Повторить ошибки";
     el.broadcastSend.dataset.id = String(broadcast.id);
-    el.broadcastEditSent.hidden = !(broadcast.status === "sent" && broadcast.editable);
-    el.broadcastEditSent.disabled = !(broadcast.status === "sent" && broadcast.editable);
+    el.broadcastEditSent.hidden = !canEdit;
+    el.broadcastEditSent.disabled = !canEdit;
     el.broadcastEditSent.dataset.id = String(broadcast.id);
+    if (el.broadcastSchedule && el.broadcastCancelSchedule) {
+        el.broadcastSchedule.hidden = broadcast.status !== "draft" || Boolean(broadcast.parentId);
+        el.broadcastCancelSchedule.hidden = broadcast.status !== "scheduled";
+    }
     if (el.broadcastStatus) {
         const pieces = [];
-        if (broadcast.status === "sent") {
-            pieces.push(`Отправлено ${broadcast.sentAt ? formatDateTime(broadcast.sentAt) : ""}`.trim());
+        if (broadcast.status === "draft") {
+            pieces.push("Черновик не отправлен");
+        } else if (broadcast.status === "scheduled") {
+            pieces.push(`Будет отправлено ${formatDateTime(broadcast.scheduledAt)}`);
+            if (broadcast.recurrence) {
+                pieces.push(`Повтор: ${broadcast.recurrence}`);
+            }
+        } else if (!isBroadcastFinished(broadcast)) {
+            pieces.push(describeBroadcastStatus(broadcast.status));
+            pieces.push(`Отправлено ${broadcast.success || 0} из ${broadcast.deliveries || 0}`);
+            if (broadcast.failed) {
+                pieces.push(`Ошибки: ${broadcast.failed}`);
+            }
+        } else {
+            pieces.push(`${describeBroadcastStatus(broadcast.status)} ${broadcast.sentAt ? formatDateTime(broadcast.sentAt) : ""}`.trim());
             pieces.push(`Доставлено: ${broadcast.success || 0}`);
             if (broadcast.failed) {
                 pieces.push(`Ошибки: ${broadcast.failed}`);
             }
-            if (broadcast.editable) {
-                pieces.push("Можно обновить текст");
+            if (broadcast.editing) {
+                pieces.push(`Обновляется сообщений: ${broadcast.editing}`);
+            } else if (broadcast.editable) {
+                pieces.push("Можно обновить сообщения");
+            }
+            if (broadcast.editFailed) {
+                pieces.push(`Не обновлено: ${broadcast.editFailed}`);
             }
-        } else {
-            pieces.push("Черновик не отправлен");
         }
         el.broadcastStatus.textContent = pieces.filter(Boolean).join(" • ");
     }
@@ -666,6 +937,11 @@ function populateBroadcastForm(broadcast) {
     if (el.broadcastEditable) {
         el.broadcastEditable.checked = Boolean(broadcast.editable);
     }
+    if (el.broadcastParseMode) {
+        el.broadcastParseMode.value = broadcast.parseMode || "";
+    }
+    setBroadcastMedia(broadcast.media || null);
+    renderBroadcastButtons(broadcast.buttons || []);
     const audience = broadcast.audience || {};
     if (el.broadcastAllUsers) {
         el.broadcastAllUsers.checked = Boolean(audience.allUsers);
@@ -676,6 +952,21 @@ function populateBroadcastForm(broadcast) {
     if (el.broadcastIncludeExpired) {
         el.broadcastIncludeExpired.checked = Boolean(audience.includeExpired);
     }
+    if (el.broadcastIncludeTrial) {
+        el.broadcastIncludeTrial.checked = Boolean(audience.includeTrial);
+    }
+    if (el.broadcastAudienceExpression) {
+        el.broadcastAudienceExpression.value = audience.expression || "";
+    }
+    if (el.broadcastAudienceCount) {
+        el.broadcastAudienceCount.textContent = "";
+    }
+    if (el.broadcastScheduledAt) {
+        el.broadcastScheduledAt.value = toDateTimeLocal(broadcast.scheduledAt);
+    }
+    if (el.broadcastRecurrence) {
+        el.broadcastRecurrence.value = broadcast.recurrence || "";
+    }
     renderBroadcastAudienceOptions(audience.tariffIds || []);
     updateBroadcastActionButtons(broadcast);
 }
@@ -686,16 +977,128 @@ function collectBroadcastPayload() {
         id: el.broadcastId?.value ? Number(el.broadcastId.value) : 0,
         title: el.broadcastTitle?.value.trim() || "",
         body: el.broadcastBody?.value.trim() || "",
+        parseMode: el.broadcastParseMode?.value || "",
+        media: state.broadcastMedia,
+        buttons: collectBroadcastButtons(),
         editable: Boolean(el.broadcastEditable?.checked),
         audience: {
             allUsers: Boolean(el.broadcastAllUsers?.checked),
             tariffIds: tariffs,
             includeNeverSubscribed: Boolean(el.broadcastIncludeNever?.checked),
             includeExpired: Boolean(el.broadcastIncludeExpired?.checked),
+            includeTrial: Boolean(el.broadcastIncludeTrial?.checked),
+            expression: el.broadcastAudienceExpression?.value.trim() || "",
         },
     };
 }
 
+async function previewBroadcastAudience() {
+    const { audience } = collectBroadcastPayload();
+    try {
+        setLoading(true);
+        const obj = await request("telegramAudiencePreview", {
+            method: "POST",
+            body: JSON.stringify(audience),
+        });
+        if (el.broadcastAudienceCount) {
+            el.broadcastAudienceCount.textContent = `Получателей: ${obj?.count || 0}`;
+        }
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+}
+
+function setBroadcastMedia(media) {
+    state.broadcastMedia = media;
+    if (el.broadcastMediaFile) {
+        el.broadcastMediaFile.value = "";
+    }
+    if (el.broadcastMediaInfo) {
+        el.broadcastMediaInfo.innerHTML = "";
+        if (!media) {
+            el.broadcastMediaInfo.textContent = "Без вложения.";
+        } else {
+            const link = document.createElement("a");
+            link.href = `${apiBase}telegramBroadcastMedia?file=${encodeURIComponent(media.file)}`;
+            link.target = "_blank";
+            link.rel = "noopener";
+            link.textContent = media.name || media.file;
+            el.broadcastMediaInfo.append(media.type === "photo" ? "Фото: " : "Файл: ", link);
+        }
+    }
+    if (el.broadcastMediaRemove) {
+        el.broadcastMediaRemove.hidden = !media;
+    }
+}
+
+async function uploadBroadcastMedia(file) {
+    const asDocument = el.broadcastMediaAsDocument?.checked || !/^image\/(jpeg|png|webp)$/.test(file.type);
+    const form = new FormData();
+    form.append("type", asDocument ? "document" : "photo");
+    form.append("file", file);
+    try {
+        setLoading(true);
+        const media = await request("telegramBroadcastMedia", { method: "POST", body: form });
+        setBroadcastMedia(media);
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+        if (el.broadcastMediaFile) {
+            el.broadcastMediaFile.value = "";
+        }
+    } finally {
+        setLoading(false);
+    }
+}
+
+function renderBroadcastButtons(rows) {
+    if (!el.broadcastButtonsBody) {
+        return;
+    }
+    el.broadcastButtonsBody.innerHTML = "";
+    for (const row of rows || []) {
+        row.forEach((button, index) => addBroadcastButtonRow(button, index === 0));
+    }
+}
+
+function addBroadcastButtonRow(button = {}, newRow = true) {
+    const target = button.url || button.callback || "";
+    const row = document.createElement("tr");
+    row.innerHTML = `
+        <td><input type="text" class="input" value="${escapeHtml(button.text || "")}" placeholder="Тарифы" /></td>
+        <td><input type="text" class="input" value="${escapeHtml(target)}" placeholder="https://... или tariffs:" /></td>
+        <td><input type="checkbox" ${newRow ? "checked" : ""} /></td>
+        <td class="actions"><button type="button" class="btn subtle remove-link">Удалить</button></td>
+    `;
+    row.querySelector(".remove-link").addEventListener("click", (event) => {
+        event.stopPropagation();
+        row.remove();
+    });
+    el.broadcastButtonsBody.appendChild(row);
+}
+
+function collectBroadcastButtons() {
+    const rows = [];
+    for (const row of el.broadcastButtonsBody?.querySelectorAll("tr") || []) {
+        const inputs = row.querySelectorAll("input");
+        const text = inputs[0]?.value.trim();
+        const target = inputs[1]?.value.trim();
+        if (!text && !target) {
+            continue;
+        }
+        const button = /^(https?|tg):\/\//i.test(target) ? { text, url: target } : { text, callback: target };
+        if (inputs[2]?.checked || !rows.length) {
+            rows.push([button]);
+        } else {
+            rows[rows.length - 1].push(button);
+        }
+    }
+    return rows;
+}
+
 function renderPromos() {
     if (!el.promoTableBody) {
         return;
@@ -804,17 +1207,78 @@ function collectPromoPayload() {
     };
 }
 
+const ticketStatusLabels = {
+    open: ["Открыто", "warning"],
+    pending: ["Ждёт пользователя", "muted"],
+    closed: ["Закрыто", "success"],
+};
+
+function ticketStatusBadge(status) {
+    const [label, tone] = ticketStatusLabels[status] || [status, "muted"];
+    return `<span class="badge ${tone}">${escapeHtml(label)}</span>`;
+}
+
+function conversationFilterQuery() {
+    const params = new URLSearchParams();
+    const assignee = el.conversationFilterAssignee.value;
+    const values = {
+        status: el.conversationFilterStatus.value,
+        assignee: assignee === "-" ? "" : assignee,
+        unassigned: assignee === "-" ? "true" : "",
+        tag: el.conversationFilterTag.value.trim(),
+        search: el.conversationFilterSearch.value.trim(),
+    };
+    for (const [key, value] of Object.entries(values)) {
+        if (value) {
+            params.set(key, value);
+        }
+    }
+    return params;
+}
+
+async function loadConversations(append = false) {
+    if (!el.conversationList) {
+        return;
+    }
+    const params = conversationFilterQuery();
+    params.set("offset", String(append ? state.conversations.length : 0));
+    try {
+        const list = await request(`telegramConversations?${params}`, { method: "GET" });
+        state.conversations = append ? state.conversations.concat(list.conversations) : list.conversations;
+        state.conversationsTotal = list.total;
+        renderConversations();
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    }
+}
+
+async function loadPanelUsers() {
+    try {
+        const users = await request("users", { method: "GET" });
+        state.panelUsers = Array.isArray(users) ? users.map((u) => u.username) : [];
+    } catch (error) {
+        console.error(error);
+        state.panelUsers = [];
+    }
+    for (const select of [el.conversationFilterAssignee, el.conversationAssignee]) {
+        for (const username of state.panelUsers) {
+            select?.appendChild(new Option(username, username));
+        }
+    }
+}
+
 function renderConversations() {
     if (!el.conversationList) {
         return;
     }
     el.conversationList.innerHTML = "";
-    if (!Array.isArray(state.conversations) || !state.conversations.length) {
+    el.loadMoreConversations.hidden = state.conversations.length >= state.conversationsTotal;
+    if (!state.conversations.length) {
         const empty = document.createElement("p");
         empty.className = "hint";
-        empty.textContent = "Сообщений от пользователей ещё не поступало.";
+        empty.textContent = "Обращений не найдено.";
         el.conversationList.appendChild(empty);
-        renderConversationThread(null);
         return;
     }
     for (const summary of state.conversations) {
@@ -826,10 +1290,17 @@ function renderConversations() {
             item.classList.add("active");
         }
         const title = summary.user.firstName || summary.user.username || `ID ${summary.user.telegramId}`;
-        const subtitle = summary.lastMessage?.body ? summary.lastMessage.body.slice(0, 80) : "Без сообщений";
+        const last = summary.lastMessage;
+        let subtitle = last?.body ? last.body.slice(0, 80) : "Без сообщений";
+        if (last && !last.body && last.attachmentType) {
+            subtitle = last.attachmentType === "photo" ? "Фото" : `Файл: ${last.attachmentName}`;
+        }
+        const tags = (summary.tags || []).map((tag) => `<span class="badge muted">${escapeHtml(tag)}</span>`).join(" ");
         item.innerHTML = `
             <strong>${escapeHtml(title)}</strong>
             <span class="subtitle">${escapeHtml(subtitle)}</span>
+            <span class="ticket-meta">${ticketStatusBadge(summary.status)} ${tags}</span>
+            <span class="subtitle">${summary.assignee ? `Исполнитель: ${escapeHtml(summary.assignee)}` : "Не назначено"} · ${formatDateTime(summary.lastMessageAt)}</span>
         `;
         if (summary.unreadCount) {
             const badge = document.createElement("span");
@@ -839,13 +1310,10 @@ function renderConversations() {
         }
         el.conversationList.appendChild(item);
     }
-    if (state.activeConversation) {
-        const exists = state.conversations.some((c) => c.user.id === state.activeConversation.user.id);
-        if (!exists) {
-            state.activeConversation = null;
-            renderConversationThread(null);
-        }
-    }
+}
+
+function attachmentUrl(message) {
+    return `${apiBase}telegramAttachment?id=${encodeURIComponent(message.id)}`;
 }
 
 function renderConversationThread(conversation) {
@@ -853,9 +1321,11 @@ function renderConversationThread(conversation) {
         return;
     }
     if (!conversation) {
+        state.activeConversation = null;
         el.conversationEmpty.hidden = false;
         el.conversationMessages.innerHTML = "";
         el.conversationHeader.textContent = "Выберите пользователя";
+        el.conversationTicketForm.hidden = true;
         el.conversationReplyForm?.classList.add("disabled");
         return;
     }
@@ -863,17 +1333,52 @@ function renderConversationThread(conversation) {
     el.conversationEmpty.hidden = true;
     const nameParts = [conversation.user.firstName, conversation.user.lastName].filter(Boolean);
     const displayName = nameParts.length ? nameParts.join(" ") : conversation.user.username || `ID ${conversation.user.telegramId}`;
-    el.conversationHeader.textContent = displayName;
+    el.conversationHeader.textContent = `${displayName} · ${describeSubscription(conversation.user.subscription)}`;
+    el.conversationTicketForm.hidden = false;
+    el.conversationStatus.value = conversation.status;
+    if (conversation.assignee && !state.panelUsers.includes(conversation.assignee)) {
+        el.conversationAssignee.appendChild(new Option(conversation.assignee, conversation.assignee));
+    }
+    el.conversationAssignee.value = conversation.assignee || "";
+    el.conversationTags.value = (conversation.tags || []).join(", ");
     el.conversationMessages.innerHTML = "";
     for (const message of conversation.messages || []) {
         const bubble = document.createElement("div");
         bubble.className = `message ${message.direction === "inbound" ? "inbound" : "outbound"}`;
-        const text = document.createElement("p");
-        text.textContent = message.body || "";
+        if (message.attachmentType && !message.attachmentStored) {
+            const note = document.createElement("p");
+            note.className = "attachment-missing";
+            const name = message.attachmentType === "photo" ? "Фото" : `📎 ${message.attachmentName || "document"}`;
+            note.textContent = message.attachmentError
+                ? `${name}: не удалось сохранить (${message.attachmentError})`
+                : `${name}: загружается…`;
+            bubble.appendChild(note);
+        } else if (message.attachmentType === "photo") {
+            const link = document.createElement("a");
+            link.href = attachmentUrl(message);
+            link.target = "_blank";
+            const img = document.createElement("img");
+            img.className = "attachment-photo";
+            img.src = attachmentUrl(message);
+            img.alt = message.attachmentName || "photo";
+            img.loading = "lazy";
+            link.appendChild(img);
+            bubble.appendChild(link);
+        } else if (message.attachmentType) {
+            const link = document.createElement("a");
+            link.href = attachmentUrl(message);
+            link.textContent = `📎 ${message.attachmentName || "document"}`;
+            bubble.appendChild(link);
+        }
+        if (message.body) {
+            const text = document.createElement("p");
+            text.textContent = message.body;
+            bubble.appendChild(text);
+        }
         const meta = document.createElement("span");
         meta.className = "meta";
-        meta.textContent = formatDateTime(message.createdAt);
-        bubble.append(text, meta);
+        meta.textContent = message.author ? `${message.author} · ${formatDateTime(message.createdAt)}` : formatDateTime(message.createdAt);
+        bubble.appendChild(meta);
         el.conversationMessages.appendChild(bubble);
     }
     el.conversationMessages.scrollTop = el.conversationMessages.scrollHeight;
@@ -890,9 +1395,505 @@ async function loadConversation(userId, showLoader = true) {
         }
         const convo = await request(`telegramConversation?id=${encodeURIComponent(userId)}`, { method: "GET" });
         renderConversationThread(convo);
+        renderConversations();
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    }
+}
+
+async function updateConversation() {
+    const id = state.activeConversation?.user?.id;
+    if (!id) {
+        return;
+    }
+    const tags = el.conversationTags.value.split(",").map((tag) => tag.trim()).filter(Boolean);
+    try {
+        setLoading(true);
+        const convo = await request("telegramConversationUpdate", {
+            method: "POST",
+            body: JSON.stringify({
+                id,
+                status: el.conversationStatus.value,
+                assignee: el.conversationAssignee.value,
+                tags,
+            }),
+        });
+        showToast("Обращение обновлено");
+        renderConversationThread(convo);
+        await loadConversations();
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+}
+
+function renderCannedReplies() {
+    if (el.conversationCanned) {
+        el.conversationCanned.innerHTML = "<option value=\"\">Готовый ответ…</option>";
+        for (const reply of state.cannedReplies) {
+            el.conversationCanned.appendChild(new Option(reply.title, String(reply.id)));
+        }
+    }
+    if (!el.cannedReplyTableBody) {
+        return;
+    }
+    el.cannedReplyTableBody.innerHTML = "";
+    if (!state.cannedReplies.length) {
+        const row = document.createElement("tr");
+        row.innerHTML = "<td colspan=\"3\" class=\"hint\">Готовых ответов ещё нет.</td>";
+        el.cannedReplyTableBody.appendChild(row);
+        return;
+    }
+    for (const reply of state.cannedReplies) {
+        const row = document.createElement("tr");
+        row.dataset.id = String(reply.id);
+        row.classList.toggle("selected", reply.id === Number(el.cannedReplyForm.dataset.id));
+        row.innerHTML = `
+            <td>${escapeHtml(reply.title)}</td>
+            <td>${escapeHtml(reply.body.slice(0, 120))}</td>
+            <td class="actions"><button type="button" class="btn subtle" data-action="delete" data-id="${reply.id}">Удалить</button></td>
+        `;
+        el.cannedReplyTableBody.appendChild(row);
+    }
+}
+
+function selectCannedReply(reply) {
+    el.cannedReplyForm.dataset.id = reply ? String(reply.id) : "";
+    el.cannedReplyTitle.value = reply?.title || "";
+    el.cannedReplyBody.value = reply?.body || "";
+    renderCannedReplies();
+}
+
+async function saveCannedReply() {
+    try {
+        setLoading(true);
+        const reply = await request("telegramCannedReply", {
+            method: "POST",
+            body: JSON.stringify({
+                id: Number(el.cannedReplyForm.dataset.id) || 0,
+                title: el.cannedReplyTitle.value,
+                body: el.cannedReplyBody.value,
+            }),
+        });
+        showToast("Ответ сохранён");
+        state.cannedReplies = await request("telegramCannedReplies", { method: "GET" });
+        selectCannedReply(reply);
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+}
+
+async function deleteCannedReply(id) {
+    if (!window.confirm("Удалить готовый ответ?")) {
+        return;
+    }
+    try {
+        setLoading(true);
+        await request("telegramCannedReplyDelete", { method: "POST", body: JSON.stringify({ id }) });
+        showToast("Ответ удалён");
+        state.cannedReplies = await request("telegramCannedReplies", { method: "GET" });
+        selectCannedReply(null);
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+}
+
+const paymentStatusLabels = {
+    pending: "Ожидает оплаты",
+    waiting_for_capture: "Ожидает оплаты",
+    succeeded: "Оплачен",
+    refunded: "Возвращён",
+    canceled: "Отменён",
+    failed: "Ошибка",
+};
+
+function paymentFilterQuery() {
+    const params = new URLSearchParams();
+    const values = {
+        status: el.paymentFilterStatus.value,
+        tariffId: el.paymentFilterTariff.value,
+        currency: el.paymentFilterCurrency.value.trim(),
+        telegramId: el.paymentFilterTelegram.value.trim(),
+        from: el.paymentFilterFrom.value,
+        to: el.paymentFilterTo.value,
+    };
+    for (const [key, value] of Object.entries(values)) {
+        if (value) {
+            params.set(key, value);
+        }
+    }
+    return params;
+}
+
+function renderPaymentTariffOptions() {
+    if (!el.paymentFilterTariff) {
+        return;
+    }
+    const selected = el.paymentFilterTariff.value;
+    el.paymentFilterTariff.innerHTML = "<option value=\"\">Все</option>";
+    for (const tariff of state.tariffs) {
+        const option = document.createElement("option");
+        option.value = String(tariff.id);
+        option.textContent = tariff.title || `Тариф #${tariff.id}`;
+        el.paymentFilterTariff.appendChild(option);
+    }
+    el.paymentFilterTariff.value = selected;
+}
+
+function renderPaymentReport(report) {
+    el.paymentReport.innerHTML = "";
+    const groups = [
+        ["Выручка по валютам", report.byCurrency],
+        ["По тарифам", report.byTariff],
+        ["По дням", report.byDay],
+    ];
+    for (const [title, rows] of groups) {
+        const table = document.createElement("table");
+        table.className = "table";
+        table.innerHTML = `<caption>${title}</caption><thead><tr><th></th><th>Заказов</th><th>Выручка</th><th>Возвраты</th></tr></thead>`;
+        const body = document.createElement("tbody");
+        for (const row of rows || []) {
+            const tr = document.createElement("tr");
+            tr.innerHTML = `
+                <td>${escapeHtml(row.title)}</td>
+                <td>${row.count}</td>
+                <td>${formatPrice(row.netMinor, row.currency)}</td>
+                <td>${row.refundedMinor ? formatPrice(row.refundedMinor, row.currency) : "—"}</td>
+            `;
+            body.appendChild(tr);
+        }
+        if (!body.children.length) {
+            body.innerHTML = "<tr><td colspan=\"4\" class=\"hint\">Нет оплаченных заказов.</td></tr>";
+        }
+        table.appendChild(body);
+        el.paymentReport.appendChild(table);
+    }
+}
+
+function renderPayments() {
+    el.paymentTableBody.innerHTML = "";
+    if (!state.payments.length) {
+        el.paymentTableBody.innerHTML = "<tr><td colspan=\"8\" class=\"hint\">Заказов не найдено.</td></tr>";
+    }
+    for (const payment of state.payments) {
+        const row = document.createElement("tr");
+        const user = payment.username ? `@${payment.username}` : `ID ${payment.telegramId}`;
+        const statusClass = payment.status === "succeeded" ? "success" : payment.status === "refunded" ? "warning" : "muted";
+        let amount = formatPrice(payment.amountMinor, payment.currency);
+        if (payment.refundedMinor) {
+            amount += ` (возврат ${formatPrice(payment.refundedMinor, payment.currency)})`;
+        }
+        row.innerHTML = `
+            <td>#${payment.id}</td>
+            <td>${formatDateTime(payment.paidAt || payment.createdAt)}</td>
+            <td>${escapeHtml(user)}</td>
+            <td>${escapeHtml(payment.tariffTitle || `#${payment.tariffId}`)}</td>
+            <td>${escapeHtml(payment.promoCode || "—")}</td>
+            <td>${amount}</td>
+            <td><span class="badge ${statusClass}" title="${escapeHtml(payment.errorMessage || "")}">${paymentStatusLabels[payment.status] || escapeHtml(payment.status)}</span></td>
+            <td class="actions"></td>
+        `;
+        if (payment.status === "succeeded" && payment.providerPaymentId && payment.amountMinor > payment.refundedMinor) {
+            const refundBtn = document.createElement("button");
+            refundBtn.type = "button";
+            refundBtn.className = "btn subtle";
+            refundBtn.textContent = "Возврат";
+            refundBtn.dataset.id = String(payment.id);
+            row.querySelector(".actions").appendChild(refundBtn);
+        }
+        el.paymentTableBody.appendChild(row);
+    }
+    el.loadMorePayments.hidden = state.payments.length >= state.paymentsTotal;
+}
+
+async function loadPayments(append = false) {
+    const params = paymentFilterQuery();
+    el.exportPayments.href = `${apiBase}telegramPaymentsCsv?${params}`;
+    try {
+        if (!append) {
+            const report = await request(`telegramPaymentReport?${params}`, { method: "GET" });
+            renderPaymentReport(report);
+        }
+        params.set("offset", String(append ? state.payments.length : 0));
+        const list = await request(`telegramPayments?${params}`, { method: "GET" });
+        state.payments = append ? state.payments.concat(list.payments) : list.payments;
+        state.paymentsTotal = list.total;
+        renderPayments();
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    }
+}
+
+async function refundPayment(id) {
+    const payment = state.payments.find((item) => item.id === id);
+    if (!payment) {
+        return;
+    }
+    const left = (payment.amountMinor - payment.refundedMinor) / 100;
+    const value = window.prompt(`Сумма возврата (${payment.currency}), не больше ${left.toFixed(2)}`, left.toFixed(2));
+    if (value === null) {
+        return;
+    }
+    const amount = Math.round(parseFloat(value.replace(",", ".")) * 100);
+    if (!amount || amount < 0) {
+        showToast("Укажите сумму возврата", true);
+        return;
+    }
+    const reason = window.prompt("Причина возврата", "") || "";
+    try {
+        setLoading(true);
+        await request("telegramPaymentRefund", {
+            method: "POST",
+            body: JSON.stringify({ id, amountMinor: amount, reason }),
+        });
+        showToast("Возврат выполнен");
+        await loadPayments();
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+}
+
+function updateReferralRewardControls() {
+    const days = el.referralReward.value === "days";
+    el.referralDays.disabled = !days;
+    el.referralPercent.disabled = days;
+}
+
+function describeReferralUser(user) {
+    const name = [user.firstName, user.username ? `@${user.username}` : ""].filter(Boolean).join(" ");
+    return `${name || `ID ${user.telegramId}`} (#${user.userId})`;
+}
+
+function renderReferralNode(node) {
+    const item = document.createElement("li");
+    const label = document.createElement("span");
+    label.textContent = describeReferralUser(node);
+    item.appendChild(label);
+    const badge = document.createElement("span");
+    badge.className = `badge ${node.rewarded ? "success" : node.everPaid ? "warning" : "muted"}`;
+    badge.textContent = node.rewarded ? "награда начислена" : node.everPaid ? "оплатил" : "без оплаты";
+    item.append(" ", badge);
+    if (node.referrals?.length) {
+        const list = document.createElement("ul");
+        for (const child of node.referrals) {
+            list.appendChild(renderReferralNode(child));
+        }
+        item.appendChild(list);
+    }
+    return item;
+}
+
+function renderReferralTree(tree) {
+    if (!el.referralTree) {
+        return;
+    }
+    el.referralTree.innerHTML = "";
+    if (!tree) {
+        return;
+    }
+    const summary = document.createElement("p");
+    summary.className = "hint";
+    const upline = tree.upline.map(describeReferralUser).join(" ← ");
+    summary.textContent = `Приглашено всего: ${tree.total}. ${upline ? `Пригласили: ${upline}.` : "Пользователь пришёл без приглашения."}`;
+    const list = document.createElement("ul");
+    list.appendChild(renderReferralNode(tree.root));
+    el.referralTree.append(summary, list);
+}
+
+function describeReferralReward(reward) {
+    if (reward.kind === "days") {
+        return `${reward.freeDays} д.`;
+    }
+    return `Скидка ${reward.discountPercent}%`;
+}
+
+function renderReferralRewards(rewards) {
+    if (!el.referralRewardsBody) {
+        return;
+    }
+    el.referralRewardsBody.innerHTML = "";
+    if (!rewards.length) {
+        const row = document.createElement("tr");
+        const cell = document.createElement("td");
+        cell.colSpan = 6;
+        cell.className = "hint";
+        cell.textContent = "Наград ещё нет.";
+        row.appendChild(cell);
+        el.referralRewardsBody.appendChild(row);
+        return;
+    }
+    for (const reward of rewards) {
+        const row = document.createElement("tr");
+        const granted = reward.status === "granted";
+        row.innerHTML = `
+            <td>#${reward.referrerId}</td>
+            <td>#${reward.refereeId}</td>
+            <td>#${reward.paymentId}</td>
+            <td>${describeReferralReward(reward)}</td>
+            <td>
+                <span class="badge ${granted ? "success" : "warning"}" title="${escapeHtml(reward.errorMessage || "")}">
+                    ${granted ? "Начислена" : "Ожидает"}
+                </span>
+            </td>
+            <td>${formatDateTime(reward.grantedAt || reward.createdAt)}</td>
+        `;
+        el.referralRewardsBody.appendChild(row);
+    }
+}
+
+async function loadReferrals() {
+    const userId = Number(el.referralUserId.value) || 0;
+    try {
+        const query = userId ? `?userId=${userId}` : "";
+        const rewards = await request(`telegramReferralRewards${query}`, { method: "GET" });
+        renderReferralRewards(rewards || []);
+        renderReferralTree(userId ? await request(`telegramReferralTree?userId=${userId}`, { method: "GET" }) : null);
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    }
+}
+
+function findTemplate(key) {
+    return state.templates?.messages.find((message) => message.key === key) || null;
+}
+
+function templateLanguage() {
+    return el.templateLanguage.value.trim().toLowerCase();
+}
+
+function renderTemplates() {
+    if (!el.templateTableBody || !state.templates) {
+        return;
+    }
+    const selected = el.templateKey.value;
+    el.templateTableBody.innerHTML = "";
+    el.templateKey.innerHTML = "";
+    for (const message of state.templates.messages) {
+        const row = document.createElement("tr");
+        row.dataset.key = message.key;
+        row.classList.toggle("selected", message.key === selected);
+        const languages = message.overrides.length
+            ? message.overrides.map((o) => `<span class="badge success">${escapeHtml(o.language)}</span>`).join(" ")
+            : `<span class="badge muted">по умолчанию</span>`;
+        row.innerHTML = `
+            <td><code>${escapeHtml(message.key)}</code></td>
+            <td>${escapeHtml(message.description)}</td>
+            <td>${languages}</td>
+        `;
+        el.templateTableBody.appendChild(row);
+        el.templateKey.appendChild(new Option(message.key, message.key));
+    }
+    el.templateFields.innerHTML = "";
+    for (const field of state.templates.fields) {
+        const item = document.createElement("li");
+        item.innerHTML = `<code>${escapeHtml(field.name)}</code> — ${escapeHtml(field.description)}`;
+        el.templateFields.appendChild(item);
+    }
+    selectTemplate(selected || state.templates.messages[0]?.key);
+}
+
+// selectTemplate shows the translation for the entered language, or the built-in text to start from.
+function selectTemplate(key) {
+    const message = findTemplate(key);
+    if (!message) {
+        return;
+    }
+    if (!el.templateLanguage.value.trim()) {
+        el.templateLanguage.value = state.templates.defaultLanguage || "en";
+    }
+    el.templateKey.value = key;
+    const override = message.overrides.find((o) => o.language === templateLanguage());
+    el.templateText.value = override ? override.text : message.default;
+    el.templateDefault.textContent = `Встроенный текст: ${message.default}`;
+    el.deleteTemplate.disabled = !override;
+    el.deleteTemplate.dataset.id = override ? String(override.id) : "";
+    el.templatePreview.hidden = true;
+    for (const row of el.templateTableBody.querySelectorAll("tr")) {
+        row.classList.toggle("selected", row.dataset.key === key);
+    }
+}
+
+async function loadTemplates() {
+    try {
+        state.templates = await request("telegramTemplates", { method: "GET" });
+        renderTemplates();
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    }
+}
+
+function templatePayload() {
+    return {
+        key: el.templateKey.value,
+        language: templateLanguage(),
+        text: el.templateText.value,
+    };
+}
+
+async function previewTemplate() {
+    try {
+        const result = await request("telegramTemplatePreview", {
+            method: "POST",
+            body: JSON.stringify(templatePayload()),
+        });
+        el.templatePreview.textContent = result.text;
+        el.templatePreview.hidden = false;
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    }
+}
+
+async function saveTemplate() {
+    try {
+        setLoading(true);
+        await request("telegramTemplate", {
+            method: "POST",
+            body: JSON.stringify(templatePayload()),
+        });
+        showToast("Шаблон сохранён");
+        await loadTemplates();
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+}
+
+async function deleteTemplate() {
+    const id = Number(el.deleteTemplate.dataset.id);
+    if (!id || !confirm(`Удалить перевод «${el.templateKey.value}» (${templateLanguage()})?`)) {
+        return;
+    }
+    try {
+        setLoading(true);
+        await request("telegramTemplateDelete", {
+            method: "POST",
+            body: JSON.stringify({ id }),
+        });
+        showToast("Перевод удалён");
+        await loadTemplates();
     } catch (error) {
         console.error(error);
         showToast(error.message, true);
+    } finally {
+        setLoading(false);
     }
 }
 
@@ -901,13 +1902,31 @@ el.configForm.addEventListener("submit", async (event) => {
     const payload = {
         enabled: el.configEnabled.checked,
         botToken: el.botTokenInput.value.trim(),
+        apiBaseUrl: el.apiBaseUrl.value.trim(),
         webhookDomain: el.webhookDomain.value.trim(),
         webhookSecret: el.webhookSecret.value.trim(),
         yooKassaShopId: el.shopId.value.trim(),
         yooKassaSecretKey: el.secretKey.value.trim(),
+        yooKassaApiUrl: el.yooKassaApiUrl.value.trim(),
         successRedirectUrl: el.successUrl.value.trim(),
         failureRedirectUrl: el.failureUrl.value.trim(),
         miniAppUrl: el.miniAppUrl.value.trim(),
+        remindersEnabled: el.remindersEnabled.checked,
+        reminderDays: parseIdList(el.reminderDays.value),
+        reminderVolume: parseIdList(el.reminderVolume.value),
+        trialEnabled: el.trialEnabled.checked,
+        trialInbounds: parseIdList(el.trialInbounds.value),
+        trialDays: Number(el.trialDays.value) || 0,
+        trialVolume: el.trialVolume.value ? Math.round(parseFloat(el.trialVolume.value) * GB) : 0,
+        trialMinAccountAge: Number(el.trialAccountAge.value) || 0,
+        defaultLanguage: el.defaultLanguage.value.trim(),
+        adminUserIds: parseIdList(el.adminUsers.value),
+        adminChatIds: parseChatIdList(el.adminChats.value),
+        adminEvents: Array.from(el.adminEvents.querySelectorAll("input:checked"), (input) => input.value),
+        referralEnabled: el.referralEnabled.checked,
+        referralReward: el.referralReward.value,
+        referralDays: Number(el.referralDays.value) || 0,
+        referralPercent: Number(el.referralPercent.value) || 0,
         downloadLinks: collectDownloadLinks(),
     };
     try {
@@ -928,6 +1947,19 @@ el.configForm.addEventListener("submit", async (event) => {
 
 el.addDownloadLink.addEventListener("click", () => addDownloadLinkRow());
 
+el.adminNotifyTest.addEventListener("click", async () => {
+    try {
+        setLoading(true);
+        await request("telegramAdminTest", { method: "POST" });
+        showToast("Тестовое сообщение отправлено");
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+});
+
 el.createTariff.addEventListener("click", () => {
     state.selectedTariffId = null;
     for (const row of el.tariffTableBody.querySelectorAll("tr")) {
@@ -1001,6 +2033,8 @@ el.tariffForm.addEventListener("submit", async (event) => {
         priceMinor: Math.round(priceValue * 100),
         currency: el.tariffCurrency.value.trim().toUpperCase(),
         durationDays: el.tariffDuration.value ? Number(el.tariffDuration.value) : 0,
+        volume: el.tariffVolume.value ? Math.round(parseFloat(el.tariffVolume.value) * GB) : 0,
+        inbounds: parseIdList(el.tariffInbounds.value),
         sortOrder: el.tariffSort.value ? Number(el.tariffSort.value) : 0,
         active: el.tariffActive.checked,
     };
@@ -1143,6 +2177,57 @@ async function sendBroadcast(id) {
     }
 }
 
+async function scheduleBroadcast(id) {
+    if (!id) {
+        showToast("Сначала сохраните рассылку", true);
+        return;
+    }
+    const local = el.broadcastScheduledAt?.value || "";
+    const recurrence = el.broadcastRecurrence?.value.trim() || "";
+    if (!local && !recurrence) {
+        showToast("Укажите время отправки или правило повтора", true);
+        return;
+    }
+    try {
+        setLoading(true);
+        await request("telegramBroadcastSchedule", {
+            method: "POST",
+            body: JSON.stringify({
+                id,
+                scheduledAt: local ? new Date(local).toISOString() : null,
+                recurrence,
+            }),
+        });
+        showToast("Рассылка запланирована");
+        await loadState(true);
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+}
+
+async function cancelBroadcastSchedule(id) {
+    if (!id) {
+        return;
+    }
+    try {
+        setLoading(true);
+        await request("telegramBroadcastCancel", {
+            method: "POST",
+            body: JSON.stringify({ id }),
+        });
+        showToast("Расписание отменено");
+        await loadState(true);
+    } catch (error) {
+        console.error(error);
+        showToast(error.message, true);
+    } finally {
+        setLoading(false);
+    }
+}
+
 async function deleteBroadcast(id) {
     if (!id) {
         return;
@@ -1172,7 +2257,7 @@ async function editSentBroadcast(id) {
         return;
     }
     const body = el.broadcastBody?.value.trim();
-    if (!body) {
+    if (!body && !state.broadcastMedia) {
         showToast("Введите текст сообщения", true);
         return;
     }
@@ -1180,9 +2265,15 @@ async function editSentBroadcast(id) {
         setLoading(true);
         await request("telegramBroadcastEdit", {
             method: "POST",
-            body: JSON.stringify({ broadcastId: id, body }),
+            body: JSON.stringify({
+                broadcastId: id,
+                body,
+                parseMode: el.broadcastParseMode?.value || "",
+                media: state.broadcastMedia,
+                buttons: collectBroadcastButtons(),
+            }),
         });
-        showToast("Сообщения обновлены");
+        showToast("Обновление сообщений поставлено в очередь");
         await loadState(true);
     } catch (error) {
         console.error(error);
@@ -1232,7 +2323,7 @@ async function deletePromo(id) {
     }
 }
 
-async function replyConversation(id, text) {
+async function replyConversation(id, text, close) {
     if (!id) {
         showToast("Выберите пользователя", true);
         return;
@@ -1245,12 +2336,13 @@ async function replyConversation(id, text) {
         setLoading(true);
         const convo = await request("telegramConversationReply", {
             method: "POST",
-            body: JSON.stringify({ id, text }),
+            body: JSON.stringify({ id, text, close }),
         });
         showToast("Сообщение отправлено");
         renderConversationThread(convo);
         el.conversationReply.value = "";
-        await loadState(true);
+        el.conversationClose.checked = false;
+        await loadConversations();
     } catch (error) {
         console.error(error);
         showToast(error.message, true);
@@ -1263,11 +2355,11 @@ if (el.broadcastForm) {
     el.broadcastForm.addEventListener("submit", async (event) => {
         event.preventDefault();
         const payload = collectBroadcastPayload();
-        if (!payload.title || !payload.body) {
-            showToast("Укажите название и текст рассылки", true);
+        if (!payload.title || (!payload.body && !payload.media)) {
+            showToast("Укажите название и текст или вложение рассылки", true);
             return;
         }
-        if (!payload.audience.allUsers && !payload.audience.tariffIds.length && !payload.audience.includeNeverSubscribed && !payload.audience.includeExpired) {
+        if (!payload.audience.expression && !payload.audience.allUsers && !payload.audience.tariffIds.length && !payload.audience.includeNeverSubscribed && !payload.audience.includeExpired && !payload.audience.includeTrial) {
             showToast("Выберите хотя бы один сегмент аудитории", true);
             return;
         }
@@ -1330,6 +2422,18 @@ if (el.broadcastSend) {
     });
 }
 
+if (el.broadcastSchedule) {
+    el.broadcastSchedule.addEventListener("click", () => {
+        scheduleBroadcast(Number(el.broadcastId?.value || 0));
+    });
+}
+
+if (el.broadcastCancelSchedule) {
+    el.broadcastCancelSchedule.addEventListener("click", () => {
+        cancelBroadcastSchedule(Number(el.broadcastId?.value || 0));
+    });
+}
+
 if (el.broadcastEditSent) {
     el.broadcastEditSent.addEventListener("click", () => {
         const id = Number(el.broadcastId?.value || 0);
@@ -1337,6 +2441,27 @@ if (el.broadcastEditSent) {
     });
 }
 
+if (el.broadcastMediaFile) {
+    el.broadcastMediaFile.addEventListener("change", () => {
+        const file = el.broadcastMediaFile.files?.[0];
+        if (file) {
+            uploadBroadcastMedia(file);
+        }
+    });
+}
+
+if (el.broadcastMediaRemove) {
+    el.broadcastMediaRemove.addEventListener("click", () => setBroadcastMedia(null));
+}
+
+if (el.broadcastAddButton) {
+    el.broadcastAddButton.addEventListener("click", () => addBroadcastButtonRow());
+}
+
+if (el.broadcastAudiencePreview) {
+    el.broadcastAudiencePreview.addEventListener("click", () => previewBroadcastAudience());
+}
+
 if (el.broadcastAllUsers) {
     el.broadcastAllUsers.addEventListener("change", () => updateBroadcastAudienceControls());
 }
@@ -1390,7 +2515,59 @@ if (el.promoNoExpiry) {
 }
 
 if (el.refreshConversations) {
-    el.refreshConversations.addEventListener("click", () => loadState(true));
+    el.refreshConversations.addEventListener("click", async () => {
+        await loadConversations();
+        if (state.activeConversation) {
+            await loadConversation(state.activeConversation.user.id, false);
+        }
+    });
+}
+
+if (el.conversationFilterForm) {
+    el.conversationFilterForm.addEventListener("submit", (event) => {
+        event.preventDefault();
+        loadConversations();
+    });
+    el.loadMoreConversations.addEventListener("click", () => loadConversations(true));
+    loadPanelUsers();
+}
+
+if (el.conversationTicketForm) {
+    el.conversationTicketForm.addEventListener("submit", (event) => {
+        event.preventDefault();
+        updateConversation();
+    });
+}
+
+if (el.conversationCanned) {
+    el.conversationCanned.addEventListener("change", () => {
+        const reply = state.cannedReplies.find((r) => r.id === Number(el.conversationCanned.value));
+        if (reply) {
+            const current = el.conversationReply.value.trim();
+            el.conversationReply.value = current ? `${current}\n\n${reply.body}` : reply.body;
+            el.conversationReply.focus();
+        }
+        el.conversationCanned.value = "";
+    });
+}
+
+if (el.cannedReplyForm) {
+    el.cannedReplyForm.addEventListener("submit", (event) => {
+        event.preventDefault();
+        saveCannedReply();
+    });
+    el.cannedReplyReset.addEventListener("click", () => selectCannedReply(null));
+    el.cannedReplyTableBody.addEventListener("click", (event) => {
+        const button = event.target.closest("button[data-action]");
+        if (button) {
+            deleteCannedReply(Number(button.dataset.id));
+            return;
+        }
+        const row = event.target.closest("tr[data-id]");
+        if (row) {
+            selectCannedReply(state.cannedReplies.find((r) => r.id === Number(row.dataset.id)));
+        }
+    });
 }
 
 if (el.conversationList) {
@@ -1408,8 +2585,52 @@ if (el.conversationReplyForm) {
     el.conversationReplyForm.addEventListener("submit", async (event) => {
         event.preventDefault();
         const id = state.activeConversation?.user?.id;
-        await replyConversation(id, el.conversationReply.value);
+        await replyConversation(id, el.conversationReply.value, el.conversationClose.checked);
+    });
+}
+
+el.referralReward?.addEventListener("change", updateReferralRewardControls);
+
+if (el.paymentFilterForm) {
+    el.paymentFilterForm.addEventListener("submit", (event) => {
+        event.preventDefault();
+        loadPayments();
+    });
+    el.loadMorePayments.addEventListener("click", () => loadPayments(true));
+    el.paymentTableBody.addEventListener("click", (event) => {
+        const button = event.target.closest("button[data-id]");
+        if (button) {
+            refundPayment(Number(button.dataset.id));
+        }
+    });
+    loadPayments();
+}
+
+if (el.referralForm) {
+    el.referralForm.addEventListener("submit", (event) => {
+        event.preventDefault();
+        loadReferrals();
+    });
+    el.refreshReferrals.addEventListener("click", loadReferrals);
+    loadReferrals();
+}
+
+if (el.templateForm) {
+    el.templateForm.addEventListener("submit", (event) => {
+        event.preventDefault();
+        saveTemplate();
+    });
+    el.templateTableBody.addEventListener("click", (event) => {
+        const row = event.target.closest("tr[data-key]");
+        if (row) {
+            selectTemplate(row.dataset.key);
+        }
     });
+    el.templateKey.addEventListener("change", () => selectTemplate(el.templateKey.value));
+    el.templateLanguage.addEventListener("change", () => selectTemplate(el.templateKey.value));
+    el.previewTemplate.addEventListener("click", previewTemplate);
+    el.deleteTemplate.addEventListener("click", deleteTemplate);
+    loadTemplates();
 }
 
 window.addEventListener("pageshow", () => {
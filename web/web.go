@@ -109,6 +109,9 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 	group_api := engine.Group(base_url + "api")
 	api.NewAPIHandler(group_api, apiv2)
 
+	group_telegram := engine.Group(base_url + "telegram")
+	api.NewTelegramWebhookHandler(group_telegram)
+
 	engine.GET(base_url+"telegram", func(c *gin.Context) {
 		if !api.IsLogin(c) {
 			c.Redirect(http.StatusTemporaryRedirect, base_url+"login")
@@ -116,6 +119,10 @@ func (s *Server) initRouter() (*gin.Engine, error) {
 		}
 		c.HTML(http.StatusOK, "telegram.html", gin.H{"BASE_URL": base_url})
 	})
+	// The mini app authenticates its API calls with the Telegram init data instead of a session
+	engine.GET(base_url+"telegram/miniapp", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "miniapp.html", gin.H{"BASE_URL": base_url})
+	})
 
 	// Serve index.html as the entry point
 	// Handle all other routes by serving index.html